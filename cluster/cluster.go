@@ -0,0 +1,211 @@
+package cluster
+
+import (
+	"fmt"
+	"record-traffic-press/goreplay/core"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"sort"
+	"time"
+)
+
+// 分布式回放: gin 服务作为协调者, 把录制任务拆分给多个 worker 进程, worker 为同一程序以 -worker 参数启动.
+// 协调者与 worker 之间只通过 Redis 通信:
+//   - cluster:workers            hash, worker ID -> WorkerInfo, worker 定时心跳
+//   - cluster:assign:<worker ID> list, 协调者下发的 Assignment
+//   - cluster:run:<录制任务ID>      Run, 分布式回放的分片信息
+//   - cluster:metrics:<录制任务ID>  hash, worker ID -> WorkerMetrics, worker 定时上报
+//   - cluster:stop:<录制任务ID>     存在时 worker 停止任务
+//   - cluster:dispatch           协调者选取并下发空闲 worker 时的锁, 避免多个协调者选中同一 worker
+
+const (
+	heartbeatInterval = 2 * time.Second
+	// workerOfflineAfter 超过该时间没有心跳的 worker 视为离线
+	workerOfflineAfter = 5 * heartbeatInterval
+	// keyExpiration 回放相关 key 的过期时间
+	keyExpiration = 24 * time.Hour
+	// maxDiffSamples 每个接口上报的不一致示例数
+	maxDiffSamples = 5
+	// dispatchLockExpiration 下发锁的过期时间, 协调者异常退出时锁自动释放
+	dispatchLockExpiration = 10 * time.Second
+)
+
+// 分片方式
+const (
+	ShardByFile = "file" // 按 input-file 文件分配给 worker
+	ShardByID   = "id"   // 每个 worker 读取全部输入, 按请求ID中的会话(连接或客户端)哈希保留自己的部分, 见 core.Shard
+)
+
+// worker 状态
+const (
+	WorkerIdle    = "idle"
+	WorkerWaiting = "waiting" // 已收到任务, 等待统一开始时间
+	WorkerRunning = "running"
+	WorkerOffline = "offline" // 心跳超时, 仅由协调者标记
+)
+
+const (
+	workersKey      = "cluster:workers"
+	dispatchLockKey = "cluster:dispatch"
+)
+
+func assignKey(workerID string) string {
+	return "cluster:assign:" + workerID
+}
+
+func runKey(recordID int32) string {
+	return fmt.Sprintf("cluster:run:%d", recordID)
+}
+
+func metricsKey(recordID int32) string {
+	return fmt.Sprintf("cluster:metrics:%d", recordID)
+}
+
+func stopKey(recordID int32) string {
+	return fmt.Sprintf("cluster:stop:%d", recordID)
+}
+
+// WorkerInfo worker 注册信息
+type WorkerInfo struct {
+	ID        string `json:"id"`
+	Host      string `json:"host"`
+	PID       int    `json:"pid"`
+	Status    string `json:"status"`
+	RecordID  int32  `json:"record_id"` // 正在执行的录制任务ID
+	StartedAt int64  `json:"started_at"`
+	Heartbeat int64  `json:"heartbeat"` // 最近一次心跳时间(毫秒)
+}
+
+// Alive worker 心跳是否正常
+func (w *WorkerInfo) Alive(now time.Time) bool {
+	return now.Sub(time.UnixMilli(w.Heartbeat)) < workerOfflineAfter
+}
+
+// Assignment 下发给 worker 的分片任务
+type Assignment struct {
+	RecordID int32                `json:"record_id"`
+	Shard    int                  `json:"shard"`
+	Shards   int                  `json:"shards"`
+	StartAt  int64                `json:"start_at"` // 统一开始时间(毫秒), 各机器需要时钟同步
+	Settings settings.AppSettings `json:"settings"`
+}
+
+// Run 分布式回放
+type Run struct {
+	RecordID int32    `json:"record_id"`
+	ShardBy  string   `json:"shard_by"`
+	Workers  []string `json:"workers"` // 下标即分片序号
+	StartAt  int64    `json:"start_at"`
+}
+
+// WorkerMetrics worker 上报的回放数据, 结束前定时覆盖上报
+type WorkerMetrics struct {
+	WorkerID  string                            `json:"worker_id"`
+	Shard     int                               `json:"shard"`
+	Status    string                            `json:"status"`
+	Finished  bool                              `json:"finished"`
+	Error     string                            `json:"error,omitempty"`
+	UpdatedAt int64                             `json:"updated_at"` // 毫秒
+	Latencies map[string][]core.LatencySnapshot `json:"latencies"`  // 按回放输出
+	Diff      map[string]proto.DiffStats        `json:"diff"`       // 按协议及接口, 见 proto.DiffStatsKey
+	Samples   map[string][]*proto.DiffRecord    `json:"samples"`    // 按接口的不一致示例
+	Stages    []core.LoadStageReport            `json:"stages,omitempty"`
+}
+
+// RunMetrics 合并后的分布式回放数据
+type RunMetrics struct {
+	Run      *Run             `json:"run"`
+	Workers  []*WorkerMetrics `json:"workers"` // 按分片序号, 未上报的 worker 只有状态
+	Finished bool             `json:"finished"`
+
+	latencies map[string]map[string]*core.LatencySnapshot
+	diff      map[string]proto.DiffStats
+}
+
+// merge 合并各 worker 的数据, 各 worker 同名回放输出及接口的数据合并在一起
+func (m *RunMetrics) merge() {
+	m.latencies = make(map[string]map[string]*core.LatencySnapshot)
+	m.diff = make(map[string]proto.DiffStats)
+
+	for _, w := range m.Workers {
+		for output, snapshots := range w.Latencies {
+			endpoints, ok := m.latencies[output]
+			if !ok {
+				endpoints = make(map[string]*core.LatencySnapshot)
+				m.latencies[output] = endpoints
+			}
+			for _, s := range snapshots {
+				merged, ok := endpoints[s.Endpoint]
+				if !ok {
+					merged = &core.LatencySnapshot{Endpoint: s.Endpoint}
+					endpoints[s.Endpoint] = merged
+				}
+				merged.Merge(s)
+			}
+		}
+
+		for key, s := range w.Diff {
+			total := m.diff[key]
+			total.Protocol, total.Endpoint = s.Protocol, s.Endpoint
+			total.Total += s.Total
+			total.Matched += s.Matched
+			total.Mismatched += s.Mismatched
+			total.Missing += s.Missing
+			total.OriginalLatency += s.OriginalLatency
+			total.ReplayedLatency += s.ReplayedLatency
+			m.diff[key] = total
+		}
+	}
+}
+
+// DiffStats 合并后按协议及接口的比对结果, 键见 proto.DiffStatsKey
+func (m *RunMetrics) DiffStats() map[string]proto.DiffStats {
+	return m.diff
+}
+
+// LatencyReports 合并后各回放输出按接口的耗时分位数
+func (m *RunMetrics) LatencyReports() map[string][]core.LatencyReport {
+	reports := make(map[string][]core.LatencyReport)
+	for output, endpoints := range m.latencies {
+		list := make([]core.LatencyReport, 0, len(endpoints))
+		for _, s := range endpoints {
+			list = append(list, s.Report())
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Endpoint < list[j].Endpoint })
+		reports[output] = list
+	}
+	return reports
+}
+
+// latencyTotals 合并后各回放输出全部接口的耗时分位数
+func (m *RunMetrics) latencyTotals() map[string]core.LatencyReport {
+	totals := make(map[string]core.LatencyReport)
+	for output, endpoints := range m.latencies {
+		total := core.LatencySnapshot{Endpoint: core.LatencyTotalEndpoint}
+		for _, s := range endpoints {
+			total.Merge(*s)
+		}
+		totals[output] = total.Report()
+	}
+	return totals
+}
+
+// SLOVerdict 按合并后的数据评估 SLO, 未配置规则时返回 nil
+func (m *RunMetrics) SLOVerdict(rules []core.SLORule, minSamples int64) *core.SLOVerdict {
+	if len(rules) == 0 {
+		return nil
+	}
+	verdict := core.EvaluateSLO(rules, m.latencyTotals(), m.diff, minSamples)
+	return &verdict
+}
+
+// Samples 各 worker 上报的不一致示例
+func (m *RunMetrics) Samples() []*proto.DiffRecord {
+	var samples []*proto.DiffRecord
+	for _, w := range m.Workers {
+		for _, records := range w.Samples {
+			samples = append(samples, records...)
+		}
+	}
+	return samples
+}
@@ -0,0 +1,90 @@
+package cluster
+
+import (
+	"context"
+	"os"
+	"record-traffic-press/config/conf"
+	cache "record-traffic-press/config/redis"
+	"record-traffic-press/goreplay/core"
+	"record-traffic-press/goreplay/proto"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+var server *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	var err error
+	if server, err = miniredis.Run(); err != nil {
+		panic(err)
+	}
+	port, _ := strconv.Atoi(server.Port())
+	cache.InitRedisWithConfig(conf.RedisConfig{Host: server.Host(), Port: port, Fix: "test:"})
+
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
+}
+
+// registerWorker 以给定状态及最近心跳时间注册 worker
+func registerWorker(t *testing.T, id, status string, heartbeat time.Time) {
+	t.Helper()
+	info := &WorkerInfo{ID: id, Status: status, Heartbeat: heartbeat.UnixMilli()}
+	if err := cache.HSet(context.Background(), workersKey, id, info); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func latencySnapshot(endpoint string, latencies ...time.Duration) core.LatencySnapshot {
+	s := core.LatencySnapshot{Endpoint: endpoint, Histogram: core.NewHistogram()}
+	for _, d := range latencies {
+		s.Histogram.Record(d)
+		s.Requests++
+	}
+	return s
+}
+
+func TestRunMetricsMerge(t *testing.T) {
+	m := &RunMetrics{Workers: []*WorkerMetrics{
+		{
+			Latencies: map[string][]core.LatencySnapshot{
+				"HTTP output: a": {latencySnapshot("GET /a", time.Millisecond, 2*time.Millisecond), latencySnapshot("GET /b", time.Millisecond)},
+			},
+			Diff: map[string]proto.DiffStats{
+				"http GET /a": {Protocol: "http", Endpoint: "GET /a", Total: 2, Matched: 1, Mismatched: 1, OriginalLatency: 10, ReplayedLatency: 20},
+			},
+			Samples: map[string][]*proto.DiffRecord{"GET /a": {{Endpoint: "GET /a"}}},
+		},
+		{
+			Latencies: map[string][]core.LatencySnapshot{
+				"HTTP output: a": {latencySnapshot("GET /a", 3*time.Millisecond)},
+			},
+			Diff: map[string]proto.DiffStats{
+				"http GET /a": {Protocol: "http", Endpoint: "GET /a", Total: 3, Matched: 2, Missing: 1, OriginalLatency: 5, ReplayedLatency: 5},
+			},
+		},
+		// 未上报数据的 worker
+		{WorkerID: "offline", Status: WorkerOffline},
+	}}
+	m.merge()
+
+	reports := m.LatencyReports()["HTTP output: a"]
+	if len(reports) != 2 || reports[0].Endpoint != "GET /a" || reports[0].Requests != 3 || reports[1].Endpoint != "GET /b" || reports[1].Requests != 1 {
+		t.Errorf("Unexpected latency reports %+v", reports)
+	}
+	if total := m.latencyTotals()["HTTP output: a"]; total.Requests != 4 || total.Endpoint != core.LatencyTotalEndpoint {
+		t.Errorf("Unexpected latency total %+v", total)
+	}
+
+	expected := proto.DiffStats{Protocol: "http", Endpoint: "GET /a", Total: 5, Matched: 3, Mismatched: 1, Missing: 1, OriginalLatency: 15, ReplayedLatency: 25}
+	if diff := m.DiffStats()[proto.DiffStatsKey("http", "GET /a")]; diff != expected {
+		t.Errorf("Expected %+v, got %+v", expected, diff)
+	}
+
+	if samples := m.Samples(); len(samples) != 1 {
+		t.Errorf("Expected 1 sample, got %d", len(samples))
+	}
+}
@@ -0,0 +1,228 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	cache "record-traffic-press/config/redis"
+	"record-traffic-press/goreplay/settings"
+	"sort"
+	"time"
+)
+
+// defaultStartDelay 下发任务到统一开始的默认间隔, 需大于 worker 接收任务的时间
+const defaultStartDelay = 5 * time.Second
+
+// DispatchOption 分布式回放参数
+type DispatchOption struct {
+	Workers    int           // 使用的 worker 数
+	ShardBy    string        // 分片方式, 默认 ShardByID
+	StartDelay time.Duration // 下发后多久统一开始, 默认 defaultStartDelay
+}
+
+// Workers 查询已注册的 worker, 心跳超时的标记为离线
+func Workers(ctx context.Context) ([]*WorkerInfo, error) {
+	values, err := cache.HGetAll(ctx, workersKey)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	workers := make([]*WorkerInfo, 0, len(values))
+	for _, value := range values {
+		var info WorkerInfo
+		if err = json.Unmarshal([]byte(value), &info); err != nil {
+			continue
+		}
+		if !info.Alive(now) {
+			info.Status = WorkerOffline
+		}
+		workers = append(workers, &info)
+	}
+
+	sort.Slice(workers, func(i, j int) bool { return workers[i].ID < workers[j].ID })
+	return workers, nil
+}
+
+// Dispatch 把录制任务拆分给空闲的 worker, 各 worker 在同一时间开始回放
+func Dispatch(ctx context.Context, recordID int32, appSettings settings.AppSettings, opt DispatchOption) (*Run, error) {
+	if opt.Workers <= 0 {
+		return nil, errors.New("workers must be positive")
+	}
+	if opt.ShardBy == "" {
+		opt.ShardBy = ShardByID
+	}
+	if opt.ShardBy != ShardByFile && opt.ShardBy != ShardByID {
+		return nil, fmt.Errorf("unknown shard_by %q", opt.ShardBy)
+	}
+	if opt.StartDelay <= 0 {
+		opt.StartDelay = defaultStartDelay
+	}
+	if opt.ShardBy == ShardByFile && len(appSettings.InputFile) < opt.Workers {
+		return nil, fmt.Errorf("%d input files can't be split across %d workers", len(appSettings.InputFile), opt.Workers)
+	}
+
+	if !cache.IsValid() {
+		return nil, cache.RedisNotValid
+	}
+	// 选取空闲 worker 到下发完成期间加锁, 否则同时下发的两个回放可能选中同一 worker
+	locked, lockValue := cache.Lock(ctx, dispatchLockKey, dispatchLockExpiration)
+	if !locked {
+		return nil, errors.New("another replay is being dispatched, retry later")
+	}
+	defer cache.UnLock(ctx, dispatchLockKey, lockValue)
+
+	workers, err := Workers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var idle []*WorkerInfo
+	for _, w := range workers {
+		if w.Status == WorkerIdle {
+			idle = append(idle, w)
+		}
+	}
+	if len(idle) < opt.Workers {
+		return nil, fmt.Errorf("%d idle workers, %d required", len(idle), opt.Workers)
+	}
+	idle = idle[:opt.Workers]
+
+	run := &Run{
+		RecordID: recordID,
+		ShardBy:  opt.ShardBy,
+		Workers:  make([]string, len(idle)),
+		StartAt:  time.Now().Add(opt.StartDelay).UnixMilli(),
+	}
+	for i, w := range idle {
+		run.Workers[i] = w.ID
+	}
+
+	// 先清理上一次回放遗留的数据
+	_, _ = cache.Del(ctx, metricsKey(recordID))
+	_, _ = cache.Del(ctx, stopKey(recordID))
+	if err = cache.Set(ctx, runKey(recordID), run, keyExpiration); err != nil {
+		return nil, err
+	}
+
+	for i, w := range idle {
+		a := Assignment{
+			RecordID: recordID,
+			Shard:    i,
+			Shards:   len(idle),
+			StartAt:  run.StartAt,
+			Settings: shardSettings(appSettings, opt.ShardBy, i, len(idle)),
+		}
+		if err = cache.LPush(ctx, assignKey(w.ID), &a); err != nil {
+			// 已下发的 worker 通过停止标记取消
+			_ = StopRun(ctx, recordID)
+			return nil, err
+		}
+		// worker 收到任务后的心跳才会更新状态, 先标记为等待, 释放锁后不会再被选中
+		w.Status, w.RecordID = WorkerWaiting, recordID
+		_ = cache.HSet(ctx, workersKey, w.ID, w)
+	}
+
+	return run, nil
+}
+
+// shardSettings 第 shard 个 worker 的配置
+func shardSettings(appSettings settings.AppSettings, shardBy string, shard, shards int) settings.AppSettings {
+	switch shardBy {
+	case ShardByFile:
+		var files []string
+		for i, file := range appSettings.InputFile {
+			if i%shards == shard {
+				files = append(files, file)
+			}
+		}
+		appSettings.InputFile = files
+	case ShardByID:
+		appSettings.InputShard, appSettings.InputShards = shard, shards
+	}
+	// SLO 由协调者按合并后的数据判断是否提前结束
+	appSettings.SLO.Abort = false
+	return appSettings
+}
+
+// GetRun 查询分布式回放, 不存在时返回 nil
+func GetRun(ctx context.Context, recordID int32) (*Run, error) {
+	if !cache.IsValid() {
+		return nil, cache.RedisNotValid
+	}
+	value, err := cache.Get(ctx, runKey(recordID))
+	if errors.Is(err, cache.ValNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var run Run
+	if err = json.Unmarshal([]byte(value), &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// StopRun 通知各 worker 停止回放
+func StopRun(ctx context.Context, recordID int32) error {
+	if !cache.IsValid() {
+		return cache.RedisNotValid
+	}
+	return cache.Set(ctx, stopKey(recordID), &struct{}{}, keyExpiration)
+}
+
+// Collect 合并各 worker 上报的数据, 全部 worker 结束或离线时 Finished 为 true
+func Collect(ctx context.Context, run *Run) (*RunMetrics, error) {
+	values, err := cache.HGetAll(ctx, metricsKey(run.RecordID))
+	if err != nil {
+		return nil, err
+	}
+	workers, err := Workers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	infos := make(map[string]*WorkerInfo, len(workers))
+	for _, w := range workers {
+		infos[w.ID] = w
+	}
+
+	// 开始时间过后仍未收到任务的 worker 视为任务丢失, 例如下发后 worker 重启过
+	lost := time.Now().After(time.UnixMilli(run.StartAt).Add(workerOfflineAfter))
+
+	metrics := &RunMetrics{Run: run, Workers: make([]*WorkerMetrics, len(run.Workers)), Finished: true}
+	for i, id := range run.Workers {
+		m := &WorkerMetrics{WorkerID: id, Shard: i, Status: WorkerWaiting}
+		value, reported := values[id]
+		if reported {
+			if err = json.Unmarshal([]byte(value), m); err != nil {
+				return nil, fmt.Errorf("decode metrics of worker %s: %w", id, err)
+			}
+		}
+
+		if !m.Finished {
+			info, ok := infos[id]
+			switch {
+			case !ok || info.Status == WorkerOffline:
+				m.Status, m.Error, m.Finished = WorkerOffline, "worker offline", true
+			case lost && info.RecordID != run.RecordID:
+				m.Status, m.Error, m.Finished = WorkerIdle, "assignment lost", true
+			}
+		}
+		metrics.Finished = metrics.Finished && m.Finished
+		metrics.Workers[i] = m
+	}
+
+	metrics.merge()
+	return metrics, nil
+}
+
+// Cleanup 回放结束后删除分片信息及停止标记, 上报的数据保留到过期
+func Cleanup(ctx context.Context, recordID int32) {
+	if !cache.IsValid() {
+		return
+	}
+	_, _ = cache.Del(ctx, runKey(recordID))
+	_, _ = cache.Del(ctx, stopKey(recordID))
+}
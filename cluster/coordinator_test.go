@@ -0,0 +1,148 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	cache "record-traffic-press/config/redis"
+	"record-traffic-press/goreplay/settings"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShardSettings(t *testing.T) {
+	var appSettings settings.AppSettings
+	appSettings.InputFile = []string{"a.gor", "b.gor", "c.gor"}
+	appSettings.SLO.Abort = true
+
+	first := shardSettings(appSettings, ShardByFile, 0, 2)
+	second := shardSettings(appSettings, ShardByFile, 1, 2)
+	if !reflect.DeepEqual(first.InputFile, []string{"a.gor", "c.gor"}) || !reflect.DeepEqual(second.InputFile, []string{"b.gor"}) {
+		t.Errorf("Unexpected files %q and %q", first.InputFile, second.InputFile)
+	}
+	if first.SLO.Abort || second.SLO.Abort {
+		t.Error("The workers must not abort on their own SLO")
+	}
+
+	byID := shardSettings(appSettings, ShardByID, 1, 3)
+	if byID.InputShard != 1 || byID.InputShards != 3 || len(byID.InputFile) != 3 {
+		t.Errorf("Unexpected shard %d/%d of %q", byID.InputShard, byID.InputShards, byID.InputFile)
+	}
+
+	if len(appSettings.InputFile) != 3 || !appSettings.SLO.Abort {
+		t.Error("The original settings must not change")
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	server.FlushAll()
+	ctx := context.Background()
+	now := time.Now()
+	registerWorker(t, "w1", WorkerIdle, now)
+	registerWorker(t, "w2", WorkerIdle, now)
+	registerWorker(t, "w3", WorkerRunning, now)
+	registerWorker(t, "w4", WorkerIdle, now.Add(-2*workerOfflineAfter))
+
+	var appSettings settings.AppSettings
+	appSettings.InputFile = []string{"a.gor"}
+	run, err := Dispatch(ctx, 7, appSettings, DispatchOption{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(run.Workers, []string{"w1", "w2"}) || run.ShardBy != ShardByID {
+		t.Errorf("Unexpected run %+v", run)
+	}
+
+	for i, id := range run.Workers {
+		value, err := cache.BRPop(ctx, time.Second, assignKey(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var a Assignment
+		if err = json.Unmarshal([]byte(value), &a); err != nil {
+			t.Fatal(err)
+		}
+		if a.RecordID != 7 || a.Shard != i || a.Shards != 2 || a.StartAt != run.StartAt || a.Settings.InputShard != i || a.Settings.InputShards != 2 {
+			t.Errorf("Unexpected assignment %+v", a)
+		}
+	}
+
+	if stored, err := GetRun(ctx, 7); err != nil || !reflect.DeepEqual(stored, run) {
+		t.Errorf("Expected the run to be stored, got %+v, %v", stored, err)
+	}
+
+	// 已下发的 worker 不能再被选中
+	workers, err := Workers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range workers {
+		if (w.ID == "w1" || w.ID == "w2") && (w.Status != WorkerWaiting || w.RecordID != 7) {
+			t.Errorf("Expected %s to wait for record 7, got %+v", w.ID, w)
+		}
+	}
+	if _, err = Dispatch(ctx, 8, appSettings, DispatchOption{Workers: 1}); err == nil {
+		t.Error("Should fail without idle workers")
+	}
+}
+
+func TestDispatchLocked(t *testing.T) {
+	server.FlushAll()
+	ctx := context.Background()
+	registerWorker(t, "w1", WorkerIdle, time.Now())
+
+	locked, value := cache.Lock(ctx, dispatchLockKey, time.Minute)
+	if !locked {
+		t.Fatal("Expected to get the lock")
+	}
+
+	if _, err := Dispatch(ctx, 7, settings.AppSettings{}, DispatchOption{Workers: 1}); err == nil {
+		t.Error("Should fail while another dispatch holds the lock")
+	}
+
+	cache.UnLock(ctx, dispatchLockKey, value)
+	if _, err := Dispatch(ctx, 7, settings.AppSettings{}, DispatchOption{Workers: 1}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	server.FlushAll()
+	ctx := context.Background()
+	now := time.Now()
+	registerWorker(t, "w1", WorkerIdle, now)
+	registerWorker(t, "w2", WorkerRunning, now)
+	registerWorker(t, "w3", WorkerRunning, now.Add(-2*workerOfflineAfter))
+
+	run := &Run{RecordID: 7, ShardBy: ShardByID, Workers: []string{"w1", "w2", "w3"}, StartAt: now.UnixMilli()}
+	report := func(m *WorkerMetrics) {
+		if err := cache.HSet(ctx, metricsKey(run.RecordID), m.WorkerID, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report(&WorkerMetrics{WorkerID: "w1", Shard: 0, Status: WorkerIdle, Finished: true})
+	report(&WorkerMetrics{WorkerID: "w2", Shard: 1, Status: WorkerRunning})
+
+	metrics, err := Collect(ctx, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.Finished {
+		t.Error("w2 is still running")
+	}
+	if w := metrics.Workers[0]; !w.Finished || w.Error != "" {
+		t.Errorf("Unexpected w1 %+v", w)
+	}
+	if w := metrics.Workers[1]; w.Finished || w.Status != WorkerRunning {
+		t.Errorf("Unexpected w2 %+v", w)
+	}
+	// 心跳超时的 worker 视为结束
+	if w := metrics.Workers[2]; !w.Finished || w.Status != WorkerOffline || w.Shard != 2 {
+		t.Errorf("Unexpected w3 %+v", w)
+	}
+
+	report(&WorkerMetrics{WorkerID: "w2", Shard: 1, Status: WorkerIdle, Finished: true})
+	if metrics, err = Collect(ctx, run); err != nil || !metrics.Finished {
+		t.Errorf("Expected the run to be finished, got %+v, %v", metrics, err)
+	}
+}
@@ -0,0 +1,291 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	cache "record-traffic-press/config/redis"
+	"record-traffic-press/goreplay/bootstrap"
+	"record-traffic-press/goreplay/proto"
+	"sync"
+	"time"
+)
+
+// Worker 执行协调者下发的分片任务, 同一时间只执行一个
+type Worker struct {
+	info WorkerInfo
+
+	// reportMu 保证最终数据之后不会再上报进行中的数据
+	reportMu   sync.Mutex
+	mu         sync.Mutex
+	assignment *Assignment
+	abort      chan struct{} // 等待开始时收到停止请求
+	task       *bootstrap.Task
+	samples    map[string][]*proto.DiffRecord
+}
+
+// NewWorker 创建 worker, id 在集群内唯一, 通常为 主机名-进程号
+func NewWorker(id, host string, pid int) *Worker {
+	return &Worker{info: WorkerInfo{ID: id, Host: host, PID: pid, Status: WorkerIdle, StartedAt: time.Now().Unix()}}
+}
+
+// Run 注册 worker 并等待任务, ctx 取消后停止正在执行的任务并注销
+func (w *Worker) Run(ctx context.Context) error {
+	if err := w.heartbeat(ctx); err != nil {
+		return err
+	}
+	logrus.Infof("cluster worker %s registered", w.info.ID)
+
+	go w.heartbeatLoop(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.shutdown()
+			return nil
+		default:
+		}
+
+		value, err := cache.BRPop(ctx, heartbeatInterval, assignKey(w.info.ID))
+		if errors.Is(err, cache.ValNotExist) || errors.Is(err, context.Canceled) {
+			continue
+		}
+		if err != nil {
+			logrus.Errorf("cluster worker %s receive assignment failed. err:%v", w.info.ID, err)
+			time.Sleep(heartbeatInterval)
+			continue
+		}
+
+		var a Assignment
+		if err = json.Unmarshal([]byte(value), &a); err != nil {
+			logrus.Errorf("cluster worker %s decode assignment failed. err:%v", w.info.ID, err)
+			continue
+		}
+		go w.start(ctx, &a)
+	}
+}
+
+// start 等到统一开始时间后启动分片任务
+func (w *Worker) start(ctx context.Context, a *Assignment) {
+	w.mu.Lock()
+	if w.assignment != nil {
+		w.mu.Unlock()
+		w.report(ctx, &WorkerMetrics{Shard: a.Shard, Finished: true, Error: "worker is busy"}, a.RecordID)
+		return
+	}
+	w.assignment = a
+	w.abort = make(chan struct{})
+	w.samples = make(map[string][]*proto.DiffRecord)
+	abort := w.abort
+	w.mu.Unlock()
+
+	logrus.Infof("cluster worker %s got shard %d/%d of record %d, starts at %s",
+		w.info.ID, a.Shard, a.Shards, a.RecordID, time.UnixMilli(a.StartAt).Format(time.RFC3339Nano))
+
+	timer := time.NewTimer(time.Until(time.UnixMilli(a.StartAt)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		w.finishAssignment(ctx, "worker stopped")
+		return
+	case <-abort:
+		w.finishAssignment(ctx, "stopped before start")
+		return
+	case <-timer.C:
+	}
+
+	settings := a.Settings
+	settings.OutputDiffConfig.Handler = w.addSample
+
+	task, err := bootstrap.StartTask(a.RecordID, settings, func(task *bootstrap.Task) {
+		w.finish(ctx, task)
+	})
+	if err != nil {
+		logrus.Errorf("cluster worker %s start record %d failed. err:%v", w.info.ID, a.RecordID, err)
+		w.finishAssignment(ctx, err.Error())
+		return
+	}
+
+	// 输入很快读完时任务可能已经结束
+	w.mu.Lock()
+	if w.assignment == a && bootstrap.IsTaskRunning(a.RecordID) {
+		w.task = task
+	}
+	w.mu.Unlock()
+}
+
+func (w *Worker) addSample(record *proto.DiffRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.samples[record.Endpoint]) < maxDiffSamples {
+		w.samples[record.Endpoint] = append(w.samples[record.Endpoint], record)
+	}
+}
+
+// finish 任务结束回调, 上报最终数据
+func (w *Worker) finish(ctx context.Context, task *bootstrap.Task) {
+	w.reportMu.Lock()
+	defer w.reportMu.Unlock()
+
+	w.mu.Lock()
+	a := w.assignment
+	metrics := w.collectLocked(task)
+	w.mu.Unlock()
+
+	if a == nil {
+		return
+	}
+	metrics.Finished = true
+	// ctx 可能已取消, 最终数据仍需上报
+	w.report(context.Background(), metrics, a.RecordID)
+
+	w.mu.Lock()
+	w.assignment, w.abort, w.task, w.samples = nil, nil, nil, nil
+	w.mu.Unlock()
+
+	logrus.Infof("cluster worker %s finished record %d", w.info.ID, a.RecordID)
+	_ = w.heartbeat(ctx)
+}
+
+// finishAssignment 任务未能启动时上报错误
+func (w *Worker) finishAssignment(ctx context.Context, reason string) {
+	w.reportMu.Lock()
+	defer w.reportMu.Unlock()
+
+	w.mu.Lock()
+	a := w.assignment
+	w.assignment, w.abort, w.task, w.samples = nil, nil, nil, nil
+	w.mu.Unlock()
+
+	if a != nil {
+		w.report(context.Background(), &WorkerMetrics{Shard: a.Shard, Finished: true, Error: reason}, a.RecordID)
+	}
+	_ = w.heartbeat(ctx)
+}
+
+// collectLocked 收集任务当前的回放数据, w.mu 需已加锁
+func (w *Worker) collectLocked(task *bootstrap.Task) *WorkerMetrics {
+	metrics := &WorkerMetrics{Status: WorkerRunning}
+	if w.assignment != nil {
+		metrics.Shard = w.assignment.Shard
+	}
+	if task != nil {
+		metrics.Latencies = task.Pipeline.LatencySnapshots()
+		metrics.Diff = task.Pipeline.DiffStats()
+		metrics.Stages = task.Pipeline.LoadStages()
+	}
+	metrics.Samples = make(map[string][]*proto.DiffRecord, len(w.samples))
+	for endpoint, records := range w.samples {
+		metrics.Samples[endpoint] = append([]*proto.DiffRecord(nil), records...)
+	}
+	return metrics
+}
+
+func (w *Worker) report(ctx context.Context, metrics *WorkerMetrics, recordID int32) {
+	metrics.WorkerID = w.info.ID
+	metrics.UpdatedAt = time.Now().UnixMilli()
+	if metrics.Finished {
+		metrics.Status = WorkerIdle
+	}
+
+	if err := cache.HSet(ctx, metricsKey(recordID), w.info.ID, metrics); err != nil {
+		logrus.Errorf("cluster worker %s report record %d failed. err:%v", w.info.ID, recordID, err)
+		return
+	}
+	_ = cache.Expire(ctx, metricsKey(recordID), keyExpiration)
+}
+
+// heartbeat 更新注册信息
+func (w *Worker) heartbeat(ctx context.Context) error {
+	w.mu.Lock()
+	info := w.info
+	info.Status, info.RecordID = WorkerIdle, 0
+	if w.assignment != nil {
+		info.RecordID = w.assignment.RecordID
+		info.Status = WorkerWaiting
+		if w.task != nil {
+			info.Status = WorkerRunning
+		}
+	}
+	w.mu.Unlock()
+
+	info.Heartbeat = time.Now().UnixMilli()
+	if err := cache.HSet(ctx, workersKey, info.ID, &info); err != nil {
+		return fmt.Errorf("cluster worker %s heartbeat failed: %w", info.ID, err)
+	}
+	return nil
+}
+
+// heartbeatLoop 定时心跳, 执行任务时同时上报数据并检查是否需要停止
+func (w *Worker) heartbeatLoop(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := w.heartbeat(ctx); err != nil {
+			logrus.Error(err)
+		}
+		w.reportRunning(ctx)
+	}
+}
+
+// reportRunning 上报进行中任务的数据, 收到停止请求时停止任务
+func (w *Worker) reportRunning(ctx context.Context) {
+	w.reportMu.Lock()
+	w.mu.Lock()
+	a, task, abort := w.assignment, w.task, w.abort
+	if a == nil {
+		w.mu.Unlock()
+		w.reportMu.Unlock()
+		return
+	}
+	metrics := w.collectLocked(task)
+	if task == nil {
+		metrics.Status = WorkerWaiting
+	}
+	w.mu.Unlock()
+	w.report(ctx, metrics, a.RecordID)
+	w.reportMu.Unlock()
+
+	if stop, err := cache.Exists(ctx, stopKey(a.RecordID)); err != nil || !stop {
+		return
+	}
+	logrus.Infof("cluster worker %s stops record %d", w.info.ID, a.RecordID)
+	if task != nil {
+		_ = bootstrap.StopTask(task.ID)
+		return
+	}
+	w.mu.Lock()
+	if w.assignment == a {
+		select {
+		case <-abort:
+		default:
+			close(abort)
+		}
+	}
+	w.mu.Unlock()
+}
+
+// shutdown 停止正在执行的任务并注销
+func (w *Worker) shutdown() {
+	w.mu.Lock()
+	task := w.task
+	w.mu.Unlock()
+
+	if task != nil {
+		_ = bootstrap.StopTask(task.ID)
+	}
+	if err := cache.HDel(context.Background(), workersKey, w.info.ID); err != nil {
+		logrus.Errorf("cluster worker %s unregister failed. err:%v", w.info.ID, err)
+	}
+	logrus.Infof("cluster worker %s stopped", w.info.ID)
+}
@@ -23,30 +23,42 @@ var (
 func InitRedis() {
 	one.Do(func() {
 		conf.ReadFromLocal()
-		var ss = conf.GetAppConf().RedisConfig
-		redisClient := redis.NewClient(&redis.Options{
-			Network:      "tcp",
-			Addr:         fmt.Sprintf("%s:%d", ss.Host, conf.GetAppConf().RedisConfig.Port),
-			Password:     conf.GetAppConf().RedisConfig.Password,
-			DB:           conf.GetAppConf().RedisConfig.DB,
-			DialTimeout:  5 * time.Second,
-			ReadTimeout:  5 * time.Second,
-			WriteTimeout: 5 * time.Second,
-			PoolTimeout:  6 * time.Second,
-			PoolSize:     conf.GetAppConf().RedisConfig.MaxPoolSize,
-			MinIdleConns: conf.GetAppConf().RedisConfig.MinPoolSize,
-		})
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		_, err := redisClient.Ping(ctx).Result()
-		if err != nil {
-			logrus.Errorf("init redis fail username: %s, err:%v", "root", err)
-		} else {
-			valid = true
-			logrus.Infof("init redis success username: %+v", "root")
-		}
-		client = redisClient
+		connect(conf.GetAppConf().RedisConfig)
+	})
+}
+
+// InitRedisWithConfig 按给定配置初始化, 不读取本地配置文件, 如测试时连接 miniredis
+func InitRedisWithConfig(ss conf.RedisConfig) {
+	one.Do(func() {
+		conf.GetAppConf().RedisConfig = ss
+		connect(ss)
+	})
+}
+
+// connect 连接 Redis
+func connect(ss conf.RedisConfig) {
+	redisClient := redis.NewClient(&redis.Options{
+		Network:      "tcp",
+		Addr:         fmt.Sprintf("%s:%d", ss.Host, ss.Port),
+		Password:     ss.Password,
+		DB:           ss.DB,
+		DialTimeout:  5 * time.Second,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		PoolTimeout:  6 * time.Second,
+		PoolSize:     ss.MaxPoolSize,
+		MinIdleConns: ss.MinPoolSize,
 	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := redisClient.Ping(ctx).Result()
+	if err != nil {
+		logrus.Errorf("init redis fail username: %s, err:%v", "root", err)
+	} else {
+		valid = true
+		logrus.Infof("init redis success username: %+v", "root")
+	}
+	client = redisClient
 }
 
 // IsValid 是否可用
@@ -165,3 +177,95 @@ func UnLock(ctx context.Context, key string, expectValue string) bool {
 
 	return true
 }
+
+// withFix 拼接 key 前缀
+func withFix(key string) string {
+	return conf.GetAppConf().RedisConfig.Fix + key
+}
+
+// toString 字符串原样保存, 其他对象序列化为 JSON
+func toString(object interface{}) (string, error) {
+	if str, ok := object.(string); ok {
+		return str, nil
+	}
+	b, err := json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// HSet Redis-HSet方法
+func HSet(ctx context.Context, key string, field string, object interface{}) error {
+	if !IsValid() {
+		return RedisNotValid
+	}
+	value, err := toString(object)
+	if err != nil {
+		logrus.Errorf("Redis marshal Object:%s, field:%s, error:%v", key, field, err)
+		return err
+	}
+	return client.HSet(ctx, withFix(key), field, value).Err()
+}
+
+// HGetAll Redis-HGetAll方法
+func HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	if !IsValid() {
+		return nil, RedisNotValid
+	}
+	return client.HGetAll(ctx, withFix(key)).Result()
+}
+
+// HDel Redis-HDel方法
+func HDel(ctx context.Context, key string, fields ...string) error {
+	if !IsValid() {
+		return RedisNotValid
+	}
+	return client.HDel(ctx, withFix(key), fields...).Err()
+}
+
+// LPush Redis-LPush方法
+func LPush(ctx context.Context, key string, object interface{}) error {
+	if !IsValid() {
+		return RedisNotValid
+	}
+	value, err := toString(object)
+	if err != nil {
+		logrus.Errorf("Redis marshal Object:%s, error:%v", key, err)
+		return err
+	}
+	return client.LPush(ctx, withFix(key), value).Err()
+}
+
+// BRPop Redis-BRPop方法, 超时返回 ValNotExist
+func BRPop(ctx context.Context, timeout time.Duration, key string) (string, error) {
+	if !IsValid() {
+		return "", RedisNotValid
+	}
+	values, err := client.BRPop(ctx, timeout, withFix(key)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ValNotExist
+	}
+	if err != nil {
+		return "", err
+	}
+	// 返回值为 key 和 value
+	return values[1], nil
+}
+
+// Expire Redis-Expire方法
+func Expire(ctx context.Context, key string, expiration time.Duration) error {
+	if !IsValid() {
+		return RedisNotValid
+	}
+	return client.Expire(ctx, withFix(key), expiration).Err()
+}
+
+// Exists Redis-Exists方法
+func Exists(ctx context.Context, key string) (bool, error) {
+	if !IsValid() {
+		return false, RedisNotValid
+	}
+	n, err := client.Exists(ctx, withFix(key)).Result()
+	return n > 0, err
+}
@@ -21,3 +21,16 @@ var (
 	RecordStatusRecording = EnumType{Code: 2, Desc: "进行中"}
 	RecordStatusFinished  = EnumType{Code: 3, Desc: "结束"}
 )
+
+// 回放执行状态枚举
+var (
+	ReplayStatusRunning  = EnumType{Code: 1, Desc: "进行中"}
+	ReplayStatusFinished = EnumType{Code: 2, Desc: "结束"}
+	ReplayStatusFailed   = EnumType{Code: 3, Desc: "失败"}
+)
+
+// 压测结论枚举, 未配置 SLO 的任务为 0
+var (
+	RecordVerdictPassed = EnumType{Code: 1, Desc: "通过"}
+	RecordVerdictFailed = EnumType{Code: 2, Desc: "未通过"}
+)
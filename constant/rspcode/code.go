@@ -8,6 +8,18 @@ type RspCode struct {
 	Msg  string // 错误信息
 }
 
+// Response 携带数据的响应结构体
+type Response struct {
+	Code int32       // 错误码
+	Msg  string      // 错误信息
+	Data interface{} // 响应数据
+}
+
+// WithData 以当前错误码为信封返回数据
+func (e *RspCode) WithData(data interface{}) *Response {
+	return &Response{Code: e.Code, Msg: e.Msg, Data: data}
+}
+
 // 实现 error 接口
 func (e *RspCode) Error() string {
 	return fmt.Sprintf("Error %d: %s", e.Code, e.Msg)
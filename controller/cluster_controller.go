@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"record-traffic-press/cluster"
+	"record-traffic-press/constant/rspcode"
+	"record-traffic-press/goreplay/core"
+	settings2 "record-traffic-press/goreplay/settings"
+	"sync"
+	"time"
+)
+
+type ClusterController struct{}
+
+// clusterPollInterval 分布式回放汇总 worker 数据的间隔
+const clusterPollInterval = 2 * time.Second
+
+var (
+	clusterRunsMu sync.Mutex
+	clusterRuns   = make(map[int32]struct{}) // 当前进程中汇总的分布式回放, 按录制任务ID索引
+)
+
+// ClusterRunResult 分布式回放的 worker 执行情况
+type ClusterRunResult struct {
+	Run     *cluster.Run           `json:"run"`
+	Workers []*ClusterWorkerResult `json:"workers"`
+}
+
+// ClusterWorkerResult 单个 worker 的执行情况
+type ClusterWorkerResult struct {
+	WorkerID   string                 `json:"worker_id"`
+	Shard      int                    `json:"shard"`
+	Status     string                 `json:"status"`
+	Finished   bool                   `json:"finished"`
+	Error      string                 `json:"error,omitempty"`
+	UpdatedAt  int64                  `json:"updated_at"` // 最近一次上报时间(毫秒)
+	LoadStages []core.LoadStageReport `json:"load_stages,omitempty"`
+}
+
+// Workers 查询已注册的 worker 及其状态
+func (c ClusterController) Workers(context *gin.Context) {
+	workers, err := cluster.Workers(context.Request.Context())
+	if err != nil {
+		logrus.Errorf("list cluster workers failed. err:%v", err)
+		context.JSON(http.StatusOK, &rspcode.RspCode{Code: rspcode.SystemError.Code, Msg: err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, rspcode.Success.WithData(workers))
+}
+
+// startClusterRecord 把任务分发给 worker, 由 watchClusterRecord 汇总数据并在结束后更新任务
+func startClusterRecord(param RecordStartParam, settings settings2.AppSettings) error {
+	rules, err := core.ParseSLORules(settings.SLO.Rules)
+	if err != nil {
+		return err
+	}
+
+	run, err := cluster.Dispatch(context.Background(), param.ID, settings, cluster.DispatchOption{
+		Workers:    param.Workers,
+		ShardBy:    param.ShardBy,
+		StartDelay: time.Duration(param.StartDelay) * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+
+	clusterRunsMu.Lock()
+	clusterRuns[param.ID] = struct{}{}
+	clusterRunsMu.Unlock()
+
+	go watchClusterRecord(run, rules, settings.SLO)
+	return nil
+}
+
+// isClusterRecordWatched 分布式回放是否由当前进程汇总
+func isClusterRecordWatched(recordID int32) bool {
+	clusterRunsMu.Lock()
+	defer clusterRunsMu.Unlock()
+
+	_, ok := clusterRuns[recordID]
+	return ok
+}
+
+// watchClusterRecord 定时汇总 worker 数据, 违反 SLO 时提前停止, 全部 worker 结束后保存结果
+func watchClusterRecord(run *cluster.Run, rules []core.SLORule, slo settings2.SLOConfig) {
+	ticker := time.NewTicker(clusterPollInterval)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+	aborted := false
+	for range ticker.C {
+		metrics, err := cluster.Collect(ctx, run)
+		if err != nil {
+			logrus.Errorf("collect cluster run failed. id:%d, err:%v", run.RecordID, err)
+			continue
+		}
+
+		if !metrics.Finished {
+			if !slo.Abort || aborted {
+				continue
+			}
+			if verdict := metrics.SLOVerdict(rules, slo.MinRequests); verdict != nil && !verdict.Passed {
+				logrus.Infof("cluster run breached slo, stopping. id:%d", run.RecordID)
+				aborted = true
+				if err = cluster.StopRun(ctx, run.RecordID); err != nil {
+					logrus.Errorf("stop cluster run failed. id:%d, err:%v", run.RecordID, err)
+				}
+			}
+			continue
+		}
+
+		finishClusterRecord(metrics, rules, aborted)
+		return
+	}
+}
+
+// finishClusterRecord 保存合并后的回放结果及 SLO 结论
+func finishClusterRecord(metrics *cluster.RunMetrics, rules []core.SLORule, aborted bool) {
+	recordID := metrics.Run.RecordID
+	for _, w := range metrics.Workers {
+		if w.Error != "" {
+			logrus.Errorf("cluster worker failed. id:%d, worker:%s, shard:%d, err:%s", recordID, w.WorkerID, w.Shard, w.Error)
+		}
+	}
+
+	addReplaySamples(recordID, metrics.Samples())
+	finishReplayRun(recordID, metrics)
+
+	verdict := metrics.SLOVerdict(rules, 0)
+	if verdict != nil && aborted {
+		verdict.Passed, verdict.Aborted = false, true
+	}
+	if err := finishRecordByID(recordID, time.Now(), verdict); err != nil {
+		logrus.Errorf("finish record traffic failed. id:%d, err:%v", recordID, err)
+	}
+
+	cluster.Cleanup(context.Background(), recordID)
+
+	clusterRunsMu.Lock()
+	delete(clusterRuns, recordID)
+	clusterRunsMu.Unlock()
+}
+
+// clusterRunResult 查询分布式回放的 worker 执行情况, 非分布式回放返回 nil
+func clusterRunResult(ctx context.Context, recordID int32) (*ClusterRunResult, *cluster.RunMetrics) {
+	run, err := cluster.GetRun(ctx, recordID)
+	if err != nil || run == nil {
+		return nil, nil
+	}
+	metrics, err := cluster.Collect(ctx, run)
+	if err != nil {
+		logrus.Errorf("collect cluster run failed. id:%d, err:%v", recordID, err)
+		return &ClusterRunResult{Run: run}, nil
+	}
+
+	result := &ClusterRunResult{Run: run, Workers: make([]*ClusterWorkerResult, 0, len(metrics.Workers))}
+	for _, w := range metrics.Workers {
+		result.Workers = append(result.Workers, &ClusterWorkerResult{
+			WorkerID:   w.WorkerID,
+			Shard:      w.Shard,
+			Status:     w.Status,
+			Finished:   w.Finished,
+			Error:      w.Error,
+			UpdatedAt:  w.UpdatedAt,
+			LoadStages: w.Stages,
+		})
+	}
+	return result, metrics
+}
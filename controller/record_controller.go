@@ -4,9 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 	"net/http"
+	"record-traffic-press/cluster"
 	"record-traffic-press/constant/common"
 	"record-traffic-press/constant/rspcode"
+	"record-traffic-press/goreplay/bootstrap"
+	"record-traffic-press/goreplay/core"
 	settings2 "record-traffic-press/goreplay/settings"
 	"record-traffic-press/model"
 	"time"
@@ -14,6 +18,63 @@ import (
 
 type RecordController struct{}
 
+const (
+	defaultPageSize = 20  // 默认每页条数
+	maxPageSize     = 100 // 每页最大条数
+)
+
+// RecordIDParam 录制任务ID参数
+type RecordIDParam struct {
+	ID int32 `json:"id" form:"id" binding:"required"`
+}
+
+// RecordStartParam 启动录制任务参数, Workers 大于0时分发给多个 worker 分布式回放
+type RecordStartParam struct {
+	ID         int32  `json:"id" binding:"required"`
+	Workers    int    `json:"workers"`     // 分布式回放使用的 worker 数, 0 表示在本机执行
+	ShardBy    string `json:"shard_by"`    // 分片方式, file: 按输入文件, id: 按请求ID哈希, 默认 id
+	StartDelay int64  `json:"start_delay"` // 下发后多少秒统一开始, 默认5秒
+}
+
+// RecordListParam 录制任务列表查询参数
+type RecordListParam struct {
+	Status    int32 `form:"status"`     // 状态, 0 表示全部
+	StartTime int64 `form:"start_time"` // 创建时间起始(含)
+	EndTime   int64 `form:"end_time"`   // 创建时间截止(含)
+	Page      int   `form:"page"`       // 页码, 从1开始
+	PageSize  int   `form:"page_size"`  // 每页条数
+}
+
+// RecordListResult 录制任务列表
+type RecordListResult struct {
+	List     []*model.RecordTraffic `json:"list"`
+	Total    int64                  `json:"total"`
+	Page     int                    `json:"page"`
+	PageSize int                    `json:"page_size"`
+}
+
+// RecordDetailResult 录制任务详情, 附带解码后的配置
+type RecordDetailResult struct {
+	*model.RecordTraffic
+	AppSettings *settings2.AppSettings `json:"app_settings"`
+	// BPFFilters 进行中任务实际生效的 BPF 过滤规则, 按 input-raw 地址及网卡分组
+	BPFFilters map[string]map[string]string `json:"bpf_filters,omitempty"`
+	// LoadStages 进行中任务的压测阶段及实际达到的 RPS
+	LoadStages []core.LoadStageReport `json:"load_stages,omitempty"`
+	// ScheduleLags 进行中任务开环回放输出(output-http-open-loop)落后于计划发送时间的情况, 按输出分组
+	ScheduleLags map[string]core.ScheduleLag `json:"schedule_lags,omitempty"`
+	// SLOVerdict 进行中任务按目前数据评估的 SLO 结论
+	SLOVerdict *core.SLOVerdict `json:"slo_verdict,omitempty"`
+	// Cluster 进行中的分布式回放各 worker 的执行情况
+	Cluster *ClusterRunResult `json:"cluster,omitempty"`
+}
+
+// RecordEditParam 录制任务修改参数
+type RecordEditParam struct {
+	ID       int32                 `json:"id" binding:"required"`
+	Settings settings2.AppSettings `json:"settings"`
+}
+
 func (r RecordController) Index(context *gin.Context) {
 	username, _ := context.Get("username")
 	fmt.Println(username)
@@ -27,30 +88,100 @@ func (r RecordController) Index(context *gin.Context) {
 	}
 }
 
+// List 分页查询录制任务, 支持按状态及创建时间范围过滤
 func (r RecordController) List(context *gin.Context) {
-	username, _ := context.Get("username")
-	fmt.Println(username)
+	var param RecordListParam
 
-	//类型断言
-	v, ok := username.(string)
-	if ok {
-		context.String(200, "用户列表--"+v)
-	} else {
-		context.String(200, "用户列表--获取用户失败")
+	if err := context.ShouldBindQuery(&param); err != nil {
+		context.JSON(http.StatusOK, rspcode.InvalidParameter)
+		return
+	}
+
+	if param.Page < 1 {
+		param.Page = 1
 	}
+	if param.PageSize < 1 {
+		param.PageSize = defaultPageSize
+	}
+	if param.PageSize > maxPageSize {
+		param.PageSize = maxPageSize
+	}
+	if param.StartTime > 0 && param.EndTime > 0 && param.StartTime > param.EndTime {
+		context.JSON(http.StatusOK, rspcode.InvalidParameterLawful)
+		return
+	}
+
+	list, total, err := model.GetRecordTrafficDAO().List(&model.RecordTrafficQuery{
+		Status:    param.Status,
+		StartTime: param.StartTime,
+		EndTime:   param.EndTime,
+		Page:      param.Page,
+		PageSize:  param.PageSize,
+	})
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+	for _, recordTraffic := range list {
+		redactRecordSettings(recordTraffic)
+	}
+
+	context.JSON(http.StatusOK, rspcode.Success.WithData(&RecordListResult{
+		List:     list,
+		Total:    total,
+		Page:     param.Page,
+		PageSize: param.PageSize,
+	}))
 }
 
+// Detail 查询录制任务详情, 配置信息解码为 AppSettings 返回
 func (r RecordController) Detail(context *gin.Context) {
-	username, _ := context.Get("username")
-	fmt.Println(username)
+	var param RecordIDParam
 
-	//类型断言
-	v, ok := username.(string)
-	if ok {
-		context.String(200, "用户列表--"+v)
-	} else {
-		context.String(200, "用户列表--获取用户失败")
+	if err := context.ShouldBindQuery(&param); err != nil {
+		context.JSON(http.StatusOK, rspcode.InvalidParameter)
+		return
+	}
+
+	recordTraffic, err := model.GetRecordTrafficDAO().GetByID(param.ID)
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+	if recordTraffic == nil {
+		context.JSON(http.StatusOK, rspcode.NotExist)
+		return
+	}
+
+	var settings settings2.AppSettings
+	if err = json.Unmarshal([]byte(recordTraffic.Settings), &settings); err != nil {
+		logrus.Errorf("unmarshal record traffic settings failed. id:%d, err:%v", param.ID, err)
+		context.JSON(http.StatusOK, rspcode.DataWrong)
+		return
+	}
+
+	redacted := settings.Redact()
+	redactRecordSettings(recordTraffic)
+	result := &RecordDetailResult{
+		RecordTraffic: recordTraffic,
+		AppSettings:   &redacted,
 	}
+	if task, ok := bootstrap.GetTask(param.ID); ok {
+		result.BPFFilters = task.Pipeline.BPFFilters()
+		result.LoadStages = task.Pipeline.LoadStages()
+		result.ScheduleLags = task.Pipeline.ScheduleLags()
+		result.SLOVerdict = task.Pipeline.SLOVerdict()
+	} else if recordTraffic.Status == common.RecordStatusRecording.Code {
+		var metrics *cluster.RunMetrics
+		result.Cluster, metrics = clusterRunResult(context.Request.Context(), param.ID)
+		if metrics != nil {
+			if rules, err := core.ParseSLORules(settings.SLO.Rules); err == nil {
+				result.SLOVerdict = metrics.SLOVerdict(rules, 0)
+			}
+		}
+	}
+
+	context.JSON(http.StatusOK, rspcode.Success.WithData(result))
 }
 
 func (r RecordController) Add(context *gin.Context) {
@@ -58,11 +189,23 @@ func (r RecordController) Add(context *gin.Context) {
 	var settings settings2.AppSettings
 
 	if err := context.ShouldBindJSON(&settings); err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		context.JSON(http.StatusOK, rspcode.InvalidParameter)
+		return
+	}
+	if _, err := core.ParseSLORules(settings.SLO.Rules); err != nil {
+		context.JSON(http.StatusOK, &rspcode.RspCode{Code: rspcode.InvalidParameter.Code, Msg: err.Error()})
+		return
+	}
+	if err := core.CheckLoadProfile(&settings.LoadProfile); err != nil {
+		context.JSON(http.StatusOK, &rspcode.RspCode{Code: rspcode.InvalidParameter.Code, Msg: err.Error()})
 		return
 	}
 
 	settingsJson, err := json.Marshal(settings)
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.InvalidParameterLawful)
+		return
+	}
 
 	recordTraffic := model.RecordTraffic{
 		BaseModel: model.BaseModel{
@@ -71,10 +214,8 @@ func (r RecordController) Add(context *gin.Context) {
 			UpdateTime: time.Now().Unix(),
 			OrderId:    &common.NumberZero,
 		},
-		StartTime: time.Now().Unix(),
-		EndTime:   time.Now().Unix(),
-		Settings:  string(settingsJson),
-		Status:    common.RecordStatusInit.Code,
+		Settings: string(settingsJson),
+		Status:   common.RecordStatusInit.Code,
 	}
 
 	err = model.GetRecordTrafficDAO().Insert(&recordTraffic)
@@ -84,18 +225,272 @@ func (r RecordController) Add(context *gin.Context) {
 		return
 	}
 
-	context.JSON(http.StatusOK, rspcode.Success)
+	context.JSON(http.StatusOK, rspcode.Success.WithData(gin.H{"id": recordTraffic.ID}))
 }
 
+// Edit 修改录制任务配置, 仅初始化状态的任务允许修改
 func (r RecordController) Edit(context *gin.Context) {
-	username, _ := context.Get("username")
-	fmt.Println(username)
+	var param RecordEditParam
 
-	//类型断言
-	v, ok := username.(string)
-	if ok {
-		context.String(200, "用户列表--"+v)
+	if err := context.ShouldBindJSON(&param); err != nil {
+		context.JSON(http.StatusOK, rspcode.InvalidParameter)
+		return
+	}
+
+	recordTraffic, err := model.GetRecordTrafficDAO().GetByID(param.ID)
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+	if recordTraffic == nil {
+		context.JSON(http.StatusOK, rspcode.NotExist)
+		return
+	}
+	if recordTraffic.Status != common.RecordStatusInit.Code {
+		context.JSON(http.StatusOK, rspcode.DataWrong)
+		return
+	}
+	if _, err = core.ParseSLORules(param.Settings.SLO.Rules); err != nil {
+		context.JSON(http.StatusOK, &rspcode.RspCode{Code: rspcode.InvalidParameter.Code, Msg: err.Error()})
+		return
+	}
+	if err = core.CheckLoadProfile(&param.Settings.LoadProfile); err != nil {
+		context.JSON(http.StatusOK, &rspcode.RspCode{Code: rspcode.InvalidParameter.Code, Msg: err.Error()})
+		return
+	}
+
+	// 详情中的密钥已脱敏, 提交回来的脱敏值沿用已保存的配置
+	var stored settings2.AppSettings
+	if err = json.Unmarshal([]byte(recordTraffic.Settings), &stored); err != nil {
+		logrus.Errorf("unmarshal record traffic settings failed. id:%d, err:%v", param.ID, err)
+		context.JSON(http.StatusOK, rspcode.DataWrong)
+		return
+	}
+	param.Settings.RestoreSecrets(&stored)
+
+	settingsJson, err := json.Marshal(param.Settings)
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.InvalidParameterLawful)
+		return
+	}
+
+	// 以初始化状态为条件更新, 避免覆盖并发启动的任务
+	ok, err := model.GetRecordTrafficDAO().UpdateStatus(param.ID, common.RecordStatusInit.Code, map[string]interface{}{
+		"settings":    string(settingsJson),
+		"update_time": time.Now().Unix(),
+	})
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+	if !ok {
+		context.JSON(http.StatusOK, rspcode.DataWrong)
+		return
+	}
+
+	context.JSON(http.StatusOK, rspcode.Success)
+}
+
+// Delete 软删除录制任务, 进行中的任务需先停止
+func (r RecordController) Delete(context *gin.Context) {
+	var param RecordIDParam
+
+	if err := context.ShouldBindJSON(&param); err != nil {
+		context.JSON(http.StatusOK, rspcode.InvalidParameter)
+		return
+	}
+
+	recordTraffic, err := model.GetRecordTrafficDAO().GetByID(param.ID)
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+	if recordTraffic == nil {
+		context.JSON(http.StatusOK, rspcode.NotExist)
+		return
+	}
+
+	ok, err := model.GetRecordTrafficDAO().Delete(param.ID, time.Now().Unix())
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+	if !ok {
+		context.JSON(http.StatusOK, rspcode.DataWrong)
+		return
+	}
+
+	context.JSON(http.StatusOK, rspcode.Success)
+}
+
+// Start 启动录制任务, 任务从初始化状态进入进行中状态
+func (r RecordController) Start(context *gin.Context) {
+	var param RecordStartParam
+
+	if err := context.ShouldBindJSON(&param); err != nil {
+		context.JSON(http.StatusOK, rspcode.InvalidParameter)
+		return
+	}
+
+	recordTraffic, err := model.GetRecordTrafficDAO().GetByID(param.ID)
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+	if recordTraffic == nil {
+		context.JSON(http.StatusOK, rspcode.NotExist)
+		return
+	}
+	if recordTraffic.Status != common.RecordStatusInit.Code {
+		context.JSON(http.StatusOK, rspcode.DataWrong)
+		return
+	}
+
+	var settings settings2.AppSettings
+	if err = json.Unmarshal([]byte(recordTraffic.Settings), &settings); err != nil {
+		logrus.Errorf("unmarshal record traffic settings failed. id:%d, err:%v", param.ID, err)
+		context.JSON(http.StatusOK, rspcode.DataWrong)
+		return
+	}
+
+	// 先抢占状态, 避免同一任务被重复启动
+	ok, err := model.GetRecordTrafficDAO().UpdateStatus(param.ID, common.RecordStatusInit.Code, map[string]interface{}{
+		"status":      common.RecordStatusRecording.Code,
+		"start_time":  time.Now().Unix(),
+		"update_time": time.Now().Unix(),
+	})
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+	if !ok {
+		context.JSON(http.StatusOK, rspcode.DataWrong)
+		return
+	}
+
+	if err = startReplayRun(param.ID, &settings); err != nil {
+		logrus.Errorf("start replay run failed. id:%d, err:%v", param.ID, err)
+	}
+
+	if param.Workers > 0 {
+		err = startClusterRecord(param, settings)
 	} else {
-		context.String(200, "用户列表--获取用户失败")
+		_, err = bootstrap.StartTask(param.ID, settings, finishRecord)
+	}
+	if err != nil {
+		logrus.Errorf("start record traffic failed. id:%d, err:%v", param.ID, err)
+		failReplayRun(param.ID)
+
+		// 启动失败, 回滚为初始化状态
+		_, _ = model.GetRecordTrafficDAO().UpdateStatus(param.ID, common.RecordStatusRecording.Code, map[string]interface{}{
+			"status":      common.RecordStatusInit.Code,
+			"start_time":  0,
+			"update_time": time.Now().Unix(),
+		})
+
+		context.JSON(http.StatusOK, &rspcode.RspCode{Code: rspcode.Fail.Code, Msg: err.Error()})
+		return
+	}
+
+	context.JSON(http.StatusOK, rspcode.Success)
+}
+
+// Stop 停止录制任务, 任务从进行中状态进入结束状态
+func (r RecordController) Stop(context *gin.Context) {
+	var param RecordIDParam
+
+	if err := context.ShouldBindJSON(&param); err != nil {
+		context.JSON(http.StatusOK, rspcode.InvalidParameter)
+		return
+	}
+
+	recordTraffic, err := model.GetRecordTrafficDAO().GetByID(param.ID)
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+	if recordTraffic == nil {
+		context.JSON(http.StatusOK, rspcode.NotExist)
+		return
+	}
+	if recordTraffic.Status != common.RecordStatusRecording.Code {
+		context.JSON(http.StatusOK, rspcode.DataWrong)
+		return
+	}
+
+	// 任务结束时由 finishRecord 更新状态
+	if err = bootstrap.StopTask(param.ID); err == nil {
+		context.JSON(http.StatusOK, rspcode.Success)
+		return
+	}
+
+	// 分布式回放通知 worker 停止, 由 watchClusterRecord 汇总结果后更新状态
+	run, _ := cluster.GetRun(context.Request.Context(), param.ID)
+	if run != nil {
+		if err = cluster.StopRun(context.Request.Context(), param.ID); err != nil {
+			context.JSON(http.StatusOK, &rspcode.RspCode{Code: rspcode.SystemError.Code, Msg: err.Error()})
+			return
+		}
+		if isClusterRecordWatched(param.ID) {
+			context.JSON(http.StatusOK, rspcode.Success)
+			return
+		}
+		cluster.Cleanup(context.Request.Context(), param.ID)
+	}
+
+	// 任务不在当前进程中运行(例如服务重启过), 直接标记为结束, 回放结果已无法汇总
+	failReplayRun(param.ID)
+	if err = finishRecordByID(param.ID, time.Now(), nil); err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+
+	context.JSON(http.StatusOK, rspcode.Success)
+}
+
+// finishRecord 录制任务结束回调, 记录真实的结束时间、SLO 结论并保存回放比对结果
+func finishRecord(task *bootstrap.Task) {
+	finishReplayRun(task.ID, task.Pipeline)
+
+	if err := finishRecordByID(task.ID, time.Now(), task.Pipeline.SLOVerdict()); err != nil {
+		logrus.Errorf("finish record traffic failed. id:%d, err:%v", task.ID, err)
+	}
+}
+
+// finishRecordByID 标记任务结束, verdict 为空表示未配置 SLO 或无法评估
+func finishRecordByID(id int32, endTime time.Time, verdict *core.SLOVerdict) error {
+	values := map[string]interface{}{
+		"status":      common.RecordStatusFinished.Code,
+		"end_time":    endTime.Unix(),
+		"update_time": time.Now().Unix(),
+	}
+
+	if verdict != nil {
+		values["verdict"] = common.RecordVerdictFailed.Code
+		if verdict.Passed {
+			values["verdict"] = common.RecordVerdictPassed.Code
+		}
+		if detail, err := json.Marshal(verdict); err == nil {
+			values["verdict_detail"] = string(detail)
+		}
+	}
+
+	_, err := model.GetRecordTrafficDAO().UpdateStatus(id, common.RecordStatusRecording.Code, values)
+
+	return err
+}
+
+// redactRecordSettings 将返回给前端的录制任务配置中的密钥(JWT 密钥, MySQL 密码)脱敏, 解析失败时不返回配置
+func redactRecordSettings(recordTraffic *model.RecordTraffic) {
+	var settings settings2.AppSettings
+	if err := json.Unmarshal([]byte(recordTraffic.Settings), &settings); err != nil {
+		recordTraffic.Settings = ""
+		return
+	}
+	settingsJson, err := json.Marshal(settings.Redact())
+	if err != nil {
+		recordTraffic.Settings = ""
+		return
 	}
+	recordTraffic.Settings = string(settingsJson)
 }
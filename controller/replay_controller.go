@@ -0,0 +1,364 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"record-traffic-press/constant/common"
+	"record-traffic-press/constant/rspcode"
+	"record-traffic-press/goreplay/core"
+	"record-traffic-press/goreplay/proto"
+	settings2 "record-traffic-press/goreplay/settings"
+	"record-traffic-press/model"
+	"sync"
+	"time"
+)
+
+type ReplayController struct{}
+
+// maxDiffSamples 每个接口保存的不一致示例数
+const maxDiffSamples = 5
+
+// ReplayListParam 回放记录列表查询参数
+type ReplayListParam struct {
+	RecordID int32 `form:"record_id" binding:"required"` // 录制任务ID
+	Page     int   `form:"page"`                         // 页码, 从1开始
+	PageSize int   `form:"page_size"`                    // 每页条数
+}
+
+// ReplayListResult 回放记录列表
+type ReplayListResult struct {
+	List     []*model.ReplayRun `json:"list"`
+	Total    int64              `json:"total"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"page_size"`
+}
+
+// ReplayReportParam 回放比对报告查询参数
+type ReplayReportParam struct {
+	ID       int32  `form:"id" binding:"required"` // 回放执行ID
+	Protocol string `form:"protocol"`              // 协议过滤, http/dubbo, 为空表示全部
+}
+
+// ReplayEndpointReport 接口比对结果
+type ReplayEndpointReport struct {
+	*model.ReplayDiffSummary
+	PassRate     float64                   `json:"pass_rate"`     // 一致率, 不含缺少响应的请求
+	LatencyDelta int64                     `json:"latency_delta"` // 回放与原始平均耗时之差(微秒)
+	Samples      []*model.ReplayDiffSample `json:"samples"`
+}
+
+// ReplayReportResult 回放比对报告
+type ReplayReportResult struct {
+	Run       *model.ReplayRun        `json:"run"`
+	Endpoints []*ReplayEndpointReport `json:"endpoints"`
+	Latencies []*model.ReplayLatency  `json:"latencies"` // 各回放输出按接口的耗时分位数
+}
+
+// List 分页查询录制任务的回放记录
+func (r ReplayController) List(context *gin.Context) {
+	var param ReplayListParam
+
+	if err := context.ShouldBindQuery(&param); err != nil {
+		context.JSON(http.StatusOK, rspcode.InvalidParameter)
+		return
+	}
+
+	if param.Page < 1 {
+		param.Page = 1
+	}
+	if param.PageSize < 1 {
+		param.PageSize = defaultPageSize
+	}
+	if param.PageSize > maxPageSize {
+		param.PageSize = maxPageSize
+	}
+
+	list, total, err := model.GetReplayRunDAO().ListByRecordID(param.RecordID, param.Page, param.PageSize)
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+
+	context.JSON(http.StatusOK, rspcode.Success.WithData(&ReplayListResult{
+		List:     list,
+		Total:    total,
+		Page:     param.Page,
+		PageSize: param.PageSize,
+	}))
+}
+
+// Report 查询回放的比对报告, 按接口返回一致率、耗时差及不一致示例
+func (r ReplayController) Report(context *gin.Context) {
+	var param ReplayReportParam
+
+	if err := context.ShouldBindQuery(&param); err != nil {
+		context.JSON(http.StatusOK, rspcode.InvalidParameter)
+		return
+	}
+
+	run, err := model.GetReplayRunDAO().GetByID(param.ID)
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+	if run == nil {
+		context.JSON(http.StatusOK, rspcode.NotExist)
+		return
+	}
+
+	summaries, err := model.GetReplayDiffDAO().ListSummaries(param.ID, param.Protocol)
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+
+	samples, err := model.GetReplayDiffDAO().ListSamples(param.ID, "")
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+
+	latencies, err := model.GetReplayLatencyDAO().ListByRunID(param.ID)
+	if err != nil {
+		context.JSON(http.StatusOK, rspcode.SystemError)
+		return
+	}
+
+	samplesByEndpoint := make(map[string][]*model.ReplayDiffSample)
+	for _, sample := range samples {
+		samplesByEndpoint[sample.Endpoint] = append(samplesByEndpoint[sample.Endpoint], sample)
+	}
+
+	result := &ReplayReportResult{Run: run, Endpoints: make([]*ReplayEndpointReport, 0, len(summaries)), Latencies: latencies}
+	for _, summary := range summaries {
+		report := &ReplayEndpointReport{
+			ReplayDiffSummary: summary,
+			LatencyDelta:      summary.ReplayedLatency - summary.OriginalLatency,
+			Samples:           samplesByEndpoint[summary.Endpoint],
+		}
+		if summary.Total > 0 {
+			report.PassRate = float64(summary.Matched) / float64(summary.Total)
+		}
+		result.Endpoints = append(result.Endpoints, report)
+	}
+
+	context.JSON(http.StatusOK, rspcode.Success.WithData(result))
+}
+
+// replayRecorder 收集进行中回放的不一致示例, 任务结束时与比对汇总一起保存
+type replayRecorder struct {
+	runID   int32
+	mu      sync.Mutex
+	samples map[string][]*proto.DiffRecord
+}
+
+var (
+	replayRecordersMu sync.Mutex
+	replayRecorders   = make(map[int32]*replayRecorder) // 按录制任务ID索引
+)
+
+func (rec *replayRecorder) add(record *proto.DiffRecord) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if len(rec.samples[record.Endpoint]) < maxDiffSamples {
+		rec.samples[record.Endpoint] = append(rec.samples[record.Endpoint], record)
+	}
+}
+
+// startReplayRun 配置了回放输出(output-http/output-http2/output-binary/output-dubbo/output-redis/output-mysql)或比对输出(output-diff)的任务会生成一次回放记录
+func startReplayRun(recordID int32, settings *settings2.AppSettings) error {
+	if len(settings.OutputHTTP) == 0 && len(settings.OutputHTTP2) == 0 && len(settings.OutputBinary) == 0 && len(settings.OutputDubbo) == 0 && len(settings.OutputRedis) == 0 &&
+		len(settings.OutputMySQL) == 0 && len(settings.OutputDiff) == 0 {
+		return nil
+	}
+
+	run := &model.ReplayRun{
+		BaseModel: model.BaseModel{
+			Flag:       &common.NumberZero,
+			CreateTime: time.Now().Unix(),
+			UpdateTime: time.Now().Unix(),
+			OrderId:    &common.NumberZero,
+		},
+		RecordID:  recordID,
+		StartTime: time.Now().Unix(),
+		Status:    common.ReplayStatusRunning.Code,
+	}
+	if err := model.GetReplayRunDAO().Insert(run); err != nil {
+		return err
+	}
+
+	rec := &replayRecorder{runID: run.ID, samples: make(map[string][]*proto.DiffRecord)}
+	settings.OutputDiffConfig.Handler = rec.add
+
+	replayRecordersMu.Lock()
+	replayRecorders[recordID] = rec
+	replayRecordersMu.Unlock()
+
+	return nil
+}
+
+func popReplayRecorder(recordID int32) *replayRecorder {
+	replayRecordersMu.Lock()
+	defer replayRecordersMu.Unlock()
+
+	rec := replayRecorders[recordID]
+	delete(replayRecorders, recordID)
+	return rec
+}
+
+// addReplaySamples 保存其他进程收集的不一致示例, 例如分布式回放的 worker
+func addReplaySamples(recordID int32, records []*proto.DiffRecord) {
+	replayRecordersMu.Lock()
+	rec := replayRecorders[recordID]
+	replayRecordersMu.Unlock()
+
+	if rec == nil {
+		return
+	}
+	for _, record := range records {
+		rec.add(record)
+	}
+}
+
+// failReplayRun 任务启动失败或无法汇总结果时(例如服务重启后停止任务)将录制任务进行中的回放标记为失败
+func failReplayRun(recordID int32) {
+	popReplayRecorder(recordID)
+
+	_, _ = model.GetReplayRunDAO().UpdateStatusByRecordID(recordID, common.ReplayStatusRunning.Code, map[string]interface{}{
+		"status":      common.ReplayStatusFailed.Code,
+		"end_time":    time.Now().Unix(),
+		"update_time": time.Now().Unix(),
+	})
+}
+
+// replayResult 回放结果, 本机任务为 bootstrap.Pipeline, 分布式回放为合并后的 cluster.RunMetrics
+type replayResult interface {
+	DiffStats() map[string]proto.DiffStats
+	LatencyReports() map[string][]core.LatencyReport
+}
+
+// finishReplayRun 保存任务的比对汇总、不一致示例及耗时统计
+func finishReplayRun(recordID int32, result replayResult) {
+	rec := popReplayRecorder(recordID)
+	if rec == nil {
+		return
+	}
+
+	now := time.Now().Unix()
+	report := buildReplayReport(rec, result, now)
+
+	status := common.ReplayStatusFinished.Code
+	if err := model.GetReplayDiffDAO().BatchInsert(report.summaries, report.samples); err != nil {
+		logrus.Errorf("save replay diff failed. run_id:%d, err:%v", rec.runID, err)
+		status = common.ReplayStatusFailed.Code
+	}
+	if err := model.GetReplayLatencyDAO().BatchInsert(report.latencies); err != nil {
+		logrus.Errorf("save replay latency failed. run_id:%d, err:%v", rec.runID, err)
+		status = common.ReplayStatusFailed.Code
+	}
+
+	_, err := model.GetReplayRunDAO().UpdateStatus(rec.runID, common.ReplayStatusRunning.Code, map[string]interface{}{
+		"status":      status,
+		"total":       report.run.Total,
+		"matched":     report.run.Matched,
+		"mismatched":  report.run.Mismatched,
+		"missing":     report.run.Missing,
+		"end_time":    now,
+		"update_time": now,
+	})
+	if err != nil {
+		logrus.Errorf("finish replay run failed. run_id:%d, err:%v", rec.runID, err)
+	}
+}
+
+// replayReport 一次回放待保存的比对汇总、不一致示例及耗时统计
+type replayReport struct {
+	run       model.ReplayRun // 各接口比对数的合计
+	summaries []*model.ReplayDiffSummary
+	samples   []*model.ReplayDiffSample
+	latencies []*model.ReplayLatency
+}
+
+// buildReplayReport 由回放结果及收集的不一致示例组装回放报告
+func buildReplayReport(rec *replayRecorder, result replayResult, now int64) *replayReport {
+	report := &replayReport{}
+
+	for _, s := range result.DiffStats() {
+		summary := &model.ReplayDiffSummary{
+			BaseModel: model.BaseModel{
+				Flag:       &common.NumberZero,
+				CreateTime: now,
+				UpdateTime: now,
+				OrderId:    &common.NumberZero,
+			},
+			RunID:      rec.runID,
+			Protocol:   s.Protocol,
+			Endpoint:   s.Endpoint,
+			Total:      s.Total,
+			Matched:    s.Matched,
+			Mismatched: s.Mismatched,
+			Missing:    s.Missing,
+		}
+		if s.Total > 0 {
+			summary.OriginalLatency = s.OriginalLatency / s.Total / int64(time.Microsecond)
+			summary.ReplayedLatency = s.ReplayedLatency / s.Total / int64(time.Microsecond)
+		}
+		report.summaries = append(report.summaries, summary)
+
+		report.run.Total += s.Total
+		report.run.Matched += s.Matched
+		report.run.Mismatched += s.Mismatched
+		report.run.Missing += s.Missing
+	}
+
+	rec.mu.Lock()
+	for endpoint, records := range rec.samples {
+		for _, record := range records {
+			diffs, _ := json.Marshal(record.Diffs)
+			report.samples = append(report.samples, &model.ReplayDiffSample{
+				BaseModel: model.BaseModel{
+					Flag:       &common.NumberZero,
+					CreateTime: now,
+					UpdateTime: now,
+					OrderId:    &common.NumberZero,
+				},
+				RunID:     rec.runID,
+				Endpoint:  endpoint,
+				PayloadID: record.ID,
+				Diffs:     string(diffs),
+			})
+		}
+	}
+	rec.mu.Unlock()
+
+	for output, reports := range result.LatencyReports() {
+		for _, l := range reports {
+			report.latencies = append(report.latencies, &model.ReplayLatency{
+				BaseModel: model.BaseModel{
+					Flag:       &common.NumberZero,
+					CreateTime: now,
+					UpdateTime: now,
+					OrderId:    &common.NumberZero,
+				},
+				RunID:    rec.runID,
+				Output:   output,
+				Endpoint: l.Endpoint,
+				Requests: l.Requests,
+				Count:    l.Count,
+				Errors:   l.Errors,
+				Timeouts: l.Timeouts,
+				Mean:     l.Mean.Microseconds(),
+				P50:      l.P50.Microseconds(),
+				P90:      l.P90.Microseconds(),
+				P99:      l.P99.Microseconds(),
+				P999:     l.P999.Microseconds(),
+				Max:      l.Max.Microseconds(),
+			})
+		}
+	}
+
+	return report
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"record-traffic-press/goreplay/core"
+	"record-traffic-press/goreplay/proto"
+	"testing"
+	"time"
+)
+
+type fakeReplayResult struct {
+	diff      map[string]proto.DiffStats
+	latencies map[string][]core.LatencyReport
+}
+
+func (r fakeReplayResult) DiffStats() map[string]proto.DiffStats { return r.diff }
+
+func (r fakeReplayResult) LatencyReports() map[string][]core.LatencyReport { return r.latencies }
+
+func TestBuildReplayReport(t *testing.T) {
+	rec := &replayRecorder{runID: 7, samples: make(map[string][]*proto.DiffRecord)}
+	for i := 0; i < maxDiffSamples+2; i++ {
+		rec.add(&proto.DiffRecord{ID: "a", Endpoint: "GET /user", Diffs: []proto.Difference{{Field: "body.name", Original: "bob", Replayed: "carol"}}})
+	}
+
+	result := fakeReplayResult{
+		diff: map[string]proto.DiffStats{
+			proto.DiffStatsKey("http", "GET /user"): {Protocol: "http", Endpoint: "GET /user", Total: 4, Matched: 3, Mismatched: 1, Missing: 2,
+				OriginalLatency: int64(8 * time.Millisecond), ReplayedLatency: int64(12 * time.Millisecond)},
+			proto.DiffStatsKey("redis", "GET"): {Protocol: "redis", Endpoint: "GET", Missing: 1},
+		},
+		latencies: map[string][]core.LatencyReport{
+			"HTTP output: a": {{Endpoint: "GET /user", Requests: 4, Count: 4, P99: 3 * time.Millisecond}},
+		},
+	}
+
+	report := buildReplayReport(rec, result, 100)
+
+	if run := report.run; run.Total != 4 || run.Matched != 3 || run.Mismatched != 1 || run.Missing != 3 {
+		t.Errorf("unexpected run totals %+v", run)
+	}
+
+	if len(report.summaries) != 2 {
+		t.Fatalf("expected 2 summaries, got %d", len(report.summaries))
+	}
+	for _, summary := range report.summaries {
+		if summary.RunID != 7 || summary.CreateTime != 100 {
+			t.Errorf("unexpected summary %+v", summary)
+		}
+		switch summary.Protocol {
+		case "http":
+			if summary.Endpoint != "GET /user" || summary.Total != 4 || summary.OriginalLatency != 2000 || summary.ReplayedLatency != 3000 {
+				t.Errorf("unexpected http summary %+v", summary)
+			}
+		case "redis":
+			if summary.Endpoint != "GET" || summary.Missing != 1 || summary.OriginalLatency != 0 {
+				t.Errorf("unexpected redis summary %+v", summary)
+			}
+		default:
+			t.Errorf("unexpected protocol %q", summary.Protocol)
+		}
+	}
+
+	if len(report.samples) != maxDiffSamples {
+		t.Fatalf("expected %d samples, got %d", maxDiffSamples, len(report.samples))
+	}
+	if sample := report.samples[0]; sample.RunID != 7 || sample.Endpoint != "GET /user" || sample.PayloadID != "a" ||
+		sample.Diffs != `[{"field":"body.name","original":"bob","replayed":"carol"}]` {
+		t.Errorf("unexpected sample %+v", sample)
+	}
+
+	if len(report.latencies) != 1 {
+		t.Fatalf("expected 1 latency, got %d", len(report.latencies))
+	}
+	if l := report.latencies[0]; l.Output != "HTTP output: a" || l.Endpoint != "GET /user" || l.Requests != 4 || l.P99 != 3000 {
+		t.Errorf("unexpected latency %+v", l)
+	}
+}
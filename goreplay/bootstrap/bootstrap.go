@@ -13,6 +13,7 @@ import (
 	"record-traffic-press/goreplay/core"
 	"record-traffic-press/goreplay/input"
 	"record-traffic-press/goreplay/output"
+	"record-traffic-press/goreplay/proto"
 	"record-traffic-press/goreplay/settings"
 	"runtime"
 	"runtime/pprof"
@@ -77,14 +78,13 @@ func start() {
 
 	settings.CheckSettings()
 
-	p := NewPlugins()
+	p, err := NewPipeline(settings.Settings)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	log.Printf("[PPID %d and PID %d] Version:%s\n", os.Getppid(), os.Getpid(), VERSION)
 
-	if len(p.Inputs) == 0 || len(p.Outputs) == 0 {
-		log.Fatal("Required at least 1 input and 1 output")
-	}
-
 	if *memprofile != "" {
 		profileMEM(*memprofile)
 	}
@@ -100,9 +100,8 @@ func start() {
 	}
 
 	closeCh := make(chan int)
-	emitter := NewEmitter()
 
-	go emitter.Start(p, settings.Settings.Middleware)
+	p.Start()
 	if settings.Settings.ExitAfter > 0 {
 		log.Printf("Running gor for a duration of %s\n", settings.Settings.ExitAfter)
 
@@ -124,7 +123,7 @@ func start() {
 		exit = 0
 	}
 
-	emitter.Close()
+	p.Close()
 	os.Exit(exit)
 }
 
@@ -156,70 +155,265 @@ func profileMEM(memprofile string) {
 	}
 }
 
-// NewPlugins specify and initialize all available plugins
-func NewPlugins() *core.InOutPlugins {
+// NewPlugins specify and initialize all available plugins from the given settings, when a plugin
+// fails to start the plugins already started are returned along with the error, so they can be closed
+func NewPlugins(config *settings.AppSettings) (*core.InOutPlugins, error) {
 	plugins := new(core.InOutPlugins)
 
-	for _, options := range settings.Settings.InputDummy {
-		plugins.RegisterPlugin(input.NewDummyInput, options)
+	var err error
+	register := func(constructor interface{}, options ...interface{}) {
+		if err == nil {
+			err = plugins.RegisterPlugin(constructor, options...)
+		}
+	}
+
+	for _, options := range config.InputDummy {
+		register(input.NewDummyInput, options)
+	}
+
+	for range config.OutputDummy {
+		register(output.NewDummyOutput)
+	}
+
+	if config.OutputStdout {
+		register(output.NewDummyOutput)
 	}
 
-	for range settings.Settings.OutputDummy {
-		plugins.RegisterPlugin(output.NewDummyOutput)
+	if config.OutputNull {
+		register(output.NewNullOutput)
 	}
 
-	if settings.Settings.OutputStdout {
-		plugins.RegisterPlugin(output.NewDummyOutput)
+	// the captured packets are only kept for the pcap outputs
+	rawConfig := config.InputRAWConfig
+	rawConfig.KeepPackets = len(config.OutputPcap) > 0
+	for _, options := range config.InputRAW {
+		register(input.NewRAWInput, options, rawConfig)
 	}
 
-	if settings.Settings.OutputNull {
-		plugins.RegisterPlugin(output.NewNullOutput)
+	for _, options := range config.InputTCP {
+		register(input.NewTCPInput, options, &config.InputTCPConfig)
 	}
 
-	for _, options := range settings.Settings.InputRAW {
-		plugins.RegisterPlugin(input.NewRAWInput, options, settings.Settings.InputRAWConfig)
+	config.OutputTCPConfig.Stats = config.OutputTCPStats
+	for _, options := range config.OutputTCP {
+		register(output.NewTCPOutput, options, &config.OutputTCPConfig)
 	}
 
-	for _, options := range settings.Settings.InputTCP {
-		plugins.RegisterPlugin(input.NewTCPInput, options, &settings.Settings.InputTCPConfig)
+	config.OutputWebSocketConfig.Stats = config.OutputWebSocketStats
+	for _, options := range config.OutputWebSocket {
+		register(output.NewWebSocketOutput, options, &config.OutputWebSocketConfig)
 	}
 
-	for _, options := range settings.Settings.OutputTCP {
-		plugins.RegisterPlugin(output.NewTCPOutput, options, &settings.Settings.OutputTCPConfig)
+	for _, options := range config.InputFile {
+		register(input.NewFileInput, options, config.InputFileLoop, config.InputFileReadDepth, config.InputFileMaxWait, config.InputFileDryRun)
 	}
 
-	for _, options := range settings.Settings.OutputWebSocket {
-		plugins.RegisterPlugin(output.NewWebSocketOutput, options, &settings.Settings.OutputWebSocketConfig)
+	for _, path := range config.OutputFile {
+		register(output.NewFileOutput, path, &config.OutputFileConfig)
 	}
 
-	for _, options := range settings.Settings.InputFile {
-		plugins.RegisterPlugin(input.NewFileInput, options, settings.Settings.InputFileLoop, settings.Settings.InputFileReadDepth, settings.Settings.InputFileMaxWait, settings.Settings.InputFileDryRun)
+	for _, path := range config.OutputPcap {
+		register(output.NewPcapOutput, path, &config.OutputPcapConfig)
 	}
 
-	for _, path := range settings.Settings.OutputFile {
-		plugins.RegisterPlugin(output.NewFileOutput, path, &settings.Settings.OutputFileConfig)
+	if config.InputKafkaConfig.Host != "" && config.InputKafkaConfig.Topic != "" {
+		register(input.NewKafkaInput, config.InputKafkaConfig.Offset, &config.InputKafkaConfig, &config.KafkaTLSConfig)
 	}
 
-	for _, options := range settings.Settings.InputHTTP {
-		plugins.RegisterPlugin(input.NewHTTPInput, options)
+	if config.OutputKafkaConfig.Host != "" && config.OutputKafkaConfig.Topic != "" {
+		register(output.NewKafkaOutput, "", &config.OutputKafkaConfig, &config.KafkaTLSConfig)
+	}
+
+	for _, options := range config.InputHTTP {
+		register(input.NewHTTPInput, options)
 	}
 
 	// If we explicitly set Host header http output should not rewrite it
 	// Fix: https://record-traffic-press/gor/issues/174
-	for _, header := range settings.Settings.ModifierConfig.Headers {
+	for _, header := range config.ModifierConfig.Headers {
 		if header.Name == "Host" {
-			settings.Settings.OutputHTTPConfig.OriginalHost = true
+			config.OutputHTTPConfig.OriginalHost = true
 			break
 		}
 	}
 
-	for _, options := range settings.Settings.OutputHTTP {
-		plugins.RegisterPlugin(output.NewHTTPOutput, options, &settings.Settings.OutputHTTPConfig)
+	config.OutputHTTPConfig.LatencyWindow = config.LatencyWindow
+	// correlation reads the values issued by the replayed server from the replayed responses
+	if len(config.Correlation.Rules) > 0 {
+		config.OutputHTTPConfig.TrackResponses = true
+	}
+	for _, options := range config.OutputHTTP {
+		register(output.NewHTTPOutput, options, &config.OutputHTTPConfig)
+	}
+
+	config.OutputBinaryConfig.LatencyWindow = config.LatencyWindow
+	for _, options := range config.OutputBinary {
+		register(output.NewBinaryOutput, options, &config.OutputBinaryConfig)
+	}
+
+	config.OutputDubboConfig.LatencyWindow = config.LatencyWindow
+	for _, options := range config.OutputDubbo {
+		register(output.NewDubboOutput, options, &config.OutputDubboConfig)
+	}
+
+	config.OutputHTTP2Config.LatencyWindow = config.LatencyWindow
+	for _, options := range config.OutputHTTP2 {
+		register(output.NewHTTP2Output, options, &config.OutputHTTP2Config)
+	}
+
+	config.OutputRedisConfig.LatencyWindow = config.LatencyWindow
+	for _, options := range config.OutputRedis {
+		register(output.NewRedisOutput, options, &config.OutputRedisConfig)
+	}
+
+	config.OutputMySQLConfig.LatencyWindow = config.LatencyWindow
+	for _, options := range config.OutputMySQL {
+		register(output.NewMySQLOutput, options, &config.OutputMySQLConfig)
+	}
+
+	for _, options := range config.OutputDiff {
+		register(output.NewDiffOutput, options, &config.OutputDiffConfig)
+	}
+
+	if err != nil {
+		return plugins, err
+	}
+
+	// sharding first, so every worker amplifies its own part of the traffic
+	if config.InputShards > 1 {
+		shardInputs(plugins, config.InputShard, config.InputShards, shardSession(config))
+	}
+
+	profile := &config.LoadProfile
+	if (config.InputAmplify > 0 && config.InputAmplify != 1) || (len(profile.Stages) > 0 && profile.Target == settings.LoadTargetAmplify) {
+		factor := config.InputAmplify
+		if factor <= 0 {
+			factor = 1
+		}
+		amplifyInputs(plugins, factor, config.InputAmplifyJitter)
+	}
+
+	// the open-loop HTTP outputs schedule the requests at the replay speed of the inputs
+	if factor := replaySpeedFactor(plugins); factor != 1 {
+		for _, out := range plugins.Outputs {
+			if out, ok := unwrapPlugin(out).(*output.HTTPOutput); ok {
+				out.SetSpeedFactor(factor)
+			}
+		}
+	}
+
+	if len(profile.Stages) > 0 {
+		register(core.NewLoadProfiler, "", profile, loadProfileSetters(plugins, profile.Target))
 	}
 
-	for _, options := range settings.Settings.OutputBinary {
-		plugins.RegisterPlugin(output.NewBinaryOutput, options, &settings.Settings.OutputBinaryConfig)
+	return plugins, err
+}
+
+// replaySpeedFactor returns the speed of the first input replaying at a speed of its own, 1 without one
+func replaySpeedFactor(plugins *core.InOutPlugins) float64 {
+	for _, in := range plugins.Inputs {
+		switch in := unwrapPlugin(in).(type) {
+		case *input.FileInput:
+			return in.SpeedFactor
+		case *input.KafkaInput:
+			return in.SpeedFactor
+		}
 	}
+	return 1
+}
 
-	return plugins
+// loadProfileSetters returns the knobs driven by a load profile, speed is the default target
+func loadProfileSetters(plugins *core.InOutPlugins, target string) []func(float64) {
+	var setters []func(float64)
+
+	for _, in := range plugins.Inputs {
+		if target == settings.LoadTargetAmplify {
+			if a, ok := in.(*core.Amplifier); ok {
+				setters = append(setters, a.SetFactor)
+			}
+			continue
+		}
+
+		// the replay can be slowed down but not paused
+		switch in := unwrapPlugin(in).(type) {
+		case *input.FileInput:
+			setters = append(setters, func(f float64) {
+				if f > 0 {
+					in.SetSpeedFactor(f)
+				}
+			})
+		case *input.KafkaInput:
+			setters = append(setters, func(f float64) {
+				if f > 0 {
+					in.SetSpeedFactor(f)
+				}
+			})
+		}
+	}
+
+	// the open-loop send times follow the speed of the inputs
+	if target != settings.LoadTargetAmplify {
+		for _, out := range plugins.Outputs {
+			if out, ok := unwrapPlugin(out).(*output.HTTPOutput); ok {
+				setters = append(setters, out.SetSpeedFactor)
+			}
+		}
+	}
+
+	return setters
+}
+
+// amplifyInputs wraps the traffic inputs with core.Amplifier
+func amplifyInputs(plugins *core.InOutPlugins, factor float64, jitter time.Duration) {
+	wrapInputs(plugins, func(in core.PluginReader) core.PluginReader {
+		return core.NewAmplifier(in, factor, jitter)
+	})
+}
+
+// shardInputs wraps the traffic inputs with core.Shard
+func shardInputs(plugins *core.InOutPlugins, index, count int, session string) {
+	wrapInputs(plugins, func(in core.PluginReader) core.PluginReader {
+		return core.NewShard(in, index, count, session)
+	})
+}
+
+// shardSession returns the session kept on one worker, the client when any sticky output or the
+// correlation uses it so its connections are not split across the workers
+func shardSession(config *settings.AppSettings) string {
+	for _, session := range []string{config.OutputHTTPConfig.Session, config.OutputBinaryConfig.Session, config.OutputWebSocketConfig.Session, config.Correlation.Session} {
+		if session == proto.SessionByClient {
+			return proto.SessionByClient
+		}
+	}
+	return proto.SessionByConnection
+}
+
+// wrapInputs replaces the traffic inputs by their wrappers, outputs reading replayed responses are left as is
+func wrapInputs(plugins *core.InOutPlugins, wrap func(core.PluginReader) core.PluginReader) {
+	for i, in := range plugins.Inputs {
+		switch unwrapPlugin(in).(type) {
+		case *input.RAWInput, *input.TCPInput, *input.FileInput, *input.KafkaInput, *input.HTTPInput, *input.DummyInput:
+		default:
+			continue
+		}
+
+		wrapper := wrap(in)
+		plugins.Inputs[i] = wrapper
+		for j, p := range plugins.All {
+			if p == interface{}(in) {
+				plugins.All[j] = wrapper
+			}
+		}
+	}
+}
+
+// unwrapPlugin returns the plugin wrapped by limiters and amplifiers
+func unwrapPlugin(plugin interface{}) interface{} {
+	for {
+		w, ok := plugin.(interface{ Plugin() interface{} })
+		if !ok {
+			return plugin
+		}
+		plugin = w.Plugin()
+	}
 }
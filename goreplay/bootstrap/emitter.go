@@ -9,7 +9,9 @@ import (
 	"record-traffic-press/goreplay/proto"
 	"record-traffic-press/goreplay/settings"
 	"record-traffic-press/goreplay/utils"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/coocood/freecache"
 )
@@ -17,23 +19,43 @@ import (
 // Emitter represents an abject to manage plugins communication
 type Emitter struct {
 	sync.WaitGroup
-	plugins *core.InOutPlugins
+	plugins    *core.InOutPlugins
+	config     *settings.AppSettings
+	modifier   *core.HTTPModifier
+	correlator *core.Correlator
+	// correlationWait is how long a request waits for the replayed values of its session, 0 without middleware
+	correlationWait time.Duration
+	shifter         *core.TimeShifter
 }
 
-// NewEmitter creates and initializes new Emitter object.
+// NewEmitter creates and initializes new Emitter object bound to the global settings.Settings.
 func NewEmitter() *Emitter {
-	return &Emitter{}
+	return NewEmitterWithSettings(&settings.Settings)
+}
+
+// NewEmitterWithSettings creates and initializes new Emitter object bound to the given settings.
+func NewEmitterWithSettings(config *settings.AppSettings) *Emitter {
+	return &Emitter{config: config}
 }
 
 // Start initialize loop for sending data from inputs to outputs
 func (e *Emitter) Start(plugins *core.InOutPlugins, middlewareCmd string) {
-	if settings.Settings.CopyBufferSize < 1 {
-		settings.Settings.CopyBufferSize = 5 << 20
+	if e.config.CopyBufferSize < 1 {
+		e.config.CopyBufferSize = 5 << 20
 	}
 	e.plugins = plugins
+	e.modifier = core.NewHTTPModifier(&e.config.ModifierConfig)
+	e.shifter = core.NewTimeShifter(&e.config.TimeShift)
+	correlation := e.config.Correlation
+	if middlewareCmd != "" {
+		// the replayed responses go through the same copy as the requests, which can't wait for them
+		correlation.Wait = 0
+	}
+	e.correlator = core.NewCorrelator(&correlation)
+	e.correlationWait = correlation.Wait
 
 	if middlewareCmd != "" {
-		middleware := core.NewMiddleware(middlewareCmd)
+		middleware := core.NewMiddleware(middlewareCmd, e.config.PrettifyHTTP)
 
 		for _, in := range plugins.Inputs {
 			middleware.ReadFrom(in)
@@ -44,7 +66,7 @@ func (e *Emitter) Start(plugins *core.InOutPlugins, middlewareCmd string) {
 		e.Add(1)
 		go func() {
 			defer e.Done()
-			if err := CopyMulty(middleware, plugins.Outputs...); err != nil {
+			if err := e.CopyMulty(middleware, plugins.Outputs...); err != nil {
 				glogs.Debug(2, fmt.Sprintf("[EMITTER] error during copy: %q", err))
 			}
 		}()
@@ -53,7 +75,7 @@ func (e *Emitter) Start(plugins *core.InOutPlugins, middlewareCmd string) {
 			e.Add(1)
 			go func(in core.PluginReader) {
 				defer e.Done()
-				if err := CopyMulty(in, plugins.Outputs...); err != nil {
+				if err := e.CopyMulty(in, plugins.Outputs...); err != nil {
 					glogs.Debug(2, fmt.Sprintf("[EMITTER] error during copy: %q", err))
 				}
 			}(in)
@@ -76,10 +98,32 @@ func (e *Emitter) Close() {
 }
 
 // CopyMulty copies from 1 reader to multiple writers
-func CopyMulty(src core.PluginReader, writers ...core.PluginWriter) error {
+func (e *Emitter) CopyMulty(src core.PluginReader, writers ...core.PluginWriter) error {
+	modifier := e.modifier
+	correlator := e.correlator
+	shifter := e.shifter
+
+	var queue chan *common.Message
+	var sessions *core.SessionDispatcher[*common.Message]
+	if correlator != nil && e.correlationWait > 0 {
+		queue = make(chan *common.Message, 1000)
+		sessions = core.NewSessionDispatcher(queue, func(msg *common.Message) string {
+			return string(proto.SessionID(proto.PayloadID(msg.Meta), e.config.Correlation.Session))
+		}, func() core.SessionWorker[*common.Message] {
+			return &correlatedSession{emitter: e, writers: writers}
+		})
+		defer func() {
+			// the queued requests are still sent
+			close(queue)
+			sessions.Wait()
+		}()
+	}
 
-	modifier := core.NewHTTPModifier(&settings.Settings.ModifierConfig)
-	filteredRequests := freecache.NewCache(200 * 1024 * 1024) // 200M
+	// requests skipped by the modifier, used to skip their responses as well
+	var filteredRequests *freecache.Cache
+	if modifier != nil {
+		filteredRequests = freecache.NewCache(200 * 1024 * 1024) // 200M
+	}
 
 	for {
 		msg, err := src.PluginRead()
@@ -90,8 +134,8 @@ func CopyMulty(src core.PluginReader, writers ...core.PluginWriter) error {
 			return err
 		}
 		if msg != nil && len(msg.Data) > 0 {
-			if len(msg.Data) > int(settings.Settings.CopyBufferSize) {
-				msg.Data = msg.Data[:settings.Settings.CopyBufferSize]
+			if len(msg.Data) > int(e.config.CopyBufferSize) {
+				msg.Data = msg.Data[:e.config.CopyBufferSize]
 			}
 			meta := proto.PayloadMeta(msg.Meta)
 			if len(meta) < 3 {
@@ -100,7 +144,7 @@ func CopyMulty(src core.PluginReader, writers ...core.PluginWriter) error {
 			}
 			requestID := meta[1]
 			// start a subroutine only when necessary
-			if settings.Settings.Verbose >= 3 {
+			if e.config.Verbose >= 3 {
 				glogs.Debug(3, "[EMITTER] input: ", utils.SliceToString(msg.Meta[:len(msg.Meta)-1]), " from: ", src)
 			}
 			if modifier != nil {
@@ -123,18 +167,62 @@ func CopyMulty(src core.PluginReader, writers ...core.PluginWriter) error {
 				}
 			}
 
-			if settings.Settings.PrettifyHTTP {
-				msg.Data = core.PrettifyHTTP(msg.Data)
-				if len(msg.Data) == 0 {
-					continue
-				}
+			if shifter != nil && proto.IsRequestPayload(msg.Meta) {
+				captured, _ := strconv.ParseInt(string(meta[2]), 10, 64)
+				msg.Data = shifter.Rewrite(captured, msg.Data)
 			}
 
-			for _, dst := range writers {
-				if _, err := dst.PluginWrite(msg); err != nil && err != io.ErrClosedPipe {
-					return err
+			// the replayed responses come back through the outputs tracking them, e.g. output.HTTPOutput
+			if correlator != nil {
+				switch msg.Meta[0] {
+				case proto.RequestPayload:
+					if sessions != nil {
+						// the request may wait for replayed values, without holding the other sessions
+						queue <- msg
+						continue
+					}
+					msg.Data = correlator.Rewrite(requestID, msg.Data)
+				case proto.ResponsePayload, proto.ReplayedResponsePayload:
+					correlator.Observe(msg.Meta[0], requestID, msg.Data)
 				}
 			}
+
+			if err := e.write(msg, writers); err != nil {
+				return err
+			}
 		}
 	}
 }
+
+// write writes a message to all the writers
+func (e *Emitter) write(msg *common.Message, writers []core.PluginWriter) error {
+	if e.config.PrettifyHTTP {
+		msg.Data = core.PrettifyHTTP(msg.Data)
+		if len(msg.Data) == 0 {
+			return nil
+		}
+	}
+
+	for _, dst := range writers {
+		if _, err := dst.PluginWrite(msg); err != nil && err != io.ErrClosedPipe {
+			return err
+		}
+	}
+	return nil
+}
+
+// correlatedSession rewrites and writes the requests of a session one after the other, a request
+// waiting for the replayed values of its session only holds the next requests of the same session
+type correlatedSession struct {
+	emitter *Emitter
+	writers []core.PluginWriter
+}
+
+func (s *correlatedSession) Handle(msg *common.Message) {
+	msg.Data = s.emitter.correlator.Rewrite(proto.PayloadID(msg.Meta), msg.Data)
+	if err := s.emitter.write(msg, s.writers); err != nil {
+		glogs.Debug(2, fmt.Sprintf("[EMITTER] error during copy: %q", err))
+	}
+}
+
+func (s *correlatedSession) Close() {}
@@ -0,0 +1,218 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"record-traffic-press/goreplay/core"
+	"record-traffic-press/goreplay/glogs"
+	"record-traffic-press/goreplay/input"
+	"record-traffic-press/goreplay/output"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Pipeline is a gor runtime scoped to its own settings. It owns the plugins, the modifier and
+// the emitter built from those settings, so several pipelines can run side by side in one process.
+type Pipeline struct {
+	Settings *settings.AppSettings
+	Plugins  *core.InOutPlugins
+
+	emitter    *Emitter
+	sloRules   []core.SLORule
+	sloAborted int32
+	once       sync.Once
+}
+
+// NewPipeline copies appSettings, fills their defaults and initializes the plugins
+func NewPipeline(appSettings settings.AppSettings) (*Pipeline, error) {
+	p := new(Pipeline)
+	p.Settings = &appSettings
+	p.Settings.Check()
+
+	rules, err := core.ParseSLORules(p.Settings.SLO.Rules)
+	if err != nil {
+		return nil, err
+	}
+	p.sloRules = rules
+
+	if _, err = core.ParseCorrelationRules(p.Settings.Correlation.Rules); err != nil {
+		return nil, err
+	}
+	if _, err = core.ParseTimeShiftRules(p.Settings.TimeShift.Rules); err != nil {
+		return nil, err
+	}
+	if _, err = proto.ParseRedisKeyPrefixes(p.Settings.OutputRedisConfig.KeyPrefix); err != nil {
+		return nil, err
+	}
+	for _, dsn := range p.Settings.OutputMySQL {
+		if _, err = mysql.ParseDSN(dsn); err != nil {
+			return nil, fmt.Errorf("output-mysql: %w", err)
+		}
+	}
+
+	for _, session := range []string{p.Settings.OutputHTTPConfig.Session, p.Settings.OutputBinaryConfig.Session, p.Settings.OutputWebSocketConfig.Session, p.Settings.Correlation.Session} {
+		if session != "" && session != proto.SessionByConnection && session != proto.SessionByClient {
+			return nil, fmt.Errorf("unknown session %q, expected %q or %q", session, proto.SessionByConnection, proto.SessionByClient)
+		}
+	}
+
+	p.emitter = NewEmitterWithSettings(p.Settings)
+	if p.Plugins, err = NewPlugins(p.Settings); err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	if len(p.Plugins.Inputs) == 0 || len(p.Plugins.Outputs) == 0 {
+		p.Close()
+		return nil, errors.New("required at least 1 input and 1 output")
+	}
+
+	return p, nil
+}
+
+// BPFFilters returns the effective BPF filters of the raw inputs, keyed by input address and then by capture handle
+func (p *Pipeline) BPFFilters() map[string]map[string]string {
+	filters := make(map[string]map[string]string)
+	for _, plugin := range p.Plugins.All {
+		if in, ok := unwrapPlugin(plugin).(*input.RAWInput); ok {
+			filters[in.Address()] = in.BPFFilters()
+		}
+	}
+	return filters
+}
+
+// DiffStats returns the comparisons of the diff outputs aggregated per endpoint, keyed by proto.DiffStatsKey
+func (p *Pipeline) DiffStats() map[string]proto.DiffStats {
+	stats := make(map[string]proto.DiffStats)
+	for _, plugin := range p.Plugins.All {
+		out, ok := unwrapPlugin(plugin).(*output.DiffOutput)
+		if !ok {
+			continue
+		}
+		for key, s := range out.Stats() {
+			total := stats[key]
+			total.Protocol, total.Endpoint = s.Protocol, s.Endpoint
+			total.Total += s.Total
+			total.Matched += s.Matched
+			total.Mismatched += s.Mismatched
+			total.Missing += s.Missing
+			total.OriginalLatency += s.OriginalLatency
+			total.ReplayedLatency += s.ReplayedLatency
+			stats[key] = total
+		}
+	}
+	return stats
+}
+
+// LatencyReports returns the latency percentiles of the replay outputs per endpoint, keyed by output
+func (p *Pipeline) LatencyReports() map[string][]core.LatencyReport {
+	reports := make(map[string][]core.LatencyReport)
+	for _, plugin := range p.Plugins.All {
+		plugin = unwrapPlugin(plugin)
+		if out, ok := plugin.(core.LatencyReporter); ok {
+			reports[fmt.Sprint(plugin)] = out.LatencyReports()
+		}
+	}
+	return reports
+}
+
+// ScheduleLags returns how far the open-loop outputs lag behind the intended send times, keyed by output
+func (p *Pipeline) ScheduleLags() map[string]core.ScheduleLag {
+	lags := make(map[string]core.ScheduleLag)
+	for _, plugin := range p.Plugins.All {
+		plugin = unwrapPlugin(plugin)
+		if out, ok := plugin.(core.ScheduleReporter); ok {
+			if lag, ok := out.ScheduleLag(); ok {
+				lags[fmt.Sprint(plugin)] = lag
+			}
+		}
+	}
+	return lags
+}
+
+// LatencySnapshots returns the raw latencies of the replay outputs per endpoint, keyed by output
+func (p *Pipeline) LatencySnapshots() map[string][]core.LatencySnapshot {
+	snapshots := make(map[string][]core.LatencySnapshot)
+	for _, plugin := range p.Plugins.All {
+		plugin = unwrapPlugin(plugin)
+		if out, ok := plugin.(core.LatencyReporter); ok {
+			snapshots[fmt.Sprint(plugin)] = out.LatencySnapshots()
+		}
+	}
+	return snapshots
+}
+
+func (p *Pipeline) latencyTotals() map[string]core.LatencyReport {
+	totals := make(map[string]core.LatencyReport)
+	for _, plugin := range p.Plugins.All {
+		plugin = unwrapPlugin(plugin)
+		if out, ok := plugin.(core.LatencyReporter); ok {
+			totals[fmt.Sprint(plugin)] = out.LatencyTotal()
+		}
+	}
+	return totals
+}
+
+// SLOVerdict evaluates the SLO rules against the whole replay so far, nil when there are no rules
+func (p *Pipeline) SLOVerdict() *core.SLOVerdict {
+	if len(p.sloRules) == 0 {
+		return nil
+	}
+
+	verdict := core.EvaluateSLO(p.sloRules, p.latencyTotals(), p.DiffStats(), 0)
+	if atomic.LoadInt32(&p.sloAborted) == 1 {
+		verdict.Passed, verdict.Aborted = false, true
+	}
+	return &verdict
+}
+
+// checkSLO returns false once a rule with enough samples is breached, the pipeline is then marked as aborted
+func (p *Pipeline) checkSLO() bool {
+	verdict := core.EvaluateSLO(p.sloRules, p.latencyTotals(), p.DiffStats(), p.Settings.SLO.MinRequests)
+	if verdict.Passed {
+		return true
+	}
+
+	atomic.StoreInt32(&p.sloAborted, 1)
+	for _, r := range verdict.Results {
+		if !r.Passed {
+			glogs.Debug(1, fmt.Sprintf("[SLO] %s breached by %s: %v", r.Expr, r.Output, r.Value))
+		}
+	}
+	return false
+}
+
+// LoadStages returns the stages of the load profile started so far with their achieved RPS
+func (p *Pipeline) LoadStages() []core.LoadStageReport {
+	for _, plugin := range p.Plugins.All {
+		if profiler, ok := plugin.(*core.LoadProfiler); ok {
+			return profiler.Reports()
+		}
+	}
+	return nil
+}
+
+// Start starts copying messages from inputs to outputs
+func (p *Pipeline) Start() {
+	p.emitter.Start(p.Plugins, p.Settings.Middleware)
+}
+
+// Wait blocks until every input of a started pipeline is drained or closed
+func (p *Pipeline) Wait() {
+	p.emitter.Wait()
+}
+
+// Close closes all the plugins and waits for the emitter to finish, it is safe to call it more than once
+func (p *Pipeline) Close() {
+	p.once.Do(func() {
+		if p.emitter.plugins == nil {
+			// never started, only the plugins need to be closed
+			p.emitter.plugins = p.Plugins
+		}
+		p.emitter.Close()
+	})
+}
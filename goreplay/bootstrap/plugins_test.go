@@ -1,6 +1,7 @@
-package core
+package bootstrap
 
 import (
+	"record-traffic-press/goreplay/core"
 	input2 "record-traffic-press/goreplay/input"
 	output2 "record-traffic-press/goreplay/output"
 	"record-traffic-press/goreplay/settings"
@@ -13,7 +14,10 @@ func TestPluginsRegistration(t *testing.T) {
 	settings.Settings.OutputHTTP = []string{"www.example.com|10"}
 	settings.Settings.InputFile = []string{"/dev/null"}
 
-	plugins := NewPlugins()
+	plugins, err := NewPlugins(&settings.Settings)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if len(plugins.Inputs) != 3 {
 		t.Errorf("Should be 3 inputs got %d", len(plugins.Inputs))
@@ -35,8 +39,8 @@ func TestPluginsRegistration(t *testing.T) {
 		t.Errorf("First output should be DummyOutput")
 	}
 
-	if l, ok := plugins.Outputs[1].(*Limiter); ok {
-		if _, ok := l.plugin.(*output2.HTTPOutput); !ok {
+	if l, ok := plugins.Outputs[1].(*core.Limiter); ok {
+		if _, ok := l.Plugin().(*output2.HTTPOutput); !ok {
 			t.Errorf("HTTPOutput should be wrapped in limiter")
 		}
 	} else {
@@ -0,0 +1,150 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"record-traffic-press/goreplay/glogs"
+	"record-traffic-press/goreplay/settings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTaskRunning is returned when a task with the same id is already running
+	ErrTaskRunning = errors.New("task is already running")
+	// ErrTaskNotFound is returned when there is no running task with the given id
+	ErrTaskNotFound = errors.New("task is not running")
+)
+
+// Task is a gor pipeline started in-process, identified by its record id
+type Task struct {
+	ID        int32
+	StartedAt time.Time
+	Pipeline  *Pipeline
+
+	onFinish func(*Task)
+	done     chan struct{}
+	once     sync.Once
+}
+
+// sloCheckInterval is how often the SLO rules of a task are checked when a breach aborts it
+const sloCheckInterval = time.Second
+
+var (
+	tasksMu sync.Mutex
+	tasks   = make(map[int32]*Task)
+)
+
+// StartTask builds plugins from appSettings and starts copying traffic from inputs to outputs.
+// The task runs until StopTask is called, appSettings.ExitAfter elapses or all inputs are drained,
+// after that onFinish is called exactly once.
+func StartTask(id int32, appSettings settings.AppSettings, onFinish func(*Task)) (*Task, error) {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+
+	if _, ok := tasks[id]; ok {
+		return nil, ErrTaskRunning
+	}
+
+	pipeline, err := NewPipeline(appSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Task{
+		ID:        id,
+		StartedAt: time.Now(),
+		Pipeline:  pipeline,
+		onFinish:  onFinish,
+		done:      make(chan struct{}),
+	}
+	tasks[id] = t
+
+	pipeline.Start()
+
+	// finish the task by itself once all inputs are drained, e.g. the end of a pcap file
+	go func() {
+		pipeline.Wait()
+		t.stop()
+	}()
+
+	if exitAfter := pipeline.Settings.ExitAfter; exitAfter > 0 {
+		glogs.Debug(1, fmt.Sprintf("[TASK] task %d runs for a duration of %s", id, exitAfter))
+		time.AfterFunc(exitAfter, func() {
+			glogs.Debug(1, fmt.Sprintf("[TASK] task %d run timeout %s", id, exitAfter))
+			t.stop()
+		})
+	}
+
+	if len(pipeline.sloRules) > 0 && pipeline.Settings.SLO.Abort {
+		go t.watchSLO()
+	}
+
+	return t, nil
+}
+
+// StopTask stops a running task and waits for its plugins to be closed
+func StopTask(id int32) error {
+	tasksMu.Lock()
+	t, ok := tasks[id]
+	tasksMu.Unlock()
+
+	if !ok {
+		return ErrTaskNotFound
+	}
+
+	t.stop()
+	return nil
+}
+
+// GetTask returns the running task with the given id
+func GetTask(id int32) (*Task, bool) {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+
+	t, ok := tasks[id]
+	return t, ok
+}
+
+// IsTaskRunning reports whether a task with the given id is running
+func IsTaskRunning(id int32) bool {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+
+	_, ok := tasks[id]
+	return ok
+}
+
+// watchSLO stops the task as soon as a SLO rule is breached
+func (t *Task) watchSLO() {
+	ticker := time.NewTicker(sloCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+			if !t.Pipeline.checkSLO() {
+				glogs.Debug(1, fmt.Sprintf("[TASK] task %d aborted by SLO breach", t.ID))
+				t.stop()
+				return
+			}
+		}
+	}
+}
+
+func (t *Task) stop() {
+	t.once.Do(func() {
+		close(t.done)
+		t.Pipeline.Close()
+
+		tasksMu.Lock()
+		delete(tasks, t.ID)
+		tasksMu.Unlock()
+
+		if t.onFinish != nil {
+			t.onFinish(t)
+		}
+	})
+}
@@ -1,11 +1,17 @@
 package common
 
-import "errors"
+import (
+	"errors"
+)
 
 // Message represents data across plugins
 type Message struct {
 	Meta []byte // metadata
 	Data []byte // actual data
+
+	// Capture is what Data was captured from, set by the inputs asked to keep it, e.g. the []*tcp.Packet
+	// of the HTTP and binary messages of the raw input when a pcap output is configured
+	Capture interface{}
 }
 
 // ErrorStopped is the error returned when the go routines reading the input is stopped.
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/proto"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Amplifier is a wrapper for input plugins which multiplies the traffic by a fractional factor.
+//
+// A request is emitted floor(factor) times, plus once more with the probability of the
+// fractional part. The decision is made by hashing the payload ID, so the original responses
+// fan out exactly like their requests and every duplicate can still be paired with a
+// response. The first copy keeps the payload ID, the others get "<id>-<n>" and may be
+// delayed by a random jitter to avoid replaying them in bursts.
+type Amplifier struct {
+	plugin PluginReader
+	factor uint64 // math.Float64bits of the factor, changed by SetFactor
+	jitter time.Duration
+
+	messages chan *common.Message
+	err      chan error
+	quit     chan struct{}
+	pending  sync.WaitGroup // jittered duplicates not emitted yet
+	once     sync.Once
+}
+
+// NewAmplifier constructor for Amplifier, factor must be positive
+func NewAmplifier(plugin PluginReader, factor float64, jitter time.Duration) *Amplifier {
+	a := &Amplifier{
+		plugin:   plugin,
+		factor:   math.Float64bits(factor),
+		jitter:   jitter,
+		messages: make(chan *common.Message, 1000),
+		err:      make(chan error, 1),
+		quit:     make(chan struct{}),
+	}
+
+	go a.read()
+
+	return a
+}
+
+// SetFactor changes the factor while the input is running, e.g. by a load profile
+func (a *Amplifier) SetFactor(factor float64) {
+	atomic.StoreUint64(&a.factor, math.Float64bits(factor))
+}
+
+// Factor returns the current factor
+func (a *Amplifier) Factor() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&a.factor))
+}
+
+// Copies returns how many times the payload with the given ID is emitted.
+// Changing the factor in between a request and its response may break their pairing.
+func (a *Amplifier) Copies(id []byte) int {
+	whole, frac := math.Modf(a.Factor())
+	n := int(whole)
+
+	if frac > 0 {
+		h := fnv.New32a()
+		h.Write(id)
+		if float64(h.Sum32())/float64(math.MaxUint32) < frac {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (a *Amplifier) read() {
+	for {
+		msg, err := a.plugin.PluginRead()
+		if err != nil {
+			// let the delayed duplicates through before reporting the end of the input
+			a.pending.Wait()
+			a.err <- err
+			return
+		}
+		if msg == nil {
+			continue
+		}
+
+		meta := proto.PayloadMeta(msg.Meta)
+		if len(meta) < 3 || (meta[0][0] != proto.RequestPayload && meta[0][0] != proto.ResponsePayload) {
+			a.emit(msg)
+			continue
+		}
+
+		id := meta[1]
+		copies := a.Copies(id)
+		if copies > 0 {
+			a.emit(msg)
+		}
+
+		for i := 1; i < copies; i++ {
+			dup := &common.Message{
+				Meta: []byte(fmt.Sprintf("%s %s-%d %s", meta[0], id, i, msg.Meta[len(meta[0])+len(id)+2:])),
+				Data: append([]byte(nil), msg.Data...),
+			}
+
+			if a.jitter <= 0 || meta[0][0] != proto.RequestPayload {
+				a.emit(dup)
+				continue
+			}
+
+			a.pending.Add(1)
+			time.AfterFunc(time.Duration(rand.Int63n(int64(a.jitter))), func() {
+				defer a.pending.Done()
+				a.emit(dup)
+			})
+		}
+	}
+}
+
+func (a *Amplifier) emit(msg *common.Message) {
+	select {
+	case <-a.quit:
+	case a.messages <- msg:
+	}
+}
+
+// PluginRead reads message from this plugin
+func (a *Amplifier) PluginRead() (*common.Message, error) {
+	select {
+	case <-a.quit:
+		return nil, common.ErrorStopped
+	case msg := <-a.messages:
+		return msg, nil
+	case err := <-a.err:
+		// the duplicates are emitted before the error is reported, drain what is left
+		select {
+		case msg := <-a.messages:
+			a.err <- err
+			return msg, nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+// Plugin returns the amplified plugin
+func (a *Amplifier) Plugin() interface{} {
+	return a.plugin
+}
+
+func (a *Amplifier) String() string {
+	return fmt.Sprintf("Amplifying %s by: %s (jitter: %s)", a.plugin, strconv.FormatFloat(a.Factor(), 'f', -1, 64), a.jitter)
+}
+
+// Close closes the resources.
+func (a *Amplifier) Close() error {
+	a.once.Do(func() {
+		close(a.quit)
+		if c, ok := a.plugin.(io.Closer); ok {
+			c.Close()
+		}
+	})
+	return nil
+}
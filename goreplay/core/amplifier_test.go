@@ -0,0 +1,91 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/proto"
+	"testing"
+	"time"
+)
+
+type sliceReader struct {
+	messages []*common.Message
+}
+
+func (r *sliceReader) PluginRead() (*common.Message, error) {
+	if len(r.messages) == 0 {
+		return nil, io.EOF
+	}
+	msg := r.messages[0]
+	r.messages = r.messages[1:]
+	return msg, nil
+}
+
+func readAll(t *testing.T, a *Amplifier) map[string]int {
+	ids := make(map[string]int)
+	for {
+		msg, err := a.PluginRead()
+		if err == io.EOF {
+			return ids
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[string(proto.PayloadMeta(msg.Meta)[0])+" "+string(proto.PayloadID(msg.Meta))]++
+	}
+}
+
+func TestAmplifier(t *testing.T) {
+	reader := new(sliceReader)
+	for i := 0; i < 1000; i++ {
+		id := []byte(fmt.Sprintf("%024x", i))
+		reader.messages = append(reader.messages,
+			&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, id, 1, 2), Data: []byte("GET / HTTP/1.1\r\n\r\n")},
+			&common.Message{Meta: proto.PayloadHeader(proto.ResponsePayload, id, 3, 4), Data: []byte("HTTP/1.1 200 OK\r\n\r\n")})
+	}
+
+	a := NewAmplifier(reader, 2.5, time.Millisecond)
+	defer a.Close()
+
+	ids := readAll(t, a)
+
+	requests := 0
+	for key := range ids {
+		if key[0] == proto.RequestPayload {
+			requests++
+			// every duplicated request has its own response
+			if ids["2"+key[1:]] != 1 {
+				t.Errorf("Response missing for %s", key)
+			}
+		}
+		if ids[key] != 1 {
+			t.Errorf("Payload ID %s emitted %d times", key, ids[key])
+		}
+	}
+
+	if requests < 2400 || requests > 2600 {
+		t.Errorf("Expected about 2500 requests, got %d", requests)
+	}
+	if ids["1 "+fmt.Sprintf("%024x", 0)] != 1 {
+		t.Error("The first copy should keep the payload ID")
+	}
+}
+
+func TestAmplifierMeta(t *testing.T) {
+	reader := &sliceReader{messages: []*common.Message{
+		{Meta: proto.PayloadHeader(proto.RequestPayload, []byte("abc"), 10, 20), Data: []byte("GET / HTTP/1.1\r\n\r\n")},
+	}}
+
+	a := NewAmplifier(reader, 2, 0)
+	defer a.Close()
+
+	a.PluginRead()
+	msg, err := a.PluginRead()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Meta) != "1 abc-1 10 20\n" {
+		t.Errorf("Wrong duplicate meta: %q", msg.Meta)
+	}
+}
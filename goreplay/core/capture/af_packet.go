@@ -30,3 +30,6 @@ func (h *afpacketHandle) ReadPacketData() (data []byte, ci gopacket.CaptureInfo,
 func (h *afpacketHandle) SetBPFFilter(filter string, snaplen int) (err error) {
 	return fmt.Errorf("Not implemented")
 }
+
+// Close will close afpacket source.
+func (h *afpacketHandle) Close() {}
@@ -69,9 +69,6 @@ func (h *afpacketHandle) SetBPFFilter(filter string, snaplen int) (err error) {
 		}
 		bpfIns = append(bpfIns, bpfIns2)
 	}
-	if h.TPacket.SetBPF(bpfIns); err != nil {
-		return err
-	}
 	return h.TPacket.SetBPF(bpfIns)
 }
 
@@ -1,6 +1,7 @@
 package capture
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"expvar"
@@ -11,6 +12,7 @@ import (
 	"os"
 	"record-traffic-press/goreplay/common"
 	"record-traffic-press/goreplay/core/tcp"
+	"record-traffic-press/goreplay/glogs"
 	"record-traffic-press/goreplay/proto"
 	"runtime"
 	"strings"
@@ -63,7 +65,9 @@ type PcapOptions struct {
 	Stats           bool            `json:"input-raw-stats"`
 	AllowIncomplete bool            `json:"input-raw-allow-incomplete"`
 	IgnoreInterface []string        `json:"input-raw-ignore-interface"`
+	TLSKeyLog       string          `json:"input-raw-tls-keylog"` // SSLKEYLOGFILE of the captured servers, to decrypt their TLS connections
 	Transport       string
+	KeepPackets     bool `json:"-"` // attach the captured packets to the messages, set when a pcap output is configured
 }
 
 // Listener handle traffic capture, this is its representation.
@@ -79,8 +83,10 @@ type Listener struct {
 	Reading    chan bool // this channel is closed when the listener has started reading packets
 	messages   chan *tcp.TcpMessage
 
-	ports []uint16
-	host  string // pcap file name or interface (name, hardware addr, index or ip address)
+	ports   []uint16
+	host    string            // pcap file name or interface (name, hardware addr, index or ip address)
+	filters map[string]string // effective BPF filter of every handle
+	keyLog  *tcp.KeyLog       // secrets of the TLS connections, when set
 
 	closeDone chan struct{}
 	quit      chan struct{}
@@ -156,12 +162,19 @@ func NewListener(host string, ports []uint16, config PcapOptions) (l *Listener,
 	l.config = config
 	l.config.Transport = "tcp"
 	l.Handles = make(map[string]packetHandle)
+	l.filters = make(map[string]string)
 
 	l.closeDone = make(chan struct{})
 	l.quit = make(chan struct{})
 	l.Reading = make(chan bool)
 	l.messages = make(chan *tcp.TcpMessage, 10000)
 
+	if config.TLSKeyLog != "" {
+		if l.keyLog, err = tcp.NewKeyLog(config.TLSKeyLog); err != nil {
+			return nil, fmt.Errorf("TLS key log error: %q", err)
+		}
+	}
+
 	switch config.Engine {
 	default:
 		l.Activate = l.activatePcap
@@ -266,7 +279,8 @@ func (l *Listener) ListenBackground(ctx context.Context) chan error {
 func (l *Listener) Filter(ifi pcap.Interface, hosts ...string) (filter string) {
 	// https://www.tcpdump.org/manpages/pcap-filter.7.html
 
-	if len(hosts) == 0 {
+	// the host of a pcap file is the file name, it is filtered by ports only
+	if len(hosts) == 0 && l.config.Engine != EnginePcapFile {
 		hosts = []string{l.host}
 
 		if listenAll(l.host) || isDevice(l.host, ifi) {
@@ -294,19 +308,50 @@ func (l *Listener) Filter(ifi pcap.Interface, hosts ...string) (filter string) {
 		filter = fmt.Sprintf("%s or %s", filter, responseFilter)
 	}
 
+	// "and" and "or" have the same precedence in pcap filters, so the
+	// request and response parts must be grouped before adding vlan
 	if l.config.VLAN {
 		if len(l.config.VLANVIDs) > 0 {
+			var vlans []string
 			for _, vi := range l.config.VLANVIDs {
-				filter = fmt.Sprintf("vlan %d and ", vi) + filter
+				vlans = append(vlans, fmt.Sprintf("vlan %d", vi))
 			}
+			filter = fmt.Sprintf("(%s) and (%s)", strings.Join(vlans, " or "), filter)
 		} else {
-			filter = "vlan and " + filter
+			filter = fmt.Sprintf("vlan and (%s)", filter)
 		}
 	}
 
 	return
 }
 
+// BPFFilter returns the filter applied to the handle of a specific interface,
+// a user defined input-raw-bpf-filter overrides the automatic one
+func (l *Listener) BPFFilter(ifi pcap.Interface) string {
+	if l.config.BPFFilter != "" {
+		return l.config.BPFFilter
+	}
+	return l.Filter(ifi)
+}
+
+// Filters returns the effective BPF filters keyed by handle, i.e. interface name or "pcap_file"
+func (l *Listener) Filters() map[string]string {
+	l.Lock()
+	defer l.Unlock()
+
+	filters := make(map[string]string, len(l.filters))
+	for key, filter := range l.filters {
+		filters[key] = filter
+	}
+	return filters
+}
+
+func (l *Listener) setFilter(key, filter string) {
+	l.Lock()
+	l.filters[key] = filter
+	l.Unlock()
+}
+
 // PcapHandle returns new pcap Handle from dev on success.
 // this function should be called after setting all necessary options for this listener
 func (l *Listener) PcapHandle(ifi pcap.Interface) (handle *pcap.Handle, err error) {
@@ -374,21 +419,14 @@ func (l *Listener) PcapHandle(ifi pcap.Interface) (handle *pcap.Handle, err erro
 		return nil, fmt.Errorf("PCAP Activate device error: %q, interface: %q", err, ifi.Name)
 	}
 
-	bpfFilter := l.config.BPFFilter
-	if bpfFilter == "" {
-		bpfFilter = l.Filter(ifi)
-	}
-
-	bpfFilter = "tcp dst port 20881"
-
-	//bpfFilter = "((tcp dst port 20881) and (dst host ::1 or dst host 127.0.0.1))"
-
-	fmt.Println("Interface:", ifi.Name, ". BPF Filter:", bpfFilter)
+	bpfFilter := l.BPFFilter(ifi)
+	glogs.Debug(1, "[INPUT-RAW] interface:", ifi.Name, "BPF filter:", bpfFilter)
 	err = handle.SetBPFFilter(bpfFilter)
 	if err != nil {
 		handle.Close()
 		return nil, fmt.Errorf("BPF filter error: %q%s, interface: %q", err, bpfFilter, ifi.Name)
 	}
+	l.setFilter(ifi.Name, bpfFilter)
 	return
 }
 
@@ -401,14 +439,13 @@ func (l *Listener) SocketHandle(ifi pcap.Interface) (handle Socket, err error) {
 	if err = handle.SetPromiscuous(l.config.Promiscuous || l.config.Monitor); err != nil {
 		return nil, fmt.Errorf("promiscuous mode error: %q, interface: %q", err, ifi.Name)
 	}
-	if l.config.BPFFilter == "" {
-		l.config.BPFFilter = l.Filter(ifi)
-	}
-	fmt.Println("BPF Filter: ", l.config.BPFFilter)
-	if err = handle.SetBPFFilter(l.config.BPFFilter); err != nil {
+	bpfFilter := l.BPFFilter(ifi)
+	glogs.Debug(1, "[INPUT-RAW] interface:", ifi.Name, "BPF filter:", bpfFilter)
+	if err = handle.SetBPFFilter(bpfFilter); err != nil {
 		handle.Close()
-		return nil, fmt.Errorf("BPF filter error: %q%s, interface: %q", err, l.config.BPFFilter, ifi.Name)
+		return nil, fmt.Errorf("BPF filter error: %q%s, interface: %q", err, bpfFilter, ifi.Name)
 	}
+	l.setFilter(ifi.Name, bpfFilter)
 	handle.SetLoopbackIndex(int32(l.loopIndex))
 	return
 }
@@ -435,6 +472,98 @@ func http1EndHint(m *tcp.TcpMessage) bool {
 	return proto.HasFullPayload(m, m.PacketData()...) && (req || res)
 }
 
+func dubboStartHint(pckt *tcp.Packet) (isRequest, isResponse bool) {
+	header, ok := proto.ParseDubboHeader(pckt.Payload)
+	if !ok {
+		return false, false
+	}
+
+	return header.IsRequest(), !header.IsRequest()
+}
+
+// dubboEndHint ends a message once it consists of whole dubbo frames
+func dubboEndHint(m *tcp.TcpMessage) bool {
+	if m.MissingChunk() {
+		return false
+	}
+
+	_, complete := proto.DubboFrames(bytes.Join(m.PacketData(), nil))
+	return complete
+}
+
+// http2StartHint only recognizes the client preface, the direction of the other packets is given by the ports
+func http2StartHint(pckt *tcp.Packet) (isRequest, isResponse bool) {
+	return bytes.HasPrefix(pckt.Payload, proto.HTTP2Preface), false
+}
+
+// http2EndHint ends a message once it consists of whole HTTP/2 frames, the streams are rebuilt from them later
+func http2EndHint(m *tcp.TcpMessage) bool {
+	if m.MissingChunk() {
+		return false
+	}
+
+	_, complete := proto.HTTP2Frames(bytes.Join(m.PacketData(), nil))
+	return complete
+}
+
+// redisStartHint takes arrays of bulk strings for commands and the other RESP values for replies,
+// an array reply of bulk strings is only told apart by the ports
+func redisStartHint(pckt *tcp.Packet) (isRequest, isResponse bool) {
+	if len(pckt.Payload) == 0 {
+		return false, false
+	}
+	switch pckt.Payload[0] {
+	case proto.RedisArray:
+		if i := bytes.Index(pckt.Payload, proto.CRLF); i > 0 && i+2 < len(pckt.Payload) {
+			isRequest = pckt.Payload[i+2] == proto.RedisBulkString
+		}
+		return isRequest, !isRequest
+	case proto.RedisSimpleString, proto.RedisError, proto.RedisInteger, proto.RedisBulkString, proto.RedisNull,
+		proto.RedisDouble, proto.RedisBoolean, proto.RedisBlobError, proto.RedisVerbatim, proto.RedisBigNumber,
+		proto.RedisMap, proto.RedisSet, proto.RedisAttribute, proto.RedisPush:
+		return false, true
+	}
+	return false, false
+}
+
+// redisEndHint ends a message once it consists of whole RESP values, pipelined ones are split later
+func redisEndHint(m *tcp.TcpMessage) bool {
+	if m.MissingChunk() {
+		return false
+	}
+
+	_, complete := proto.RedisFrames(bytes.Join(m.PacketData(), nil))
+	return complete
+}
+
+// mysqlStartHint takes the packets starting a command for requests, the server greeting and the
+// client handshake are only told apart by the ports
+func mysqlStartHint(pckt *tcp.Packet) (isRequest, isResponse bool) {
+	if len(pckt.Payload) <= proto.MySQLPacketHeaderLength {
+		return false, false
+	}
+	if pckt.Payload[3] != 0 {
+		return false, true
+	}
+	switch pckt.Payload[proto.MySQLPacketHeaderLength] {
+	case proto.MySQLComQuit, proto.MySQLComInitDB, proto.MySQLComQuery, proto.MySQLComPing, proto.MySQLComStmtPrepare,
+		proto.MySQLComStmtExecute, proto.MySQLComStmtSendLongData, proto.MySQLComStmtClose, proto.MySQLComStmtReset:
+		return true, false
+	}
+	return false, false
+}
+
+// mysqlEndHint ends a message once it consists of whole MySQL packets, a result set may span several
+// messages and is rebuilt later
+func mysqlEndHint(m *tcp.TcpMessage) bool {
+	if m.MissingChunk() {
+		return false
+	}
+
+	_, complete := proto.MySQLPackets(bytes.Join(m.PacketData(), nil))
+	return complete
+}
+
 func (l *Listener) readHandle(key string, hndl packetHandle) {
 	runtime.LockOSThread()
 
@@ -457,6 +586,21 @@ func (l *Listener) readHandle(key string, hndl packetHandle) {
 	if l.config.Protocol == tcp.ProtocolHTTP {
 		messageParser.Start = http1StartHint
 		messageParser.End = http1EndHint
+	} else if l.config.Protocol == tcp.ProtocolDubbo {
+		messageParser.Start = dubboStartHint
+		messageParser.End = dubboEndHint
+	} else if l.config.Protocol == tcp.ProtocolHTTP2 {
+		messageParser.Start = http2StartHint
+		messageParser.End = http2EndHint
+	} else if l.config.Protocol == tcp.ProtocolRedis {
+		messageParser.Start = redisStartHint
+		messageParser.End = redisEndHint
+	} else if l.config.Protocol == tcp.ProtocolMySQL {
+		messageParser.Start = mysqlStartHint
+		messageParser.End = mysqlEndHint
+	}
+	if l.keyLog != nil {
+		messageParser.TLS = tcp.NewTLSDecrypter(l.keyLog, l.config.Expire)
 	}
 
 	timer := time.NewTicker(1 * time.Second)
@@ -600,17 +744,14 @@ func (l *Listener) activatePcapFile() (err error) {
 		return fmt.Errorf("open pcap file error: %q", e)
 	}
 
-	tmp := l.host
-	l.host = ""
-	l.config.BPFFilter = l.Filter(pcap.Interface{})
-	l.host = tmp
-
-	if e = handle.SetBPFFilter(l.config.BPFFilter); e != nil {
+	bpfFilter := l.BPFFilter(pcap.Interface{})
+	if e = handle.SetBPFFilter(bpfFilter); e != nil {
 		handle.Close()
-		return fmt.Errorf("BPF filter error: %q, filter: %s", e, l.config.BPFFilter)
+		return fmt.Errorf("BPF filter error: %q, filter: %s", e, bpfFilter)
 	}
 
-	fmt.Println("BPF Filter:", l.config.BPFFilter)
+	glogs.Debug(1, "[INPUT-RAW] BPF filter:", bpfFilter)
+	l.setFilter("pcap_file", bpfFilter)
 
 	l.Handles["pcap_file"] = packetHandle{
 		handler: handle,
@@ -637,11 +778,14 @@ func (l *Listener) activateAFPacket() error {
 			continue
 		}
 
-		if l.config.BPFFilter == "" {
-			l.config.BPFFilter = l.Filter(ifi)
+		bpfFilter := l.BPFFilter(ifi)
+		glogs.Debug(1, "[INPUT-RAW] interface:", ifi.Name, "BPF filter:", bpfFilter)
+		if err = handle.SetBPFFilter(bpfFilter, 64<<10); err != nil {
+			handle.Close()
+			msg += fmt.Sprintf("\nBPF filter error: %q%s, interface: %q", err, bpfFilter, ifi.Name)
+			continue
 		}
-		fmt.Println("Interface:", ifi.Name, ". BPF Filter:", l.config.BPFFilter)
-		handle.SetBPFFilter(l.config.BPFFilter, 64<<10)
+		l.setFilter(ifi.Name, bpfFilter)
 
 		l.Handles[ifi.Name] = packetHandle{
 			handler: handle,
@@ -1,7 +1,20 @@
 package capture
 
 import (
+	"expvar"
+	"fmt"
+	"net"
+	"os"
+	"record-traffic-press/goreplay/core/tcp"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
 )
 
 func TestSetInterfaces(t *testing.T) {
@@ -20,3 +33,305 @@ func TestSetInterfaces(t *testing.T) {
 		t.Errorf("loopback nic index was not found")
 	}
 }
+
+func TestFilter(t *testing.T) {
+	ifi := pcap.Interface{Name: "eth0", Addresses: []pcap.InterfaceAddress{{IP: net.ParseIP("10.0.0.2")}}}
+
+	tests := []struct {
+		name   string
+		host   string
+		ports  []uint16
+		config PcapOptions
+		filter string
+	}{
+		{
+			name:   "host",
+			host:   "127.0.0.1",
+			ports:  []uint16{80},
+			filter: "((tcp dst port 80) and (dst host 127.0.0.1))",
+		},
+		{
+			name:   "track response",
+			host:   "127.0.0.1",
+			ports:  []uint16{80},
+			config: PcapOptions{TrackResponse: true},
+			filter: "((tcp dst port 80) and (dst host 127.0.0.1)) or ((tcp src port 80) and (src host 127.0.0.1))",
+		},
+		{
+			name:   "promiscuous",
+			host:   "127.0.0.1",
+			ports:  []uint16{80},
+			config: PcapOptions{TrackResponse: true, Promiscuous: true},
+			filter: "(tcp dst port 80) or (tcp src port 80)",
+		},
+		{
+			name:   "vlan",
+			host:   "127.0.0.1",
+			ports:  []uint16{80},
+			config: PcapOptions{TrackResponse: true, Promiscuous: true, VLAN: true},
+			filter: "vlan and ((tcp dst port 80) or (tcp src port 80))",
+		},
+		{
+			name:   "vlan ids",
+			host:   "127.0.0.1",
+			ports:  []uint16{80, 8080},
+			config: PcapOptions{Promiscuous: true, VLAN: true, VLANVIDs: []int{1, 2}},
+			filter: "(vlan 1 or vlan 2) and ((tcp dst port 80 or tcp dst port 8080))",
+		},
+		{
+			name:   "interface addresses",
+			host:   "",
+			ports:  []uint16{80},
+			filter: "((tcp dst port 80) and (dst host 10.0.0.2))",
+		},
+		{
+			name:   "pcap file",
+			host:   "testdata/http.pcap",
+			ports:  []uint16{0},
+			config: PcapOptions{Engine: EnginePcapFile},
+			filter: "(tcp dst portrange 0-65535)",
+		},
+		{
+			name:   "user override",
+			host:   "127.0.0.1",
+			ports:  []uint16{80},
+			config: PcapOptions{TrackResponse: true, BPFFilter: "tcp port 20881"},
+			filter: "tcp port 20881",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener := &Listener{host: tt.host, ports: tt.ports, config: tt.config}
+			listener.config.Transport = "tcp"
+
+			if filter := listener.BPFFilter(ifi); filter != tt.filter {
+				t.Errorf("expected filter %q, got %q", tt.filter, filter)
+			}
+		})
+	}
+}
+
+func TestPcapFileFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		ports  []uint16
+		config PcapOptions
+		// destination ports of the packets that pass the filter
+		dstPorts []uint16
+	}{
+		{
+			name:     "requests",
+			ports:    []uint16{8000},
+			dstPorts: []uint16{8000},
+		},
+		{
+			name:     "track response",
+			ports:    []uint16{8000},
+			config:   PcapOptions{TrackResponse: true},
+			dstPorts: []uint16{8000, 50000},
+		},
+		{
+			name:     "all ports",
+			ports:    []uint16{0},
+			dstPorts: []uint16{8000, 50000, 9000, 50001},
+		},
+		{
+			name:     "user override",
+			ports:    []uint16{8000},
+			config:   PcapOptions{BPFFilter: "tcp port 9000"},
+			dstPorts: []uint16{9000, 50001},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Engine = EnginePcapFile
+			listener, err := NewListener("testdata/http.pcap", tt.ports, tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = listener.Activate(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := listener.Filters()["pcap_file"]; !ok {
+				t.Errorf("effective filter of the pcap file is not exposed: %v", listener.Filters())
+			}
+
+			handle := listener.Handles["pcap_file"].handler.(*pcap.Handle)
+			defer handle.Close()
+
+			var dstPorts []uint16
+			for {
+				data, _, err := handle.ReadPacketData()
+				if err != nil {
+					break
+				}
+				packet := gopacket.NewPacket(data, handle.LinkType(), gopacket.Default)
+				if tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+					dstPorts = append(dstPorts, uint16(tcp.DstPort))
+				}
+			}
+
+			if fmt.Sprint(dstPorts) != fmt.Sprint(tt.dstPorts) {
+				t.Errorf("expected packets to ports %v, got %v", tt.dstPorts, dstPorts)
+			}
+		})
+	}
+}
+
+func TestDubboStartHint(t *testing.T) {
+	request := []byte{0xda, 0xbb, 0xc2, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 3, 'a', 'b', 'c'}
+	if isRequest, isResponse := dubboStartHint(&tcp.Packet{Payload: request}); !isRequest || isResponse {
+		t.Errorf("dubbo request not detected: %v %v", isRequest, isResponse)
+	}
+
+	response := []byte{0xda, 0xbb, 0x02, 20, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+	if isRequest, isResponse := dubboStartHint(&tcp.Packet{Payload: response}); isRequest || !isResponse {
+		t.Errorf("dubbo response not detected: %v %v", isRequest, isResponse)
+	}
+
+	if isRequest, isResponse := dubboStartHint(&tcp.Packet{Payload: []byte("GET / HTTP/1.1\r\n\r\n")}); isRequest || isResponse {
+		t.Error("http payload detected as dubbo")
+	}
+}
+
+func TestHTTP2StartHint(t *testing.T) {
+	settings := []byte{0, 0, 0, 4, 0, 0, 0, 0, 0}
+	if isRequest, isResponse := http2StartHint(&tcp.Packet{Payload: append([]byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"), settings...)}); !isRequest || isResponse {
+		t.Errorf("http2 preface not detected: %v %v", isRequest, isResponse)
+	}
+
+	if isRequest, isResponse := http2StartHint(&tcp.Packet{Payload: settings}); isRequest || isResponse {
+		t.Errorf("http2 frame direction should be given by the ports: %v %v", isRequest, isResponse)
+	}
+}
+
+func TestRedisStartHint(t *testing.T) {
+	cases := []struct {
+		payload               string
+		isRequest, isResponse bool
+	}{
+		{"*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", true, false},
+		{"*2\r\n:1\r\n:2\r\n", false, true},
+		{"+OK\r\n", false, true},
+		{"-ERR unknown command\r\n", false, true},
+		{"%1\r\n+a\r\n:1\r\n", false, true},
+		{"PING\r\n", false, false},
+	}
+	for _, c := range cases {
+		if isRequest, isResponse := redisStartHint(&tcp.Packet{Payload: []byte(c.payload)}); isRequest != c.isRequest || isResponse != c.isResponse {
+			t.Errorf("%q: expected %v %v, got %v %v", c.payload, c.isRequest, c.isResponse, isRequest, isResponse)
+		}
+	}
+}
+
+func TestMySQLStartHint(t *testing.T) {
+	cases := []struct {
+		payload               string
+		isRequest, isResponse bool
+	}{
+		{"\x09\x00\x00\x00\x03SELECT 1", true, false},
+		{"\x01\x00\x00\x00\x0e", true, false},
+		{"\x01\x00\x00\x01\x01", false, true},
+		{"\x07\x00\x00\x01\x00\x00\x00\x02\x00\x00\x00", false, true},
+		// the client handshake response, on a connection being established
+		{"\x20\x00\x00\x01\x8d\xa6\x0f\x00", false, true},
+		{"\x05\x00\x00\x00\x7fabcd", false, false},
+		{"\x01\x00\x00", false, false},
+	}
+	for _, c := range cases {
+		if isRequest, isResponse := mysqlStartHint(&tcp.Packet{Payload: []byte(c.payload)}); isRequest != c.isRequest || isResponse != c.isResponse {
+			t.Errorf("%q: expected %v %v, got %v %v", c.payload, c.isRequest, c.isResponse, isRequest, isResponse)
+		}
+	}
+}
+
+// retransmittingSource replays the packets of a pcap file read without libpcap, the packets with a payload twice
+type retransmittingSource struct {
+	reader *pcapgo.Reader
+	again  []byte
+	ci     gopacket.CaptureInfo
+}
+
+func (s *retransmittingSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if s.again != nil {
+		data := s.again
+		s.again = nil
+		return data, s.ci, nil
+	}
+	data, ci, err := s.reader.ReadPacketData()
+	if err == nil && len(data) > 14+20+20 {
+		s.again, s.ci = append([]byte{}, data...), ci
+	}
+	return data, ci, err
+}
+
+func tlsStat(name string) int64 {
+	if v, ok := expvar.Get("tcp").(*expvar.Map).Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// testdata/tls.pcap holds the HTTP/1.1 exchanges of a local Go TLS server, whose KeyLogWriter wrote
+// testdata/tls.keylog, over TLS 1.2 with AES-GCM and ChaCha20-Poly1305, over TLS 1.3, and over TLS 1.3
+// without logging the secrets
+func TestTLSKeyLog(t *testing.T) {
+	f, err := os.Open("testdata/tls.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connections, decrypted, missingKey := tlsStat("tls_connections"), tlsStat("tls_decrypted"), tlsStat("tls_missing_key")
+
+	config := PcapOptions{Engine: EnginePcapFile, TrackResponse: true, TLSKeyLog: "testdata/tls.keylog", Expire: 200 * time.Millisecond}
+	listener, err := NewListener("testdata/tls.pcap", []uint16{8443}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Handles["tls"] = packetHandle{handler: &retransmittingSource{reader: reader}}
+	go listener.readHandle("tls", listener.Handles["tls"])
+
+	requests := make(map[string]string)
+	responses := make(map[string]string)
+	for len(requests)+len(responses) < 10 {
+		select {
+		case m := <-listener.Messages():
+			if m.Direction == tcp.DirIncoming {
+				requests[string(m.UUID())] = string(m.Data())
+			} else {
+				responses[string(m.UUID())] = string(m.Data())
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for the decrypted messages, got %q %q", requests, responses)
+		}
+	}
+
+	var paths []string
+	for id, request := range requests {
+		path := strings.Fields(request)[1]
+		paths = append(paths, path)
+		if !strings.HasSuffix(responses[id], "\r\n\r\nhello "+path) {
+			t.Errorf("%s: unexpected response %q", path, responses[id])
+		}
+	}
+	sort.Strings(paths)
+	if fmt.Sprint(paths) != "[/chacha/1 /tls12/1 /tls12/2 /tls13/1 /tls13/2]" {
+		t.Errorf("Unexpected requests %q", paths)
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); tlsStat("tls_missing_key") == missingKey && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if tlsStat("tls_connections")-connections != 4 || tlsStat("tls_decrypted")-decrypted != 3 || tlsStat("tls_missing_key")-missingKey != 1 {
+		t.Errorf("Expected 4 connections, 3 decrypted and 1 without key, got %d %d %d", tlsStat("tls_connections")-connections,
+			tlsStat("tls_decrypted")-decrypted, tlsStat("tls_missing_key")-missingKey)
+	}
+}
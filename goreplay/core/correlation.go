@@ -0,0 +1,433 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"record-traffic-press/goreplay/glogs"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// correlationTTL forgets the sessions without requests and the responses never paired for this long
+const correlationTTL = 2 * time.Minute
+
+// maxCorrelationValues caps the values replaced in the requests of a session
+const maxCorrelationValues = 1000
+
+// CorrelationRule extracts values from HTTP responses, either with a regexp matched against the
+// whole response, the first group being the value if any, or with a path into its JSON body
+type CorrelationRule struct {
+	Expr string
+	re   *regexp.Regexp
+	path []interface{} // string keys and int indexes
+}
+
+// ParseCorrelationRule parses "re:<regexp>", e.g. `re:csrf_token" value="(\w+)"`, or
+// "json:<path>", e.g. "json:$.data.items[0].id"
+func ParseCorrelationRule(expr string) (CorrelationRule, error) {
+	rule := CorrelationRule{Expr: expr}
+
+	kind, value, ok := strings.Cut(expr, ":")
+	if !ok || value == "" {
+		return rule, fmt.Errorf("correlation %q: expected re:<regexp> or json:<path>", expr)
+	}
+
+	switch kind {
+	case "re":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return rule, fmt.Errorf("correlation %q: %v", expr, err)
+		}
+		rule.re = re
+	case "json":
+		path, err := proto.ParseJSONPath(value)
+		if err != nil {
+			return rule, fmt.Errorf("correlation %q: %v", expr, err)
+		}
+		rule.path = path
+	default:
+		return rule, fmt.Errorf("correlation %q: unknown kind %q", expr, kind)
+	}
+
+	return rule, nil
+}
+
+// ParseCorrelationRules parses all the rules, see ParseCorrelationRule
+func ParseCorrelationRules(exprs []string) ([]CorrelationRule, error) {
+	rules := make([]CorrelationRule, 0, len(exprs))
+	for _, expr := range exprs {
+		rule, err := ParseCorrelationRule(expr)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Extract returns the values of the rule found in a HTTP response, in order
+func (r *CorrelationRule) Extract(response []byte) []string {
+	if r.re != nil {
+		var values []string
+		for _, match := range r.re.FindAllSubmatch(response, -1) {
+			value := match[0]
+			if len(match) > 1 {
+				value = match[1]
+			}
+			if len(value) > 0 {
+				values = append(values, string(value))
+			}
+		}
+		return values
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(proto.Body(response)))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil
+	}
+
+	for _, segment := range r.path {
+		switch s := segment.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = m[s]
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || s >= len(a) {
+				return nil
+			}
+			v = a[s]
+		}
+	}
+
+	switch value := v.(type) {
+	case string:
+		if value != "" {
+			return []string{value}
+		}
+	case json.Number:
+		return []string{value.String()}
+	}
+	return nil
+}
+
+type correlationSession struct {
+	values  map[string]string        // original value -> replayed value
+	pending map[string]chan struct{} // original values waiting for the replayed response, closed once known
+	updated time.Time
+}
+
+// resolve releases the requests waiting for an original value, c.mu must be held
+func (s *correlationSession) resolve(original string) {
+	if ch, ok := s.pending[original]; ok {
+		close(ch)
+		delete(s.pending, original)
+	}
+}
+
+type correlationResponses struct {
+	session            string
+	original, replayed [][]string // values per rule, nil until the response is received
+	received           time.Time
+}
+
+// Correlator pairs the values extracted from an original response with the ones extracted from its
+// replayed response, and replaces the original values by the replayed ones in the later requests of
+// the same session. A request carrying an original value whose replayed response is still awaited
+// waits for it, up to the configured time, unless the wait is 0.
+type Correlator struct {
+	rules     []CorrelationRule
+	session   string
+	wait      time.Duration
+	mu        sync.Mutex
+	sessions  map[string]*correlationSession
+	responses map[string]*correlationResponses // keyed by payload ID
+	swept     time.Time
+}
+
+// NewCorrelator constructor for Correlator, nil when there are no rules. Invalid rules are skipped,
+// they are expected to be checked with ParseCorrelationRules beforehand.
+func NewCorrelator(config *settings.CorrelationConfig) *Correlator {
+	var rules []CorrelationRule
+	for _, expr := range config.Rules {
+		rule, err := ParseCorrelationRule(expr)
+		if err != nil {
+			glogs.Debug(1, "[CORRELATION]", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return &Correlator{
+		rules:     rules,
+		session:   config.Session,
+		wait:      config.Wait,
+		sessions:  make(map[string]*correlationSession),
+		responses: make(map[string]*correlationResponses),
+		swept:     time.Now(),
+	}
+}
+
+// Observe extracts the values of an original (proto.ResponsePayload) or a replayed
+// (proto.ReplayedResponsePayload) response, the values are paired once both are received
+func (c *Correlator) Observe(kind byte, id []byte, response []byte) {
+	values := make([][]string, len(c.rules))
+	found := false
+	for i := range c.rules {
+		values[i] = c.rules[i].Extract(response)
+		found = found || len(values[i]) > 0
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep(now)
+
+	r, ok := c.responses[string(id)]
+	if !ok {
+		if !found {
+			return
+		}
+		r = &correlationResponses{session: string(proto.SessionID(id, c.session)), received: now}
+		c.responses[string(id)] = r
+	}
+
+	s := c.sessionLocked(r.session, now)
+	if kind == proto.ReplayedResponsePayload {
+		r.replayed = values
+	} else {
+		r.original = values
+		for _, originals := range values {
+			for _, original := range originals {
+				if _, ok := s.pending[original]; !ok {
+					s.pending[original] = make(chan struct{})
+				}
+			}
+		}
+	}
+	if r.original == nil || r.replayed == nil {
+		return
+	}
+
+	delete(c.responses, string(id))
+	for i, originals := range r.original {
+		for j, original := range originals {
+			if j < len(r.replayed[i]) && r.replayed[i][j] != original && len(s.values) < maxCorrelationValues {
+				s.values[original] = r.replayed[i][j]
+				glogs.Debug(2, "[CORRELATION]", c.rules[i].Expr, "session:", r.session, original, "->", r.replayed[i][j])
+			}
+			s.resolve(original)
+		}
+	}
+}
+
+// sessionLocked returns the session, created if needed, c.mu must be held
+func (c *Correlator) sessionLocked(id string, now time.Time) *correlationSession {
+	s, ok := c.sessions[id]
+	if !ok {
+		s = &correlationSession{values: make(map[string]string), pending: make(map[string]chan struct{})}
+		c.sessions[id] = s
+	}
+	s.updated = now
+	return s
+}
+
+// sweep forgets the idle sessions and the responses never paired, c.mu must be held
+func (c *Correlator) sweep(now time.Time) {
+	if now.Sub(c.swept) < time.Second {
+		return
+	}
+	c.swept = now
+
+	for id, r := range c.responses {
+		if now.Sub(r.received) < correlationTTL {
+			continue
+		}
+		delete(c.responses, id)
+		if s, ok := c.sessions[r.session]; ok {
+			for _, originals := range r.original {
+				for _, original := range originals {
+					s.resolve(original)
+				}
+			}
+		}
+	}
+	for id, s := range c.sessions {
+		if now.Sub(s.updated) >= correlationTTL {
+			for original := range s.pending {
+				s.resolve(original)
+			}
+			delete(c.sessions, id)
+		}
+	}
+}
+
+// Rewrite replaces the original values known in the session of a request by the replayed ones
+func (c *Correlator) Rewrite(id []byte, request []byte) []byte {
+	session := string(proto.SessionID(id, c.session))
+
+	c.mu.Lock()
+	s, ok := c.sessions[session]
+	if !ok {
+		c.mu.Unlock()
+		return request
+	}
+	var waits []chan struct{}
+	for original, ch := range s.pending {
+		if bytes.Contains(request, []byte(original)) {
+			waits = append(waits, ch)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(waits) > 0 && c.wait > 0 && !c.await(waits) {
+		glogs.Debug(2, "[CORRELATION] replayed values not received in time, session:", session)
+		// don't make the next requests wait for them too
+		c.mu.Lock()
+		for original, ch := range s.pending {
+			for _, w := range waits {
+				if ch == w {
+					s.resolve(original)
+				}
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	s.updated = time.Now()
+	originals := make([]string, 0, len(s.values))
+	for original := range s.values {
+		if bytes.Contains(request, []byte(original)) {
+			originals = append(originals, original)
+		}
+	}
+	// the longest values first, so a value containing another one is replaced as a whole
+	sort.Slice(originals, func(i, j int) bool { return len(originals[i]) > len(originals[j]) })
+	replaced := make([]string, len(originals))
+	for i, original := range originals {
+		replaced[i] = s.values[original]
+	}
+	c.mu.Unlock()
+
+	if len(originals) == 0 {
+		return request
+	}
+	return replaceCorrelated(request, originals, replaced)
+}
+
+// replaceCorrelated replaces the original values by the replayed ones in the path and query, the header
+// values and the body of a HTTP request, the method, the protocol and the header names are left as is
+func replaceCorrelated(request []byte, originals, replaced []string) []byte {
+	lineEnd := bytes.Index(request, []byte("\r\n"))
+	headersEnd := bytes.Index(request, []byte("\r\n\r\n"))
+	if lineEnd < 0 || headersEnd < lineEnd {
+		return request
+	}
+
+	rewritten := make([]byte, 0, len(request))
+
+	// request line: method, path and query, protocol
+	line := request[:lineEnd]
+	pathStart := bytes.IndexByte(line, ' ') + 1
+	pathEnd := bytes.LastIndexByte(line, ' ')
+	if pathStart <= 0 || pathEnd < pathStart {
+		pathStart, pathEnd = len(line), len(line)
+	}
+	rewritten = append(rewritten, line[:pathStart]...)
+	rewritten = replaceTokens(rewritten, line[pathStart:pathEnd], originals, replaced)
+	rewritten = append(rewritten, line[pathEnd:]...)
+
+	for _, header := range bytes.Split(request[lineEnd+2:headersEnd], []byte("\r\n")) {
+		rewritten = append(rewritten, "\r\n"...)
+		colon := bytes.IndexByte(header, ':')
+		if colon < 0 || bytes.EqualFold(header[:colon], []byte("Content-Length")) {
+			rewritten = append(rewritten, header...)
+			continue
+		}
+		rewritten = append(rewritten, header[:colon+1]...)
+		rewritten = replaceTokens(rewritten, header[colon+1:], originals, replaced)
+	}
+
+	rewritten = append(rewritten, "\r\n\r\n"...)
+	rewritten = replaceTokens(rewritten, request[headersEnd+4:], originals, replaced)
+
+	return fixContentLength(request, rewritten)
+}
+
+// replaceTokens appends data to dst with the originals replaced where they are whole tokens, so an
+// original value like "1" is not replaced within "10" or "1.5". The originals are tried in order.
+func replaceTokens(dst, data []byte, originals, replaced []string) []byte {
+	last := 0
+	for i := 0; i < len(data); {
+		matched := false
+		for j, original := range originals {
+			end := i + len(original)
+			if end > len(data) || string(data[i:end]) != original || !tokenBoundary(data, i, end) {
+				continue
+			}
+			dst = append(dst, data[last:i]...)
+			dst = append(dst, replaced[j]...)
+			i, last, matched = end, end, true
+			break
+		}
+		if !matched {
+			i++
+		}
+	}
+	return append(dst, data[last:]...)
+}
+
+// tokenBoundary tells whether data[start:end] isn't part of a longer word or number
+func tokenBoundary(data []byte, start, end int) bool {
+	if start > 0 && isTokenByte(data[start]) && continuesToken(data, start-1, -1) {
+		return false
+	}
+	if end < len(data) && isTokenByte(data[end-1]) && continuesToken(data, end, 1) {
+		return false
+	}
+	return true
+}
+
+// continuesToken tells whether data[i] extends the token next to it, the step being the direction
+// away from it; a dot only does when a digit follows, like in a decimal number
+func continuesToken(data []byte, i, step int) bool {
+	if isTokenByte(data[i]) {
+		return true
+	}
+	next := i + step
+	return data[i] == '.' && next >= 0 && next < len(data) && data[next] >= '0' && data[next] <= '9'
+}
+
+func isTokenByte(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b == '_' || b == '-'
+}
+
+// await waits for all the channels to be closed, false on timeout
+func (c *Correlator) await(waits []chan struct{}) bool {
+	timer := time.NewTimer(c.wait)
+	defer timer.Stop()
+
+	for _, ch := range waits {
+		select {
+		case <-ch:
+		case <-timer.C:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCorrelationRuleExtract(t *testing.T) {
+	response := []byte("HTTP/1.1 200 OK\r\nSet-Cookie: sid=abc123; Path=/\r\n\r\n" +
+		`{"data":{"token":"t-1","items":[{"id":42},{"id":43}]}}`)
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{`re:sid=(\w+)`, []string{"abc123"}},
+		{`re:"id":\d+`, []string{`"id":42`, `"id":43`}},
+		{"json:$.data.token", []string{"t-1"}},
+		{"json:data.items[1].id", []string{"43"}},
+		{"json:data.items[2].id", nil},
+		{"json:data.missing", nil},
+	}
+	for _, tt := range tests {
+		rule, err := ParseCorrelationRule(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rule.Extract(response); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: expected %q, got %q", tt.expr, tt.want, got)
+		}
+	}
+
+	for _, expr := range []string{"token", "xpath:/a", "re:(", "json:a[x]", "json:$"} {
+		if _, err := ParseCorrelationRule(expr); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+}
+
+func TestCorrelator(t *testing.T) {
+	c := NewCorrelator(&settings.CorrelationConfig{
+		Rules:   []string{"json:token"},
+		Session: proto.SessionByConnection,
+		Wait:    time.Second,
+	})
+
+	login := []byte("00000000000000010000000a")
+	c.Observe(proto.ResponsePayload, login, []byte("HTTP/1.1 200 OK\r\n\r\n{\"token\":\"original\"}"))
+
+	// the next request of the session waits for the replayed response of the login
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		c.Observe(proto.ReplayedResponsePayload, login, []byte("HTTP/1.1 200 OK\r\n\r\n{\"token\":\"replayed-value\"}"))
+	}()
+
+	request := []byte("POST /cart HTTP/1.1\r\nContent-Length: 17\r\n\r\ntoken=original&x=")
+	start := time.Now()
+	got := c.Rewrite([]byte("00000000000000010000000b"), request)
+	if time.Since(start) < 40*time.Millisecond {
+		t.Errorf("expected the request to wait for the replayed value")
+	}
+	want := "POST /cart HTTP/1.1\r\nContent-Length: 23\r\n\r\ntoken=replayed-value&x="
+	if string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// other sessions are left as is
+	if got := c.Rewrite([]byte("00000000000000020000000b"), request); string(got) != string(request) {
+		t.Errorf("expected the request of another session unchanged, got %q", got)
+	}
+}
+
+func TestCorrelatorWaitTimeout(t *testing.T) {
+	c := NewCorrelator(&settings.CorrelationConfig{
+		Rules:   []string{`re:sid=(\w+)`},
+		Session: proto.SessionByClient,
+		Wait:    50 * time.Millisecond,
+	})
+
+	c.Observe(proto.ResponsePayload, []byte("00000000000000010000000a"), []byte("HTTP/1.1 200 OK\r\nSet-Cookie: sid=abc\r\n\r\n"))
+
+	request := []byte("GET / HTTP/1.1\r\nCookie: sid=abc\r\n\r\n")
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		if got := c.Rewrite([]byte("00000000000000010000000b"), request); string(got) != string(request) {
+			t.Errorf("expected the request unchanged, got %q", got)
+		}
+		// only the first request waits for the missing replayed response
+		if elapsed := time.Since(start); (i == 0) != (elapsed >= 40*time.Millisecond) {
+			t.Errorf("request %d: unexpected wait of %s", i, elapsed)
+		}
+	}
+}
+
+func TestCorrelatorRewriteTokens(t *testing.T) {
+	c := NewCorrelator(&settings.CorrelationConfig{
+		Rules:   []string{"json:id"},
+		Session: proto.SessionByConnection,
+	})
+
+	created := []byte("00000000000000010000000a")
+	c.Observe(proto.ResponsePayload, created, []byte("HTTP/1.1 201 Created\r\n\r\n{\"id\":1}"))
+	c.Observe(proto.ReplayedResponsePayload, created, []byte("HTTP/1.1 201 Created\r\n\r\n{\"id\":20}"))
+
+	request := []byte("POST /orders/1/items?order=1&page=10 HTTP/1.1\r\nX-Order: 1\r\nX-Total: 1.5\r\nContent-Length: 32\r\n\r\n{\"order\":1,\"qty\":11,\"price\":1.1}")
+	want := "POST /orders/20/items?order=20&page=10 HTTP/1.1\r\nX-Order: 20\r\nX-Total: 1.5\r\nContent-Length: 33\r\n\r\n{\"order\":20,\"qty\":11,\"price\":1.1}"
+	if got := c.Rewrite([]byte("00000000000000010000000b"), request); string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
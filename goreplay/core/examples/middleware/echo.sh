@@ -0,0 +1,15 @@
+#!/usr/bin/env bash
+#
+# Middleware passing every message back unchanged. The messages are hex encoded, one per line.
+# Logging goes to STDERR, STDOUT only carries the messages.
+
+function log {
+    if [[ -n "$GOR_TEST" ]]; then
+        >&2 echo "[DEBUG][MIDDLEWARE] $1"
+    fi
+}
+
+while read -r line; do
+    log "message: $line"
+    echo "$line"
+done
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Histogram is a HDR style histogram of durations with microsecond resolution.
+// Values are counted in log-linear buckets, every power of two is split into
+// histogramSubBuckets/2 buckets, so a recorded value is off by 1.6% at most.
+// Values above histogramMaxValue are counted as histogramMaxValue.
+type Histogram struct {
+	counts []int64
+	count  int64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+const (
+	histogramSubBuckets = 128
+	histogramMaxValue   = int64(1) << 32 // microseconds, about 71 minutes
+)
+
+var histogramBuckets = histogramIndex(histogramMaxValue) + 1
+
+// NewHistogram returns an empty Histogram
+func NewHistogram() *Histogram {
+	return &Histogram{counts: make([]int64, histogramBuckets), min: math.MaxInt64}
+}
+
+func histogramIndex(v int64) int {
+	if v < histogramSubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - bits.Len64(histogramSubBuckets-1)
+	return histogramSubBuckets + (shift-1)*histogramSubBuckets/2 + int(v>>uint(shift)) - histogramSubBuckets/2
+}
+
+// histogramValue returns the highest value counted by a bucket
+func histogramValue(index int) int64 {
+	if index < histogramSubBuckets {
+		return int64(index)
+	}
+	shift := (index-histogramSubBuckets)/(histogramSubBuckets/2) + 1
+	sub := int64((index-histogramSubBuckets)%(histogramSubBuckets/2) + histogramSubBuckets/2)
+	return (sub+1)<<uint(shift) - 1
+}
+
+// Record counts a duration
+func (h *Histogram) Record(d time.Duration) {
+	v := d.Microseconds()
+	if v < 0 {
+		v = 0
+	}
+	if v > histogramMaxValue {
+		v = histogramMaxValue
+	}
+
+	h.counts[histogramIndex(v)]++
+	h.count++
+	h.sum += v
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// Merge adds the values of another histogram
+func (h *Histogram) Merge(other *Histogram) {
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.count += other.count
+	h.sum += other.sum
+	if other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// Reset removes all the values
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.count, h.sum, h.min, h.max = 0, 0, math.MaxInt64, 0
+}
+
+// Count returns the number of recorded values
+func (h *Histogram) Count() int64 {
+	return h.count
+}
+
+// Max returns the highest recorded value
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max) * time.Microsecond
+}
+
+// Mean returns the average of the recorded values
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum/h.count) * time.Microsecond
+}
+
+// Quantile returns the value below which the given share of the recorded values falls, e.g. 0.99
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(q * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			v := histogramValue(i)
+			if v > h.max {
+				v = h.max
+			}
+			if v < h.min {
+				v = h.min
+			}
+			return time.Duration(v) * time.Microsecond
+		}
+	}
+	return h.Max()
+}
+
+// histogramJSON is the wire format of a Histogram, only the non-empty buckets are kept
+type histogramJSON struct {
+	Buckets [][2]int64 `json:"buckets"` // bucket index and count
+	Count   int64      `json:"count"`
+	Sum     int64      `json:"sum"`
+	Min     int64      `json:"min"`
+	Max     int64      `json:"max"`
+}
+
+// MarshalJSON encodes the histogram so it can be merged in another process
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	v := histogramJSON{Buckets: [][2]int64{}, Count: h.count, Sum: h.sum, Min: h.min, Max: h.max}
+	for i, c := range h.counts {
+		if c > 0 {
+			v.Buckets = append(v.Buckets, [2]int64{int64(i), c})
+		}
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes a histogram encoded by MarshalJSON
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	var v histogramJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	h.counts = make([]int64, histogramBuckets)
+	for _, b := range v.Buckets {
+		if b[0] < 0 || b[0] >= int64(histogramBuckets) {
+			return fmt.Errorf("histogram bucket %d out of range", b[0])
+		}
+		h.counts[b[0]] = b[1]
+	}
+	h.count, h.sum, h.min, h.max = v.Count, v.Sum, v.Min, v.Max
+	return nil
+}
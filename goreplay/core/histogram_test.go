@@ -0,0 +1,94 @@
+package core
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	h := NewHistogram()
+	// 1ms to 10s, one value per millisecond
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.5, 5000 * time.Millisecond},
+		{0.9, 9000 * time.Millisecond},
+		{0.99, 9900 * time.Millisecond},
+		{0.999, 9990 * time.Millisecond},
+		{1, 10000 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		got := h.Quantile(tt.q)
+		if math.Abs(float64(got-tt.want))/float64(tt.want) > 0.016 {
+			t.Errorf("p%v: expected about %s, got %s", tt.q*100, tt.want, got)
+		}
+	}
+
+	if h.Count() != 10000 {
+		t.Errorf("expected 10000 values, got %d", h.Count())
+	}
+	if h.Max() != 10*time.Second {
+		t.Errorf("expected max 10s, got %s", h.Max())
+	}
+	if mean := h.Mean(); mean != 5000500*time.Microsecond {
+		t.Errorf("expected mean 5.0005s, got %s", mean)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b := NewHistogram(), NewHistogram()
+	for i := 0; i < 100; i++ {
+		a.Record(time.Millisecond)
+		b.Record(time.Second)
+	}
+
+	a.Merge(b)
+	if a.Count() != 200 {
+		t.Errorf("expected 200 values, got %d", a.Count())
+	}
+	if p := a.Quantile(0.5); math.Abs(float64(p-time.Millisecond)) > 0.016*float64(time.Millisecond) {
+		t.Errorf("expected p50 of about 1ms, got %s", p)
+	}
+	if p := a.Quantile(0.99); math.Abs(float64(p-time.Second)) > 0.016*float64(time.Second) {
+		t.Errorf("expected p99 of about 1s, got %s", p)
+	}
+
+	a.Reset()
+	if a.Count() != 0 || a.Quantile(0.99) != 0 || a.Max() != 0 {
+		t.Errorf("expected an empty histogram after reset")
+	}
+}
+
+func TestHistogramJSON(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewHistogram()
+	if err = json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Count() != h.Count() || decoded.Max() != h.Max() || decoded.Mean() != h.Mean() {
+		t.Errorf("expected %d values, max %s, mean %s, got %d, %s, %s",
+			h.Count(), h.Max(), h.Mean(), decoded.Count(), decoded.Max(), decoded.Mean())
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+		if decoded.Quantile(q) != h.Quantile(q) {
+			t.Errorf("p%v: expected %s, got %s", q*100, h.Quantile(q), decoded.Quantile(q))
+		}
+	}
+}
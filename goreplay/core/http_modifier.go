@@ -26,7 +26,12 @@ func NewHTTPModifier(config *settings.HTTPModifierConfig) *HTTPModifier {
 		len(config.ParamHashFilters) == 0 &&
 		len(config.Params) == 0 &&
 		len(config.Headers) == 0 &&
-		len(config.Methods) == 0 {
+		len(config.Methods) == 0 &&
+		len(config.JSONFilters) == 0 &&
+		len(config.JSONNegativeFilters) == 0 &&
+		len(config.JSONSets) == 0 &&
+		len(config.JSONDeletes) == 0 &&
+		len(config.JSONRewrite) == 0 {
 		return nil
 	}
 
@@ -34,7 +39,7 @@ func NewHTTPModifier(config *settings.HTTPModifierConfig) *HTTPModifier {
 }
 
 func (m *HTTPModifier) Rewrite(payload []byte) (response []byte) {
-	if !proto.HasRequestTitle(payload) {
+	if !proto.HasRequestTitle(payload) && !proto.HasHTTP2RequestTitle(payload) {
 		return payload
 	}
 
@@ -165,6 +170,12 @@ func (m *HTTPModifier) Rewrite(payload []byte) (response []byte) {
 		}
 	}
 
+	if m.hasJSONRules() {
+		if payload = m.rewriteJSON(payload); len(payload) == 0 {
+			return
+		}
+	}
+
 	if len(m.config.URLRewrite) > 0 {
 		path := proto.Path(payload)
 
@@ -0,0 +1,214 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"record-traffic-press/goreplay/proto"
+	"strconv"
+)
+
+func (m *HTTPModifier) hasJSONRules() bool {
+	return len(m.config.JSONFilters) > 0 ||
+		len(m.config.JSONNegativeFilters) > 0 ||
+		len(m.config.JSONSets) > 0 ||
+		len(m.config.JSONDeletes) > 0 ||
+		len(m.config.JSONRewrite) > 0
+}
+
+// rewriteJSON filters and modifies the JSON body of a request, nil when the request is filtered out.
+// Chunked and gzip bodies are decoded first, a body that isn't JSON only passes the negative filters.
+func (m *HTTPModifier) rewriteJSON(payload []byte) []byte {
+	if p := PrettifyHTTP(payload); len(p) > 0 {
+		payload = p
+	}
+
+	pos := proto.MIMEHeadersEndPos(payload)
+	if pos < 0 || pos > len(payload) {
+		pos = len(payload)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload[pos:]))
+	decoder.UseNumber()
+	var body interface{}
+	if err := decoder.Decode(&body); err != nil {
+		if len(m.config.JSONFilters) > 0 {
+			return nil
+		}
+		return payload
+	}
+
+	for _, f := range m.config.JSONFilters {
+		value, ok := jsonGet(body, f.Path)
+		if !ok || !f.Regexp.MatchString(jsonText(value)) {
+			return nil
+		}
+	}
+	for _, f := range m.config.JSONNegativeFilters {
+		if value, ok := jsonGet(body, f.Path); ok && f.Regexp.MatchString(jsonText(value)) {
+			return nil
+		}
+	}
+
+	changed := false
+	for _, s := range m.config.JSONSets {
+		if v, ok := jsonSet(body, s.Path, s.Value); ok {
+			body, changed = v, true
+		}
+	}
+	for _, path := range m.config.JSONDeletes {
+		if v, ok := jsonDelete(body, path); ok {
+			body, changed = v, true
+		}
+	}
+	for _, r := range m.config.JSONRewrite {
+		value, ok := jsonGet(body, r.Path)
+		if !ok {
+			continue
+		}
+		var rewritten interface{}
+		switch v := value.(type) {
+		case string:
+			rewritten = r.Src.ReplaceAllString(v, string(r.Target))
+		case json.Number:
+			n := r.Src.ReplaceAllString(v.String(), string(r.Target))
+			if _, err := strconv.ParseFloat(n, 64); err == nil {
+				rewritten = json.Number(n)
+			} else {
+				rewritten = n
+			}
+		default:
+			continue
+		}
+		if rewritten != value {
+			body, _ = jsonSet(body, r.Path, rewritten)
+			changed = true
+		}
+	}
+	if !changed {
+		return payload
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(body); err != nil {
+		return payload
+	}
+
+	rewritten := make([]byte, 0, pos+buf.Len())
+	rewritten = append(rewritten, payload[:pos]...)
+	rewritten = append(rewritten, bytes.TrimSuffix(buf.Bytes(), []byte("\n"))...)
+	return fixContentLength(payload, rewritten)
+}
+
+// jsonGet returns the value at the path of a decoded JSON document
+func jsonGet(v interface{}, path []interface{}) (interface{}, bool) {
+	for _, segment := range path {
+		switch s := segment.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[s]; !ok {
+				return nil, false
+			}
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || s >= len(a) {
+				return nil, false
+			}
+			v = a[s]
+		}
+	}
+	return v, true
+}
+
+// jsonSet sets the value at the path of a decoded JSON document, the missing objects on the way are
+// created, out of range indexes are not
+func jsonSet(v interface{}, path []interface{}, value interface{}) (interface{}, bool) {
+	if len(path) == 0 {
+		return value, true
+	}
+
+	switch s := path[0].(type) {
+	case string:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			if v != nil {
+				return v, false
+			}
+			m = make(map[string]interface{})
+		}
+		child, ok := jsonSet(m[s], path[1:], value)
+		if !ok {
+			return v, false
+		}
+		m[s] = child
+		return m, true
+	case int:
+		a, ok := v.([]interface{})
+		if !ok || s >= len(a) {
+			return v, false
+		}
+		child, ok := jsonSet(a[s], path[1:], value)
+		if !ok {
+			return v, false
+		}
+		a[s] = child
+		return a, true
+	}
+	return v, false
+}
+
+// jsonDelete removes the value at the path of a decoded JSON document, false when there is none
+func jsonDelete(v interface{}, path []interface{}) (interface{}, bool) {
+	if len(path) == 0 {
+		return v, false
+	}
+
+	switch s := path[0].(type) {
+	case string:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return v, false
+		}
+		child, ok := m[s]
+		if !ok {
+			return v, false
+		}
+		if len(path) == 1 {
+			delete(m, s)
+			return m, true
+		}
+		if child, ok = jsonDelete(child, path[1:]); ok {
+			m[s] = child
+		}
+		return m, ok
+	case int:
+		a, ok := v.([]interface{})
+		if !ok || s >= len(a) {
+			return v, false
+		}
+		if len(path) == 1 {
+			return append(a[:s], a[s+1:]...), true
+		}
+		child, ok := jsonDelete(a[s], path[1:])
+		if ok {
+			a[s] = child
+		}
+		return a, ok
+	}
+	return v, false
+}
+
+// jsonText is the text matched by the filters: strings as is, other values encoded
+func jsonText(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
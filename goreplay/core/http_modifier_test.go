@@ -0,0 +1,102 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strconv"
+	"testing"
+)
+
+func jsonRequest(body string) []byte {
+	return []byte("POST /orders HTTP/1.1\r\nContent-Type: application/json\r\nContent-Length: " +
+		strconv.Itoa(len(body)) + "\r\n\r\n" + body)
+}
+
+func TestHTTPModifierJSONBody(t *testing.T) {
+	config := settings.HTTPModifierConfig{}
+	for _, v := range []string{"$.user.id=42", "$.note=hello", "$.meta.env=\"qa\""} {
+		if err := config.JSONSets.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := config.JSONDeletes.Set("$.items[0]"); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.JSONRewrite.Set("$.user.email: @example\\.com$,@test.local"); err != nil {
+		t.Fatal(err)
+	}
+	modifier := NewHTTPModifier(&config)
+
+	payload := modifier.Rewrite(jsonRequest(`{"user":{"id":1,"email":"bob@example.com"},"items":[1,2]}`))
+	want := `{"items":[2],"meta":{"env":"qa"},"note":"hello","user":{"email":"bob@test.local","id":42}}`
+	if body := string(proto.Body(payload)); body != want {
+		t.Errorf("expected body %s, got %s", want, body)
+	}
+	if cl := string(proto.Header(payload, []byte("Content-Length"))); cl != strconv.Itoa(len(want)) {
+		t.Errorf("expected Content-Length %d, got %s", len(want), cl)
+	}
+
+	// bodies that aren't JSON are left as is
+	form := []byte("POST /post HTTP/1.1\r\nContent-Length: 7\r\n\r\na=1&b=2")
+	if !bytes.Equal(modifier.Rewrite(form), form) {
+		t.Error("Non JSON body should not be modified")
+	}
+}
+
+func TestHTTPModifierJSONFilters(t *testing.T) {
+	config := settings.HTTPModifierConfig{}
+	if err := config.JSONFilters.Set("$.user.role:^admin$"); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.JSONNegativeFilters.Set("$.dry_run:true"); err != nil {
+		t.Fatal(err)
+	}
+	modifier := NewHTTPModifier(&config)
+
+	tests := []struct {
+		body string
+		pass bool
+	}{
+		{`{"user":{"role":"admin"}}`, true},
+		{`{"user":{"role":"guest"}}`, false},
+		{`{"user":{}}`, false},
+		{`{"user":{"role":"admin"},"dry_run":true}`, false},
+		{`{"user":{"role":"admin"},"dry_run":false}`, true},
+		{`not json`, false},
+	}
+	for _, tt := range tests {
+		if pass := len(modifier.Rewrite(jsonRequest(tt.body))) > 0; pass != tt.pass {
+			t.Errorf("%s: expected pass %v, got %v", tt.body, tt.pass, pass)
+		}
+	}
+}
+
+func TestHTTPModifierJSONEncodedBody(t *testing.T) {
+	config := settings.HTTPModifierConfig{}
+	if err := config.JSONSets.Set("$.id=2"); err != nil {
+		t.Fatal(err)
+	}
+	modifier := NewHTTPModifier(&config)
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(`{"id":1,"name":"x"}`))
+	w.Close()
+	gzipped := append([]byte("POST / HTTP/1.1\r\nContent-Encoding: gzip\r\nContent-Length: "+
+		strconv.Itoa(gz.Len())+"\r\n\r\n"), gz.Bytes()...)
+	chunked := []byte("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"8\r\n{\"id\":1,\r\nb\r\n\"name\":\"x\"}\r\n0\r\n\r\n")
+
+	want := `{"id":2,"name":"x"}`
+	for name, payload := range map[string][]byte{"gzip": gzipped, "chunked": chunked} {
+		payload = modifier.Rewrite(payload)
+		if body := string(proto.Body(payload)); body != want {
+			t.Errorf("%s: expected body %s, got %q", name, want, body)
+		}
+		if cl := string(proto.Header(payload, []byte("Content-Length"))); cl != strconv.Itoa(len(want)) {
+			t.Errorf("%s: expected Content-Length %d, got %s", name, len(want), cl)
+		}
+	}
+}
@@ -19,7 +19,7 @@ func TestHTTPPrettifierGzip(t *testing.T) {
 	payload := []byte("HTTP/1.1 200 OK\r\nContent-Length: " + size + "\r\nContent-Encoding: gzip\r\n\r\n")
 	payload = append(payload, b.Bytes()...)
 
-	newPayload := prettifyHTTP(payload)
+	newPayload := PrettifyHTTP(payload)
 
 	if string(newPayload) != "HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\ntest" {
 		t.Errorf("Payload not match %q", string(newPayload))
@@ -29,7 +29,7 @@ func TestHTTPPrettifierGzip(t *testing.T) {
 func TestHTTPPrettifierChunked(t *testing.T) {
 	payload := []byte("POST / HTTP/1.1\r\nHost: www.w3.org\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nWiki\r\n5\r\npedia\r\ne\r\n in\r\n\r\nchunks.\r\n0\r\n\r\n")
 
-	payload = prettifyHTTP(payload)
+	payload = PrettifyHTTP(payload)
 	if string(proto.Header(payload, []byte("Content-Length"))) != "23" {
 		t.Errorf("payload should have content length of 23")
 	}
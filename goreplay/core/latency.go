@@ -0,0 +1,318 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"record-traffic-press/goreplay/glogs"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxLatencyEndpoints caps the endpoints tracked by a LatencyRecorder, the others are counted as LatencyOtherEndpoint
+const maxLatencyEndpoints = 1000
+
+// LatencyOtherEndpoint collects the endpoints above the cap
+const LatencyOtherEndpoint = "other"
+
+// LatencyTotalEndpoint is the endpoint of the reports aggregating all the endpoints of an output
+const LatencyTotalEndpoint = "*"
+
+// LatencyReport holds latency percentiles and failures of an endpoint
+type LatencyReport struct {
+	Endpoint string        `json:"endpoint"`
+	Requests int64         `json:"requests"` // responses and failed requests
+	Count    int64         `json:"count"`    // responses
+	Errors   int64         `json:"errors"`
+	Timeouts int64         `json:"timeouts"`
+	Mean     time.Duration `json:"mean"`
+	P50      time.Duration `json:"p50"`
+	P90      time.Duration `json:"p90"`
+	P99      time.Duration `json:"p99"`
+	P999     time.Duration `json:"p999"`
+	Max      time.Duration `json:"max"`
+}
+
+// LatencyReporter is implemented by the outputs measuring the round-trip time of replayed requests
+type LatencyReporter interface {
+	LatencyReports() []LatencyReport
+	LatencyTotal() LatencyReport
+	LatencySnapshots() []LatencySnapshot
+}
+
+// LatencySnapshot holds the raw latencies of an endpoint, the snapshots of several
+// processes replaying the same traffic can be merged, e.g. by a distributed replay
+type LatencySnapshot struct {
+	Endpoint  string     `json:"endpoint"`
+	Requests  int64      `json:"requests"`
+	Errors    int64      `json:"errors"`
+	Timeouts  int64      `json:"timeouts"`
+	Histogram *Histogram `json:"histogram"`
+}
+
+// Merge adds the latencies of another snapshot
+func (s *LatencySnapshot) Merge(other LatencySnapshot) {
+	if s.Histogram == nil {
+		s.Histogram = NewHistogram()
+	}
+	if other.Histogram != nil {
+		s.Histogram.Merge(other.Histogram)
+	}
+	s.Requests += other.Requests
+	s.Errors += other.Errors
+	s.Timeouts += other.Timeouts
+}
+
+// Report returns the percentiles of the snapshot
+func (s *LatencySnapshot) Report() LatencyReport {
+	h := s.Histogram
+	if h == nil {
+		h = NewHistogram()
+	}
+	return latencyReport(s.Endpoint, h, s.Requests, s.Errors, s.Timeouts)
+}
+
+// ScheduleLag is how far an open-loop output lags behind the intended send times of the requests
+type ScheduleLag struct {
+	Current time.Duration `json:"current"` // lag of the last sent request
+	Max     time.Duration `json:"max"`
+	Backlog int           `json:"backlog"` // requests waiting to be sent
+	Dropped int64         `json:"dropped"` // requests dropped because the backlog was full
+}
+
+// ScheduleReporter is implemented by the outputs which can send requests on an open-loop schedule
+type ScheduleReporter interface {
+	ScheduleLag() (lag ScheduleLag, ok bool) // ok is false when the output isn't open-loop
+}
+
+type endpointLatency struct {
+	window, total                                *Histogram
+	windowRequests, windowErrors, windowTimeouts int64
+	totalRequests, totalErrors, totalTimeouts    int64
+}
+
+func (e *endpointLatency) fail(err error) {
+	if IsTimeout(err) {
+		e.windowTimeouts++
+	} else {
+		e.windowErrors++
+	}
+}
+
+// LatencyRecorder keeps latency histograms of an output per endpoint. When window is set
+// the percentiles of every window are logged, the totals are logged on Close.
+type LatencyRecorder struct {
+	name      string
+	window    time.Duration
+	mu        sync.Mutex
+	endpoints map[string]*endpointLatency
+	quit      chan struct{}
+	once      sync.Once
+}
+
+// NewLatencyRecorder constructor for LatencyRecorder
+func NewLatencyRecorder(name string, window time.Duration) *LatencyRecorder {
+	r := &LatencyRecorder{
+		name:      name,
+		window:    window,
+		endpoints: make(map[string]*endpointLatency),
+		quit:      make(chan struct{}),
+	}
+
+	if window > 0 {
+		go r.reportWindows()
+	}
+
+	return r
+}
+
+// IsTimeout reports whether a request failed because of a timeout
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+func (r *LatencyRecorder) endpoint(name string) *endpointLatency {
+	e, ok := r.endpoints[name]
+	if !ok {
+		if len(r.endpoints) >= maxLatencyEndpoints && name != LatencyOtherEndpoint {
+			return r.endpoint(LatencyOtherEndpoint)
+		}
+		e = &endpointLatency{window: NewHistogram(), total: NewHistogram()}
+		r.endpoints[name] = e
+	}
+	return e
+}
+
+// Record counts the round-trip time of a request
+func (r *LatencyRecorder) Record(endpoint string, rtt time.Duration) {
+	r.mu.Lock()
+	e := r.endpoint(endpoint)
+	e.window.Record(rtt)
+	e.windowRequests++
+	r.mu.Unlock()
+}
+
+// RecordFailure counts the round-trip time of a request answered with an error, e.g. a 5xx status
+func (r *LatencyRecorder) RecordFailure(endpoint string, rtt time.Duration, err error) {
+	r.mu.Lock()
+	e := r.endpoint(endpoint)
+	e.window.Record(rtt)
+	e.windowRequests++
+	e.fail(err)
+	r.mu.Unlock()
+}
+
+// RecordError counts a request which got no response, timeouts are told apart by IsTimeout
+func (r *LatencyRecorder) RecordError(endpoint string, err error) {
+	r.mu.Lock()
+	e := r.endpoint(endpoint)
+	e.windowRequests++
+	e.fail(err)
+	r.mu.Unlock()
+}
+
+// RecordTimeout counts a request which got no response in time
+func (r *LatencyRecorder) RecordTimeout(endpoint string) {
+	r.mu.Lock()
+	e := r.endpoint(endpoint)
+	e.windowRequests++
+	e.windowTimeouts++
+	r.mu.Unlock()
+}
+
+// roll moves the window into the totals and returns its reports, r.mu must be held
+func (r *LatencyRecorder) roll() []LatencyReport {
+	reports := make([]LatencyReport, 0, len(r.endpoints))
+	for name, e := range r.endpoints {
+		if e.windowRequests > 0 {
+			reports = append(reports, latencyReport(name, e.window, e.windowRequests, e.windowErrors, e.windowTimeouts))
+		}
+
+		e.total.Merge(e.window)
+		e.totalRequests += e.windowRequests
+		e.totalErrors += e.windowErrors
+		e.totalTimeouts += e.windowTimeouts
+		e.window.Reset()
+		e.windowRequests, e.windowErrors, e.windowTimeouts = 0, 0, 0
+	}
+
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Endpoint < reports[j].Endpoint })
+	return reports
+}
+
+func latencyReport(name string, h *Histogram, requests, errors, timeouts int64) LatencyReport {
+	return LatencyReport{
+		Endpoint: name,
+		Requests: requests,
+		Count:    h.Count(),
+		Errors:   errors,
+		Timeouts: timeouts,
+		Mean:     h.Mean(),
+		P50:      h.Quantile(0.5),
+		P90:      h.Quantile(0.9),
+		P99:      h.Quantile(0.99),
+		P999:     h.Quantile(0.999),
+		Max:      h.Max(),
+	}
+}
+
+func (r *LatencyRecorder) reportWindows() {
+	ticker := time.NewTicker(r.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.quit:
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			reports := r.roll()
+			r.mu.Unlock()
+
+			r.log("window", reports)
+		}
+	}
+}
+
+func (r *LatencyRecorder) log(kind string, reports []LatencyReport) {
+	for _, l := range reports {
+		glogs.Debug(1, "[LATENCY]", r.name, kind, l.Endpoint, "requests:", strconv.FormatInt(l.Requests, 10),
+			"count:", strconv.FormatInt(l.Count, 10),
+			"errors:", strconv.FormatInt(l.Errors, 10), "timeouts:", strconv.FormatInt(l.Timeouts, 10),
+			"p50:", l.P50, "p90:", l.P90, "p99:", l.P99, "p99.9:", l.P999, "max:", l.Max)
+	}
+}
+
+// Reports returns the reports of the whole run so far
+func (r *LatencyRecorder) Reports() []LatencyReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reports := make([]LatencyReport, 0, len(r.endpoints))
+	for name, e := range r.endpoints {
+		total := NewHistogram()
+		total.Merge(e.total)
+		total.Merge(e.window)
+		reports = append(reports, latencyReport(name, total, e.totalRequests+e.windowRequests,
+			e.totalErrors+e.windowErrors, e.totalTimeouts+e.windowTimeouts))
+	}
+
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Endpoint < reports[j].Endpoint })
+	return reports
+}
+
+// Snapshots returns the raw latencies of the whole run so far
+func (r *LatencyRecorder) Snapshots() []LatencySnapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshots := make([]LatencySnapshot, 0, len(r.endpoints))
+	for name, e := range r.endpoints {
+		h := NewHistogram()
+		h.Merge(e.total)
+		h.Merge(e.window)
+		snapshots = append(snapshots, LatencySnapshot{
+			Endpoint:  name,
+			Requests:  e.totalRequests + e.windowRequests,
+			Errors:    e.totalErrors + e.windowErrors,
+			Timeouts:  e.totalTimeouts + e.windowTimeouts,
+			Histogram: h,
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Endpoint < snapshots[j].Endpoint })
+	return snapshots
+}
+
+// Total returns the report of the whole run so far aggregating all the endpoints, as LatencyTotalEndpoint
+func (r *LatencyRecorder) Total() LatencyReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := NewHistogram()
+	var requests, errors, timeouts int64
+	for _, e := range r.endpoints {
+		total.Merge(e.total)
+		total.Merge(e.window)
+		requests += e.totalRequests + e.windowRequests
+		errors += e.totalErrors + e.windowErrors
+		timeouts += e.totalTimeouts + e.windowTimeouts
+	}
+
+	return latencyReport(LatencyTotalEndpoint, total, requests, errors, timeouts)
+}
+
+// Close stops the window reports and logs the totals
+func (r *LatencyRecorder) Close() {
+	r.once.Do(func() {
+		close(r.quit)
+		r.log("total", r.Reports())
+	})
+}
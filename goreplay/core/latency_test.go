@@ -0,0 +1,90 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLatencyRecorder(t *testing.T) {
+	r := NewLatencyRecorder("test", time.Millisecond)
+	defer r.Close()
+
+	for i := 1; i <= 100; i++ {
+		r.Record("GET /a", time.Duration(i)*time.Millisecond)
+	}
+	r.Record("GET /b", time.Second)
+	r.RecordError("GET /b", errors.New("connection refused"))
+	r.RecordError("GET /b", context.DeadlineExceeded)
+	r.RecordTimeout("GET /b")
+
+	// values must survive the windows rolling over
+	time.Sleep(20 * time.Millisecond)
+
+	reports := r.Reports()
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 endpoints, got %d", len(reports))
+	}
+
+	a, b := reports[0], reports[1]
+	if a.Endpoint != "GET /a" || a.Count != 100 || a.Max != 100*time.Millisecond {
+		t.Errorf("unexpected report %+v", a)
+	}
+	if a.P50 < 49*time.Millisecond || a.P50 > 51*time.Millisecond {
+		t.Errorf("expected p50 of about 50ms, got %s", a.P50)
+	}
+	if b.Endpoint != "GET /b" || b.Requests != 4 || b.Count != 1 || b.Errors != 1 || b.Timeouts != 2 {
+		t.Errorf("unexpected report %+v", b)
+	}
+
+	r.RecordFailure("GET /b", time.Second, errors.New("response status 503"))
+	total := r.Total()
+	if total.Endpoint != LatencyTotalEndpoint || total.Requests != 105 || total.Count != 102 || total.Errors != 2 {
+		t.Errorf("unexpected total %+v", total)
+	}
+}
+
+func TestLatencyRecorderEndpointsCap(t *testing.T) {
+	r := NewLatencyRecorder("test", 0)
+	defer r.Close()
+
+	for i := 0; i < maxLatencyEndpoints+10; i++ {
+		r.Record(string(rune('a'+i%26))+string(rune(i)), time.Millisecond)
+	}
+
+	reports := r.Reports()
+	if len(reports) != maxLatencyEndpoints+1 {
+		t.Fatalf("expected %d endpoints, got %d", maxLatencyEndpoints+1, len(reports))
+	}
+	for _, l := range reports {
+		if l.Endpoint == LatencyOtherEndpoint && l.Count != 10 {
+			t.Errorf("expected 10 values in %q, got %d", LatencyOtherEndpoint, l.Count)
+		}
+	}
+}
+
+func TestLatencySnapshotMerge(t *testing.T) {
+	a, b := NewLatencyRecorder("a", 0), NewLatencyRecorder("b", 0)
+	defer a.Close()
+	defer b.Close()
+
+	for i := 0; i < 100; i++ {
+		a.Record("GET /", time.Millisecond)
+		b.Record("GET /", time.Second)
+	}
+	b.RecordTimeout("GET /")
+
+	var merged LatencySnapshot
+	for _, s := range append(a.Snapshots(), b.Snapshots()...) {
+		merged.Merge(s)
+	}
+
+	l := merged.Report()
+	if l.Requests != 201 || l.Count != 200 || l.Timeouts != 1 {
+		t.Errorf("unexpected merged report %+v", l)
+	}
+	if l.P50 > 2*time.Millisecond || l.P99 < 900*time.Millisecond {
+		t.Errorf("expected p50 of about 1ms and p99 of about 1s, got %s and %s", l.P50, l.P99)
+	}
+}
@@ -44,8 +44,8 @@ func newLimiterExceptions(l *Limiter) {
 	switch input := l.plugin.(type) {
 	case *input2.FileInput:
 		input.SpeedFactor = speedFactor
-		//case *input2.KafkaInput:
-		//	input.SpeedFactor = speedFactor
+	case *input2.KafkaInput:
+		input.SpeedFactor = speedFactor
 	}
 }
 
@@ -70,8 +70,8 @@ func (l *Limiter) isLimitedExceptions() bool {
 	switch l.plugin.(type) {
 	case *input2.FileInput:
 		return true
-	//case *input2.KafkaInput:
-	//	return true
+	case *input2.KafkaInput:
+		return true
 	default:
 		return false
 	}
@@ -128,6 +128,11 @@ func (l *Limiter) PluginRead() (msg *common.Message, err error) {
 	return
 }
 
+// Plugin returns the limited plugin
+func (l *Limiter) Plugin() interface{} {
+	return l.plugin
+}
+
 func (l *Limiter) String() string {
 	return fmt.Sprintf("Limiting %s to: %d (isPercent: %v)", l.plugin, l.limit, l.isPercent)
 }
@@ -1,10 +1,11 @@
 //go:build !race
 
-package core
+package core_test
 
 import (
 	"record-traffic-press/goreplay/bootstrap"
-	"record-traffic-press/goreplay/plugins"
+	"record-traffic-press/goreplay/common"
+	. "record-traffic-press/goreplay/core"
 	"record-traffic-press/goreplay/settings"
 	"sync"
 	"testing"
@@ -14,7 +15,7 @@ func TestOutputLimiter(t *testing.T) {
 	wg := new(sync.WaitGroup)
 
 	input := bootstrap.NewTestInput()
-	output := NewLimiter(bootstrap.NewTestOutput(func(*plugins.Message) {
+	output := NewLimiter(bootstrap.NewTestOutput(func(*common.Message) {
 		wg.Done()
 	}), "10")
 	wg.Add(10)
@@ -40,7 +41,7 @@ func TestInputLimiter(t *testing.T) {
 	wg := new(sync.WaitGroup)
 
 	input := NewLimiter(bootstrap.NewTestInput(), "10")
-	output := bootstrap.NewTestOutput(func(*plugins.Message) {
+	output := bootstrap.NewTestOutput(func(*common.Message) {
 		wg.Done()
 	})
 	wg.Add(10)
@@ -55,7 +56,7 @@ func TestInputLimiter(t *testing.T) {
 	go emitter.Start(plugins, settings.Settings.Middleware)
 
 	for i := 0; i < 100; i++ {
-		input.(*Limiter).plugin.(*plugins.TestInput).EmitGET()
+		input.(*Limiter).Plugin().(*bootstrap.TestInput).EmitGET()
 	}
 
 	wg.Wait()
@@ -67,7 +68,7 @@ func TestPercentLimiter1(t *testing.T) {
 	wg := new(sync.WaitGroup)
 
 	input := bootstrap.NewTestInput()
-	output := NewLimiter(bootstrap.NewTestOutput(func(*plugins.Message) {
+	output := NewLimiter(bootstrap.NewTestOutput(func(*common.Message) {
 		wg.Done()
 	}), "0%")
 
@@ -92,7 +93,7 @@ func TestPercentLimiter2(t *testing.T) {
 	wg := new(sync.WaitGroup)
 
 	input := bootstrap.NewTestInput()
-	output := NewLimiter(bootstrap.NewTestOutput(func(*plugins.Message) {
+	output := NewLimiter(bootstrap.NewTestOutput(func(*common.Message) {
 		wg.Done()
 	}), "100%")
 	wg.Add(100)
@@ -0,0 +1,236 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/glogs"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loadProfileTick is how often the rate of a stage is updated
+const loadProfileTick = 100 * time.Millisecond
+
+// LoadStageReport is the rate achieved by a stage of a load profile
+type LoadStageReport struct {
+	Name      string        `json:"name"`
+	Type      string        `json:"type"`
+	From      float64       `json:"from"`
+	To        float64       `json:"to"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Requests  int64         `json:"requests"`
+	RPS       float64       `json:"rps"`
+	Finished  bool          `json:"finished"`
+}
+
+// LoadProfiler is an output plugin which drives the replay rate through the stages of a load profile.
+// The rate of the running stage is passed to the setters, e.g. FileInput.SetSpeedFactor or
+// Amplifier.SetFactor, and the requests written to it are counted to report the achieved RPS.
+type LoadProfiler struct {
+	requests int64 // requests of the running stage
+
+	config  *settings.LoadProfileConfig
+	setters []func(float64)
+
+	mu      sync.Mutex
+	current int // index of the running stage, len(config.Stages) once all are over
+	reports []*LoadStageReport
+
+	quit chan struct{}
+	once sync.Once
+}
+
+// CheckLoadProfile returns an error on a stage type or a target other than the known ones
+func CheckLoadProfile(config *settings.LoadProfileConfig) error {
+	switch config.Target {
+	case "", settings.LoadTargetSpeed, settings.LoadTargetAmplify:
+	default:
+		return fmt.Errorf("unknown load profile target %q, expected %q or %q", config.Target, settings.LoadTargetSpeed, settings.LoadTargetAmplify)
+	}
+
+	for i := range config.Stages {
+		switch stage := &config.Stages[i]; stage.Type {
+		case settings.LoadStageRamp, settings.LoadStageStep, settings.LoadStageSpike, settings.LoadStageHold:
+		default:
+			return fmt.Errorf("unknown type %q of the load profile stage %d %q, expected %q, %q, %q or %q", stage.Type, i, stage.Name,
+				settings.LoadStageRamp, settings.LoadStageStep, settings.LoadStageSpike, settings.LoadStageHold)
+		}
+	}
+	return nil
+}
+
+// NewLoadProfiler constructor for LoadProfiler, the first stage starts right away
+func NewLoadProfiler(_ string, config *settings.LoadProfileConfig, setters []func(float64)) (*LoadProfiler, error) {
+	if err := CheckLoadProfile(config); err != nil {
+		return nil, err
+	}
+
+	p := &LoadProfiler{
+		config:  config,
+		setters: setters,
+		quit:    make(chan struct{}),
+	}
+
+	if len(config.Stages) > 0 {
+		p.startStage(time.Now())
+		p.apply(time.Now())
+		go p.run()
+	}
+
+	return p, nil
+}
+
+// StageRate returns the rate of a stage after the elapsed time
+func StageRate(stage *settings.LoadStage, elapsed time.Duration) float64 {
+	progress := 1.0
+	if stage.Duration > 0 && elapsed < stage.Duration {
+		progress = float64(elapsed) / float64(stage.Duration)
+	}
+
+	switch stage.Type {
+	case settings.LoadStageRamp:
+		return stage.From + (stage.To-stage.From)*progress
+	case settings.LoadStageStep:
+		if stage.Steps < 2 {
+			return stage.To
+		}
+		step := math.Min(math.Floor(progress*float64(stage.Steps)), float64(stage.Steps-1))
+		return stage.From + (stage.To-stage.From)*step/float64(stage.Steps-1)
+	case settings.LoadStageSpike:
+		if progress < 0.5 {
+			return stage.To
+		}
+		return stage.From
+	default:
+		return stage.To
+	}
+}
+
+func (p *LoadProfiler) run() {
+	ticker := time.NewTicker(loadProfileTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.quit:
+			return
+		case now := <-ticker.C:
+			if !p.apply(now) {
+				return
+			}
+		}
+	}
+}
+
+// apply moves to the stage of the given time and updates the rate, it returns false once all the stages are over
+func (p *LoadProfiler) apply(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stages := p.config.Stages
+	for p.current < len(stages) {
+		report := p.reports[p.current]
+		end := report.StartedAt.Add(stages[p.current].Duration)
+		if now.Before(end) {
+			break
+		}
+
+		p.finishStage(end)
+		p.current++
+		if p.current < len(stages) {
+			p.startStage(end)
+		}
+	}
+
+	if p.current == len(stages) {
+		return false
+	}
+
+	stage := &stages[p.current]
+	rate := StageRate(stage, now.Sub(p.reports[p.current].StartedAt))
+	for _, set := range p.setters {
+		set(rate)
+	}
+
+	return true
+}
+
+func (p *LoadProfiler) startStage(start time.Time) {
+	stage := p.config.Stages[len(p.reports)]
+	p.reports = append(p.reports, &LoadStageReport{
+		Name:      stage.Name,
+		Type:      stage.Type,
+		From:      stage.From,
+		To:        stage.To,
+		StartedAt: start,
+	})
+}
+
+func (p *LoadProfiler) finishStage(end time.Time) {
+	report := p.reports[p.current]
+	report.Finished = true
+	report.Duration = end.Sub(report.StartedAt)
+	report.Requests = atomic.SwapInt64(&p.requests, 0)
+	if report.Duration > 0 {
+		report.RPS = float64(report.Requests) / report.Duration.Seconds()
+	}
+
+	glogs.Debug(1, "[LOAD-PROFILE] stage", strconv.Itoa(p.current), report.Name, "requests:", report.Requests,
+		"rps:", strconv.FormatFloat(report.RPS, 'f', 2, 64))
+}
+
+// PluginWrite counts the requests of the running stage
+func (p *LoadProfiler) PluginWrite(msg *common.Message) (int, error) {
+	if proto.IsRequestPayload(msg.Meta) {
+		atomic.AddInt64(&p.requests, 1)
+	}
+	return len(msg.Data) + len(msg.Meta), nil
+}
+
+// Reports returns the reports of the stages started so far, the running one included
+func (p *LoadProfiler) Reports() []LoadStageReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	reports := make([]LoadStageReport, len(p.reports))
+	for i, r := range p.reports {
+		reports[i] = *r
+	}
+
+	if p.current < len(reports) {
+		running := &reports[p.current]
+		running.Duration = time.Since(running.StartedAt)
+		running.Requests = atomic.LoadInt64(&p.requests)
+		if running.Duration > 0 {
+			running.RPS = float64(running.Requests) / running.Duration.Seconds()
+		}
+	}
+
+	return reports
+}
+
+func (p *LoadProfiler) String() string {
+	return fmt.Sprintf("Load profile of %d stages (target: %s)", len(p.config.Stages), p.config.Target)
+}
+
+// Close stops the profile, the running stage is reported as it is
+func (p *LoadProfiler) Close() error {
+	p.once.Do(func() {
+		close(p.quit)
+
+		p.mu.Lock()
+		if p.current < len(p.reports) {
+			p.finishStage(time.Now())
+			p.reports[p.current].Finished = false
+			p.current = len(p.config.Stages)
+		}
+		p.mu.Unlock()
+	})
+	return nil
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStageRate(t *testing.T) {
+	tests := []struct {
+		stage   settings.LoadStage
+		elapsed time.Duration
+		rate    float64
+	}{
+		{settings.LoadStage{Type: settings.LoadStageRamp, Duration: 10 * time.Second, From: 1, To: 3}, 5 * time.Second, 2},
+		{settings.LoadStage{Type: settings.LoadStageRamp, Duration: 10 * time.Second, From: 1, To: 3}, 20 * time.Second, 3},
+		{settings.LoadStage{Type: settings.LoadStageStep, Duration: 9 * time.Second, From: 1, To: 3, Steps: 3}, 2 * time.Second, 1},
+		{settings.LoadStage{Type: settings.LoadStageStep, Duration: 9 * time.Second, From: 1, To: 3, Steps: 3}, 4 * time.Second, 2},
+		{settings.LoadStage{Type: settings.LoadStageStep, Duration: 9 * time.Second, From: 1, To: 3, Steps: 3}, 9 * time.Second, 3},
+		{settings.LoadStage{Type: settings.LoadStageSpike, Duration: time.Second, From: 1, To: 10}, 0, 10},
+		{settings.LoadStage{Type: settings.LoadStageSpike, Duration: time.Second, From: 1, To: 10}, 400 * time.Millisecond, 10},
+		{settings.LoadStage{Type: settings.LoadStageSpike, Duration: time.Second, From: 1, To: 10}, 600 * time.Millisecond, 1},
+		{settings.LoadStage{Type: settings.LoadStageSpike, Duration: time.Second, From: 1, To: 10}, 2 * time.Second, 1},
+		{settings.LoadStage{Type: settings.LoadStageHold, Duration: time.Minute, From: 1, To: 2}, 0, 2},
+	}
+
+	for _, tt := range tests {
+		if rate := StageRate(&tt.stage, tt.elapsed); rate != tt.rate {
+			t.Errorf("%s after %s: expected %v, got %v", tt.stage.Type, tt.elapsed, tt.rate, rate)
+		}
+	}
+}
+
+func TestLoadProfiler(t *testing.T) {
+	var mu sync.Mutex
+	var rates []float64
+
+	p, err := NewLoadProfiler("", &settings.LoadProfileConfig{Stages: []settings.LoadStage{
+		{Name: "warmup", Type: settings.LoadStageHold, Duration: 200 * time.Millisecond, To: 1},
+		{Name: "spike", Type: settings.LoadStageSpike, Duration: time.Hour, To: 5},
+	}}, []func(float64){func(f float64) {
+		mu.Lock()
+		rates = append(rates, f)
+		mu.Unlock()
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	for i := 0; i < 10; i++ {
+		p.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, proto.Uuid(), 1, 1)})
+	}
+	// responses are not counted
+	p.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.ResponsePayload, proto.Uuid(), 1, 1)})
+
+	time.Sleep(400 * time.Millisecond)
+
+	reports := p.Reports()
+	if len(reports) != 2 {
+		t.Fatalf("Expected 2 started stages, got %d", len(reports))
+	}
+	if !reports[0].Finished || reports[0].Requests != 10 || reports[0].RPS != 50 {
+		t.Errorf("Wrong report of the first stage: %+v", reports[0])
+	}
+	if reports[1].Finished || reports[1].Name != "spike" {
+		t.Errorf("Wrong report of the running stage: %+v", reports[1])
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if rates[0] != 1 || rates[len(rates)-1] != 5 {
+		t.Errorf("Wrong rates: %v", rates)
+	}
+}
+
+func TestLoadProfilerInvalid(t *testing.T) {
+	if _, err := NewLoadProfiler("", &settings.LoadProfileConfig{Stages: []settings.LoadStage{
+		{Name: "warmup", Type: "linear", Duration: time.Minute, To: 1},
+	}}, nil); err == nil {
+		t.Error("Should fail on an unknown stage type")
+	}
+	if _, err := NewLoadProfiler("", &settings.LoadProfileConfig{Target: "rps", Stages: []settings.LoadStage{
+		{Name: "warmup", Type: settings.LoadStageHold, Duration: time.Minute, To: 1},
+	}}, nil); err == nil {
+		t.Error("Should fail on an unknown target")
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"record-traffic-press/goreplay/common"
 	"record-traffic-press/goreplay/glogs"
 	"record-traffic-press/goreplay/proto"
-	"record-traffic-press/goreplay/settings"
 	"strings"
 	"sync"
 	"syscall"
@@ -26,13 +25,15 @@ type Middleware struct {
 	commandCancel context.CancelFunc
 	stop          chan bool // Channel used only to indicate goroutine should shutdown
 	closed        bool
+	prettifyHTTP  bool
 	mu            sync.RWMutex
 }
 
-// NewMiddleware returns new middleware
-func NewMiddleware(command string) *Middleware {
+// NewMiddleware returns new middleware, prettifyHTTP decodes chunked and gzip bodies before passing them to the command
+func NewMiddleware(command string, prettifyHTTP bool) *Middleware {
 	m := new(Middleware)
 	m.command = command
+	m.prettifyHTTP = prettifyHTTP
 	m.data = make(chan *common.Message, 1000)
 	m.stop = make(chan bool)
 
@@ -86,7 +87,7 @@ func (m *Middleware) copy(to io.Writer, from PluginReader) {
 			continue
 		}
 		buf = msg.Data
-		if settings.Settings.PrettifyHTTP {
+		if m.prettifyHTTP {
 			buf = PrettifyHTTP(msg.Data)
 		}
 		dstLen := (len(buf)+len(msg.Meta))*2 + 1
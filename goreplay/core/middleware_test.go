@@ -1,12 +1,12 @@
-package core
+package core_test
 
 import (
 	"bytes"
 	"context"
-	"os"
 	"os/exec"
 	"record-traffic-press/goreplay/bootstrap"
-	"record-traffic-press/goreplay/plugins"
+	"record-traffic-press/goreplay/common"
+	. "record-traffic-press/goreplay/core"
 	"strings"
 	"sync/atomic"
 	"syscall"
@@ -18,29 +18,6 @@ const tokenModifier = "go run ./examples/middleware/token_modifier.go"
 
 var withDebug = append(syscall.Environ(), "GOR_TEST=1")
 
-func initMiddleware(cmd *exec.Cmd, cancl context.CancelFunc, l PluginReader, c func(error)) *Middleware {
-	var m Middleware
-	m.data = make(chan *plugins.Message, 1000)
-	m.stop = make(chan bool)
-	m.commandCancel = cancl
-	m.Stdout, _ = cmd.StdoutPipe()
-	m.Stdin, _ = cmd.StdinPipe()
-	cmd.Stderr = os.Stderr
-	go m.read(m.Stdout)
-	go func() {
-		defer m.Close()
-		var err error
-		if err = cmd.Start(); err == nil {
-			err = cmd.Wait()
-		}
-		if err != nil {
-			c(err)
-		}
-	}()
-	m.ReadFrom(l)
-	return &m
-}
-
 func initCmd(command string, env []string) (*exec.Cmd, context.CancelFunc) {
 	commands := strings.Split(command, " ")
 	ctx, cancl := context.WithCancel(context.Background())
@@ -53,7 +30,7 @@ func TestMiddlewareEarlyClose(t *testing.T) {
 	quit := make(chan struct{})
 	in := bootstrap.NewTestInput()
 	cmd, cancl := initCmd(echoSh, withDebug)
-	midd := initMiddleware(cmd, cancl, in, func(err error) {
+	midd := InitMiddleware(cmd, cancl, in, func(err error) {
 		if err != nil {
 			if e, ok := err.(*exec.ExitError); ok {
 				status := e.Sys().(syscall.WaitStatus)
@@ -66,7 +43,7 @@ func TestMiddlewareEarlyClose(t *testing.T) {
 	})
 	var body = []byte("OPTIONS / HTTP/1.1\r\nHost: example.org\r\n\r\n")
 	count := uint32(0)
-	out := bootstrap.NewTestOutput(func(msg *plugins.Message) {
+	out := bootstrap.NewTestOutput(func(msg *common.Message) {
 		if !bytes.Equal(body, msg.Data) {
 			t.Errorf("expected %q to equal %q", body, msg.Data)
 		}
@@ -94,7 +71,7 @@ func TestMiddlewareEarlyClose(t *testing.T) {
 //	in := NewTestInput()
 //	in.skipHeader = true
 //	cmd, cancl := initCmd(tokenModifier, withDebug)
-//	midd := initMiddleware(cmd, cancl, in, func(err error) {})
+//	midd := InitMiddleware(cmd, cancl, in, func(err error) {})
 //	req := []byte("1 932079936fa4306fc308d67588178d17d823647c 1439818823587396305 200\nGET /token HTTP/1.1\r\nHost: example.org\r\n\r\n")
 //	res := []byte("2 932079936fa4306fc308d67588178d17d823647c 1439818823587396305 200\nHTTP/1.1 200 OK\r\nContent-Length: 10\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n17d823647c")
 //	rep := []byte("3 932079936fa4306fc308d67588178d17d823647c 1439818823587396305 200\nHTTP/1.1 200 OK\r\nContent-Length: 15\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n932079936fa4306")
@@ -132,7 +109,7 @@ func TestMiddlewareEarlyClose(t *testing.T) {
 //	quit := make(chan struct{})
 //	in := NewTestInput()
 //	cmd, cancl := initCmd(echoSh, withDebug)
-//	midd := initMiddleware(cmd, cancl, in, func(err error) {})
+//	midd := InitMiddleware(cmd, cancl, in, func(err error) {})
 //	var b1 = []byte("POST / HTTP/1.1\r\nHost: example.org\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nWiki\r\n5\r\npedia\r\nE\r\n in\r\n\r\nchunks.\r\n0\r\n\r\n")
 //	var b2 = []byte("POST / HTTP/1.1\r\nHost: example.org\r\nContent-Length: 25\r\n\r\nWikipedia in\r\n\r\nchunks.")
 //	out := NewTestOutput(func(msg *Message) {
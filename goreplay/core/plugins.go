@@ -41,10 +41,11 @@ func extractLimitOptions(options string) (string, string) {
 	return split[0], ""
 }
 
-// Automatically detects type of plugin and initialize it
+// Automatically detects type of plugin and initialize it, the error of a constructor returning
+// one as well is returned and the plugin is not registered
 //
 // See this article if curious about reflect stuff below: http://blog.burntsushi.net/type-parametric-functions-golang
-func (plugins *InOutPlugins) RegisterPlugin(constructor interface{}, options ...interface{}) {
+func (plugins *InOutPlugins) RegisterPlugin(constructor interface{}, options ...interface{}) error {
 	var path, limit string
 	vc := reflect.ValueOf(constructor)
 
@@ -63,7 +64,11 @@ func (plugins *InOutPlugins) RegisterPlugin(constructor interface{}, options ...
 	}
 
 	// Calling our constructor with list of given options
-	plugin := vc.Call(vo)[0].Interface()
+	results := vc.Call(vo)
+	if len(results) > 1 && !results[1].IsNil() {
+		return results[1].Interface().(error)
+	}
+	plugin := results[0].Interface()
 
 	if limit != "" {
 		plugin = NewLimiter(plugin, limit)
@@ -78,4 +83,5 @@ func (plugins *InOutPlugins) RegisterPlugin(constructor interface{}, options ...
 		plugins.Outputs = append(plugins.Outputs, w)
 	}
 	plugins.All = append(plugins.All, plugin)
+	return nil
 }
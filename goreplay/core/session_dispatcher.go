@@ -0,0 +1,151 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SessionIdleTimeout stops the worker of a session which got nothing for this long
+const SessionIdleTimeout = 120 * time.Second
+
+// SessionWorker handles the items of one session, see SessionDispatcher
+type SessionWorker[T any] interface {
+	// Handle is called with the items of the session one after the other, in queued order
+	Handle(item T)
+	// Close is called once the session is idle or the dispatcher is closed
+	Close()
+}
+
+// SessionDispatcher hands the items of every session, e.g. a captured connection or a client IP (see
+// proto.SessionID), to a worker of its own, so the sessions are handled concurrently while the items
+// of a session are handled one after the other. The workers of the idle sessions are stopped.
+type SessionDispatcher[T any] struct {
+	queue     <-chan T
+	sessionOf func(T) string
+	newWorker func() SessionWorker[T]
+	sessions  map[string]*dispatchedSession[T] // owned by run
+	workers   sync.WaitGroup
+	active    int64
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type dispatchedSession[T any] struct {
+	worker       SessionWorker[T]
+	lastActivity time.Time
+	queue        chan T
+	stop         chan struct{}
+}
+
+// NewSessionDispatcher constructor for SessionDispatcher, the items are read from queue until it is
+// closed, then the workers handle the items left before they stop, or until Close
+func NewSessionDispatcher[T any](queue <-chan T, sessionOf func(T) string, newWorker func() SessionWorker[T]) *SessionDispatcher[T] {
+	d := &SessionDispatcher[T]{
+		queue:     queue,
+		sessionOf: sessionOf,
+		newWorker: newWorker,
+		sessions:  make(map[string]*dispatchedSession[T]),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Workers returns the number of sessions having a worker
+func (d *SessionDispatcher[T]) Workers() int {
+	return int(atomic.LoadInt64(&d.active))
+}
+
+// Wait waits for the dispatcher to stop, once the queue is closed and the items left are handled
+func (d *SessionDispatcher[T]) Wait() {
+	<-d.done
+}
+
+// Close stops the dispatcher and the workers, the items still queued are dropped
+func (d *SessionDispatcher[T]) Close() {
+	d.closeOnce.Do(func() { close(d.quit) })
+	<-d.done
+}
+
+func (d *SessionDispatcher[T]) run() {
+	defer close(d.done)
+	gc := time.NewTicker(time.Second)
+	defer gc.Stop()
+
+	for {
+		select {
+		case <-d.quit:
+			for id, s := range d.sessions {
+				d.stop(id, s)
+			}
+			return
+		case item, ok := <-d.queue:
+			if !ok {
+				for id, s := range d.sessions {
+					close(s.queue)
+					delete(d.sessions, id)
+				}
+				d.workers.Wait()
+				return
+			}
+			id := d.sessionOf(item)
+			s, ok := d.sessions[id]
+			if !ok {
+				s = &dispatchedSession[T]{worker: d.newWorker(), queue: make(chan T, 100), stop: make(chan struct{})}
+				d.sessions[id] = s
+				atomic.AddInt64(&d.active, 1)
+				d.workers.Add(1)
+				go func() {
+					defer d.workers.Done()
+					defer atomic.AddInt64(&d.active, -1)
+					s.run()
+				}()
+			}
+
+			s.lastActivity = time.Now()
+			select {
+			case s.queue <- item:
+			case <-d.quit:
+			}
+		case <-gc.C:
+			now := time.Now()
+
+			for id, s := range d.sessions {
+				if now.Sub(s.lastActivity) >= SessionIdleTimeout && len(s.queue) == 0 {
+					d.stop(id, s)
+				}
+			}
+		}
+	}
+}
+
+// stop stops the worker of a session, only called by run
+func (d *SessionDispatcher[T]) stop(id string, s *dispatchedSession[T]) {
+	close(s.stop)
+	delete(d.sessions, id)
+}
+
+func (s *dispatchedSession[T]) run() {
+	defer s.worker.Close()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		select {
+		case item, ok := <-s.queue:
+			if !ok {
+				return
+			}
+			s.worker.Handle(item)
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+type recordingWorker struct {
+	mu      *sync.Mutex
+	handled map[string][]string
+	closed  *int
+}
+
+func (w *recordingWorker) Handle(item string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	session, _, _ := strings.Cut(item, "-")
+	w.handled[session] = append(w.handled[session], item)
+}
+
+func (w *recordingWorker) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	*w.closed++
+}
+
+func TestSessionDispatcher(t *testing.T) {
+	var mu sync.Mutex
+	handled := make(map[string][]string)
+	closed := 0
+
+	queue := make(chan string, 10)
+	d := NewSessionDispatcher(queue, func(item string) string {
+		session, _, _ := strings.Cut(item, "-")
+		return session
+	}, func() SessionWorker[string] {
+		return &recordingWorker{mu: &mu, handled: handled, closed: &closed}
+	})
+
+	for _, item := range []string{"a-1", "b-1", "a-2", "c-1", "a-3", "b-2"} {
+		queue <- item
+	}
+	// the items queued before are still handled
+	close(queue)
+	d.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(handled["a"], ","); got != "a-1,a-2,a-3" {
+		t.Errorf("expected the items of a session in order, got %s", got)
+	}
+	if len(handled["b"]) != 2 || len(handled["c"]) != 1 {
+		t.Errorf("wrong items handled %v", handled)
+	}
+	if closed != 3 || d.Workers() != 0 {
+		t.Errorf("expected the 3 workers closed, got %d closed and %d running", closed, d.Workers())
+	}
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/proto"
+)
+
+// Shard is a wrapper for input plugins which keeps the part of the traffic assigned to one of
+// the workers of a distributed replay. Payloads are assigned by hashing their session (see
+// proto.SessionID), so a request and its original response, and the requests of a session, always
+// end up on the same worker.
+type Shard struct {
+	plugin  PluginReader
+	index   uint32
+	count   uint32
+	session string
+}
+
+// NewShard constructor for Shard, index is in [0, count) and session is proto.SessionByConnection
+// or proto.SessionByClient
+func NewShard(plugin PluginReader, index, count int, session string) *Shard {
+	return &Shard{plugin: plugin, index: uint32(index), count: uint32(count), session: session}
+}
+
+// Owns reports whether the payload with the given ID belongs to this shard
+func (s *Shard) Owns(id []byte) bool {
+	h := fnv.New32a()
+	h.Write(proto.SessionID(id, s.session))
+	return h.Sum32()%s.count == s.index
+}
+
+// PluginRead reads message from this plugin
+func (s *Shard) PluginRead() (*common.Message, error) {
+	for {
+		msg, err := s.plugin.PluginRead()
+		if err != nil || msg == nil {
+			return msg, err
+		}
+
+		meta := proto.PayloadMeta(msg.Meta)
+		if len(meta) < 2 || (meta[0][0] != proto.RequestPayload && meta[0][0] != proto.ResponsePayload) {
+			return msg, nil
+		}
+		if s.Owns(meta[1]) {
+			return msg, nil
+		}
+	}
+}
+
+// Plugin returns the sharded plugin
+func (s *Shard) Plugin() interface{} {
+	return s.plugin
+}
+
+func (s *Shard) String() string {
+	return fmt.Sprintf("Shard %d/%d of %s", s.index, s.count, s.plugin)
+}
+
+// Close closes the resources.
+func (s *Shard) Close() error {
+	if c, ok := s.plugin.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/proto"
+	"testing"
+)
+
+func TestShard(t *testing.T) {
+	const shards = 3
+	seen := make(map[string]int)
+	connections := make(map[string]int)
+	total := 0
+
+	for i := 0; i < shards; i++ {
+		reader := new(sliceReader)
+		for j := 0; j < 300; j++ {
+			// 3 requests per captured connection
+			id := []byte(fmt.Sprintf("%016x%08x", j/3, j))
+			reader.messages = append(reader.messages,
+				&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, id, 1, 2), Data: []byte("GET / HTTP/1.1\r\n\r\n")},
+				&common.Message{Meta: proto.PayloadHeader(proto.ResponsePayload, id, 3, 4), Data: []byte("HTTP/1.1 200 OK\r\n\r\n")})
+		}
+
+		s := NewShard(reader, i, shards, proto.SessionByConnection)
+		kinds := make(map[string]int)
+		for {
+			msg, err := s.PluginRead()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta := proto.PayloadMeta(msg.Meta)
+			kinds[string(meta[1])] |= 1 << (meta[0][0] - '0')
+			seen[string(meta[1])]++
+			total++
+
+			// the requests of a connection stay on the same shard
+			connection := string(proto.SessionID(meta[1], proto.SessionByConnection))
+			if shard, ok := connections[connection]; ok && shard != i {
+				t.Errorf("connection %s split across shards %d and %d", connection, shard, i)
+			}
+			connections[connection] = i
+		}
+
+		if len(kinds) == 0 || len(kinds) == 300 {
+			t.Errorf("shard %d: expected a part of the requests, got %d", i, len(kinds))
+		}
+		for id, k := range kinds {
+			// the request and its response stay on the same shard
+			if k != 1<<1|1<<2 {
+				t.Errorf("shard %d: request %s split from its response", i, id)
+			}
+		}
+	}
+
+	if len(seen) != 300 || total != 600 {
+		t.Errorf("expected every payload on exactly one shard, got %d ids and %d payloads", len(seen), total)
+	}
+}
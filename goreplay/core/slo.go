@@ -0,0 +1,191 @@
+package core
+
+import (
+	"fmt"
+	"record-traffic-press/goreplay/proto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SLO metrics, the latencies are compared in milliseconds and the rates as ratios
+const (
+	SLOMetricP50          = "p50"
+	SLOMetricP90          = "p90"
+	SLOMetricP99          = "p99"
+	SLOMetricP999         = "p999"
+	SLOMetricMax          = "max"
+	SLOMetricMean         = "mean"
+	SLOMetricErrorRate    = "error_rate"    // errors and timeouts of the replayed requests
+	SLOMetricTimeoutRate  = "timeout_rate"  // timeouts of the replayed requests
+	SLOMetricMismatchRate = "mismatch_rate" // mismatched responses of the compared ones, see output.DiffOutput
+)
+
+// SLORule is a threshold a replay must stay below, e.g. "p99 < 300ms" or "error_rate < 0.5%"
+type SLORule struct {
+	Expr      string  `json:"expr"`
+	Metric    string  `json:"metric"`
+	Inclusive bool    `json:"inclusive"` // "<=" instead of "<"
+	Threshold float64 `json:"threshold"`
+}
+
+// ParseSLORule parses "<metric> < <threshold>" or "<metric> <= <threshold>".
+// Latency thresholds are durations, rate thresholds are ratios or percentages.
+func ParseSLORule(expr string) (SLORule, error) {
+	rule := SLORule{Expr: strings.TrimSpace(expr)}
+
+	i := strings.IndexByte(expr, '<')
+	if i < 0 {
+		return rule, fmt.Errorf("slo %q: expected <metric> < <threshold>", expr)
+	}
+	rule.Metric = strings.ToLower(strings.TrimSpace(expr[:i]))
+	value := expr[i+1:]
+	if strings.HasPrefix(value, "=") {
+		rule.Inclusive = true
+		value = value[1:]
+	}
+	value = strings.TrimSpace(value)
+
+	switch rule.Metric {
+	case SLOMetricP50, SLOMetricP90, SLOMetricP99, SLOMetricP999, SLOMetricMax, SLOMetricMean:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return rule, fmt.Errorf("slo %q: %v", expr, err)
+		}
+		rule.Threshold = float64(d) / float64(time.Millisecond)
+	case SLOMetricErrorRate, SLOMetricTimeoutRate, SLOMetricMismatchRate:
+		percent := strings.HasSuffix(value, "%")
+		f, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil {
+			return rule, fmt.Errorf("slo %q: %v", expr, err)
+		}
+		if percent {
+			f /= 100
+		}
+		rule.Threshold = f
+	default:
+		return rule, fmt.Errorf("slo %q: unknown metric %q", expr, rule.Metric)
+	}
+
+	return rule, nil
+}
+
+// ParseSLORules parses all the rules, see ParseSLORule
+func ParseSLORules(exprs []string) ([]SLORule, error) {
+	rules := make([]SLORule, 0, len(exprs))
+	for _, expr := range exprs {
+		rule, err := ParseSLORule(expr)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// SLOResult is the outcome of a rule, for the outputs with the worst value
+type SLOResult struct {
+	SLORule
+	Output  string  `json:"output,omitempty"`
+	Value   float64 `json:"value"`
+	Samples int64   `json:"samples"`
+	Passed  bool    `json:"passed"`
+	Pending bool    `json:"pending,omitempty"` // not enough samples to evaluate the rule yet
+}
+
+// SLOVerdict is the outcome of all the rules of a replay
+type SLOVerdict struct {
+	Passed  bool        `json:"passed"`
+	Aborted bool        `json:"aborted,omitempty"` // the replay was stopped by a breach
+	Results []SLOResult `json:"results"`
+}
+
+// EvaluateSLO checks the rules against the latencies of every replay output, keyed by output,
+// and the comparisons of the diff outputs. A rule with less than minSamples samples is pending,
+// it passes unless minSamples is 0, then a rule without samples fails.
+func EvaluateSLO(rules []SLORule, latencies map[string]LatencyReport, diff map[string]proto.DiffStats, minSamples int64) SLOVerdict {
+	verdict := SLOVerdict{Passed: true, Results: make([]SLOResult, 0, len(rules))}
+
+	outputs := make([]string, 0, len(latencies))
+	for output := range latencies {
+		outputs = append(outputs, output)
+	}
+	sort.Strings(outputs)
+
+	var compared, mismatched int64
+	for _, s := range diff {
+		compared += s.Matched + s.Mismatched
+		mismatched += s.Mismatched
+	}
+
+	for _, rule := range rules {
+		result := SLOResult{SLORule: rule}
+
+		if rule.Metric == SLOMetricMismatchRate {
+			result.Samples = compared
+			if compared > 0 {
+				result.Value = float64(mismatched) / float64(compared)
+			}
+		} else {
+			first := true
+			for _, output := range outputs {
+				l := latencies[output]
+				value, samples := sloValue(rule.Metric, l)
+				if samples == 0 {
+					continue
+				}
+				if first || value > result.Value {
+					result.Output, result.Value, result.Samples = output, value, samples
+					first = false
+				}
+			}
+		}
+
+		switch {
+		case result.Samples == 0 && minSamples <= 0:
+			result.Passed = false
+		case result.Samples < minSamples:
+			result.Passed, result.Pending = true, true
+		case rule.Inclusive:
+			result.Passed = result.Value <= rule.Threshold
+		default:
+			result.Passed = result.Value < rule.Threshold
+		}
+
+		verdict.Passed = verdict.Passed && result.Passed
+		verdict.Results = append(verdict.Results, result)
+	}
+
+	return verdict
+}
+
+func sloValue(metric string, l LatencyReport) (float64, int64) {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+	switch metric {
+	case SLOMetricP50:
+		return ms(l.P50), l.Count
+	case SLOMetricP90:
+		return ms(l.P90), l.Count
+	case SLOMetricP99:
+		return ms(l.P99), l.Count
+	case SLOMetricP999:
+		return ms(l.P999), l.Count
+	case SLOMetricMax:
+		return ms(l.Max), l.Count
+	case SLOMetricMean:
+		return ms(l.Mean), l.Count
+	case SLOMetricErrorRate:
+		if l.Requests == 0 {
+			return 0, 0
+		}
+		return float64(l.Errors+l.Timeouts) / float64(l.Requests), l.Requests
+	case SLOMetricTimeoutRate:
+		if l.Requests == 0 {
+			return 0, 0
+		}
+		return float64(l.Timeouts) / float64(l.Requests), l.Requests
+	}
+	return 0, 0
+}
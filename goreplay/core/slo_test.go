@@ -0,0 +1,81 @@
+package core
+
+import (
+	"record-traffic-press/goreplay/proto"
+	"testing"
+	"time"
+)
+
+func TestParseSLORule(t *testing.T) {
+	tests := []struct {
+		expr      string
+		metric    string
+		inclusive bool
+		threshold float64
+	}{
+		{"p99 < 300ms", SLOMetricP99, false, 300},
+		{"P999<=1.5s", SLOMetricP999, true, 1500},
+		{"error_rate < 0.5%", SLOMetricErrorRate, false, 0.005},
+		{"mismatch_rate<0.01", SLOMetricMismatchRate, false, 0.01},
+	}
+
+	for _, tt := range tests {
+		rule, err := ParseSLORule(tt.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		if rule.Metric != tt.metric || rule.Inclusive != tt.inclusive || rule.Threshold != tt.threshold {
+			t.Errorf("%q: unexpected rule %+v", tt.expr, rule)
+		}
+	}
+
+	for _, expr := range []string{"p99 > 300ms", "p95 < 1s", "p99 < 300", "error_rate < a%"} {
+		if _, err := ParseSLORule(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestEvaluateSLO(t *testing.T) {
+	rules, err := ParseSLORules([]string{"p99 < 300ms", "error_rate < 1%", "mismatch_rate < 5%"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latencies := map[string]LatencyReport{
+		"HTTP output: a": {Requests: 200, Count: 199, Errors: 1, P99: 100 * time.Millisecond},
+		"HTTP output: b": {Requests: 100, Count: 98, Timeouts: 2, P99: 400 * time.Millisecond},
+	}
+	diff := map[string]proto.DiffStats{
+		"GET /a": {Total: 60, Matched: 48, Mismatched: 2, Missing: 10},
+	}
+
+	verdict := EvaluateSLO(rules, latencies, diff, 0)
+	if verdict.Passed {
+		t.Error("expected the verdict to fail")
+	}
+
+	p99, errorRate, mismatchRate := verdict.Results[0], verdict.Results[1], verdict.Results[2]
+	if p99.Passed || p99.Output != "HTTP output: b" || p99.Value != 400 {
+		t.Errorf("unexpected p99 result %+v", p99)
+	}
+	if errorRate.Passed || errorRate.Output != "HTTP output: b" || errorRate.Value != 0.02 {
+		t.Errorf("unexpected error rate result %+v", errorRate)
+	}
+	if !mismatchRate.Passed || mismatchRate.Value != 0.04 || mismatchRate.Samples != 50 {
+		t.Errorf("unexpected mismatch rate result %+v", mismatchRate)
+	}
+
+	// rules with too few samples don't fail yet
+	verdict = EvaluateSLO(rules, latencies, diff, 150)
+	if !verdict.Results[0].Passed || !verdict.Results[2].Pending {
+		t.Errorf("unexpected results %+v", verdict.Results)
+	}
+
+	// a replay without any data can't pass
+	verdict = EvaluateSLO(rules, nil, nil, 0)
+	if verdict.Passed {
+		t.Error("expected the verdict to fail without data")
+	}
+}
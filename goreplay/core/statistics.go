@@ -2,13 +2,13 @@ package core
 
 import (
 	"record-traffic-press/goreplay/glogs"
-	"record-traffic-press/goreplay/settings"
 
 	"runtime"
 	"strconv"
 	"time"
 )
 
+// GorStat reports queue length statistics periodically, plugins create it only when stats are enabled
 type GorStat struct {
 	statName string
 	rateMs   int
@@ -16,6 +16,7 @@ type GorStat struct {
 	mean     int
 	max      int
 	count    int
+	stop     chan struct{}
 }
 
 func NewGorStat(statName string, rateMs int) (s *GorStat) {
@@ -26,24 +27,21 @@ func NewGorStat(statName string, rateMs int) (s *GorStat) {
 	s.mean = 0
 	s.max = 0
 	s.count = 0
+	s.stop = make(chan struct{})
 
-	if settings.Settings.Stats {
-		go s.ReportStats()
-	}
+	go s.ReportStats()
 	return
 }
 
 func (s *GorStat) Write(latest int) {
-	if settings.Settings.Stats {
-		if latest > s.max {
-			s.max = latest
-		}
-		if latest != 0 {
-			s.mean = ((s.mean * s.count) + latest) / (s.count + 1)
-		}
-		s.latest = latest
-		s.count = s.count + 1
+	if latest > s.max {
+		s.max = latest
+	}
+	if latest != 0 {
+		s.mean = ((s.mean * s.count) + latest) / (s.count + 1)
 	}
+	s.latest = latest
+	s.count = s.count + 1
 }
 
 func (s *GorStat) Reset() {
@@ -59,9 +57,20 @@ func (s *GorStat) String() string {
 
 func (s *GorStat) ReportStats() {
 	glogs.Debug(0, "\n", s.statName+":latest,mean,max,count,count/second,gcount")
+	ticker := time.NewTicker(time.Duration(s.rateMs) * time.Millisecond)
+	defer ticker.Stop()
 	for {
 		glogs.Debug(0, "\n", s)
 		s.Reset()
-		time.Sleep(time.Duration(s.rateMs) * time.Millisecond)
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
 	}
 }
+
+// Close stops reporting stats
+func (s *GorStat) Close() {
+	close(s.stop)
+}
@@ -20,6 +20,14 @@ const (
 	ProtocolHTTP TCPProtocol = iota
 	// ProtocolBinary ...
 	ProtocolBinary
+	// ProtocolDubbo dubbo messages framed by their 16 bytes header
+	ProtocolDubbo
+	// ProtocolHTTP2 HTTP/2 frames, demultiplexed into one message per stream by the raw input
+	ProtocolHTTP2
+	// ProtocolRedis redis commands and replies framed by RESP, pipelined ones are paired by order by the raw input
+	ProtocolRedis
+	// ProtocolMySQL MySQL packets, the queries and their results are rebuilt by the raw input
+	ProtocolMySQL
 )
 
 // Set is here so that TCPProtocol can implement flag.Var
@@ -29,6 +37,14 @@ func (protocol *TCPProtocol) Set(v string) error {
 		*protocol = ProtocolHTTP
 	case "binary":
 		*protocol = ProtocolBinary
+	case "dubbo":
+		*protocol = ProtocolDubbo
+	case "http2":
+		*protocol = ProtocolHTTP2
+	case "redis":
+		*protocol = ProtocolRedis
+	case "mysql":
+		*protocol = ProtocolMySQL
 	default:
 		return fmt.Errorf("unsupported protocol %s", v)
 	}
@@ -41,6 +57,14 @@ func (protocol *TCPProtocol) String() string {
 		return "binary"
 	case ProtocolHTTP:
 		return "http"
+	case ProtocolDubbo:
+		return "dubbo"
+	case ProtocolHTTP2:
+		return "http2"
+	case ProtocolRedis:
+		return "redis"
+	case ProtocolMySQL:
+		return "mysql"
 	default:
 		return ""
 	}
@@ -71,20 +95,10 @@ type TcpMessage struct {
 
 // UUID returns the UUID of a TCP request and its response.
 func (m *TcpMessage) UUID() []byte {
-	var streamID uint64
 	pckt := m.packets[0]
 
-	// check if response or request have generated the ID before.
-	if m.Direction == DirIncoming {
-		streamID = uint64(pckt.SrcPort)<<48 | uint64(pckt.DstPort)<<32 |
-			uint64(ip2int(pckt.SrcIP))
-	} else {
-		streamID = uint64(pckt.DstPort)<<48 | uint64(pckt.SrcPort)<<32 |
-			uint64(ip2int(pckt.DstIP))
-	}
-
 	id := make([]byte, 12)
-	binary.BigEndian.PutUint64(id, streamID)
+	binary.BigEndian.PutUint64(id, m.streamID())
 
 	if m.Direction == DirIncoming {
 		binary.BigEndian.PutUint32(id[8:], pckt.Ack)
@@ -98,6 +112,32 @@ func (m *TcpMessage) UUID() []byte {
 	return uuidHex
 }
 
+// RequestUUID returns the UUID of a request and its response identified by a protocol level request ID,
+// e.g. the dubbo request ID, which pairs them even when several requests share a connection.
+func (m *TcpMessage) RequestUUID(requestID uint64) []byte {
+	id := make([]byte, 16)
+	binary.BigEndian.PutUint64(id, m.streamID())
+	binary.BigEndian.PutUint64(id[8:], requestID)
+
+	uuidHex := make([]byte, 32)
+	hex.Encode(uuidHex[:], id[:])
+
+	return uuidHex
+}
+
+// streamID identifies the connection, it is the same for a request and its response
+func (m *TcpMessage) streamID() uint64 {
+	pckt := m.packets[0]
+
+	// check if response or request have generated the ID before.
+	if m.Direction == DirIncoming {
+		return uint64(pckt.SrcPort)<<48 | uint64(pckt.DstPort)<<32 |
+			uint64(ip2int(pckt.SrcIP))
+	}
+	return uint64(pckt.DstPort)<<48 | uint64(pckt.SrcPort)<<32 |
+		uint64(ip2int(pckt.DstIP))
+}
+
 func (m *TcpMessage) add(packet *Packet) bool {
 	// Skip duplicates
 	for _, p := range m.packets {
@@ -213,6 +253,7 @@ type MessageParser struct {
 	allowIncompete bool
 	End            HintEnd
 	Start          HintStart
+	TLS            *TLSDecrypter // decrypts the TLS connections before their messages are framed, when set
 	ticker         *time.Ticker
 	messages       chan *TcpMessage
 	packets        chan *PcapPacket
@@ -265,7 +306,7 @@ func (parser *MessageParser) wait() {
 	for {
 		select {
 		case pckt := <-parser.packets:
-			parser.processPacket(parser.parsePacket(pckt))
+			parser.processPacket(parser.decrypt(parser.parsePacket(pckt)))
 		case now = <-parser.ticker.C:
 			parser.timer(now)
 		case <-parser.close:
@@ -300,6 +341,14 @@ func (parser *MessageParser) parsePacket(pcapPkt *PcapPacket) *Packet {
 	return pckt
 }
 
+// decrypt replaces the segments of the TLS connections by the packets of their plaintext, see TLSDecrypter
+func (parser *MessageParser) decrypt(pckt *Packet) *Packet {
+	if pckt == nil || parser.TLS == nil {
+		return pckt
+	}
+	return parser.TLS.Decrypt(pckt)
+}
+
 func containsOrEmpty(element net.IP, ipList []net.IP) bool {
 	if len(ipList) == 0 {
 		return true
@@ -422,6 +471,10 @@ func (parser *MessageParser) timer(now time.Time) {
 	packetQueueLen.Set(int64(len(parser.packets)))
 	messageQueueLen.Set(int64(len(parser.m)))
 
+	if parser.TLS != nil {
+		parser.TLS.Sweep(now)
+	}
+
 	for _, m := range parser.m {
 		if now.Sub(m.End) > parser.messageExpire {
 			m.TimedOut = true
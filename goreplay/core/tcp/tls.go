@@ -0,0 +1,555 @@
+package tcp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	tlsRecordHeaderLength = 5
+	tlsMaxRecordLength    = 1<<14 + 2048
+	tlsMaxBuffered        = 1 << 20 // stream bytes of a direction waiting for the keys or for a missing segment
+	tlsMaxHeld            = 64      // segments received before a missing one
+	tlsConnTTL            = 2 * time.Minute
+
+	tlsTypeChangeCipherSpec = 20
+	tlsTypeHandshake        = 22
+	tlsTypeApplicationData  = 23
+
+	tlsClientHello = 1
+	tlsServerHello = 2
+	tlsFinished    = 20
+	tlsKeyUpdate   = 24
+
+	tlsExtensionSupportedVersions = 43
+)
+
+var (
+	errTLSMalformed   = errors.New("malformed TLS handshake")
+	errTLSUnsupported = errors.New("unsupported TLS version or cipher suite")
+
+	// helloRetryRequestRandom is the random of a ServerHello asking the client for another ClientHello
+	helloRetryRequestRandom = []byte{
+		0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+		0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+	}
+)
+
+// tlsSuite is an AEAD cipher suite
+type tlsSuite struct {
+	keyLen int
+	ivLen  int // 4 for AES-GCM in TLS 1.2, the rest of the nonce is explicit, 12 otherwise
+	hash   func() hash.Hash
+	chacha bool
+}
+
+var tlsSuites = map[uint16]*tlsSuite{
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256:               {16, 4, sha256.New, false},
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384:               {32, 4, sha512.New384, false},
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:         {16, 4, sha256.New, false},
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384:         {32, 4, sha512.New384, false},
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256:       {16, 4, sha256.New, false},
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384:       {32, 4, sha512.New384, false},
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256:   {32, 12, sha256.New, true},
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256: {32, 12, sha256.New, true},
+	tls.TLS_AES_128_GCM_SHA256:                        {16, 12, sha256.New, false},
+	tls.TLS_AES_256_GCM_SHA384:                        {32, 12, sha512.New384, false},
+	tls.TLS_CHACHA20_POLY1305_SHA256:                  {32, 12, sha256.New, true},
+}
+
+// TLSDecrypter decrypts the TLS 1.2 and 1.3 connections captured from their handshake with the secrets
+// of a key log. The TCP stream of every direction is reassembled into records, and a segment is replaced
+// by a packet of the application data it completes, whose Seq and Ack count the decrypted bytes, so the
+// messages are framed as if they were captured in plaintext. Only the AEAD cipher suites are supported,
+// AES-GCM and ChaCha20-Poly1305. The connections which are not TLS are passed through.
+//
+// The connections which can't be decrypted are counted in the tcp stats: tls_missing_key when their
+// secrets are not logged, tls_missed_handshake when the capture started after their handshake,
+// tls_unsupported and tls_errors.
+type TLSDecrypter struct {
+	keyLog  *KeyLog
+	keyWait time.Duration // how long the secrets of a connection may be missing from the key log
+	conns   map[tlsConnKey]*tlsConn
+}
+
+// tlsConnKey identifies a connection by its endpoints, in the same order for both directions
+type tlsConnKey [36]byte
+
+type tlsConn struct {
+	client, server tlsHalf
+	clientIP       net.IP
+	clientPort     uint16
+	clientRandom   []byte
+	serverRandom   []byte
+	version        uint16
+	suite          *tlsSuite
+
+	plain      bool      // not TLS, the packets are passed through
+	failed     bool      // the packets are dropped
+	decrypting bool      // the secrets were found
+	keyWait    time.Time // since when the secrets are missing
+	lastSeen   time.Time
+}
+
+// tlsHalf is a direction of a TLS connection, its TCP stream and the protection of its records
+type tlsHalf struct {
+	started   bool
+	nextSeq   uint32            // TCP sequence of the next stream byte
+	held      map[uint32][]byte // segments received before a missing one
+	buf       []byte            // stream bytes not parsed as records yet
+	handshake []byte            // handshake messages not complete yet
+
+	protected     bool // the records are encrypted, after ChangeCipherSpec or the TLS 1.3 ServerHello
+	handshakeDone bool // TLS 1.3, the traffic secret protects the next records
+	aead          cipher.AEAD
+	iv            []byte
+	secret        []byte // TLS 1.3, updated by KeyUpdate
+	seq           uint64
+
+	plainSeq uint32 // the decrypted packets carry it as Seq
+}
+
+// NewTLSDecrypter returns a TLSDecrypter waiting up to keyWait for the secrets of a connection
+func NewTLSDecrypter(keyLog *KeyLog, keyWait time.Duration) *TLSDecrypter {
+	if keyWait == 0 {
+		keyWait = time.Second
+	}
+	return &TLSDecrypter{keyLog: keyLog, keyWait: keyWait, conns: make(map[tlsConnKey]*tlsConn)}
+}
+
+// Decrypt returns the packet of the application data completed by a TLS segment, nil when there is none
+// or when the connection can't be decrypted. The packets of the connections which are not TLS are returned as is.
+func (d *TLSDecrypter) Decrypt(pckt *Packet) *Packet {
+	key := tlsKey(pckt)
+	c, ok := d.conns[key]
+	if !ok {
+		c = d.newConn(pckt)
+		d.conns[key] = c
+	}
+	c.lastSeen = time.Now()
+	if c.plain {
+		return pckt
+	}
+	if c.failed {
+		return nil
+	}
+
+	half, other := &c.server, &c.client
+	if pckt.SrcPort == c.clientPort && pckt.SrcIP.Equal(c.clientIP) {
+		half, other = &c.client, &c.server
+	}
+	if !half.add(pckt.Seq, pckt.Payload) {
+		if c.keyWait.IsZero() {
+			d.fail(c, "tls_errors")
+		} else {
+			d.fail(c, "tls_missing_key")
+		}
+		return nil
+	}
+
+	plaintext, err := d.records(c, half)
+	switch {
+	case errors.Is(err, errTLSUnsupported):
+		d.fail(c, "tls_unsupported")
+		return nil
+	case err != nil:
+		d.fail(c, "tls_errors")
+		return nil
+	case !c.keyWait.IsZero() && time.Since(c.keyWait) > d.keyWait:
+		d.fail(c, "tls_missing_key")
+		return nil
+	case len(plaintext) == 0:
+		return nil
+	}
+
+	decrypted := *pckt
+	decrypted.messageID = 0
+	decrypted.Payload = plaintext
+	decrypted.Seq = half.plainSeq
+	decrypted.Ack = other.plainSeq
+	decrypted.Lost = 0
+	half.plainSeq += uint32(len(plaintext))
+	return &decrypted
+}
+
+// Sweep gives up the connections whose secrets are still missing and forgets the idle ones
+func (d *TLSDecrypter) Sweep(now time.Time) {
+	for key, c := range d.conns {
+		if !c.failed && !c.keyWait.IsZero() && now.Sub(c.keyWait) > d.keyWait {
+			d.fail(c, "tls_missing_key")
+		}
+		if now.Sub(c.lastSeen) > tlsConnTTL {
+			delete(d.conns, key)
+		}
+	}
+}
+
+func (d *TLSDecrypter) newConn(pckt *Packet) *tlsConn {
+	c := new(tlsConn)
+	p := pckt.Payload
+	if len(p) <= tlsRecordHeaderLength || p[0] < tlsTypeChangeCipherSpec || p[0] > tlsTypeApplicationData || p[1] != 3 {
+		c.plain = true
+		return c
+	}
+
+	stats.Add("tls_connections", 1)
+	if p[0] != tlsTypeHandshake || p[tlsRecordHeaderLength] != tlsClientHello {
+		d.fail(c, "tls_missed_handshake")
+		return c
+	}
+	c.clientIP = append(net.IP{}, pckt.SrcIP...)
+	c.clientPort = pckt.SrcPort
+	return c
+}
+
+func (d *TLSDecrypter) fail(c *tlsConn, stat string) {
+	stats.Add(stat, 1)
+	c.failed = true
+	c.client, c.server = tlsHalf{}, tlsHalf{}
+}
+
+// records parses the complete records of a direction and returns the plaintext of its application data,
+// the records are kept until the secrets protecting them are found
+func (d *TLSDecrypter) records(c *tlsConn, half *tlsHalf) (plaintext []byte, err error) {
+	for len(half.buf) >= tlsRecordHeaderLength {
+		length := int(binary.BigEndian.Uint16(half.buf[3:]))
+		if length > tlsMaxRecordLength {
+			return nil, errors.New("TLS record too long")
+		}
+		if len(half.buf) < tlsRecordHeaderLength+length {
+			break
+		}
+		record := half.buf[:tlsRecordHeaderLength+length]
+		typ, data := record[0], record[tlsRecordHeaderLength:]
+
+		decrypted := false
+		switch {
+		case typ == tlsTypeChangeCipherSpec:
+			// TLS 1.3 sends it for compatibility only
+			if c.version != tls.VersionTLS13 {
+				half.protected = true
+			}
+		case half.protected && (c.version != tls.VersionTLS13 || typ == tlsTypeApplicationData):
+			if half.aead == nil && !d.keys(c, half) {
+				return plaintext, nil
+			}
+			if typ, data, err = half.open(c.version, record); err != nil {
+				return nil, err
+			}
+			decrypted = true
+		}
+
+		switch {
+		case typ == tlsTypeHandshake:
+			if err = c.handshake(half, data); err != nil {
+				return nil, err
+			}
+		case typ == tlsTypeApplicationData && decrypted:
+			plaintext = append(plaintext, data...)
+		}
+		half.buf = half.buf[len(record):]
+	}
+
+	if len(half.buf) == 0 {
+		half.buf = nil
+	}
+	return plaintext, nil
+}
+
+// keys protects a direction with the secrets logged for the connection, false when they are missing
+func (d *TLSDecrypter) keys(c *tlsConn, half *tlsHalf) bool {
+	s := c.suite
+	if s == nil || c.clientRandom == nil {
+		return d.wait(c)
+	}
+
+	if c.version == tls.VersionTLS13 {
+		label := "SERVER_"
+		if half == &c.client {
+			label = "CLIENT_"
+		}
+		if half.handshakeDone {
+			label += "TRAFFIC_SECRET_0"
+		} else {
+			label += "HANDSHAKE_TRAFFIC_SECRET"
+		}
+		secret, ok := d.keyLog.Secret(label, c.clientRandom)
+		if !ok {
+			return d.wait(c)
+		}
+		half.setSecret(s, secret)
+	} else {
+		master, ok := d.keyLog.Secret("CLIENT_RANDOM", c.clientRandom)
+		if !ok {
+			return d.wait(c)
+		}
+		seed := append(append([]byte{}, c.serverRandom...), c.clientRandom...)
+		keys := tlsPRF12(s.hash, master, "key expansion", seed, 2*s.keyLen+2*s.ivLen)
+		c.client.setKey(s, keys[:s.keyLen], keys[2*s.keyLen:2*s.keyLen+s.ivLen])
+		c.server.setKey(s, keys[s.keyLen:2*s.keyLen], keys[2*s.keyLen+s.ivLen:])
+	}
+
+	if !c.decrypting {
+		c.decrypting = true
+		stats.Add("tls_decrypted", 1)
+	}
+	c.keyWait = time.Time{}
+	return true
+}
+
+func (d *TLSDecrypter) wait(c *tlsConn) bool {
+	if c.keyWait.IsZero() {
+		c.keyWait = time.Now()
+	}
+	return false
+}
+
+// handshake reads the handshake messages of a direction: the randoms, the version and cipher suite and,
+// for TLS 1.3, the changes of traffic secret
+func (c *tlsConn) handshake(half *tlsHalf, data []byte) error {
+	half.handshake = append(half.handshake, data...)
+	if len(half.handshake) > tlsMaxBuffered {
+		return errTLSMalformed
+	}
+
+	for len(half.handshake) >= 4 {
+		length := int(half.handshake[1])<<16 | int(half.handshake[2])<<8 | int(half.handshake[3])
+		if len(half.handshake) < 4+length {
+			break
+		}
+		typ, body := half.handshake[0], half.handshake[4:4+length]
+		half.handshake = half.handshake[4+length:]
+
+		switch {
+		case typ == tlsClientHello && half == &c.client && c.clientRandom == nil:
+			if len(body) < 34 {
+				return errTLSMalformed
+			}
+			c.clientRandom = append([]byte{}, body[2:34]...)
+		case typ == tlsServerHello && half == &c.server && c.suite == nil:
+			if err := c.serverHello(body); err != nil {
+				return err
+			}
+		case typ == tlsFinished && c.version == tls.VersionTLS13 && !half.handshakeDone:
+			half.handshakeDone = true
+			half.aead = nil
+		case typ == tlsKeyUpdate && c.version == tls.VersionTLS13 && half.handshakeDone:
+			half.setSecret(c.suite, tlsExpandLabel(c.suite.hash, half.secret, "traffic upd", c.suite.hash().Size()))
+		}
+	}
+
+	if len(half.handshake) == 0 {
+		half.handshake = nil
+	}
+	return nil
+}
+
+func (c *tlsConn) serverHello(body []byte) error {
+	if len(body) < 35 {
+		return errTLSMalformed
+	}
+	if bytes.Equal(body[2:34], helloRetryRequestRandom) {
+		// the client sends another ClientHello, with the same random
+		return nil
+	}
+
+	version := binary.BigEndian.Uint16(body)
+	pos := 35 + int(body[34])
+	if len(body) < pos+3 {
+		return errTLSMalformed
+	}
+	id := binary.BigEndian.Uint16(body[pos:])
+	pos += 3 // cipher suite and compression method
+
+	if len(body) >= pos+2 {
+		end := pos + 2 + int(binary.BigEndian.Uint16(body[pos:]))
+		if end > len(body) {
+			return errTLSMalformed
+		}
+		for pos += 2; pos+4 <= end; {
+			ext, length := binary.BigEndian.Uint16(body[pos:]), int(binary.BigEndian.Uint16(body[pos+2:]))
+			pos += 4
+			if pos+length > end {
+				return errTLSMalformed
+			}
+			if ext == tlsExtensionSupportedVersions && length == 2 {
+				version = binary.BigEndian.Uint16(body[pos:])
+			}
+			pos += length
+		}
+	}
+
+	suite, ok := tlsSuites[id]
+	if !ok || (version != tls.VersionTLS12 && version != tls.VersionTLS13) || (id>>8 == 0x13) != (version == tls.VersionTLS13) {
+		return errTLSUnsupported
+	}
+	c.version, c.suite = version, suite
+	c.serverRandom = append([]byte{}, body[2:34]...)
+	if version == tls.VersionTLS13 {
+		c.client.protected, c.server.protected = true, true
+	}
+	return nil
+}
+
+// add appends a segment to the stream, the segments received out of order are held until the missing ones
+func (h *tlsHalf) add(seq uint32, payload []byte) bool {
+	if !h.started {
+		h.started, h.nextSeq, h.plainSeq = true, seq, seq
+	}
+
+	if diff := int32(seq - h.nextSeq); diff > 0 {
+		if h.held == nil {
+			h.held = make(map[uint32][]byte)
+		}
+		h.held[seq] = append([]byte{}, payload...)
+		return len(h.held) <= tlsMaxHeld
+	} else if diff < 0 {
+		// retransmitted
+		if int(-diff) >= len(payload) {
+			return true
+		}
+		payload = payload[-diff:]
+	}
+	h.buf = append(h.buf, payload...)
+	h.nextSeq += uint32(len(payload))
+
+	for progress := true; progress && len(h.held) > 0; {
+		progress = false
+		for seq, p := range h.held {
+			diff := int32(seq - h.nextSeq)
+			if diff > 0 {
+				continue
+			}
+			delete(h.held, seq)
+			if int(-diff) < len(p) {
+				h.buf = append(h.buf, p[-diff:]...)
+				h.nextSeq += uint32(len(p) + int(diff))
+			}
+			progress = true
+		}
+	}
+	return len(h.buf) <= tlsMaxBuffered
+}
+
+func (h *tlsHalf) setKey(s *tlsSuite, key, iv []byte) {
+	if s.chacha {
+		h.aead, _ = chacha20poly1305.New(key)
+	} else {
+		block, _ := aes.NewCipher(key)
+		h.aead, _ = cipher.NewGCM(block)
+	}
+	h.iv = append([]byte{}, iv...)
+	h.seq = 0
+}
+
+func (h *tlsHalf) setSecret(s *tlsSuite, secret []byte) {
+	h.secret = secret
+	h.setKey(s, tlsExpandLabel(s.hash, secret, "key", s.keyLen), tlsExpandLabel(s.hash, secret, "iv", 12))
+}
+
+// open decrypts a record, it returns the content type and the plaintext
+func (h *tlsHalf) open(version uint16, record []byte) (byte, []byte, error) {
+	header, payload := record[:tlsRecordHeaderLength], record[tlsRecordHeaderLength:]
+
+	nonce := make([]byte, h.aead.NonceSize())
+	copy(nonce, h.iv)
+	if len(h.iv) == len(nonce) {
+		for i := 0; i < 8; i++ {
+			nonce[len(nonce)-1-i] ^= byte(h.seq >> (8 * i))
+		}
+	} else {
+		// the explicit part of the nonce starts the record
+		if len(payload) < len(nonce)-len(h.iv) {
+			return 0, nil, errors.New("TLS record too short")
+		}
+		copy(nonce[len(h.iv):], payload)
+		payload = payload[len(nonce)-len(h.iv):]
+	}
+
+	additional := header
+	if version != tls.VersionTLS13 {
+		if len(payload) < h.aead.Overhead() {
+			return 0, nil, errors.New("TLS record too short")
+		}
+		additional = make([]byte, 13)
+		binary.BigEndian.PutUint64(additional, h.seq)
+		copy(additional[8:], header[:3])
+		binary.BigEndian.PutUint16(additional[11:], uint16(len(payload)-h.aead.Overhead()))
+	}
+
+	plaintext, err := h.aead.Open(nil, nonce, payload, additional)
+	if err != nil {
+		return 0, nil, err
+	}
+	h.seq++
+
+	if version != tls.VersionTLS13 {
+		return record[0], plaintext, nil
+	}
+	// the content type follows the content, then the padding
+	i := len(plaintext) - 1
+	for i >= 0 && plaintext[i] == 0 {
+		i--
+	}
+	if i < 0 {
+		return 0, nil, errors.New("TLS record without content type")
+	}
+	return plaintext[i], plaintext[:i], nil
+}
+
+func tlsKey(pckt *Packet) (key tlsConnKey) {
+	a, b := key[:18], key[18:]
+	copy(a, pckt.SrcIP.To16())
+	binary.BigEndian.PutUint16(a[16:], pckt.SrcPort)
+	copy(b, pckt.DstIP.To16())
+	binary.BigEndian.PutUint16(b[16:], pckt.DstPort)
+	if bytes.Compare(a, b) > 0 {
+		var tmp [18]byte
+		copy(tmp[:], a)
+		copy(a, b)
+		copy(b, tmp[:])
+	}
+	return key
+}
+
+// tlsPRF12 is the PRF of TLS 1.2, P_hash of RFC 5246
+func tlsPRF12(h func() hash.Hash, secret []byte, label string, seed []byte, length int) []byte {
+	seed = append([]byte(label), seed...)
+	mac := hmac.New(h, secret)
+	out := make([]byte, 0, length+mac.Size())
+	a := seed
+	for len(out) < length {
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(seed)
+		out = mac.Sum(out)
+	}
+	return out[:length]
+}
+
+// tlsExpandLabel is HKDF-Expand-Label of TLS 1.3 with an empty context
+func tlsExpandLabel(h func() hash.Hash, secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := append([]byte{byte(length >> 8), byte(length), byte(len(label))}, label...)
+	info = append(info, 0)
+	out := make([]byte, length)
+	_, _ = io.ReadFull(hkdf.Expand(h, secret, info), out)
+	return out
+}
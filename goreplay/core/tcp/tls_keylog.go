@@ -0,0 +1,103 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyLogRefreshInterval limits how often the key log file is read again for a missing key
+const keyLogRefreshInterval = 100 * time.Millisecond
+
+// KeyLog holds the secrets of a key log file in the NSS format, as written by SSLKEYLOGFILE or the
+// KeyLogWriter of a Go TLS config: "<label> <client random> <secret>" lines. The servers append the
+// secrets of the new connections while they are captured, so the lines appended since the last read
+// are read again when a secret is missing.
+type KeyLog struct {
+	path      string
+	mu        sync.Mutex
+	offset    int64
+	partial   []byte            // the last line, not terminated yet
+	secrets   map[string][]byte // keyed by label and client random
+	refreshed time.Time
+}
+
+// NewKeyLog reads the key log file at path
+func NewKeyLog(path string) (*KeyLog, error) {
+	k := &KeyLog{path: path, secrets: make(map[string][]byte)}
+	if err := k.refresh(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Secret returns the secret logged with label, e.g. CLIENT_RANDOM or SERVER_TRAFFIC_SECRET_0,
+// for the connection with clientRandom
+func (k *KeyLog) Secret(label string, clientRandom []byte) ([]byte, bool) {
+	key := label + " " + hex.EncodeToString(clientRandom)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if secret, ok := k.secrets[key]; ok {
+		return secret, true
+	}
+	if time.Since(k.refreshed) < keyLogRefreshInterval {
+		return nil, false
+	}
+	_ = k.refresh()
+	secret, ok := k.secrets[key]
+	return secret, ok
+}
+
+// refresh reads the lines appended since the last read, the whole file when it was truncated
+func (k *KeyLog) refresh() error {
+	k.refreshed = time.Now()
+
+	f, err := os.Open(k.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < k.offset {
+		k.offset = 0
+		k.partial = nil
+	}
+	if _, err = f.Seek(k.offset, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	k.offset += int64(len(data))
+
+	data = append(k.partial, data...)
+	end := bytes.LastIndexByte(data, '\n') + 1
+	k.partial = append([]byte{}, data[end:]...)
+
+	for _, line := range strings.Split(string(data[:end]), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		clientRandom, err := hex.DecodeString(fields[1])
+		if err != nil {
+			continue
+		}
+		secret, err := hex.DecodeString(fields[2])
+		if err != nil {
+			continue
+		}
+		k.secrets[fields[0]+" "+hex.EncodeToString(clientRandom)] = secret
+	}
+	return nil
+}
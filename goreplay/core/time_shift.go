@@ -0,0 +1,358 @@
+package core
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"record-traffic-press/goreplay/glogs"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jwtClaims are the timestamps of a JWT moved by TimeShifter
+var jwtClaims = []string{"exp", "iat", "nbf"}
+
+var jwtRegexp = regexp.MustCompile(`[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+// TimeShiftRule locates a timestamp in a request: a header, a query parameter,
+// a field of the JSON body or the claims of a JWT carried by a header
+type TimeShiftRule struct {
+	Expr string
+	kind string
+	name string
+	path []interface{} // json only
+}
+
+// ParseTimeShiftRule parses "header:<name>", "param:<name>", "json:<path>", e.g. "json:$.order.created_at",
+// or "jwt:<header>", e.g. "jwt:Authorization"
+func ParseTimeShiftRule(expr string) (TimeShiftRule, error) {
+	rule := TimeShiftRule{Expr: expr}
+
+	kind, name, ok := strings.Cut(expr, ":")
+	if !ok || name == "" {
+		return rule, fmt.Errorf("time shift %q: expected <header|param|json|jwt>:<name>", expr)
+	}
+	rule.kind, rule.name = kind, name
+
+	switch kind {
+	case "header", "param", "jwt":
+	case "json":
+		path, err := proto.ParseJSONPath(name)
+		if err != nil {
+			return rule, fmt.Errorf("time shift %q: %v", expr, err)
+		}
+		rule.path = path
+	default:
+		return rule, fmt.Errorf("time shift %q: unknown kind %q", expr, kind)
+	}
+
+	return rule, nil
+}
+
+// ParseTimeShiftRules parses all the rules, see ParseTimeShiftRule
+func ParseTimeShiftRules(exprs []string) ([]TimeShiftRule, error) {
+	rules := make([]TimeShiftRule, 0, len(exprs))
+	for _, expr := range exprs {
+		rule, err := ParseTimeShiftRule(expr)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// TimeShifter moves the timestamps found by its rules by the time elapsed since the request was
+// captured, so recorded requests don't carry stale dates. Epoch seconds and milliseconds, RFC3339
+// and HTTP dates are recognized, other values are left as is.
+type TimeShifter struct {
+	rules  []TimeShiftRule
+	jwtKey []byte
+}
+
+// NewTimeShifter constructor for TimeShifter, nil when there are no rules. Invalid rules are skipped,
+// they are expected to be checked with ParseTimeShiftRules beforehand.
+func NewTimeShifter(config *settings.TimeShiftConfig) *TimeShifter {
+	var rules []TimeShiftRule
+	for _, expr := range config.Rules {
+		rule, err := ParseTimeShiftRule(expr)
+		if err != nil {
+			glogs.Debug(1, "[TIME-SHIFT]", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return &TimeShifter{rules: rules, jwtKey: []byte(config.JWTKey)}
+}
+
+// Rewrite shifts the timestamps of a request captured at the given time, in nanoseconds
+func (s *TimeShifter) Rewrite(captured int64, request []byte) []byte {
+	if captured <= 0 || !proto.HasRequestTitle(request) && !proto.HasHTTP2RequestTitle(request) {
+		return request
+	}
+	// whole seconds, the sub-second part of the recorded values is kept
+	shift := time.Since(time.Unix(0, captured)).Truncate(time.Second)
+
+	rewritten := request
+	for i := range s.rules {
+		rewritten = s.rewrite(&s.rules[i], shift, rewritten)
+	}
+	return fixContentLength(request, rewritten)
+}
+
+func (s *TimeShifter) rewrite(rule *TimeShiftRule, shift time.Duration, request []byte) []byte {
+	switch rule.kind {
+	case "header":
+		if value, ok := shiftTimestamp(string(proto.Header(request, []byte(rule.name))), shift); ok {
+			return proto.SetHeader(request, []byte(rule.name), []byte(value))
+		}
+	case "param":
+		value, _, _ := proto.PathParam(request, []byte(rule.name))
+		if shifted, ok := shiftTimestamp(string(value), shift); ok {
+			return proto.SetPathParam(request, []byte(rule.name), []byte(shifted))
+		}
+	case "json":
+		pos := proto.MIMEHeadersEndPos(request)
+		if pos < 0 || pos >= len(request) {
+			return request
+		}
+		if body, ok := shiftJSON(request[pos:], rule.path, shift); ok {
+			return append(append([]byte{}, request[:pos]...), body...)
+		}
+	case "jwt":
+		header := proto.Header(request, []byte(rule.name))
+		loc := jwtRegexp.FindIndex(header)
+		if loc == nil {
+			return request
+		}
+		token, ok := s.shiftJWT(string(header[loc[0]:loc[1]]), shift)
+		if !ok {
+			return request
+		}
+		value := string(header[:loc[0]]) + token + string(header[loc[1]:])
+		return proto.SetHeader(request, []byte(rule.name), []byte(value))
+	}
+	return request
+}
+
+// shiftTimestamp shifts epoch seconds or milliseconds, RFC3339 and HTTP dates, keeping their format
+func shiftTimestamp(value string, shift time.Duration) (string, bool) {
+	if value == "" {
+		return "", false
+	}
+
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && value[0] != '-' {
+		switch len(value) {
+		case 10:
+			return strconv.FormatInt(n+int64(shift/time.Second), 10), true
+		case 13:
+			return strconv.FormatInt(n+int64(shift/time.Millisecond), 10), true
+		}
+		return "", false
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		layout := time.RFC3339
+		if strings.Contains(value, ".") {
+			layout = time.RFC3339Nano
+		}
+		return t.Add(shift).Format(layout), true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t.Add(shift).UTC().Format(http.TimeFormat), true
+	}
+
+	return "", false
+}
+
+// shiftJSON shifts the string or number at the path of a JSON document, the rest of the document is kept as is
+func shiftJSON(data []byte, path []interface{}, shift time.Duration) ([]byte, bool) {
+	start, end, ok := jsonValueSpan(data, path)
+	if !ok {
+		return data, false
+	}
+
+	raw := data[start:end]
+	var shifted []byte
+	if raw[0] == '"' {
+		var value string
+		if json.Unmarshal(raw, &value) != nil {
+			return data, false
+		}
+		v, ok := shiftTimestamp(value, shift)
+		if !ok {
+			return data, false
+		}
+		shifted, _ = json.Marshal(v)
+	} else {
+		v, ok := shiftTimestamp(string(raw), shift)
+		if !ok {
+			return data, false
+		}
+		shifted = []byte(v)
+	}
+
+	rewritten := make([]byte, 0, len(data)-len(raw)+len(shifted))
+	rewritten = append(rewritten, data[:start]...)
+	rewritten = append(rewritten, shifted...)
+	return append(rewritten, data[end:]...), true
+}
+
+// jsonValueSpan returns the position of the raw scalar value at the path of a JSON document
+func jsonValueSpan(data []byte, path []interface{}) (start, end int, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	for {
+		before := dec.InputOffset()
+		tok, err := dec.Token()
+		if err != nil {
+			return 0, 0, false
+		}
+
+		if len(path) == 0 {
+			if _, isDelim := tok.(json.Delim); isDelim {
+				return 0, 0, false
+			}
+			// the offset before the token includes the separators
+			start = int(before)
+			for start < len(data) && strings.IndexByte(" \t\r\n:,", data[start]) >= 0 {
+				start++
+			}
+			return start, int(dec.InputOffset()), true
+		}
+
+		switch segment := path[0].(type) {
+		case string:
+			if tok != json.Delim('{') {
+				return 0, 0, false
+			}
+			found := false
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return 0, 0, false
+				}
+				if key == segment {
+					found = true
+					break
+				}
+				if skipJSONValue(dec) != nil {
+					return 0, 0, false
+				}
+			}
+			if !found {
+				return 0, 0, false
+			}
+		case int:
+			if tok != json.Delim('[') {
+				return 0, 0, false
+			}
+			for i := 0; i < segment; i++ {
+				if !dec.More() || skipJSONValue(dec) != nil {
+					return 0, 0, false
+				}
+			}
+			if !dec.More() {
+				return 0, 0, false
+			}
+		}
+		path = path[1:]
+	}
+}
+
+func skipJSONValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// shiftJWT shifts the time claims of a JWT, the token is signed again when it uses HMAC and the key
+// is configured, otherwise the original signature is kept
+func (s *TimeShifter) shiftJWT(token string, shift time.Duration) (string, bool) {
+	parts := strings.Split(token, ".")
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return token, false
+	}
+
+	shifted := false
+	for _, claim := range jwtClaims {
+		if c, ok := shiftJSON(claims, []interface{}{claim}, shift); ok {
+			claims, shifted = c, true
+		}
+	}
+	if !shifted {
+		return token, false
+	}
+
+	parts[1] = base64.RawURLEncoding.EncodeToString(claims)
+	if mac := s.jwtHMAC(parts[0]); mac != nil {
+		_, _ = io.WriteString(mac, parts[0]+"."+parts[1])
+		parts[2] = base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return strings.Join(parts, "."), true
+}
+
+// jwtHMAC returns the HMAC of the algorithm in the JWT header, nil without key or for other algorithms
+func (s *TimeShifter) jwtHMAC(header string) hash.Hash {
+	if len(s.jwtKey) == 0 {
+		return nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(header)
+	if err != nil {
+		return nil
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(data, &h) != nil {
+		return nil
+	}
+
+	switch h.Alg {
+	case "HS256":
+		return hmac.New(sha256.New, s.jwtKey)
+	case "HS384":
+		return hmac.New(sha512.New384, s.jwtKey)
+	case "HS512":
+		return hmac.New(sha512.New, s.jwtKey)
+	}
+	return nil
+}
+
+// fixContentLength updates the Content-Length of a rewritten HTTP request whose body changed size
+func fixContentLength(original, rewritten []byte) []byte {
+	if len(rewritten) == len(original) || len(proto.Header(rewritten, []byte("Content-Length"))) == 0 {
+		return rewritten
+	}
+	return proto.SetHeader(rewritten, []byte("Content-Length"), []byte(strconv.Itoa(len(proto.Body(rewritten)))))
+}
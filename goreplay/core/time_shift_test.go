@@ -0,0 +1,89 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShiftTimestamp(t *testing.T) {
+	shift := 48 * time.Hour
+
+	tests := []struct {
+		value, want string
+	}{
+		{"1690000000", "1690172800"},
+		{"1690000000123", "1690172800123"},
+		{"2023-07-22T04:26:40Z", "2023-07-24T04:26:40Z"},
+		{"2023-07-22T12:26:40.5+08:00", "2023-07-24T12:26:40.5+08:00"},
+		{"Sat, 22 Jul 2023 04:26:40 GMT", "Mon, 24 Jul 2023 04:26:40 GMT"},
+	}
+	for _, tt := range tests {
+		got, ok := shiftTimestamp(tt.value, shift)
+		if !ok || got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.value, tt.want, got)
+		}
+	}
+
+	for _, value := range []string{"", "42", "abc", "2023-07-22"} {
+		if _, ok := shiftTimestamp(value, shift); ok {
+			t.Errorf("%s: expected no timestamp", value)
+		}
+	}
+}
+
+func TestTimeShifter(t *testing.T) {
+	s := NewTimeShifter(&settings.TimeShiftConfig{
+		Rules: []string{"param:ts", "json:$.order.created", "json:items[1]"},
+	})
+
+	captured := time.Now().Add(-time.Hour).UnixNano()
+	body := `{"order": {"id": 1, "created": 1690000000}, "items": [1690000000, "2023-07-22T04:26:40Z"]}`
+	request := []byte("POST /orders?ts=1690000000000 HTTP/1.1\r\nContent-Length: 87\r\n\r\n" + body)
+
+	got := string(s.Rewrite(captured, request))
+	want := "POST /orders?ts=1690003600000 HTTP/1.1\r\nContent-Length: 87\r\n\r\n" +
+		`{"order": {"id": 1, "created": 1690003600}, "items": [1690000000, "2023-07-22T05:26:40Z"]}`
+	if got != want {
+		t.Errorf("expected\n%q, got\n%q", want, got)
+	}
+
+	// responses are left as is
+	response := []byte("HTTP/1.1 200 OK\r\n\r\n" + body)
+	if got := s.Rewrite(captured, response); string(got) != string(response) {
+		t.Errorf("expected the response unchanged, got %q", got)
+	}
+}
+
+func TestTimeShifterJWT(t *testing.T) {
+	key := "secret"
+	s := NewTimeShifter(&settings.TimeShiftConfig{Rules: []string{"jwt:Authorization"}, JWTKey: key})
+
+	encode := base64.RawURLEncoding.EncodeToString
+	header := encode([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	token := header + "." + encode([]byte(`{"sub":"u1","exp":1690000000}`)) + ".sig"
+	request := []byte("GET / HTTP/1.1\r\nAuthorization: Bearer " + token + "\r\n\r\n")
+
+	got := s.Rewrite(time.Now().Add(-time.Hour).UnixNano(), request)
+	value := string(proto.Header(got, []byte("Authorization")))
+	if !strings.HasPrefix(value, "Bearer ") {
+		t.Fatalf("unexpected header %q", value)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, "Bearer "), ".")
+	claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if string(claims) != `{"sub":"u1","exp":1690003600}` {
+		t.Errorf("unexpected claims %s", claims)
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if parts[2] != encode(mac.Sum(nil)) {
+		t.Errorf("expected the token signed again")
+	}
+}
@@ -0,0 +1,11 @@
+package input
+
+// Addr returns the address the input listens to
+func (i *HTTPInput) Addr() string {
+	return i.address
+}
+
+// Addr returns the address the input listens to
+func (i *TCPInput) Addr() string {
+	return i.listener.Addr().String()
+}
@@ -203,6 +203,7 @@ type FileInput struct {
 	path        string
 	readers     []*fileInputReader
 	SpeedFactor float64
+	speed       uint64 // math.Float64bits of the factor set by SetSpeedFactor, 0 until it is called
 	loop        bool
 	readDepth   int
 	dryRun      bool
@@ -220,7 +221,7 @@ func NewFileInput(path string, loop bool, readDepth int, maxWait time.Duration,
 	i.SpeedFactor = 1
 	i.loop = loop
 	i.readDepth = readDepth
-	i.stats = expvar.NewMap("file-" + path)
+	i.stats = fileStats(path)
 	i.dryRun = dryRun
 	i.maxWait = maxWait
 
@@ -233,6 +234,20 @@ func NewFileInput(path string, loop bool, readDepth int, maxWait time.Duration,
 	return
 }
 
+var fileStatsMu sync.Mutex
+
+// fileStats returns the stats of a file path. Inputs reading the same path share it,
+// because expvar panics when a name is published twice.
+func fileStats(path string) *expvar.Map {
+	fileStatsMu.Lock()
+	defer fileStatsMu.Unlock()
+
+	if m, ok := expvar.Get("file-" + path).(*expvar.Map); ok {
+		return m
+	}
+	return expvar.NewMap("file-" + path)
+}
+
 func parseS3Url(path string) (bucket, key string) {
 	path = path[5:] // stripping `s3://`
 	sep := strings.IndexByte(path, '/')
@@ -270,6 +285,18 @@ func (i *FileInput) init() (err error) {
 	return nil
 }
 
+// SetSpeedFactor changes the replay speed while the input is running, e.g. by a load profile
+func (i *FileInput) SetSpeedFactor(factor float64) {
+	atomic.StoreUint64(&i.speed, math.Float64bits(factor))
+}
+
+func (i *FileInput) speedFactor() float64 {
+	if bits := atomic.LoadUint64(&i.speed); bits != 0 {
+		return math.Float64frombits(bits)
+	}
+	return i.SpeedFactor
+}
+
 // PluginRead reads message from this plugin
 func (i *FileInput) PluginRead() (*common.Message, error) {
 	var msg common.Message
@@ -349,8 +376,8 @@ func (i *FileInput) emit() {
 				firstWait = diff
 			}
 
-			if i.SpeedFactor != 1 {
-				diff = int64(float64(diff) / i.SpeedFactor)
+			if speed := i.speedFactor(); speed != 1 {
+				diff = int64(float64(diff) / speed)
 			}
 
 			if i.maxWait > 0 && diff > int64(i.maxWait) {
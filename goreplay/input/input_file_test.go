@@ -1,4 +1,4 @@
-package input
+package input_test
 
 import (
 	"bytes"
@@ -7,12 +7,12 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
-	"record-traffic-press/goreplay"
 	"record-traffic-press/goreplay/bootstrap"
+	"record-traffic-press/goreplay/common"
 	"record-traffic-press/goreplay/core"
-	output3 "record-traffic-press/goreplay/output"
-	"record-traffic-press/goreplay/plugins"
-	"record-traffic-press/goreplay/plugins/output"
+	. "record-traffic-press/goreplay/input"
+	"record-traffic-press/goreplay/output"
+	"record-traffic-press/goreplay/proto"
 	"record-traffic-press/goreplay/settings"
 	"sync"
 	"testing"
@@ -22,14 +22,14 @@ import (
 func TestInputFileWithGET(t *testing.T) {
 	input := bootstrap.NewTestInput()
 	rg := NewRequestGenerator([]core.PluginReader{input}, func() { input.EmitGET() }, 1)
-	readPayloads := []*plugins.Message{}
+	readPayloads := []*common.Message{}
 
 	// Given a capture file with a GET request
 	expectedCaptureFile := CreateCaptureFile(rg)
 	defer expectedCaptureFile.TearDown()
 
 	// When the request is read from the capture file
-	err := ReadFromCaptureFile(expectedCaptureFile.file, 1, func(msg *plugins.Message) {
+	err := ReadFromCaptureFile(expectedCaptureFile.file, 1, func(msg *common.Message) {
 		readPayloads = append(readPayloads, msg)
 	})
 
@@ -45,14 +45,14 @@ func TestInputFileWithGET(t *testing.T) {
 func TestInputFileWithPayloadLargerThan64Kb(t *testing.T) {
 	input := bootstrap.NewTestInput()
 	rg := NewRequestGenerator([]core.PluginReader{input}, func() { input.EmitSizedPOST(64 * 1024) }, 1)
-	readPayloads := []*plugins.Message{}
+	readPayloads := []*common.Message{}
 
 	// Given a capture file with a request over 64Kb
 	expectedCaptureFile := CreateCaptureFile(rg)
 	defer expectedCaptureFile.TearDown()
 
 	// When the request is read from the capture file
-	err := ReadFromCaptureFile(expectedCaptureFile.file, 1, func(msg *plugins.Message) {
+	err := ReadFromCaptureFile(expectedCaptureFile.file, 1, func(msg *common.Message) {
 		readPayloads = append(readPayloads, msg)
 	})
 
@@ -73,14 +73,14 @@ func TestInputFileWithGETAndPOST(t *testing.T) {
 		input.EmitGET()
 		input.EmitPOST()
 	}, 2)
-	readPayloads := []*plugins.Message{}
+	readPayloads := []*common.Message{}
 
 	// Given a capture file with a GET request
 	expectedCaptureFile := CreateCaptureFile(rg)
 	defer expectedCaptureFile.TearDown()
 
 	// When the requests are read from the capture file
-	err := ReadFromCaptureFile(expectedCaptureFile.file, 2, func(msg *plugins.Message) {
+	err := ReadFromCaptureFile(expectedCaptureFile.file, 2, func(msg *common.Message) {
 		readPayloads = append(readPayloads, msg)
 	})
 
@@ -99,16 +99,16 @@ func TestInputFileMultipleFilesWithRequestsOnly(t *testing.T) {
 
 	file1, _ := os.OpenFile(fmt.Sprintf("/tmp/%d_0", rnd), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
 	file1.Write([]byte("1 1 1\ntest1"))
-	file1.Write([]byte(goreplay.payloadSeparator))
+	file1.Write([]byte(proto.PayloadSeparator))
 	file1.Write([]byte("1 1 3\ntest2"))
-	file1.Write([]byte(goreplay.payloadSeparator))
+	file1.Write([]byte(proto.PayloadSeparator))
 	file1.Close()
 
 	file2, _ := os.OpenFile(fmt.Sprintf("/tmp/%d_1", rnd), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
 	file2.Write([]byte("1 1 2\ntest3"))
-	file2.Write([]byte(goreplay.payloadSeparator))
+	file2.Write([]byte(proto.PayloadSeparator))
 	file2.Write([]byte("1 1 4\ntest4"))
-	file2.Write([]byte(goreplay.payloadSeparator))
+	file2.Write([]byte(proto.PayloadSeparator))
 	file2.Close()
 
 	input := NewFileInput(fmt.Sprintf("/tmp/%d*", rnd), false, 100, 0, false)
@@ -131,11 +131,11 @@ func TestInputFileRequestsWithLatency(t *testing.T) {
 	defer file.Close()
 
 	file.Write([]byte("1 1 100000000\nrequest1"))
-	file.Write([]byte(goreplay.payloadSeparator))
+	file.Write([]byte(proto.PayloadSeparator))
 	file.Write([]byte("1 2 150000000\nrequest2"))
-	file.Write([]byte(goreplay.payloadSeparator))
+	file.Write([]byte(proto.PayloadSeparator))
 	file.Write([]byte("1 3 250000000\nrequest3"))
-	file.Write([]byte(goreplay.payloadSeparator))
+	file.Write([]byte(proto.PayloadSeparator))
 
 	input := NewFileInput(fmt.Sprintf("/tmp/%d", rnd), false, 100, 0, false)
 
@@ -157,24 +157,24 @@ func TestInputFileMultipleFilesWithRequestsAndResponses(t *testing.T) {
 
 	file1, _ := os.OpenFile(fmt.Sprintf("/tmp/%d_0", rnd), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
 	file1.Write([]byte("1 1 1\nrequest1"))
-	file1.Write([]byte(goreplay.payloadSeparator))
+	file1.Write([]byte(proto.PayloadSeparator))
 	file1.Write([]byte("2 1 1\nresponse1"))
-	file1.Write([]byte(goreplay.payloadSeparator))
+	file1.Write([]byte(proto.PayloadSeparator))
 	file1.Write([]byte("1 2 3\nrequest2"))
-	file1.Write([]byte(goreplay.payloadSeparator))
+	file1.Write([]byte(proto.PayloadSeparator))
 	file1.Write([]byte("2 2 3\nresponse2"))
-	file1.Write([]byte(goreplay.payloadSeparator))
+	file1.Write([]byte(proto.PayloadSeparator))
 	file1.Close()
 
 	file2, _ := os.OpenFile(fmt.Sprintf("/tmp/%d_1", rnd), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
 	file2.Write([]byte("1 3 2\nrequest3"))
-	file2.Write([]byte(goreplay.payloadSeparator))
+	file2.Write([]byte(proto.PayloadSeparator))
 	file2.Write([]byte("2 3 2\nresponse3"))
-	file2.Write([]byte(goreplay.payloadSeparator))
+	file2.Write([]byte(proto.PayloadSeparator))
 	file2.Write([]byte("1 4 4\nrequest4"))
-	file2.Write([]byte(goreplay.payloadSeparator))
+	file2.Write([]byte(proto.PayloadSeparator))
 	file2.Write([]byte("2 4 4\nresponse4"))
-	file2.Write([]byte(goreplay.payloadSeparator))
+	file2.Write([]byte(proto.PayloadSeparator))
 	file2.Close()
 
 	input := NewFileInput(fmt.Sprintf("/tmp/%d*", rnd), false, 100, 0, false)
@@ -200,9 +200,9 @@ func TestInputFileLoop(t *testing.T) {
 
 	file, _ := os.OpenFile(fmt.Sprintf("/tmp/%d", rnd), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
 	file.Write([]byte("1 1 1\ntest1"))
-	file.Write([]byte(goreplay.payloadSeparator))
+	file.Write([]byte(proto.PayloadSeparator))
 	file.Write([]byte("1 1 2\ntest2"))
-	file.Write([]byte(goreplay.payloadSeparator))
+	file.Write([]byte(proto.PayloadSeparator))
 	file.Close()
 
 	input := NewFileInput(fmt.Sprintf("/tmp/%d", rnd), true, 100, 0, false)
@@ -219,19 +219,19 @@ func TestInputFileLoop(t *testing.T) {
 func TestInputFileCompressed(t *testing.T) {
 	rnd := rand.Int63()
 
-	output := output3.NewFileOutput(fmt.Sprintf("/tmp/%d_0.gz", rnd), &output.FileOutputConfig{FlushInterval: time.Minute, Append: true})
+	name1 := fmt.Sprintf("/tmp/%d_0.gz", rnd)
+	out := output.NewFileOutput(name1, &settings.FileOutputConfig{FlushInterval: time.Minute, Append: true})
 	for i := 0; i < 1000; i++ {
-		output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+		out.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	}
-	name1 := output.file.Name()
-	output.Close()
+	out.Close()
 
-	output2 := output.NewFileOutput(fmt.Sprintf("/tmp/%d_1.gz", rnd), &output.FileOutputConfig{FlushInterval: time.Minute, Append: true})
+	name2 := fmt.Sprintf("/tmp/%d_1.gz", rnd)
+	out2 := output.NewFileOutput(name2, &settings.FileOutputConfig{FlushInterval: time.Minute, Append: true})
 	for i := 0; i < 1000; i++ {
-		output2.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+		out2.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	}
-	name2 := output2.file.Name()
-	output2.Close()
+	out2.Close()
 
 	input := NewFileInput(fmt.Sprintf("/tmp/%d*", rnd), false, 100, 0, false)
 	for i := 0; i < 2000; i++ {
@@ -243,11 +243,11 @@ func TestInputFileCompressed(t *testing.T) {
 }
 
 type CaptureFile struct {
-	msgs []*plugins.Message
+	msgs []*common.Message
 	file *os.File
 }
 
-func NewExpectedCaptureFile(msgs []*plugins.Message, file *os.File) *CaptureFile {
+func NewExpectedCaptureFile(msgs []*common.Message, file *os.File) *CaptureFile {
 	ecf := new(CaptureFile)
 	ecf.file = file
 	ecf.msgs = msgs
@@ -275,7 +275,7 @@ func NewRequestGenerator(inputs []core.PluginReader, emit func(), count int) (rg
 	return
 }
 
-func (expectedCaptureFile *CaptureFile) PayloadsEqual(other []*plugins.Message) bool {
+func (expectedCaptureFile *CaptureFile) PayloadsEqual(other []*common.Message) bool {
 
 	if len(expectedCaptureFile.msgs) != len(other) {
 		return false
@@ -300,22 +300,22 @@ func CreateCaptureFile(requestGenerator *RequestGenerator) *CaptureFile {
 		panic(err)
 	}
 
-	readPayloads := []*plugins.Message{}
-	output := bootstrap.NewTestOutput(func(msg *plugins.Message) {
+	readPayloads := []*common.Message{}
+	out := bootstrap.NewTestOutput(func(msg *common.Message) {
 		readPayloads = append(readPayloads, msg)
 		requestGenerator.wg.Done()
 	})
 
-	outputFile := output.NewFileOutput(f.Name(), &output.FileOutputConfig{FlushInterval: time.Second, Append: true})
+	outputFile := output.NewFileOutput(f.Name(), &settings.FileOutputConfig{FlushInterval: time.Second, Append: true})
 
 	plugins := &core.InOutPlugins{
 		Inputs:  requestGenerator.inputs,
-		Outputs: []core.PluginWriter{output, outputFile},
+		Outputs: []core.PluginWriter{out, outputFile},
 	}
 	for _, input := range requestGenerator.inputs {
 		plugins.All = append(plugins.All, input)
 	}
-	plugins.All = append(plugins.All, output, outputFile)
+	plugins.All = append(plugins.All, out, outputFile)
 
 	emitter := bootstrap.NewEmitter()
 	go emitter.Start(plugins, settings.Settings.Middleware)
@@ -330,11 +330,11 @@ func CreateCaptureFile(requestGenerator *RequestGenerator) *CaptureFile {
 
 }
 
-func ReadFromCaptureFile(captureFile *os.File, count int, callback goreplay.writeCallback) (err error) {
+func ReadFromCaptureFile(captureFile *os.File, count int, callback func(*common.Message)) (err error) {
 	wg := new(sync.WaitGroup)
 
 	input := NewFileInput(captureFile.Name(), false, 100, 0, false)
-	output := bootstrap.NewTestOutput(func(msg *plugins.Message) {
+	output := bootstrap.NewTestOutput(func(msg *common.Message) {
 		callback(msg)
 		wg.Done()
 	})
@@ -1,11 +1,12 @@
-package input
+package input_test
 
 import (
 	"bytes"
 	"net/http"
 	"record-traffic-press/goreplay/bootstrap"
+	"record-traffic-press/goreplay/common"
 	"record-traffic-press/goreplay/core"
-	"record-traffic-press/goreplay/plugins"
+	. "record-traffic-press/goreplay/input"
 	"record-traffic-press/goreplay/settings"
 	"strings"
 	"sync"
@@ -18,7 +19,7 @@ func TestHTTPInput(t *testing.T) {
 
 	input := NewHTTPInput("127.0.0.1:0")
 	time.Sleep(time.Millisecond)
-	output := bootstrap.NewTestOutput(func(*plugins.Message) {
+	output := bootstrap.NewTestOutput(func(*common.Message) {
 		wg.Done()
 	})
 
@@ -31,7 +32,7 @@ func TestHTTPInput(t *testing.T) {
 	emitter := bootstrap.NewEmitter()
 	go emitter.Start(plugins, settings.Settings.Middleware)
 
-	address := strings.Replace(input.address, "[::]", "127.0.0.1", -1)
+	address := strings.Replace(input.Addr(), "[::]", "127.0.0.1", -1)
 
 	for i := 0; i < 100; i++ {
 		wg.Add(1)
@@ -49,7 +50,7 @@ func TestInputHTTPLargePayload(t *testing.T) {
 	large[n-1] = '0'
 
 	input := NewHTTPInput("127.0.0.1:0")
-	output := bootstrap.NewTestOutput(func(msg *plugins.Message) {
+	output := bootstrap.NewTestOutput(func(msg *common.Message) {
 		_len := len(msg.Data)
 		if _len >= n { // considering http body CRLF
 			t.Errorf("expected body to be >= %d", n)
@@ -66,7 +67,7 @@ func TestInputHTTPLargePayload(t *testing.T) {
 	defer emitter.Close()
 	go emitter.Start(plugins, settings.Settings.Middleware)
 
-	address := strings.Replace(input.address, "[::]", "127.0.0.1", -1)
+	address := strings.Replace(input.Addr(), "[::]", "127.0.0.1", -1)
 	var req *http.Request
 	var err error
 	req, err = http.NewRequest("POST", "http://"+address, bytes.NewBuffer(large[:]))
@@ -0,0 +1,228 @@
+package input
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/glogs"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// KafkaInput is used for receiving Kafka messages and
+// transforming them into HTTP payloads.
+type KafkaInput struct {
+	config      *settings.InputKafkaConfig
+	offset      string
+	consumer    sarama.Consumer
+	consumers   []sarama.PartitionConsumer
+	messages    chan *sarama.ConsumerMessage
+	SpeedFactor float64
+	speed       uint64 // math.Float64bits of the factor set by SetSpeedFactor, 0 until it is called
+	quit        chan struct{}
+	kafkaTimer  *kafkaTimer
+}
+
+// getOffsetOfPartitions maps offset option to sarama offsets, "-1" is the newest one and "-2" the oldest one
+func getOffsetOfPartitions(offsetCfg string) (int64, error) {
+	offset, err := strconv.ParseInt(offsetCfg, 10, 64)
+	if err != nil || offset < -2 {
+		return 0, fmt.Errorf("invalid Kafka offset %q, expected -2, -1 or a positive offset", offsetCfg)
+	}
+	return offset, nil
+}
+
+// NewKafkaInput creates instance of kafka consumer client with TLS config
+func NewKafkaInput(offsetCfg string, config *settings.InputKafkaConfig, tlsConfig *settings.KafkaTLSConfig) (*KafkaInput, error) {
+	if offsetCfg == "" {
+		offsetCfg = "-1"
+	}
+	offset, err := getOffsetOfPartitions(offsetCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	con := config.Consumer
+	if con == nil {
+		kafkaConfig, err := settings.NewKafkaConfig(&config.SASLConfig, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		con, err = sarama.NewConsumer(strings.Split(config.Host, ","), kafkaConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start Sarama(Kafka) consumer: %w", err)
+		}
+	}
+
+	partitions, err := con.Partitions(config.Topic)
+	if err != nil {
+		con.Close()
+		return nil, fmt.Errorf("failed to collect Sarama(Kafka) partitions: %w", err)
+	}
+
+	i := &KafkaInput{
+		config:      config,
+		offset:      offsetCfg,
+		consumer:    con,
+		consumers:   make([]sarama.PartitionConsumer, len(partitions)),
+		messages:    make(chan *sarama.ConsumerMessage, 256),
+		SpeedFactor: 1,
+		quit:        make(chan struct{}),
+		kafkaTimer:  new(kafkaTimer),
+	}
+
+	for index, partition := range partitions {
+		consumer, err := con.ConsumePartition(config.Topic, partition, offset)
+		if err != nil {
+			i.Close()
+			return nil, fmt.Errorf("failed to start Sarama(Kafka) partition consumer: %w", err)
+		}
+
+		go i.consume(consumer)
+		go i.ErrorHandler(consumer)
+
+		i.consumers[index] = consumer
+	}
+
+	return i, nil
+}
+
+func (i *KafkaInput) consume(consumer sarama.PartitionConsumer) {
+	for message := range consumer.Messages() {
+		select {
+		case <-i.quit:
+			return
+		case i.messages <- message:
+		}
+	}
+}
+
+// ErrorHandler should receive errors
+func (i *KafkaInput) ErrorHandler(consumer sarama.PartitionConsumer) {
+	for err := range consumer.Errors() {
+		glogs.Debug(1, "Failed to read access log entry:", err)
+	}
+}
+
+// PluginRead a reads message from this plugin, the records which can't be decoded are skipped
+func (i *KafkaInput) PluginRead() (*common.Message, error) {
+	var msg common.Message
+	inputTs := ""
+
+	for {
+		var message *sarama.ConsumerMessage
+		select {
+		case <-i.quit:
+			return nil, common.ErrorStopped
+		case message = <-i.messages:
+		}
+
+		msg.Data = message.Value
+		if !i.config.UseJSON {
+			break
+		}
+
+		var kafkaMessage proto.KafkaMessage
+		if err := json.Unmarshal(message.Value, &kafkaMessage); err != nil {
+			glogs.Debug(1, "[INPUT-KAFKA] failed to decode access log entry, skipping it:", err)
+			continue
+		}
+
+		inputTs = kafkaMessage.ReqTs
+		msg.Data, _ = kafkaMessage.Dump()
+		break
+	}
+
+	// does it have meta
+	if len(msg.Data) > 0 && proto.IsOriginPayload(msg.Data) {
+		msg.Meta, msg.Data = proto.PayloadMetaWithBody(msg.Data)
+		if meta := proto.PayloadMeta(msg.Meta); len(meta) > 2 {
+			inputTs = string(meta[2])
+		}
+	}
+
+	i.timeWait(inputTs)
+
+	return &msg, nil
+}
+
+// SetSpeedFactor changes the replay speed while the input is running, e.g. by a load profile
+func (i *KafkaInput) SetSpeedFactor(factor float64) {
+	atomic.StoreUint64(&i.speed, math.Float64bits(factor))
+}
+
+func (i *KafkaInput) speedFactor() float64 {
+	if bits := atomic.LoadUint64(&i.speed); bits != 0 {
+		return math.Float64frombits(bits)
+	}
+	return i.SpeedFactor
+}
+
+func (i *KafkaInput) String() string {
+	return "Kafka Input: " + i.config.Host + "/" + i.config.Topic
+}
+
+// Close closes this plugin
+func (i *KafkaInput) Close() error {
+	close(i.quit)
+
+	for _, consumer := range i.consumers {
+		consumer.AsyncClose()
+	}
+
+	// the injected consumer is owned by the caller
+	if i.config.Consumer == nil {
+		return i.consumer.Close()
+	}
+	return nil
+}
+
+// timeWait keeps the original intervals between messages, scaled by SpeedFactor.
+// Consuming from the newest offset is live traffic and is never delayed.
+func (i *KafkaInput) timeWait(curInputTs string) {
+	if i.offset == "-1" || curInputTs == "" {
+		return
+	}
+
+	curInput, err := strconv.ParseInt(curInputTs, 10, 64)
+	if err != nil {
+		glogs.Debug(1, fmt.Sprintf("[INPUT-KAFKA] failed to parse timestamp %q: %v", curInputTs, err))
+		return
+	}
+
+	timer := i.kafkaTimer
+	curTs := time.Now().UnixNano()
+
+	if timer.latestInputTs == 0 || timer.latestOutputTs == 0 {
+		timer.latestInputTs = curInput
+		timer.latestOutputTs = curTs
+		return
+	}
+
+	diffTs := curInput - timer.latestInputTs
+	pastTs := curTs - timer.latestOutputTs
+
+	diff := diffTs - pastTs
+	if speed := i.speedFactor(); speed != 1 {
+		diff = int64(float64(diff) / speed)
+	}
+
+	if diff > 0 {
+		time.Sleep(time.Duration(diff))
+	}
+
+	timer.latestInputTs = curInput
+	timer.latestOutputTs = curTs
+}
+
+type kafkaTimer struct {
+	latestInputTs  int64
+	latestOutputTs int64
+}
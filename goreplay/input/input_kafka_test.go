@@ -1,7 +1,7 @@
 package input
 
 import (
-	"record-traffic-press/goreplay/bootstrap"
+	"record-traffic-press/goreplay/settings"
 	"testing"
 
 	"github.com/Shopify/sarama"
@@ -17,11 +17,14 @@ func TestInputKafkaRAW(t *testing.T) {
 		map[string][]int32{"test": {0}},
 	)
 
-	input := NewKafkaInput("-1", &bootstrap.InputKafkaConfig{
-		consumer: consumer,
+	input, err := NewKafkaInput("-1", &settings.InputKafkaConfig{
+		Consumer: consumer,
 		Topic:    "test",
 		UseJSON:  false,
 	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	msg, err := input.PluginRead()
 
@@ -43,11 +46,14 @@ func TestInputKafkaJSON(t *testing.T) {
 		map[string][]int32{"test": {0}},
 	)
 
-	input := NewKafkaInput("-1", &bootstrap.InputKafkaConfig{
-		consumer: consumer,
+	input, err := NewKafkaInput("-1", &settings.InputKafkaConfig{
+		Consumer: consumer,
 		Topic:    "test",
 		UseJSON:  true,
 	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	msg, err := input.PluginRead()
 
@@ -59,3 +65,46 @@ func TestInputKafkaJSON(t *testing.T) {
 		t.Error("Message not properly decoded")
 	}
 }
+
+func TestInputKafkaJSONMalformed(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	defer consumer.Close()
+
+	consumer.ExpectConsumePartition("test", 0, mocks.AnyOffset).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte(`{"Req_URL":`)}).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte(`{"Req_URL":"/","Req_Type":"1","Req_ID":"2","Req_Ts":"3","Req_Method":"GET","Req_Headers":{"Header":"1"}}`)})
+	consumer.SetTopicMetadata(
+		map[string][]int32{"test": {0}},
+	)
+
+	input, err := NewKafkaInput("-1", &settings.InputKafkaConfig{
+		Consumer: consumer,
+		Topic:    "test",
+		UseJSON:  true,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the malformed record is skipped, the input keeps reading
+	msg, err := input.PluginRead()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(append(msg.Meta, msg.Data...)) != "1 2 3\nGET / HTTP/1.1\r\nHeader: 1\r\n\r\n" {
+		t.Error("Message not properly decoded")
+	}
+}
+
+func TestInputKafkaInvalidOffset(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	defer consumer.Close()
+
+	if _, err := NewKafkaInput("-3", &settings.InputKafkaConfig{
+		Consumer: consumer,
+		Topic:    "test",
+	}, nil); err == nil {
+		t.Error("Should fail on an invalid offset")
+	}
+}
@@ -14,8 +14,46 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+// connTTL forgets the state of the HTTP/2, redis and MySQL connections idle for this long
+const connTTL = 10 * time.Minute
+
+// http2Conn is one direction of a captured HTTP/2 connection
+type http2Conn struct {
+	demuxer *proto.HTTP2Demuxer
+	seen    time.Time
+}
+
+// redisConn is one direction of a captured redis connection, its commands and replies are paired by order
+type redisConn struct {
+	seq  uint64
+	seen time.Time
+}
+
+// mysqlMaxPending caps the commands waiting for their response, e.g. when only requests are captured
+const mysqlMaxPending = 1000
+
+// mysqlConn is a captured MySQL connection, the responses to its COM_STMT_PREPARE are needed to
+// decode its COM_STMT_EXECUTE
+type mysqlConn struct {
+	requests  []byte // packets of a command not complete yet
+	responses []byte // packets of a response not complete yet, e.g. a large result set
+	respStart time.Time
+	pending   []mysqlCommand // commands waiting for their response, in order
+	stmts     map[uint32]*proto.MySQLStmt
+	seq       uint64
+	seen      time.Time
+}
+
+// mysqlCommand is a command waiting for its response
+type mysqlCommand struct {
+	command byte
+	id      []byte // nil when the query is not emitted
+	sql     string // COM_STMT_PREPARE only
+}
+
 // RAWInput used for intercepting traffic for given address
 type RAWInput struct {
 	sync.Mutex
@@ -26,9 +64,17 @@ type RAWInput struct {
 	cancelListener context.CancelFunc
 	closed         bool
 
-	quit  chan bool // Channel used only to indicate goroutine should shutdown
-	host  string
-	ports []uint16
+	pending []*common.Message // dubbo frames, HTTP/2 streams, redis commands or MySQL queries captured in one tcp message but not read yet
+
+	http2Conns map[string]*http2Conn // keyed by connection and direction
+	redisConns map[string]*redisConn // keyed by connection and direction
+	mysqlConns map[string]*mysqlConn // keyed by connection
+	connsSwept time.Time
+
+	quit    chan bool // Channel used only to indicate goroutine should shutdown
+	address string
+	host    string
+	ports   []uint16
 }
 
 // NewRAWInput constructor for RAWInput. Accepts raw input config as arguments.
@@ -36,6 +82,9 @@ func NewRAWInput(address string, config settings.RAWInputConfig) (i *RAWInput) {
 	i = new(RAWInput)
 	i.config = config
 	i.quit = make(chan bool)
+	i.http2Conns = make(map[string]*http2Conn)
+	i.redisConns = make(map[string]*redisConn)
+	i.mysqlConns = make(map[string]*mysqlConn)
 
 	host, _ports, err := net.SplitHostPort(address)
 	if err != nil {
@@ -71,6 +120,7 @@ func NewRAWInput(address string, config settings.RAWInputConfig) (i *RAWInput) {
 		}
 	}
 
+	i.address = address
 	i.host = host
 	i.ports = ports
 
@@ -81,6 +131,12 @@ func NewRAWInput(address string, config settings.RAWInputConfig) (i *RAWInput) {
 
 // PluginRead reads meassage from this plugin
 func (i *RAWInput) PluginRead() (*common.Message, error) {
+	if len(i.pending) > 0 {
+		msg := i.pending[0]
+		i.pending = i.pending[1:]
+		return msg, nil
+	}
+
 	var msgTCP *tcp.TcpMessage
 	var msg common.Message
 	select {
@@ -88,17 +144,41 @@ func (i *RAWInput) PluginRead() (*common.Message, error) {
 		return nil, common.ErrorStopped
 	case msgTCP = <-i.listener.Messages():
 		msg.Data = msgTCP.Data()
+		if i.config.KeepPackets {
+			msg.Capture = msgTCP.Packets()
+		}
 	}
 
+	// the messages of the other protocols are rebuilt from the tcp messages
+	rebuilt := i.config.Protocol != tcp.ProtocolHTTP && i.config.Protocol != tcp.ProtocolBinary
+
 	var msgType byte = proto.ResponsePayload
 	if msgTCP.Direction == tcp.DirIncoming {
 		msgType = proto.RequestPayload
-		if i.config.RealIPHeader != "" {
+		if i.config.RealIPHeader != "" && !rebuilt {
 			msg.Data = proto.SetHeader(msg.Data, []byte(i.config.RealIPHeader), []byte(msgTCP.SrcAddr))
 		}
 	}
 	msg.Meta = proto.PayloadHeader(msgType, msgTCP.UUID(), msgTCP.Start.UnixNano(), msgTCP.End.UnixNano()-msgTCP.Start.UnixNano())
 
+	if rebuilt {
+		switch i.config.Protocol {
+		case tcp.ProtocolDubbo:
+			i.pending = dubboMessages(msgTCP, msgType, msg.Data)
+		case tcp.ProtocolHTTP2:
+			i.pending = i.http2Messages(msgTCP, msgType, msg.Data)
+		case tcp.ProtocolRedis:
+			i.pending = i.redisMessages(msgTCP, msgType, msg.Data)
+		case tcp.ProtocolMySQL:
+			i.pending = i.mysqlMessages(msgTCP, msgType, msg.Data)
+		}
+		msg.Meta, msg.Data, msg.Capture = nil, nil, nil
+		if len(i.pending) > 0 {
+			msg = *i.pending[0]
+			i.pending = i.pending[1:]
+		}
+	}
+
 	// to be removed....
 	if msgTCP.Truncated {
 		glogs.Debug(2, "[INPUT-RAW] message truncated, increase copy-buffer-size")
@@ -142,6 +222,16 @@ func (i *RAWInput) String() string {
 	return fmt.Sprintf("Intercepting traffic from: %s:%s", i.host, strings.Join(strings.Fields(fmt.Sprint(i.ports)), ","))
 }
 
+// Address returns the address the input was created with
+func (i *RAWInput) Address() string {
+	return i.address
+}
+
+// BPFFilters returns the effective BPF filter of every capture handle
+func (i *RAWInput) BPFFilters() map[string]string {
+	return i.listener.Filters()
+}
+
 // GetStats returns the stats so far and reset the stats
 func (i *RAWInput) GetStats() []tcp.Stats {
 	i.Lock()
@@ -165,6 +255,236 @@ func (i *RAWInput) Close() error {
 	return nil
 }
 
+// dubboMessages splits a tcp message into its dubbo frames, several pipelined requests may share one tcp message.
+// Each request and its response are paired by the dubbo request ID, events like heartbeats are dropped.
+func dubboMessages(msgTCP *tcp.TcpMessage, msgType byte, data []byte) (messages []*common.Message) {
+	frames, _ := proto.DubboFrames(data)
+	for _, frame := range frames {
+		header, _ := proto.ParseDubboHeader(frame)
+		if header.IsEvent() {
+			continue
+		}
+
+		messages = append(messages, &common.Message{
+			Meta: proto.PayloadHeader(msgType, msgTCP.RequestUUID(uint64(header.RequestID)), msgTCP.Start.UnixNano(), msgTCP.End.UnixNano()-msgTCP.Start.UnixNano()),
+			Data: frame,
+		})
+	}
+	return
+}
+
+// http2Messages feeds a tcp message to the HTTP/2 demuxer of its connection and direction, and returns the
+// requests or responses of the streams it ends. Each request and its response are paired by the stream ID.
+func (i *RAWInput) http2Messages(msgTCP *tcp.TcpMessage, msgType byte, data []byte) (messages []*common.Message) {
+	now := time.Now()
+	i.sweepConns(now)
+
+	key := string(msgTCP.UUID()[:16]) + string(msgType)
+	c, ok := i.http2Conns[key]
+	if !ok {
+		c = &http2Conn{demuxer: proto.NewHTTP2Demuxer(msgType == proto.RequestPayload)}
+		i.http2Conns[key] = c
+	}
+	c.seen = now
+
+	streams, err := c.demuxer.Feed(data)
+	if err != nil {
+		// e.g. the capture started after the connection, the header blocks refer to an unknown HPACK state
+		glogs.Debug(2, "[INPUT-RAW] http2:", err)
+		c.demuxer = proto.NewHTTP2Demuxer(msgType == proto.RequestPayload)
+	}
+
+	for _, s := range streams {
+		payload := s.Payload
+		if msgType == proto.RequestPayload && i.config.RealIPHeader != "" {
+			payload = proto.SetHeader(payload, []byte(i.config.RealIPHeader), []byte(msgTCP.SrcAddr))
+		}
+		messages = append(messages, &common.Message{
+			Meta: proto.PayloadHeader(msgType, msgTCP.RequestUUID(uint64(s.StreamID)), msgTCP.Start.UnixNano(), msgTCP.End.UnixNano()-msgTCP.Start.UnixNano()),
+			Data: payload,
+		})
+	}
+	return
+}
+
+// redisMessages splits a tcp message into its RESP values, a client may pipeline several commands in one tcp
+// message. Each command and its reply are paired by their order on the connection, RESP3 pushes are dropped
+// as they don't answer any command.
+func (i *RAWInput) redisMessages(msgTCP *tcp.TcpMessage, msgType byte, data []byte) (messages []*common.Message) {
+	now := time.Now()
+	i.sweepConns(now)
+
+	key := string(msgTCP.UUID()[:16]) + string(msgType)
+	c, ok := i.redisConns[key]
+	if !ok {
+		c = &redisConn{}
+		i.redisConns[key] = c
+	}
+	c.seen = now
+
+	frames, _ := proto.RedisFrames(data)
+	for _, frame := range frames {
+		if frame[0] == proto.RedisPush {
+			continue
+		}
+		c.seq++
+		messages = append(messages, &common.Message{
+			Meta: proto.PayloadHeader(msgType, msgTCP.RequestUUID(c.seq), msgTCP.Start.UnixNano(), msgTCP.End.UnixNano()-msgTCP.Start.UnixNano()),
+			Data: frame,
+		})
+	}
+	return
+}
+
+// mysqlMessages feeds a tcp message to the state of its MySQL connection and returns the queries, COM_QUERY
+// and COM_STMT_EXECUTE, or the results it completes. Each query and its result are paired by their order.
+func (i *RAWInput) mysqlMessages(msgTCP *tcp.TcpMessage, msgType byte, data []byte) []*common.Message {
+	now := time.Now()
+	i.sweepConns(now)
+
+	key := string(msgTCP.UUID()[:16])
+	c, ok := i.mysqlConns[key]
+	if !ok {
+		c = &mysqlConn{stmts: make(map[uint32]*proto.MySQLStmt)}
+		i.mysqlConns[key] = c
+	}
+	c.seen = now
+
+	if msgType == proto.RequestPayload {
+		return c.queries(msgTCP, data)
+	}
+	return c.results(msgTCP, data)
+}
+
+func (c *mysqlConn) queries(msgTCP *tcp.TcpMessage, data []byte) (messages []*common.Message) {
+	c.requests = append(c.requests, data...)
+	payloads, n := proto.MySQLPayloads(c.requests)
+	c.requests = append([]byte(nil), c.requests[n:]...)
+
+	for _, p := range payloads {
+		if p.Seq != 0 || len(p.Data) == 0 {
+			// handshake and authentication
+			continue
+		}
+
+		cmd := mysqlCommand{command: p.Data[0]}
+		var q *proto.MySQLQuery
+		switch cmd.command {
+		case proto.MySQLComQuery:
+			q = &proto.MySQLQuery{Command: "query", SQL: string(p.Data[1:])}
+		case proto.MySQLComStmtPrepare:
+			cmd.sql = string(p.Data[1:])
+		case proto.MySQLComStmtExecute:
+			stmt := c.stmts[proto.MySQLStmtID(p.Data)]
+			if stmt == nil {
+				// prepared before the capture started
+				glogs.Debug(2, "[INPUT-RAW] mysql: unknown statement", proto.MySQLStmtID(p.Data))
+				break
+			}
+			args, err := stmt.ExecuteArgs(p.Data)
+			if err != nil {
+				glogs.Debug(2, "[INPUT-RAW] mysql:", err)
+				break
+			}
+			q = &proto.MySQLQuery{Command: "execute", SQL: stmt.SQL, Args: args}
+		case proto.MySQLComStmtClose:
+			delete(c.stmts, proto.MySQLStmtID(p.Data))
+			continue
+		case proto.MySQLComQuit, proto.MySQLComStmtSendLongData:
+			// not answered
+			continue
+		}
+
+		if q != nil {
+			c.seq++
+			cmd.id = msgTCP.RequestUUID(c.seq)
+			messages = append(messages, &common.Message{
+				Meta: proto.PayloadHeader(proto.RequestPayload, cmd.id, msgTCP.Start.UnixNano(), msgTCP.End.UnixNano()-msgTCP.Start.UnixNano()),
+				Data: proto.EncodeMySQLQuery(q),
+			})
+		}
+		c.pending = append(c.pending, cmd)
+		if len(c.pending) > mysqlMaxPending {
+			c.pending = c.pending[1:]
+		}
+	}
+	return
+}
+
+func (c *mysqlConn) results(msgTCP *tcp.TcpMessage, data []byte) (messages []*common.Message) {
+	if len(c.pending) == 0 {
+		// server greeting and authentication
+		c.responses = nil
+		return
+	}
+	if len(c.responses) == 0 {
+		c.respStart = msgTCP.Start
+	}
+	c.responses = append(c.responses, data...)
+
+	for len(c.pending) > 0 {
+		cmd := c.pending[0]
+		var result *proto.MySQLResult
+		var n int
+		var err error
+		if cmd.command == proto.MySQLComStmtPrepare {
+			var stmt *proto.MySQLStmt
+			stmt, result, n, err = proto.ParseMySQLPrepareResponse(c.responses)
+			if stmt != nil {
+				stmt.SQL = cmd.sql
+				c.stmts[stmt.ID] = stmt
+			}
+		} else {
+			result, n, err = proto.ParseMySQLResponse(c.responses, cmd.command == proto.MySQLComStmtExecute)
+		}
+		if err == proto.ErrMySQLIncomplete {
+			return
+		}
+		if err != nil {
+			// e.g. the capture started in the middle of a result set
+			glogs.Debug(2, "[INPUT-RAW] mysql:", err)
+			c.responses, c.pending = nil, nil
+			return
+		}
+
+		c.pending = c.pending[1:]
+		c.responses = c.responses[n:]
+		if cmd.id != nil {
+			messages = append(messages, &common.Message{
+				Meta: proto.PayloadHeader(proto.ResponsePayload, cmd.id, c.respStart.UnixNano(), msgTCP.End.UnixNano()-c.respStart.UnixNano()),
+				Data: proto.EncodeMySQLResult(result),
+			})
+		}
+		c.respStart = msgTCP.Start
+	}
+	c.responses = nil
+	return
+}
+
+// sweepConns forgets the idle HTTP/2, redis and MySQL connections
+func (i *RAWInput) sweepConns(now time.Time) {
+	if now.Sub(i.connsSwept) < time.Minute {
+		return
+	}
+	i.connsSwept = now
+
+	for key, c := range i.http2Conns {
+		if now.Sub(c.seen) >= connTTL {
+			delete(i.http2Conns, key)
+		}
+	}
+	for key, c := range i.redisConns {
+		if now.Sub(c.seen) >= connTTL {
+			delete(i.redisConns, key)
+		}
+	}
+	for key, c := range i.mysqlConns {
+		if now.Sub(c.seen) >= connTTL {
+			delete(i.mysqlConns, key)
+		}
+	}
+}
+
 func (i *RAWInput) addStats(mStats tcp.Stats) {
 	i.Lock()
 	if len(i.messageStats) >= 10000 {
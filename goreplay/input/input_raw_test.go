@@ -1,4 +1,4 @@
-package input
+package input_test
 
 import (
 	"bytes"
@@ -9,12 +9,12 @@ import (
 	"net/http/httputil"
 	"os/exec"
 	"record-traffic-press/goreplay/bootstrap"
+	"record-traffic-press/goreplay/common"
 	"record-traffic-press/goreplay/core"
 	"record-traffic-press/goreplay/core/capture"
 	"record-traffic-press/goreplay/core/tcp"
-	output2 "record-traffic-press/goreplay/output"
-	"record-traffic-press/goreplay/plugins"
-	"record-traffic-press/goreplay/plugins/output"
+	. "record-traffic-press/goreplay/input"
+	"record-traffic-press/goreplay/output"
 	"record-traffic-press/goreplay/proto"
 	"record-traffic-press/goreplay/settings"
 	"strings"
@@ -46,7 +46,7 @@ func TestRAWInputIPv4(t *testing.T) {
 	_, port, _ := net.SplitHostPort(listener.Addr().String())
 
 	var respCounter, reqCounter int64
-	conf := RAWInputConfig{
+	conf := settings.RAWInputConfig{
 		Engine:        capture.EnginePcap,
 		Expire:        0,
 		Protocol:      tcp.ProtocolHTTP,
@@ -55,7 +55,7 @@ func TestRAWInputIPv4(t *testing.T) {
 	}
 	input := NewRAWInput(listener.Addr().String(), conf)
 
-	output := bootstrap.NewTestOutput(func(msg *plugins.Message) {
+	output := bootstrap.NewTestOutput(func(msg *common.Message) {
 		if msg.Meta[0] == '1' {
 			if len(proto.Header(msg.Data, []byte("X-Real-IP"))) == 0 {
 				t.Error("Should have X-Real-IP header")
@@ -116,7 +116,7 @@ func TestRAWInputNoKeepAlive(t *testing.T) {
 	defer listener.Close()
 	_, port, _ := net.SplitHostPort(listener.Addr().String())
 
-	conf := RAWInputConfig{
+	conf := settings.RAWInputConfig{
 		Engine:        capture.EnginePcap,
 		Expire:        testRawExpire,
 		Protocol:      tcp.ProtocolHTTP,
@@ -124,7 +124,7 @@ func TestRAWInputNoKeepAlive(t *testing.T) {
 	}
 	input := NewRAWInput(":"+port, conf)
 	var respCounter, reqCounter int64
-	output := bootstrap.NewTestOutput(func(msg *plugins.Message) {
+	output := bootstrap.NewTestOutput(func(msg *common.Message) {
 		if msg.Meta[0] == '1' {
 			atomic.AddInt64(&reqCounter, 1)
 			wg.Done()
@@ -183,14 +183,14 @@ func TestRAWInputIPv6(t *testing.T) {
 	originAddr := "[::1]:" + port
 
 	var respCounter, reqCounter int64
-	conf := RAWInputConfig{
+	conf := settings.RAWInputConfig{
 		Engine:        capture.EnginePcap,
 		Protocol:      tcp.ProtocolHTTP,
 		TrackResponse: true,
 	}
 	input := NewRAWInput(originAddr, conf)
 
-	output := bootstrap.NewTestOutput(func(msg *plugins.Message) {
+	output := bootstrap.NewTestOutput(func(msg *common.Message) {
 		if msg.Meta[0] == '1' {
 			atomic.AddInt64(&reqCounter, 1)
 		} else {
@@ -239,7 +239,7 @@ func TestInputRAWChunkedEncoding(t *testing.T) {
 	}))
 
 	originAddr := strings.Replace(origin.Listener.Addr().String(), "[::]", "127.0.0.1", -1)
-	conf := RAWInputConfig{
+	conf := settings.RAWInputConfig{
 		Engine:          capture.EnginePcap,
 		Expire:          time.Second,
 		Protocol:        tcp.ProtocolHTTP,
@@ -261,7 +261,7 @@ func TestInputRAWChunkedEncoding(t *testing.T) {
 	}))
 	defer replay.Close()
 
-	httpOutput := output2.NewHTTPOutput(replay.URL, &output.HTTPOutputConfig{})
+	httpOutput := output.NewHTTPOutput(replay.URL, &settings.HTTPOutputConfig{})
 
 	plugins := &core.InOutPlugins{
 		Inputs:  []core.PluginReader{input},
@@ -319,7 +319,7 @@ func BenchmarkRAWInputWithReplay(b *testing.B) {
 	defer replay.Close()
 	replayAddr := listener0.Addr().String()
 
-	conf := RAWInputConfig{
+	conf := settings.RAWInputConfig{
 		Engine:        capture.EnginePcap,
 		Expire:        testRawExpire,
 		Protocol:      tcp.ProtocolHTTP,
@@ -327,7 +327,7 @@ func BenchmarkRAWInputWithReplay(b *testing.B) {
 	}
 	input := NewRAWInput(originAddr, conf)
 
-	testOutput := bootstrap.NewTestOutput(func(msg *plugins.Message) {
+	testOutput := bootstrap.NewTestOutput(func(msg *common.Message) {
 		if msg.Meta[0] == '1' {
 			reqCounter++
 		} else {
@@ -335,7 +335,7 @@ func BenchmarkRAWInputWithReplay(b *testing.B) {
 		}
 		wg.Done()
 	})
-	httpOutput := output2.NewHTTPOutput("http://"+replayAddr, &output.HTTPOutputConfig{})
+	httpOutput := output.NewHTTPOutput("http://"+replayAddr, &settings.HTTPOutputConfig{})
 
 	plugins := &core.InOutPlugins{
 		Inputs:  []core.PluginReader{input},
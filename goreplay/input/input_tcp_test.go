@@ -1,4 +1,4 @@
-package input
+package input_test
 
 import (
 	"bytes"
@@ -12,10 +12,11 @@ import (
 	"math/big"
 	"net"
 	"os"
-	"record-traffic-press/goreplay"
 	"record-traffic-press/goreplay/bootstrap"
+	"record-traffic-press/goreplay/common"
 	"record-traffic-press/goreplay/core"
-	"record-traffic-press/goreplay/plugins"
+	. "record-traffic-press/goreplay/input"
+	"record-traffic-press/goreplay/proto"
 	"record-traffic-press/goreplay/settings"
 	"sync"
 	"testing"
@@ -25,8 +26,8 @@ import (
 func TestTCPInput(t *testing.T) {
 	wg := new(sync.WaitGroup)
 
-	input := NewTCPInput("127.0.0.1:0", &TCPInputConfig{})
-	output := bootstrap.NewTestOutput(func(*plugins.Message) {
+	input := NewTCPInput("127.0.0.1:0", &settings.TCPInputConfig{})
+	output := bootstrap.NewTestOutput(func(*common.Message) {
 		wg.Done()
 	})
 
@@ -39,7 +40,7 @@ func TestTCPInput(t *testing.T) {
 	emitter := bootstrap.NewEmitter()
 	go emitter.Start(plugins, settings.Settings.Middleware)
 
-	tcpAddr, err := net.ResolveTCPAddr("tcp", input.listener.Addr().String())
+	tcpAddr, err := net.ResolveTCPAddr("tcp", input.Addr())
 
 	if err != nil {
 		log.Fatal(err)
@@ -55,7 +56,7 @@ func TestTCPInput(t *testing.T) {
 	for i := 0; i < 100; i++ {
 		wg.Add(1)
 		if _, err = conn.Write(msg); err == nil {
-			_, err = conn.Write(payloadSeparatorAsBytes)
+			_, err = conn.Write(PayloadSeparatorAsBytes)
 		}
 		if err != nil {
 			t.Error(err)
@@ -106,12 +107,12 @@ func TestTCPInputSecure(t *testing.T) {
 
 	wg := new(sync.WaitGroup)
 
-	input := NewTCPInput("127.0.0.1:0", &TCPInputConfig{
+	input := NewTCPInput("127.0.0.1:0", &settings.TCPInputConfig{
 		Secure:          true,
 		CertificatePath: serverCertPemFile.Name(),
 		KeyPath:         serverPrivPemFile.Name(),
 	})
-	output := bootstrap.NewTestOutput(func(*plugins.Message) {
+	output := bootstrap.NewTestOutput(func(*common.Message) {
 		wg.Done()
 	})
 
@@ -128,7 +129,7 @@ func TestTCPInputSecure(t *testing.T) {
 		InsecureSkipVerify: true,
 	}
 
-	conn, err := tls.Dial("tcp", input.listener.Addr().String(), conf)
+	conn, err := tls.Dial("tcp", input.Addr(), conf)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -139,7 +140,7 @@ func TestTCPInputSecure(t *testing.T) {
 	for i := 0; i < 100; i++ {
 		wg.Add(1)
 		conn.Write(msg)
-		conn.Write([]byte(goreplay.payloadSeparator))
+		conn.Write([]byte(proto.PayloadSeparator))
 	}
 
 	wg.Wait()
@@ -0,0 +1,31 @@
+package output
+
+import (
+	"net"
+	"testing"
+)
+
+// serveTCP accepts connections on a local port until the test ends, every connection is served by
+// serve in a goroutine of its own and closed once serve returns
+func serveTCP(t *testing.T, serve func(conn net.Conn)) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return l
+}
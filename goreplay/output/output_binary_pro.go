@@ -1,7 +1,7 @@
 package output
 
 import (
-	"record-traffic-press/goreplay/bootstrap"
+	"hash/fnv"
 	"record-traffic-press/goreplay/common"
 	"record-traffic-press/goreplay/core"
 	"record-traffic-press/goreplay/glogs"
@@ -14,9 +14,16 @@ import (
 // 是一种编译时检查，确保 BinaryOutput 类型实现了 bootstrap.PluginWriter 接口
 var _ core.PluginWriter = (*BinaryOutput)(nil)
 
+// binaryEndpoint is the only latency endpoint of a binary output, requests of unknown protocols can't be told apart
+const binaryEndpoint = "*"
+
 // BinaryOutput plugin manage pool of workers which send request to replayed server
 // By default workers pool is dynamic and starts with 10 workers
 // You can specify fixed number of workers using `--output-tcp-workers`
+//
+// In session mode (`--output-binary-session`) the workers are fixed and every session, a captured
+// connection or a client IP, is bound to one of them, so its requests go over the same connection
+// in captured order.
 type BinaryOutput struct {
 	// Keep this as first element of struct because it guarantees 64bit
 	// alignment. atomic.* functions crash on 32bit machines if operand is not
@@ -24,11 +31,13 @@ type BinaryOutput struct {
 	activeWorkers int64
 	address       string
 	queue         chan *common.Message
+	sessions      []chan *common.Message // a queue per worker, session mode only
 	responses     chan response
 	needWorker    chan int
 	quit          chan struct{}
 	config        *settings.BinaryOutputConfig
 	queueStats    *core.GorStat
+	latency       *core.LatencyRecorder
 }
 
 // NewBinaryOutput constructor for BinaryOutput
@@ -43,6 +52,20 @@ func NewBinaryOutput(address string, config *settings.BinaryOutputConfig) core.P
 	o.responses = make(chan response, 1000)
 	o.needWorker = make(chan int, 1)
 	o.quit = make(chan struct{})
+	o.latency = core.NewLatencyRecorder(o.String(), config.LatencyWindow)
+
+	if o.config.Session != "" {
+		workers := o.config.Workers
+		if workers == 0 {
+			workers = common.InitialDynamicWorkers
+		}
+		o.sessions = make([]chan *common.Message, workers)
+		for i := range o.sessions {
+			o.sessions[i] = make(chan *common.Message, 100)
+			go o.startSessionWorker(o.sessions[i])
+		}
+		return o
+	}
 
 	// Initial workers count
 	if o.config.Workers == 0 {
@@ -70,12 +93,34 @@ func (o *BinaryOutput) workerMaster() {
 	}
 }
 
-func (o *BinaryOutput) startWorker() {
-	client := bootstrap.NewTCPClient(o.address, &bootstrap.TCPClientConfig{
+func (o *BinaryOutput) newClient() *TCPClient {
+	return NewTCPClient(o.address, &TCPClientConfig{
 		Debug:              o.config.Debug,
 		Timeout:            o.config.Timeout,
 		ResponseBufferSize: int(o.config.BufferSize),
 	})
+}
+
+// startSessionWorker sends the requests of the sessions bound to the queue one after the other
+func (o *BinaryOutput) startSessionWorker(queue chan *common.Message) {
+	client := o.newClient()
+	defer client.Disconnect()
+
+	atomic.AddInt64(&o.activeWorkers, 1)
+	defer atomic.AddInt64(&o.activeWorkers, -1)
+
+	for {
+		select {
+		case <-o.quit:
+			return
+		case msg := <-queue:
+			o.sendRequest(client, msg)
+		}
+	}
+}
+
+func (o *BinaryOutput) startWorker() {
+	client := o.newClient()
 
 	deathCount := 0
 
@@ -113,6 +158,13 @@ func (o *BinaryOutput) PluginWrite(msg *common.Message) (n int, err error) {
 		return len(msg.Data), nil
 	}
 
+	if o.sessions != nil {
+		hasher := fnv.New32a()
+		hasher.Write(proto.SessionID(proto.PayloadID(msg.Meta), o.config.Session))
+		o.sessions[hasher.Sum32()%uint32(len(o.sessions))] <- msg
+		return len(msg.Data) + len(msg.Meta), nil
+	}
+
 	o.queue <- msg
 
 	if o.config.Workers == 0 {
@@ -141,7 +193,7 @@ func (o *BinaryOutput) PluginRead() (*common.Message, error) {
 	return &msg, nil
 }
 
-func (o *BinaryOutput) sendRequest(client *bootstrap.TCPClient, msg *common.Message) {
+func (o *BinaryOutput) sendRequest(client *TCPClient, msg *common.Message) {
 	if !proto.IsRequestPayload(msg.Meta) {
 		return
 	}
@@ -153,7 +205,10 @@ func (o *BinaryOutput) sendRequest(client *bootstrap.TCPClient, msg *common.Mess
 	stop := time.Now()
 
 	if err != nil {
+		o.latency.RecordError(binaryEndpoint, err)
 		glogs.Debug(1, "Request error:", err)
+	} else {
+		o.latency.Record(binaryEndpoint, stop.Sub(start))
 	}
 
 	if o.config.TrackResponses {
@@ -165,8 +220,24 @@ func (o *BinaryOutput) String() string {
 	return "Binary output: " + o.address
 }
 
+// LatencyReports returns the latency percentiles of the replayed requests
+func (o *BinaryOutput) LatencyReports() []core.LatencyReport {
+	return o.latency.Reports()
+}
+
+// LatencySnapshots returns the raw latencies of the replayed requests, to be merged with other processes
+func (o *BinaryOutput) LatencySnapshots() []core.LatencySnapshot {
+	return o.latency.Snapshots()
+}
+
+// LatencyTotal returns the latency percentiles of all the replayed requests
+func (o *BinaryOutput) LatencyTotal() core.LatencyReport {
+	return o.latency.Total()
+}
+
 // Close closes this plugin for reading
 func (o *BinaryOutput) Close() error {
 	close(o.quit)
+	o.latency.Close()
 	return nil
 }
@@ -0,0 +1,282 @@
+package output
+
+import (
+	"container/list"
+	"encoding/json"
+	"io"
+	"os"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/core"
+	"record-traffic-press/goreplay/glogs"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDiffTTL        = 30 * time.Second
+	defaultDiffMaxPending = 10000
+)
+
+var _ core.PluginWriter = (*DiffOutput)(nil)
+
+// DiffOutput joins every request with its original ('2') and replayed ('3') responses
+// by payload ID and compares them. Mismatches are written as JSON lines to the file
+// given as address ("stdout" for the console, empty for none) and passed to
+// config.Handler; all comparisons are aggregated per protocol and endpoint, see Stats.
+type DiffOutput struct {
+	mu      sync.Mutex
+	address string
+	config  *settings.DiffOutputConfig
+	ignore  proto.DiffIgnore
+	writer  io.Writer
+	encoder *json.Encoder
+	pending map[string]*diffEntry
+	order   *list.List // pending entries, oldest first
+	stats   map[string]*proto.DiffStats
+	quit    chan struct{}
+	once    sync.Once
+}
+
+type diffEntry struct {
+	id              string
+	request         []byte
+	original        []byte
+	replayed        []byte
+	requestTs       int64
+	originalTs      int64
+	replayedLatency int64
+	received        time.Time
+	elem            *list.Element
+}
+
+// NewDiffOutput constructor for DiffOutput
+func NewDiffOutput(address string, config *settings.DiffOutputConfig) core.PluginWriter {
+	o := new(DiffOutput)
+
+	c := *config
+	if c.TTL <= 0 {
+		c.TTL = defaultDiffTTL
+	}
+	if c.MaxPending <= 0 {
+		c.MaxPending = defaultDiffMaxPending
+	}
+
+	o.address = address
+	o.config = &c
+	o.ignore = proto.NewDiffIgnore(c.IgnoreFields)
+	o.pending = make(map[string]*diffEntry)
+	o.order = list.New()
+	o.stats = make(map[string]*proto.DiffStats)
+	o.quit = make(chan struct{})
+
+	switch address {
+	case "":
+	case "stdout":
+		o.writer = os.Stdout
+	default:
+		file, err := os.OpenFile(address, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			glogs.Debug(0, "[DIFF-OUTPUT] can't open file:", err)
+		} else {
+			o.writer = file
+		}
+	}
+	if o.writer != nil {
+		o.encoder = json.NewEncoder(o.writer)
+	}
+
+	go o.expire()
+
+	return o
+}
+
+// PluginWrite writes a message to this plugin
+func (o *DiffOutput) PluginWrite(msg *common.Message) (n int, err error) {
+	meta := proto.PayloadMeta(msg.Meta)
+	if len(meta) < 3 || len(meta[0]) == 0 {
+		return 0, nil
+	}
+	id := string(meta[1])
+	ts, _ := strconv.ParseInt(string(meta[2]), 10, 64)
+
+	o.mu.Lock()
+
+	entry, ok := o.pending[id]
+	if !ok {
+		entry = &diffEntry{id: id, received: time.Now()}
+		entry.elem = o.order.PushBack(entry)
+		o.pending[id] = entry
+	}
+
+	data := append([]byte{}, msg.Data...)
+	switch meta[0][0] {
+	case proto.RequestPayload:
+		entry.request, entry.requestTs = data, ts
+	case proto.ResponsePayload:
+		entry.original, entry.originalTs = data, ts
+	case proto.ReplayedResponsePayload:
+		entry.replayed = data
+		if len(meta) > 3 {
+			entry.replayedLatency, _ = strconv.ParseInt(string(meta[3]), 10, 64)
+		}
+	}
+
+	var mismatch *proto.DiffRecord
+	if entry.request != nil && entry.original != nil && entry.replayed != nil {
+		o.remove(entry)
+		if record := o.compare(entry); !record.Match {
+			mismatch = record
+		}
+	}
+
+	for len(o.pending) > o.config.MaxPending {
+		o.evict(o.order.Front().Value.(*diffEntry))
+	}
+	o.mu.Unlock()
+
+	// a slow handler doesn't hold the other writes
+	if mismatch != nil && o.config.Handler != nil {
+		o.config.Handler(mismatch)
+	}
+
+	return len(msg.Data) + len(msg.Meta), nil
+}
+
+// compare compares the responses of an entry, counts the comparison and writes a mismatch to the file
+func (o *DiffOutput) compare(entry *diffEntry) *proto.DiffRecord {
+	record := &proto.DiffRecord{
+		ID:              entry.id,
+		Timestamp:       entry.requestTs,
+		ReplayedLatency: entry.replayedLatency,
+	}
+	if entry.originalTs > entry.requestTs && entry.requestTs > 0 {
+		record.OriginalLatency = entry.originalTs - entry.requestTs
+	}
+
+	if proto.HasDubboHeader(entry.request) {
+		record.Protocol = "dubbo"
+		record.Endpoint = proto.DubboEndpoint(entry.request)
+		record.Diffs = proto.DiffDubbo(entry.original, entry.replayed, o.ignore)
+	} else if proto.HasRedisCommand(entry.request) {
+		record.Protocol = "redis"
+		record.Endpoint = proto.RedisEndpoint(entry.request)
+		record.Diffs = proto.DiffRedis(entry.original, entry.replayed, o.ignore)
+	} else if proto.HasMySQLQuery(entry.request) {
+		record.Protocol = "mysql"
+		record.Endpoint = proto.MySQLEndpoint(entry.request)
+		record.Diffs = proto.DiffMySQL(entry.original, entry.replayed, o.ignore)
+	} else {
+		record.Protocol = "http"
+		record.Endpoint = proto.HTTPEndpoint(entry.request)
+		record.Diffs = proto.DiffHTTP(core.PrettifyHTTP(entry.original), core.PrettifyHTTP(entry.replayed), o.config.Headers, o.ignore)
+	}
+	record.Match = len(record.Diffs) == 0
+
+	o.endpointStats(record.Protocol, record.Endpoint).Add(record)
+
+	if !record.Match && o.encoder != nil {
+		if err := o.encoder.Encode(record); err != nil {
+			glogs.Debug(1, "[DIFF-OUTPUT] write error:", err)
+		}
+	}
+	return record
+}
+
+func (o *DiffOutput) endpointStats(protocol, endpoint string) *proto.DiffStats {
+	key := proto.DiffStatsKey(protocol, endpoint)
+	s, ok := o.stats[key]
+	if !ok {
+		s = &proto.DiffStats{Protocol: protocol, Endpoint: endpoint}
+		o.stats[key] = s
+	}
+	return s
+}
+
+// evict drops an incomplete entry, requests without a response to compare are counted as missing
+func (o *DiffOutput) evict(entry *diffEntry) {
+	o.remove(entry)
+	if entry.request == nil {
+		return
+	}
+
+	protocol, endpoint := "http", proto.HTTPEndpoint(entry.request)
+	if proto.HasDubboHeader(entry.request) {
+		protocol, endpoint = "dubbo", proto.DubboEndpoint(entry.request)
+	} else if proto.HasRedisCommand(entry.request) {
+		protocol, endpoint = "redis", proto.RedisEndpoint(entry.request)
+	} else if proto.HasMySQLQuery(entry.request) {
+		protocol, endpoint = "mysql", proto.MySQLEndpoint(entry.request)
+	}
+	o.endpointStats(protocol, endpoint).Missing++
+}
+
+func (o *DiffOutput) remove(entry *diffEntry) {
+	delete(o.pending, entry.id)
+	o.order.Remove(entry.elem)
+}
+
+func (o *DiffOutput) expire() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.quit:
+			return
+		case now := <-ticker.C:
+			o.mu.Lock()
+			for e := o.order.Front(); e != nil; e = o.order.Front() {
+				entry := e.Value.(*diffEntry)
+				if now.Sub(entry.received) < o.config.TTL {
+					break
+				}
+				o.evict(entry)
+			}
+			o.mu.Unlock()
+		}
+	}
+}
+
+// Stats returns a copy of the comparisons aggregated per endpoint, keyed by proto.DiffStatsKey
+func (o *DiffOutput) Stats() map[string]proto.DiffStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	stats := make(map[string]proto.DiffStats, len(o.stats))
+	for key, s := range o.stats {
+		stats[key] = *s
+	}
+	return stats
+}
+
+func (o *DiffOutput) String() string {
+	return "Diff output: " + o.address
+}
+
+// Close reports the mismatch rates and closes the file
+func (o *DiffOutput) Close() error {
+	o.once.Do(func() {
+		close(o.quit)
+
+		stats := o.Stats()
+		endpoints := make([]string, 0, len(stats))
+		for endpoint := range stats {
+			endpoints = append(endpoints, endpoint)
+		}
+		sort.Strings(endpoints)
+		for _, endpoint := range endpoints {
+			s := stats[endpoint]
+			glogs.Debug(1, "[DIFF-OUTPUT]", endpoint, "total:", s.Total, "mismatched:", s.Mismatched, "missing:", s.Missing,
+				"mismatch rate:", strconv.FormatFloat(s.MismatchRate(), 'f', 4, 64))
+		}
+
+		if file, ok := o.writer.(*os.File); ok && file != os.Stdout {
+			file.Close()
+		}
+	})
+	return nil
+}
@@ -0,0 +1,89 @@
+package output
+
+import (
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"testing"
+	"time"
+)
+
+func TestDiffOutput(t *testing.T) {
+	var records []*proto.DiffRecord
+	output := NewDiffOutput("", &settings.DiffOutputConfig{
+		IgnoreFields: []string{"ts"},
+		MaxPending:   2,
+		Handler:      func(r *proto.DiffRecord) { records = append(records, r) },
+	}).(*DiffOutput)
+	defer output.Close()
+
+	write := func(payloadType byte, id string, ts int64, data string) {
+		output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(payloadType, []byte(id), ts, 1), Data: []byte(data)})
+	}
+
+	// the replayed response may arrive before the original one
+	write(proto.RequestPayload, "a", 100, "GET /user?id=1 HTTP/1.1\r\n\r\n")
+	write(proto.ReplayedResponsePayload, "a", 0, "HTTP/1.1 200 OK\r\n\r\n{\"name\":\"alice\",\"ts\":2}")
+	write(proto.ResponsePayload, "a", 150, "HTTP/1.1 200 OK\r\n\r\n{\"name\":\"alice\",\"ts\":1}")
+
+	write(proto.RequestPayload, "b", 100, "GET /user?id=2 HTTP/1.1\r\n\r\n")
+	write(proto.ResponsePayload, "b", 200, "HTTP/1.1 200 OK\r\n\r\n{\"name\":\"bob\"}")
+	write(proto.ReplayedResponsePayload, "b", 0, "HTTP/1.1 200 OK\r\n\r\n{\"name\":\"carol\"}")
+
+	// never replayed, evicted once more than 2 requests are pending
+	write(proto.RequestPayload, "c", 100, "GET /user?id=3 HTTP/1.1\r\n\r\n")
+	write(proto.RequestPayload, "d", 100, "GET /order HTTP/1.1\r\n\r\n")
+	write(proto.RequestPayload, "e", 100, "GET /order HTTP/1.1\r\n\r\n")
+
+	if len(records) != 1 || records[0].ID != "b" || records[0].Endpoint != "GET /user" || records[0].OriginalLatency != 100 {
+		t.Fatalf("Wrong records: %+v", records)
+	}
+	if diffs := records[0].Diffs; len(diffs) != 1 || diffs[0].Field != "body.name" {
+		t.Errorf("Wrong diffs: %+v", diffs)
+	}
+
+	stats := output.Stats()[proto.DiffStatsKey("http", "GET /user")]
+	if stats.Endpoint != "GET /user" || stats.Total != 2 || stats.Matched != 1 || stats.Mismatched != 1 || stats.Missing != 1 || stats.MismatchRate() != 0.5 {
+		t.Errorf("Wrong stats: %+v", stats)
+	}
+}
+
+func TestDiffOutputTTL(t *testing.T) {
+	output := NewDiffOutput("", &settings.DiffOutputConfig{TTL: time.Millisecond}).(*DiffOutput)
+	defer output.Close()
+
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte("a"), 1, 1), Data: []byte("GET / HTTP/1.1\r\n\r\n")})
+
+	time.Sleep(1500 * time.Millisecond)
+
+	if stats := output.Stats()[proto.DiffStatsKey("http", "GET /")]; stats.Missing != 1 {
+		t.Errorf("Expired request should be missing: %+v", stats)
+	}
+}
+
+func TestDiffOutputProtocolStats(t *testing.T) {
+	var output *DiffOutput
+	var stats map[string]proto.DiffStats
+	output = NewDiffOutput("", &settings.DiffOutputConfig{
+		MaxPending: 1,
+		// the handler runs outside the lock, so it may read the stats
+		Handler: func(r *proto.DiffRecord) { stats = output.Stats() },
+	}).(*DiffOutput)
+	defer output.Close()
+
+	write := func(payloadType byte, id string, data string) {
+		output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(payloadType, []byte(id), 1, 1), Data: []byte(data)})
+	}
+
+	write(proto.RequestPayload, "a", "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n")
+	write(proto.RequestPayload, "b", "GET / HTTP/1.1\r\n\r\n")
+	write(proto.ResponsePayload, "b", "HTTP/1.1 200 OK\r\n\r\na")
+	write(proto.ReplayedResponsePayload, "b", "HTTP/1.1 200 OK\r\n\r\nb")
+
+	if redis := stats[proto.DiffStatsKey("redis", "GET")]; redis.Protocol != "redis" || redis.Endpoint != "GET" || redis.Missing != 1 || redis.Total != 0 {
+		t.Errorf("Wrong redis stats: %+v", redis)
+	}
+	if http := stats[proto.DiffStatsKey("http", "GET /")]; http.Protocol != "http" || http.Mismatched != 1 || http.Missing != 0 {
+		t.Errorf("Wrong http stats: %+v", http)
+	}
+}
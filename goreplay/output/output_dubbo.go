@@ -0,0 +1,409 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/core"
+	"record-traffic-press/goreplay/glogs"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	_ core.PluginReadWriter = (*DubboOutput)(nil)
+	_ core.LatencyReporter  = (*DubboOutput)(nil)
+)
+
+// errDubboConnClosed fails the invocations in flight on a connection closed without an error, e.g. by Close
+var errDubboConnClosed = errors.New("connection closed")
+
+// DubboOutput replays captured dubbo invocations over a few persistent connections.
+// Every invocation is sent with a fresh request ID, so invocations captured on
+// different client connections can be multiplexed on the same connection, and
+// responses are matched back to the payload they were replayed from. The responses
+// to the invocations of a payload are emitted together, as one replayed response.
+type DubboOutput struct {
+	// Keep this as first element of struct because it guarantees 64bit
+	// alignment. atomic.* functions crash on 32bit machines if operand is not
+	// aligned at 64bit. See https://github.com/golang/go/issues/599
+	requestID int64
+	address   string
+	config    *settings.DubboOutputConfig
+	conns     []*dubboConn
+	queue     chan *dubboCall
+	responses chan response
+	latency   *core.LatencyRecorder
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+// dubboCall is a single invocation waiting to be sent or answered
+type dubboCall struct {
+	payload   *dubboPayload // nil when the responses are not tracked or for one-way invocations
+	index     int           // of the invocation in its payload
+	endpoint  string
+	requestID int64 // captured request ID, restored in the replayed response
+	frame     []byte
+	twoWay    bool
+	startedAt time.Time
+	timer     *time.Timer
+}
+
+// dubboPayload gathers the responses to the two-way invocations of a payload
+type dubboPayload struct {
+	uuid      []byte
+	mu        sync.Mutex
+	frames    [][]byte // in the order of the invocations, nil for the failed ones
+	remaining int
+	startedAt time.Time
+	stop      time.Time
+}
+
+// dubboConn is a persistent connection shared by many in-flight invocations
+type dubboConn struct {
+	output  *DubboOutput
+	mu      sync.Mutex // guards conn and pending
+	conn    net.Conn
+	pending map[int64]*dubboCall
+}
+
+// NewDubboOutput constructor for DubboOutput
+func NewDubboOutput(address string, config *settings.DubboOutputConfig) core.PluginReadWriter {
+	o := new(DubboOutput)
+
+	c := *config
+	if c.Connections <= 0 {
+		c.Connections = 1
+	}
+	if c.Timeout < time.Millisecond*100 {
+		c.Timeout = 5 * time.Second
+	}
+
+	o.address = address
+	o.config = &c
+	o.queue = make(chan *dubboCall, 1000)
+	o.responses = make(chan response, 1000)
+	o.quit = make(chan struct{})
+	o.latency = core.NewLatencyRecorder(o.String(), c.LatencyWindow)
+
+	o.conns = make([]*dubboConn, c.Connections)
+	for i := range o.conns {
+		o.conns[i] = &dubboConn{output: o, pending: make(map[int64]*dubboCall)}
+		go o.conns[i].worker()
+	}
+
+	return o
+}
+
+// PluginWrite writes a message to this plugin
+func (o *DubboOutput) PluginWrite(msg *common.Message) (n int, err error) {
+	if !proto.IsRequestPayload(msg.Meta) {
+		return len(msg.Data), nil
+	}
+
+	var calls []*dubboCall
+	var payload *dubboPayload
+	if o.config.TrackResponses {
+		payload = &dubboPayload{uuid: proto.PayloadID(msg.Meta)}
+	}
+	frames, _ := proto.DubboFrames(msg.Data)
+	for _, frame := range frames {
+		header, _ := proto.ParseDubboHeader(frame)
+		if !header.IsRequest() || header.IsEvent() {
+			continue
+		}
+
+		call := &dubboCall{
+			endpoint:  proto.DubboEndpoint(frame),
+			requestID: header.RequestID,
+			frame:     append([]byte{}, frame...),
+			twoWay:    header.IsTwoWay(),
+		}
+		if payload != nil && call.twoWay {
+			call.payload, call.index = payload, payload.remaining
+			payload.remaining++
+		}
+		calls = append(calls, call)
+	}
+	if payload != nil {
+		payload.frames = make([][]byte, payload.remaining)
+	}
+
+	for _, call := range calls {
+		select {
+		case <-o.quit:
+			return 0, common.ErrorStopped
+		case o.queue <- call:
+		}
+	}
+
+	return len(msg.Data) + len(msg.Meta), nil
+}
+
+// PluginRead reads a message from this plugin
+func (o *DubboOutput) PluginRead() (*common.Message, error) {
+	var resp response
+	var msg common.Message
+	select {
+	case <-o.quit:
+		return nil, common.ErrorStopped
+	case resp = <-o.responses:
+	}
+	msg.Data = resp.payload
+	msg.Meta = proto.PayloadHeader(proto.ReplayedResponsePayload, resp.uuid, resp.startedAt, resp.roundTripTime)
+
+	return &msg, nil
+}
+
+func (o *DubboOutput) String() string {
+	return "Dubbo output: " + o.address
+}
+
+// LatencyReports returns the latency percentiles of the replayed invocations per "service.method"
+func (o *DubboOutput) LatencyReports() []core.LatencyReport {
+	return o.latency.Reports()
+}
+
+// LatencySnapshots returns the raw latencies of the replayed invocations, to be merged with other processes
+func (o *DubboOutput) LatencySnapshots() []core.LatencySnapshot {
+	return o.latency.Snapshots()
+}
+
+// LatencyTotal returns the latency percentiles of all the replayed invocations
+func (o *DubboOutput) LatencyTotal() core.LatencyReport {
+	return o.latency.Total()
+}
+
+// Close closes this plugin and its connections
+func (o *DubboOutput) Close() error {
+	o.closeOnce.Do(func() {
+		close(o.quit)
+		for _, c := range o.conns {
+			c.mu.Lock()
+			c.closeLocked(errDubboConnClosed)
+			c.mu.Unlock()
+		}
+		o.latency.Close()
+	})
+	return nil
+}
+
+func (c *dubboConn) worker() {
+	o := c.output
+	for {
+		select {
+		case <-o.quit:
+			return
+		case call := <-o.queue:
+			if o.config.ServiceVersion != "" || o.config.Group != "" {
+				frame, err := proto.RewriteDubboRequest(call.frame, o.config.ServiceVersion, o.config.Group)
+				if err != nil {
+					glogs.Debug(1, "[DUBBO-OUTPUT] can't rewrite invocation, sending it unchanged:", err)
+				} else {
+					call.frame = frame
+				}
+			}
+
+			if err := c.send(call); err != nil {
+				o.latency.RecordError(call.endpoint, err)
+				o.done(call, nil, time.Time{})
+				glogs.Debug(1, "[DUBBO-OUTPUT] request error:", err)
+			}
+		}
+	}
+}
+
+func (c *dubboConn) send(call *dubboCall) error {
+	o := c.output
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", o.address, o.config.Timeout)
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+		go c.read(conn)
+	}
+
+	id := atomic.AddInt64(&o.requestID, 1)
+	proto.SetDubboRequestID(call.frame, id)
+	call.startedAt = time.Now()
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(o.config.Timeout))
+	if _, err := c.conn.Write(call.frame); err != nil {
+		c.closeLocked(err)
+		return err
+	}
+
+	// registered once sent, the response can't be read before as c.mu is held
+	if call.twoWay {
+		c.pending[id] = call
+		call.timer = time.AfterFunc(o.config.Timeout, func() { c.expire(id) })
+	}
+
+	if o.config.Debug {
+		glogs.Debug(1, "[DUBBO-OUTPUT] sent request", call.requestID, "as", id)
+	}
+
+	return nil
+}
+
+// read receives responses of a connection until it is broken or closed
+func (c *dubboConn) read(conn net.Conn) {
+	o := c.output
+	err := errDubboConnClosed
+	defer func() {
+		c.mu.Lock()
+		if c.conn == conn {
+			c.closeLocked(err)
+		}
+		c.mu.Unlock()
+	}()
+
+	header := make([]byte, proto.DubboHeaderLength)
+	for {
+		if _, err = io.ReadFull(conn, header); err != nil {
+			if err != io.EOF {
+				glogs.Debug(1, "[DUBBO-OUTPUT] read error:", err)
+			}
+			return
+		}
+		h, ok := proto.ParseDubboHeader(header)
+		if !ok {
+			err = errors.New("invalid response header")
+			glogs.Debug(1, "[DUBBO-OUTPUT] invalid response header:", header)
+			return
+		}
+
+		frame := make([]byte, h.FrameLength())
+		copy(frame, header)
+		if _, err = io.ReadFull(conn, frame[proto.DubboHeaderLength:]); err != nil {
+			glogs.Debug(1, "[DUBBO-OUTPUT] read error:", err)
+			return
+		}
+		stop := time.Now()
+
+		if h.IsRequest() {
+			if h.IsEvent() && h.IsTwoWay() {
+				c.heartbeat(conn, &h)
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		call, ok := c.pending[h.RequestID]
+		delete(c.pending, h.RequestID)
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+		call.timer.Stop()
+
+		if h.Status != proto.DubboResponseOK {
+			o.latency.RecordFailure(call.endpoint, stop.Sub(call.startedAt), fmt.Errorf("response status %d", h.Status))
+		} else {
+			o.latency.Record(call.endpoint, stop.Sub(call.startedAt))
+		}
+
+		// the replayed response carries the captured request ID so it can be compared with the original one
+		proto.SetDubboRequestID(frame, call.requestID)
+		o.done(call, frame, stop)
+	}
+}
+
+// done records the response to a call, nil when the call failed, and emits the responses of its payload
+// once all its calls are over
+func (o *DubboOutput) done(call *dubboCall, frame []byte, stop time.Time) {
+	p := call.payload
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	p.frames[call.index] = frame
+	if frame != nil {
+		if p.startedAt.IsZero() || call.startedAt.Before(p.startedAt) {
+			p.startedAt = call.startedAt
+		}
+		if stop.After(p.stop) {
+			p.stop = stop
+		}
+	}
+	p.remaining--
+	last := p.remaining == 0
+	p.mu.Unlock()
+
+	if !last {
+		return
+	}
+	data := bytes.Join(p.frames, nil)
+	if len(data) == 0 {
+		return
+	}
+
+	resp := response{data, p.uuid, p.startedAt.UnixNano(), p.stop.UnixNano() - p.startedAt.UnixNano()}
+	select {
+	case <-o.quit:
+	case o.responses <- resp:
+	}
+}
+
+// heartbeat answers heartbeats of the server, which otherwise closes idle connections
+func (c *dubboConn) heartbeat(conn net.Conn, h *proto.DubboHeader) {
+	frame := make([]byte, proto.DubboHeaderLength+1)
+	frame[0], frame[1] = proto.DubboMagicHigh, proto.DubboMagicLow
+	frame[2] = proto.DubboFlagEvent | h.SerialID()
+	frame[3] = proto.DubboResponseOK
+	proto.SetDubboRequestID(frame, h.RequestID)
+	binary.BigEndian.PutUint32(frame[12:16], 1)
+	frame[proto.DubboHeaderLength] = 'N' // hessian null
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == conn {
+		_ = conn.SetWriteDeadline(time.Now().Add(c.output.config.Timeout))
+		if _, err := conn.Write(frame); err != nil {
+			glogs.Debug(1, "[DUBBO-OUTPUT] heartbeat error:", err)
+		}
+	}
+}
+
+func (c *dubboConn) expire(id int64) {
+	c.mu.Lock()
+	call, ok := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+
+	if ok {
+		c.output.latency.RecordTimeout(call.endpoint)
+		c.output.done(call, nil, time.Time{})
+		glogs.Debug(1, "[DUBBO-OUTPUT] request", call.requestID, "timed out")
+	}
+}
+
+// closeLocked drops the connection, its in-flight invocations are counted as failed with err, c.mu must be held
+func (c *dubboConn) closeLocked(err error) {
+	if c.conn == nil {
+		return
+	}
+	_ = c.conn.Close()
+	c.conn = nil
+
+	for id, call := range c.pending {
+		call.timer.Stop()
+		delete(c.pending, id)
+		c.output.latency.RecordError(call.endpoint, err)
+		c.output.done(call, nil, time.Time{})
+	}
+}
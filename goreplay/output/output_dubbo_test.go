@@ -0,0 +1,202 @@
+package output
+
+import (
+	"io"
+	"net"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"testing"
+	"time"
+
+	hessian "github.com/apache/dubbo-go-hessian2"
+)
+
+// startDubboEchoServer answers every invocation with "<version>/<group>/<first argument>"
+func startDubboEchoServer(t *testing.T) string {
+	ln := serveTCP(t, func(conn net.Conn) {
+		header := make([]byte, proto.DubboHeaderLength)
+		for {
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			h, _ := proto.ParseDubboHeader(header)
+			frame := make([]byte, h.FrameLength())
+			copy(frame, header)
+			if _, err := io.ReadFull(conn, frame[proto.DubboHeaderLength:]); err != nil {
+				return
+			}
+
+			_, body, err := proto.DecodeDubboRequest(frame)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			value := body.ServiceVersion + "/" + body.Attachments["group"] + "/" + body.Arguments[0].(string)
+
+			rsp, err := hessian.NewHessianCodec(nil).Write(hessian.Service{}, hessian.DubboHeader{
+				SerialID:       proto.DubboSerialHessian2,
+				Type:           hessian.PackageResponse,
+				ID:             h.RequestID,
+				ResponseStatus: hessian.Response_OK,
+			}, hessian.NewResponse(value, nil, nil))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Write(rsp)
+		}
+	})
+
+	return ln.Addr().String()
+}
+
+func dubboInvocation(t *testing.T, id int64, arg string) []byte {
+	frame, err := hessian.NewHessianCodec(nil).Write(hessian.Service{
+		Path:    "com.example.UserService",
+		Version: "1.0.0",
+		Method:  "getUser",
+	}, hessian.DubboHeader{
+		SerialID: proto.DubboSerialHessian2,
+		Type:     hessian.PackageRequest_TwoWay,
+		ID:       id,
+	}, hessian.NewRequest([]interface{}{arg}, map[string]string{"group": "blue"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func readDubboResponses(t *testing.T, output *DubboOutput, count int) map[string]*proto.DubboResult {
+	results := make(map[string]*proto.DubboResult)
+	for len(results) < count {
+		done := make(chan *common.Message, 1)
+		go func() {
+			msg, _ := output.PluginRead()
+			done <- msg
+		}()
+
+		select {
+		case msg := <-done:
+			if msg.Meta[0] != proto.ReplayedResponsePayload {
+				t.Errorf("Wrong payload type: %q", msg.Meta)
+			}
+			header, result, err := proto.DecodeDubboResponse(msg.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.RequestID != 1 {
+				t.Errorf("Captured request ID should be restored, got %d", header.RequestID)
+			}
+			results[string(proto.PayloadID(msg.Meta))] = result
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for responses")
+		}
+	}
+	return results
+}
+
+func TestDubboOutput(t *testing.T) {
+	address := startDubboEchoServer(t)
+
+	output := NewDubboOutput(address, &settings.DubboOutputConfig{TrackResponses: true, Connections: 2}).(*DubboOutput)
+	defer output.Close()
+
+	// invocations captured on different connections share the same request ID
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte("a"), 1, -1), Data: dubboInvocation(t, 1, "alice")})
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte("b"), 1, -1), Data: dubboInvocation(t, 1, "bob")})
+	// responses are not replayed
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.ResponsePayload, []byte("a"), 1, -1), Data: dubboInvocation(t, 1, "carol")})
+
+	results := readDubboResponses(t, output, 2)
+	if results["a"].Value != "1.0.0/blue/alice" || results["b"].Value != "1.0.0/blue/bob" {
+		t.Errorf("Responses not matched to their payloads: %+v %+v", results["a"], results["b"])
+	}
+}
+
+func TestDubboOutputRewrite(t *testing.T) {
+	address := startDubboEchoServer(t)
+
+	output := NewDubboOutput(address, &settings.DubboOutputConfig{
+		TrackResponses: true,
+		ServiceVersion: "2.0.0",
+		Group:          "green",
+	}).(*DubboOutput)
+	defer output.Close()
+
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte("a"), 1, -1), Data: dubboInvocation(t, 1, "alice")})
+
+	results := readDubboResponses(t, output, 1)
+	if results["a"].Value != "2.0.0/green/alice" {
+		t.Errorf("Version and group not rewritten: %+v", results["a"])
+	}
+}
+
+func TestDubboOutputConnectionLost(t *testing.T) {
+	// the server drops the connection without answering
+	ln := serveTCP(t, func(conn net.Conn) {
+		header := make([]byte, proto.DubboHeaderLength)
+		io.ReadFull(conn, header)
+	})
+
+	output := NewDubboOutput(ln.Addr().String(), &settings.DubboOutputConfig{Timeout: time.Minute}).(*DubboOutput)
+	defer output.Close()
+
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte("a"), 1, -1), Data: dubboInvocation(t, 1, "alice")})
+
+	total := output.LatencyTotal()
+	for deadline := time.Now().Add(2 * time.Second); total.Requests < 1 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		total = output.LatencyTotal()
+	}
+	if total.Requests != 1 || total.Errors != 1 || total.Timeouts != 0 {
+		t.Errorf("Expected the invocation in flight to fail, got %+v", total)
+	}
+}
+
+func TestDubboOutputPayloadInvocations(t *testing.T) {
+	address := startDubboEchoServer(t)
+
+	output := NewDubboOutput(address, &settings.DubboOutputConfig{TrackResponses: true}).(*DubboOutput)
+	defer output.Close()
+
+	// a payload read from a file may carry several invocations
+	data := append(dubboInvocation(t, 1, "alice"), dubboInvocation(t, 2, "bob")...)
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte("a"), 1, -1), Data: data})
+
+	done := make(chan *common.Message, 1)
+	go func() {
+		msg, _ := output.PluginRead()
+		done <- msg
+	}()
+
+	var msg *common.Message
+	select {
+	case msg = <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for responses")
+	}
+
+	frames, _ := proto.DubboFrames(msg.Data)
+	if len(frames) != 2 {
+		t.Fatalf("Expected the 2 responses in one message, got %d", len(frames))
+	}
+	for i, want := range []string{"1.0.0/blue/alice", "1.0.0/blue/bob"} {
+		header, result, err := proto.DecodeDubboResponse(frames[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.RequestID != int64(i+1) || result.Value != want {
+			t.Errorf("Response %d not in order: %d %+v", i, header.RequestID, result)
+		}
+	}
+
+	go func() {
+		msg, _ := output.PluginRead()
+		done <- msg
+	}()
+	select {
+	case msg := <-done:
+		t.Errorf("Unexpected response %q", msg.Meta)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
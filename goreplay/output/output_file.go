@@ -246,7 +246,7 @@ func (o *FileOutput) PluginWrite(msg *common.Message) (n int, err error) {
 	o.currentFileSize += n
 	o.QueueLength++
 
-	if settings.Settings.OutputFileConfig.OutputFileMaxSize > 0 && o.totalFileSize >= settings.Settings.OutputFileConfig.OutputFileMaxSize {
+	if o.config.OutputFileMaxSize > 0 && o.totalFileSize >= o.config.OutputFileMaxSize {
 		return n, errors.New("File output reached size limit")
 	}
 
@@ -4,67 +4,43 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
-	"record-traffic-press/goreplay"
-	"record-traffic-press/goreplay/bootstrap"
+	"path/filepath"
 	"record-traffic-press/goreplay/common"
-	"record-traffic-press/goreplay/core"
-	input3 "record-traffic-press/goreplay/input"
-	"record-traffic-press/goreplay/plugins"
+	"record-traffic-press/goreplay/input"
+	"record-traffic-press/goreplay/proto"
 	"record-traffic-press/goreplay/settings"
 	"reflect"
 	"sort"
-	"sync"
-	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func TestFileOutput(t *testing.T) {
-	wg := new(sync.WaitGroup)
-
-	input := bootstrap.NewTestInput()
-	output := NewFileOutput("/tmp/test_requests.gor", &FileOutputConfig{FlushInterval: time.Minute, Append: true})
-
-	plugins := &core.InOutPlugins{
-		Inputs:  []core.PluginReader{input},
-		Outputs: []core.PluginWriter{output},
-	}
-	plugins.All = append(plugins.All, input, output)
-
-	emitter := bootstrap.NewEmitter()
-	go emitter.Start(plugins, settings.Settings.Middleware)
+	path := filepath.Join(t.TempDir(), "test_requests.gor")
+	output := NewFileOutput(path, &settings.FileOutputConfig{FlushInterval: time.Minute, Append: true})
 
 	for i := 0; i < 100; i++ {
-		wg.Add(2)
-		input.EmitGET()
-		input.EmitPOST()
+		writeRequests(output, nil, testGET, testPOST)
 	}
-	time.Sleep(100 * time.Millisecond)
 	output.flush()
-	emitter.Close()
-
-	var counter int64
-	input2 := input3.NewFileInput("/tmp/test_requests.gor", false, 100, 0, false)
-	output2 := plugins.NewTestOutput(func(*plugins.Message) {
-		atomic.AddInt64(&counter, 1)
-		wg.Done()
-	})
-
-	plugins2 := &plugins.InOutPlugins{
-		Inputs:  []plugins.PluginReader{input2},
-		Outputs: []plugins.PluginWriter{output2},
-	}
-	plugins2.All = append(plugins2.All, input2, output2)
+	output.Close()
 
-	emitter2 := bootstrap.NewEmitter()
-	go emitter2.Start(plugins2, settings.Settings.Middleware)
+	in := input.NewFileInput(path, false, 100, 0, false)
+	defer in.Close()
 
-	wg.Wait()
-	emitter2.Close()
+	for i := 0; i < 200; i++ {
+		msg, err := in.PluginRead()
+		if err != nil {
+			t.Fatalf("Expected 200 messages, got %d: %v", i, err)
+		}
+		if data := string(msg.Data); data != testGET && data != testPOST {
+			t.Fatalf("Wrong message %q", data)
+		}
+	}
 }
 
 func TestFileOutputWithNameCleaning(t *testing.T) {
-	output := &FileOutput{pathTemplate: "./test_requests.gor", config: &FileOutputConfig{FlushInterval: time.Minute, Append: false}}
+	output := &FileOutput{pathTemplate: "./test_requests.gor", config: &settings.FileOutputConfig{FlushInterval: time.Minute, Append: false}}
 	expectedFileName := "test_requests_0.gor"
 	output.updateName()
 
@@ -75,7 +51,7 @@ func TestFileOutputWithNameCleaning(t *testing.T) {
 }
 
 func TestFileOutputPathTemplate(t *testing.T) {
-	output := &FileOutput{pathTemplate: "/tmp/log-%Y-%m-%d-%S-%t", config: &FileOutputConfig{FlushInterval: time.Minute, Append: true}}
+	output := &FileOutput{pathTemplate: "/tmp/log-%Y-%m-%d-%S-%t", config: &settings.FileOutputConfig{FlushInterval: time.Minute, Append: true}}
 	now := time.Now()
 	output.payloadType = []byte("3")
 	expectedPath := fmt.Sprintf("/tmp/log-%s-%s-%s-%s-3", now.Format("2006"), now.Format("01"), now.Format("02"), now.Format("05"))
@@ -87,22 +63,22 @@ func TestFileOutputPathTemplate(t *testing.T) {
 }
 
 func TestFileOutputMultipleFiles(t *testing.T) {
-	output := NewFileOutput("/tmp/log-%Y-%m-%d-%S", &FileOutputConfig{Append: true, FlushInterval: time.Minute})
+	output := NewFileOutput("/tmp/log-%Y-%m-%d-%S", &settings.FileOutputConfig{Append: true, FlushInterval: time.Minute})
 
 	if output.file != nil {
 		t.Error("Should not initialize file if no writes")
 	}
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name1 := output.file.Name()
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name2 := output.file.Name()
 
 	time.Sleep(time.Second)
 	output.updateName()
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name3 := output.file.Name()
 
 	if name2 != name1 {
@@ -118,22 +94,22 @@ func TestFileOutputMultipleFiles(t *testing.T) {
 }
 
 func TestFileOutputFilePerRequest(t *testing.T) {
-	output := NewFileOutput("/tmp/log-%Y-%m-%d-%S-%r", &FileOutputConfig{Append: true})
+	output := NewFileOutput("/tmp/log-%Y-%m-%d-%S-%r", &settings.FileOutputConfig{Append: true})
 
 	if output.file != nil {
 		t.Error("Should not initialize file if no writes")
 	}
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name1 := output.file.Name()
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 2 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 2 1\r\n"), Data: []byte("test")})
 	name2 := output.file.Name()
 
 	time.Sleep(time.Second)
 	output.updateName()
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 3 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 3 1\r\n"), Data: []byte("test")})
 	name3 := output.file.Name()
 
 	if name3 == name2 || name2 == name1 || name3 == name1 {
@@ -146,14 +122,14 @@ func TestFileOutputFilePerRequest(t *testing.T) {
 }
 
 func TestFileOutputCompression(t *testing.T) {
-	output := NewFileOutput("/tmp/log-%Y-%m-%d-%S.gz", &FileOutputConfig{Append: true, FlushInterval: time.Minute})
+	output := NewFileOutput("/tmp/log-%Y-%m-%d-%S.gz", &settings.FileOutputConfig{Append: true, FlushInterval: time.Minute})
 
 	if output.file != nil {
 		t.Error("Should not initialize file if no writes")
 	}
 
 	for i := 0; i < 1000; i++ {
-		output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+		output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	}
 
 	name := output.file.Name()
@@ -210,17 +186,17 @@ func TestFileOutputAppendQueueLimitOverflow(t *testing.T) {
 	rnd := rand.Int63()
 	name := fmt.Sprintf("/tmp/%d", rnd)
 
-	output := NewFileOutput(name, &FileOutputConfig{Append: false, FlushInterval: time.Minute, QueueLimit: 2})
+	output := NewFileOutput(name, &settings.FileOutputConfig{Append: false, FlushInterval: time.Minute, QueueLimit: 2})
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name1 := output.file.Name()
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name2 := output.file.Name()
 
 	output.updateName()
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name3 := output.file.Name()
 
 	if name2 != name1 || name1 != fmt.Sprintf("/tmp/%d_0", rnd) {
@@ -239,17 +215,17 @@ func TestFileOutputAppendQueueLimitNoOverflow(t *testing.T) {
 	rnd := rand.Int63()
 	name := fmt.Sprintf("/tmp/%d", rnd)
 
-	output := NewFileOutput(name, &FileOutputConfig{Append: false, FlushInterval: time.Minute, QueueLimit: 3})
+	output := NewFileOutput(name, &settings.FileOutputConfig{Append: false, FlushInterval: time.Minute, QueueLimit: 3})
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name1 := output.file.Name()
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name2 := output.file.Name()
 
 	output.updateName()
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name3 := output.file.Name()
 
 	if name2 != name1 || name1 != fmt.Sprintf("/tmp/%d_0", rnd) {
@@ -268,17 +244,17 @@ func TestFileOutputAppendQueueLimitGzips(t *testing.T) {
 	rnd := rand.Int63()
 	name := fmt.Sprintf("/tmp/%d.gz", rnd)
 
-	output := NewFileOutput(name, &FileOutputConfig{Append: false, FlushInterval: time.Minute, QueueLimit: 2})
+	output := NewFileOutput(name, &settings.FileOutputConfig{Append: false, FlushInterval: time.Minute, QueueLimit: 2})
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name1 := output.file.Name()
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name2 := output.file.Name()
 
 	output.updateName()
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name3 := output.file.Name()
 
 	if name2 != name1 || name1 != fmt.Sprintf("/tmp/%d_0.gz", rnd) {
@@ -309,19 +285,19 @@ func TestFileOutputAppendSizeLimitOverflow(t *testing.T) {
 
 	message := []byte("1 1 1\r\ntest")
 
-	messageSize := len(message) + len(goreplay.payloadSeparator)
+	messageSize := len(message) + len(proto.PayloadSeparator)
 
-	output := NewFileOutput(name, &FileOutputConfig{Append: false, FlushInterval: time.Minute, SizeLimit: common.Size(2 * messageSize)})
+	output := NewFileOutput(name, &settings.FileOutputConfig{Append: false, FlushInterval: time.Minute, SizeLimit: common.Size(2 * messageSize)})
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name1 := output.file.Name()
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name2 := output.file.Name()
 
 	output.flush()
 
-	output.PluginWrite(&plugins.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
+	output.PluginWrite(&common.Message{Meta: []byte("1 1 1\r\n"), Data: []byte("test")})
 	name3 := output.file.Name()
 
 	if name2 != name1 || name1 != fmt.Sprintf("/tmp/%d_0", rnd) {
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -15,10 +16,15 @@ import (
 	"record-traffic-press/goreplay/glogs"
 	"record-traffic-press/goreplay/proto"
 	"record-traffic-press/goreplay/settings"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// errScheduleBacklogFull is counted for the requests dropped by an open-loop output
+var errScheduleBacklogFull = errors.New("open-loop backlog is full")
+
 type response struct {
 	payload       []byte
 	uuid          []byte
@@ -26,53 +32,70 @@ type response struct {
 	roundTripTime int64
 }
 
+// connectionOf returns the captured connection of a payload, the session of the outputs replaying
+// every connection over a connection of its own
+func connectionOf(msg *common.Message) string {
+	return string(proto.SessionID(proto.PayloadID(msg.Meta), proto.SessionByConnection))
+}
+
+// httpRequest is a request waiting for a worker
+type httpRequest struct {
+	msg      *common.Message
+	intended time.Time // intended send time, only set in open-loop mode
+}
+
 // HTTPOutput plugin manage pool of workers which send request to replayed server
 // By default workers pool is dynamic and starts with 1 worker or workerMin workers
 // You can specify maximum number of workers using `--output-http-workers`
+//
+// By default PluginWrite blocks once the queue is full, so a slow target slows down the
+// inputs and the latencies hide the requests which were never sent on time. In open-loop
+// mode (`--output-http-open-loop`) PluginWrite never blocks: the time a request is intended to
+// be sent is derived from its capture timestamp, relative to the first request and scaled by the
+// replay speed (SetSpeedFactor), so a slow input or emitter doesn't hide the delay either.
+// Latencies are measured from that time, requests above the backlog are dropped and counted as
+// errors, and ScheduleLag reports how far the sending lags.
+//
+// In session mode (`--output-http-session`) the requests of a session, a captured connection or
+// a client IP, are sent one after the other by a worker of their own over a single connection, in
+// captured order, so stateful flows like login, cart and checkout are replayed as they happened.
 type HTTPOutput struct {
-	activeWorkers  int64
-	config         *settings.HTTPOutputConfig
-	queueStats     *core.GorStat
-	elasticSearch  *common.ESPlugin
-	client         *HTTPClient
-	stopWorker     chan struct{}
-	queue          chan *common.Message
-	responses      chan *response
-	stop           chan bool // Channel used only to indicate goroutine should shutdown
-	workerSessions map[string]*httpWorker
+	activeWorkers int64
+	lag           int64 // nanoseconds, open-loop only
+	maxLag        int64
+	dropped       int64
+	config        *settings.HTTPOutputConfig
+	queueStats    *core.GorStat
+	latency       *core.LatencyRecorder
+	elasticSearch *common.ESPlugin
+	client        *HTTPClient
+	stopWorker    chan struct{}
+	queue         chan *httpRequest
+	responses     chan *response
+	stop          chan bool                             // Channel used only to indicate goroutine should shutdown
+	sessions      *core.SessionDispatcher[*httpRequest] // session mode only
+
+	// open-loop schedule: the capture timestamp and intended send time of the latest request
+	scheduleMu sync.Mutex
+	scheduleTs int64
+	scheduleAt time.Time
+	speed      float64
 }
 
-type httpWorker struct {
-	output       *HTTPOutput
-	client       *HTTPClient
-	lastActivity time.Time
-	queue        chan *common.Message
-	stop         chan bool
+// httpSessionWorker sends the requests of one session over a connection of its own
+type httpSessionWorker struct {
+	output *HTTPOutput
+	client *HTTPClient
 }
 
-func newHTTPWorker(output *HTTPOutput, queue chan *common.Message) *httpWorker {
-	client := NewHTTPClient(output.config)
-
-	w := &httpWorker{client: client, output: output}
-	if queue == nil {
-		w.queue = make(chan *common.Message, 100)
-	} else {
-		w.queue = queue
-	}
-	w.stop = make(chan bool)
-
-	go func() {
-		for {
-			select {
-			case msg := <-w.queue:
-				output.sendRequest(client, msg)
-			case <-w.stop:
-				return
-			}
-		}
-	}()
+// Handle sends a request of the session
+func (w *httpSessionWorker) Handle(req *httpRequest) {
+	w.output.sendRequest(w.client, req)
+}
 
-	return w
+// Close closes the connection of the session
+func (w *httpSessionWorker) Close() {
+	w.client.Client.CloseIdleConnections()
 }
 
 // NewHTTPOutput constructor for HTTPOutput
@@ -116,13 +139,23 @@ func NewHTTPOutput(address string, config *settings.HTTPOutputConfig) core.Plugi
 	if newConfig.WorkerTimeout <= 0 {
 		newConfig.WorkerTimeout = time.Second * 2
 	}
+	if newConfig.OpenLoopBacklog <= 0 {
+		newConfig.OpenLoopBacklog = 100000
+	}
 	o.config = newConfig
+	o.speed = 1
 	o.stop = make(chan bool)
 	if o.config.Stats {
 		o.queueStats = core.NewGorStat("output_http", o.config.StatsMs)
 	}
 
-	o.queue = make(chan *common.Message, o.config.QueueLen)
+	o.latency = core.NewLatencyRecorder(o.String(), o.config.LatencyWindow)
+
+	if o.config.OpenLoop {
+		o.queue = make(chan *httpRequest, o.config.OpenLoopBacklog)
+	} else {
+		o.queue = make(chan *httpRequest, o.config.QueueLen)
+	}
 	if o.config.TrackResponses {
 		o.responses = make(chan *response, o.config.QueueLen)
 	}
@@ -135,6 +168,15 @@ func NewHTTPOutput(address string, config *settings.HTTPOutputConfig) core.Plugi
 	}
 	o.client = NewHTTPClient(o.config)
 
+	if o.config.Session != "" {
+		o.sessions = core.NewSessionDispatcher(o.queue, func(req *httpRequest) string {
+			return string(proto.SessionID(proto.PayloadID(req.msg.Meta), o.config.Session))
+		}, func() core.SessionWorker[*httpRequest] {
+			return &httpSessionWorker{output: o, client: newSessionHTTPClient(o.config)}
+		})
+		return o
+	}
+
 	o.activeWorkers += int64(o.config.WorkersMin)
 	for i := 0; i < o.config.WorkersMin; i++ {
 		go o.startWorker()
@@ -171,45 +213,13 @@ func (o *HTTPOutput) workerMaster() {
 	}
 }
 
-func (o *HTTPOutput) sessionWorkerMaster() {
-	gc := time.Tick(time.Second)
-
-	for {
-		select {
-		case msg := <-o.queue:
-			id := proto.PayloadID(msg.Meta)
-			sessionID := string(id[0:20])
-			worker, ok := o.workerSessions[sessionID]
-
-			if !ok {
-				atomic.AddInt64(&o.activeWorkers, 1)
-				worker = newHTTPWorker(o, nil)
-				o.workerSessions[sessionID] = worker
-			}
-
-			worker.queue <- msg
-			worker.lastActivity = time.Now()
-		case <-gc:
-			now := time.Now()
-
-			for id, w := range o.workerSessions {
-				if !w.lastActivity.IsZero() && now.Sub(w.lastActivity) >= 120*time.Second {
-					w.stop <- true
-					delete(o.workerSessions, id)
-					atomic.AddInt64(&o.activeWorkers, -1)
-				}
-			}
-		}
-	}
-}
-
 func (o *HTTPOutput) startWorker() {
 	for {
 		select {
 		case <-o.stopWorker:
 			return
-		case msg := <-o.queue:
-			o.sendRequest(o.client, msg)
+		case req := <-o.queue:
+			o.sendRequest(o.client, req)
 		}
 	}
 }
@@ -220,17 +230,32 @@ func (o *HTTPOutput) PluginWrite(msg *common.Message) (n int, err error) {
 		return len(msg.Data), nil
 	}
 
-	select {
-	case <-o.stop:
-		return 0, common.ErrorStopped
-	case o.queue <- msg:
+	req := &httpRequest{msg: msg}
+	if o.config.OpenLoop {
+		req.intended = o.intendedTime(msg.Meta)
+		select {
+		case <-o.stop:
+			return 0, common.ErrorStopped
+		case o.queue <- req:
+		default:
+			// waiting for room would delay the requests behind this one
+			atomic.AddInt64(&o.dropped, 1)
+			o.latency.RecordError(proto.HTTPEndpoint(msg.Data), errScheduleBacklogFull)
+			return len(msg.Data) + len(msg.Meta), nil
+		}
+	} else {
+		select {
+		case <-o.stop:
+			return 0, common.ErrorStopped
+		case o.queue <- req:
+		}
 	}
 
 	if o.config.Stats {
 		o.queueStats.Write(len(o.queue))
 	}
 
-	if o.config.WorkersMax != o.config.WorkersMin {
+	if o.config.Session == "" && o.config.WorkersMax != o.config.WorkersMin {
 		workersCount := int(atomic.LoadInt64(&o.activeWorkers))
 
 		if len(o.queue) > workersCount {
@@ -270,26 +295,40 @@ func (o *HTTPOutput) PluginRead() (*common.Message, error) {
 	return &msg, nil
 }
 
-func (o *HTTPOutput) sendRequest(client *HTTPClient, msg *common.Message) {
+func (o *HTTPOutput) sendRequest(client *HTTPClient, req *httpRequest) {
+	msg := req.msg
 	if !proto.IsRequestPayload(msg.Meta) {
 		return
 	}
 
 	uuid := proto.PayloadID(msg.Meta)
+	endpoint := proto.HTTPEndpoint(msg.Data)
 	start := time.Now()
-	resp, err := client.Send(msg.Data)
+	// in open-loop mode the time spent behind the backlog is part of the latency
+	from := start
+	if !req.intended.IsZero() {
+		from = req.intended
+		o.observeLag(start.Sub(from))
+	}
+	status, resp, err := client.Send(msg.Data)
 	stop := time.Now()
 
 	if err != nil {
+		o.latency.RecordError(endpoint, err)
 		glogs.Debug(1, fmt.Sprintf("[HTTP-OUTPUT] error when sending: %q", err))
 		return
 	}
+	if status >= http.StatusInternalServerError {
+		o.latency.RecordFailure(endpoint, stop.Sub(from), fmt.Errorf("response status %d", status))
+	} else {
+		o.latency.Record(endpoint, stop.Sub(from))
+	}
 	if resp == nil {
 		return
 	}
 
 	if o.config.TrackResponses {
-		o.responses <- &response{resp, uuid, start.UnixNano(), stop.UnixNano() - start.UnixNano()}
+		o.responses <- &response{resp, uuid, from.UnixNano(), stop.UnixNano() - from.UnixNano()}
 	}
 
 	if o.elasticSearch != nil {
@@ -301,10 +340,101 @@ func (o *HTTPOutput) String() string {
 	return "HTTP output: " + o.config.RawURL
 }
 
+// SetSpeedFactor changes the replay speed the open-loop send times are scaled by, it follows the
+// speed of the inputs, e.g. set by a load profile
+func (o *HTTPOutput) SetSpeedFactor(factor float64) {
+	if factor <= 0 {
+		return
+	}
+	o.scheduleMu.Lock()
+	o.speed = factor
+	o.scheduleMu.Unlock()
+}
+
+// intendedTime returns the time a request is intended to be sent: the first request is sent
+// when it is written, the next ones after the capture interval since the previous one, scaled
+// by the speed at that time. Requests without a capture timestamp are intended to be sent now.
+func (o *HTTPOutput) intendedTime(payloadMeta []byte) time.Time {
+	now := time.Now()
+	meta := proto.PayloadMeta(payloadMeta)
+	if len(meta) < 3 {
+		return now
+	}
+	ts, err := strconv.ParseInt(string(meta[2]), 10, 64)
+	if err != nil || ts <= 0 {
+		return now
+	}
+
+	o.scheduleMu.Lock()
+	defer o.scheduleMu.Unlock()
+
+	if o.scheduleAt.IsZero() {
+		o.scheduleTs, o.scheduleAt = ts, now
+		return now
+	}
+	intended := o.scheduleAt.Add(time.Duration(float64(ts-o.scheduleTs) / o.speed))
+	if ts > o.scheduleTs {
+		o.scheduleTs, o.scheduleAt = ts, intended
+	}
+	// the inputs may wake up a little early
+	if intended.After(now) {
+		return now
+	}
+	return intended
+}
+
+func (o *HTTPOutput) observeLag(lag time.Duration) {
+	atomic.StoreInt64(&o.lag, int64(lag))
+	for {
+		current := atomic.LoadInt64(&o.maxLag)
+		if int64(lag) <= current || atomic.CompareAndSwapInt64(&o.maxLag, current, int64(lag)) {
+			return
+		}
+	}
+}
+
+// LatencySnapshots returns the raw latencies of the replayed requests, to be merged with other processes
+func (o *HTTPOutput) LatencySnapshots() []core.LatencySnapshot {
+	return o.latency.Snapshots()
+}
+
+// LatencyTotal returns the latency percentiles of all the replayed requests
+func (o *HTTPOutput) LatencyTotal() core.LatencyReport {
+	return o.latency.Total()
+}
+
+// ScheduleLag returns how far an open-loop output lags behind the intended send times
+func (o *HTTPOutput) ScheduleLag() (core.ScheduleLag, bool) {
+	if !o.config.OpenLoop {
+		return core.ScheduleLag{}, false
+	}
+	return core.ScheduleLag{
+		Current: time.Duration(atomic.LoadInt64(&o.lag)),
+		Max:     time.Duration(atomic.LoadInt64(&o.maxLag)),
+		Backlog: len(o.queue),
+		Dropped: atomic.LoadInt64(&o.dropped),
+	}, true
+}
+
+// LatencyReports returns the latency percentiles of the replayed requests per "METHOD /path"
+func (o *HTTPOutput) LatencyReports() []core.LatencyReport {
+	return o.latency.Reports()
+}
+
 // Close closes the data channel so that data
 func (o *HTTPOutput) Close() error {
 	close(o.stop)
 	close(o.stopWorker)
+	if o.sessions != nil {
+		o.sessions.Close()
+	}
+	o.latency.Close()
+	if lag, ok := o.ScheduleLag(); ok {
+		glogs.Debug(1, "[HTTP-OUTPUT] open-loop schedule lag max:", lag.Max, "backlog:", lag.Backlog, "dropped:", lag.Dropped)
+	}
+	if o.queueStats != nil {
+		o.queueStats.Close()
+	}
 	return nil
 }
 
@@ -342,19 +472,33 @@ func NewHTTPClient(config *settings.HTTPOutputConfig) *HTTPClient {
 	return client
 }
 
-// Send sends an http request using client created by NewHTTPClient
-func (c *HTTPClient) Send(data []byte) ([]byte, error) {
+// newSessionHTTPClient returns a client with a single connection of its own to the target,
+// the requests of a session are sent over the same connection like they were captured
+func newSessionHTTPClient(config *settings.HTTPOutputConfig) *HTTPClient {
+	client := NewHTTPClient(config)
+	transport, ok := client.Client.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	transport.MaxConnsPerHost = 1
+	transport.MaxIdleConnsPerHost = 1
+	client.Client.Transport = transport
+	return client
+}
+
+// Send sends an http request using client created by NewHTTPClient, the response status is returned
+// even when the responses are not tracked
+func (c *HTTPClient) Send(data []byte) (status int, response []byte, err error) {
 	var req *http.Request
 	var resp *http.Response
-	var err error
 
 	req, err = http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 	// we don't send CONNECT or OPTIONS request
 	if req.Method == http.MethodConnect {
-		return nil, nil
+		return 0, nil, nil
 	}
 
 	if !c.config.OriginalHost {
@@ -376,11 +520,12 @@ func (c *HTTPClient) Send(data []byte) ([]byte, error) {
 
 	resp, err = c.Client.Do(req)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 	if c.config.TrackResponses {
-		return httputil.DumpResponse(resp, true)
+		response, err = httputil.DumpResponse(resp, true)
+		return resp.StatusCode, response, err
 	}
 	_ = resp.Body.Close()
-	return nil, nil
+	return resp.StatusCode, nil, nil
 }
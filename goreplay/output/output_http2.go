@@ -0,0 +1,242 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/core"
+	"record-traffic-press/goreplay/glogs"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+var (
+	_ core.PluginReadWriter = (*HTTP2Output)(nil)
+	_ core.LatencyReporter  = (*HTTP2Output)(nil)
+)
+
+// HTTP2Output replays the HTTP/2 streams captured by the raw input with the http2 protocol, e.g. gRPC calls.
+// The streams are multiplexed over one connection to the target, h2c for a http:// address and TLS for https://.
+// The trailers of the responses are kept, so the gRPC status is part of the replayed response.
+type HTTP2Output struct {
+	address   string
+	target    *url.URL
+	config    *settings.HTTP2OutputConfig
+	transport *http2.Transport
+	client    *http.Client
+	queue     chan *common.Message
+	responses chan response
+	latency   *core.LatencyRecorder
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewHTTP2Output constructor for HTTP2Output
+func NewHTTP2Output(address string, config *settings.HTTP2OutputConfig) (core.PluginReadWriter, error) {
+	o := new(HTTP2Output)
+
+	c := *config
+	if c.Workers <= 0 {
+		c.Workers = 10
+	}
+	if c.Timeout < time.Millisecond*100 {
+		c.Timeout = 5 * time.Second
+	}
+
+	raw := address
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	target, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("[OUTPUT-HTTP2] parse HTTP/2 output URL error: %w", err)
+	}
+
+	o.transport = &http2.Transport{}
+	if target.Scheme == "https" {
+		o.transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: c.SkipVerify}
+	} else {
+		// h2c, HTTP/2 without TLS
+		o.transport.AllowHTTP = true
+		o.transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+
+	o.address = address
+	o.target = target
+	o.config = &c
+	o.client = &http.Client{Transport: o.transport, Timeout: c.Timeout}
+	o.queue = make(chan *common.Message, 1000)
+	o.responses = make(chan response, 1000)
+	o.quit = make(chan struct{})
+	o.latency = core.NewLatencyRecorder(o.String(), c.LatencyWindow)
+
+	for i := 0; i < c.Workers; i++ {
+		go o.worker()
+	}
+
+	return o, nil
+}
+
+// PluginWrite writes a message to this plugin
+func (o *HTTP2Output) PluginWrite(msg *common.Message) (n int, err error) {
+	if !proto.IsRequestPayload(msg.Meta) {
+		return len(msg.Data), nil
+	}
+
+	req := &common.Message{Meta: append([]byte{}, msg.Meta...), Data: append([]byte{}, msg.Data...)}
+	select {
+	case <-o.quit:
+		return 0, common.ErrorStopped
+	case o.queue <- req:
+	}
+
+	return len(msg.Data) + len(msg.Meta), nil
+}
+
+// PluginRead reads a message from this plugin
+func (o *HTTP2Output) PluginRead() (*common.Message, error) {
+	var resp response
+	var msg common.Message
+	select {
+	case <-o.quit:
+		return nil, common.ErrorStopped
+	case resp = <-o.responses:
+	}
+	msg.Data = resp.payload
+	msg.Meta = proto.PayloadHeader(proto.ReplayedResponsePayload, resp.uuid, resp.startedAt, resp.roundTripTime)
+
+	return &msg, nil
+}
+
+func (o *HTTP2Output) String() string {
+	return "HTTP/2 output: " + o.address
+}
+
+// LatencyReports returns the latency percentiles of the replayed streams per "METHOD /path"
+func (o *HTTP2Output) LatencyReports() []core.LatencyReport {
+	return o.latency.Reports()
+}
+
+// LatencySnapshots returns the raw latencies of the replayed streams, to be merged with other processes
+func (o *HTTP2Output) LatencySnapshots() []core.LatencySnapshot {
+	return o.latency.Snapshots()
+}
+
+// LatencyTotal returns the latency percentiles of all the replayed streams
+func (o *HTTP2Output) LatencyTotal() core.LatencyReport {
+	return o.latency.Total()
+}
+
+// Close closes this plugin and its connections
+func (o *HTTP2Output) Close() error {
+	o.closeOnce.Do(func() {
+		close(o.quit)
+		o.transport.CloseIdleConnections()
+		o.latency.Close()
+	})
+	return nil
+}
+
+func (o *HTTP2Output) worker() {
+	for {
+		select {
+		case <-o.quit:
+			return
+		case msg := <-o.queue:
+			o.send(msg)
+		}
+	}
+}
+
+func (o *HTTP2Output) send(msg *common.Message) {
+	uuid := proto.PayloadID(msg.Meta)
+	endpoint := proto.HTTPEndpoint(msg.Data)
+
+	req, err := o.request(msg.Data)
+	if err != nil {
+		o.latency.RecordError(endpoint, err)
+		glogs.Debug(1, "[HTTP2-OUTPUT] invalid request:", err)
+		return
+	}
+
+	start := time.Now()
+	resp, err := o.client.Do(req)
+	if err != nil {
+		o.latency.RecordError(endpoint, err)
+		glogs.Debug(1, fmt.Sprintf("[HTTP2-OUTPUT] error when sending: %q", err))
+		return
+	}
+	// the trailers are only known once the body is read
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	stop := time.Now()
+	if err != nil {
+		o.latency.RecordError(endpoint, err)
+		glogs.Debug(1, fmt.Sprintf("[HTTP2-OUTPUT] error when reading response: %q", err))
+		return
+	}
+
+	// a gRPC error without response message comes in the headers, "trailers-only"
+	grpcStatus := resp.Trailer.Get("Grpc-Status")
+	if grpcStatus == "" {
+		grpcStatus = resp.Header.Get("Grpc-Status")
+	}
+	switch {
+	case resp.StatusCode >= 500:
+		o.latency.RecordFailure(endpoint, stop.Sub(start), fmt.Errorf("response status %d", resp.StatusCode))
+	case grpcStatus != "" && grpcStatus != "0":
+		o.latency.RecordFailure(endpoint, stop.Sub(start), fmt.Errorf("grpc status %s", grpcStatus))
+	default:
+		o.latency.Record(endpoint, stop.Sub(start))
+	}
+
+	if o.config.Debug {
+		glogs.Debug(1, "[HTTP2-OUTPUT]", endpoint, "status:", resp.StatusCode, "grpc-status:", grpcStatus)
+	}
+
+	if !o.config.TrackResponses {
+		return
+	}
+	payload := proto.HTTP2Response(resp.StatusCode, resp.Header, resp.Trailer, body)
+	select {
+	case <-o.quit:
+	case o.responses <- response{payload, uuid, start.UnixNano(), stop.UnixNano() - start.UnixNano()}:
+	}
+}
+
+// request rebuilds the request of a HTTP/2 stream captured as text, see proto.HTTP2Request
+func (o *HTTP2Output) request(payload []byte) (*http.Request, error) {
+	if !proto.HasHTTP2RequestTitle(payload) {
+		return nil, errors.New("not a HTTP request")
+	}
+
+	target := o.target.Scheme + "://" + o.target.Host + string(proto.Path(payload))
+	req, err := http.NewRequest(string(proto.Method(payload)), target, bytes.NewReader(proto.Body(payload)))
+	if err != nil {
+		return nil, err
+	}
+
+	header, trailer := proto.SplitHTTP2Trailer(payload)
+	// the authority and the length are the ones of the replayed request
+	header.Del("Host")
+	header.Del("Content-Length")
+	req.Header = header
+	req.Trailer = trailer
+
+	return req, nil
+}
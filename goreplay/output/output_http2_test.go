@@ -0,0 +1,116 @@
+package output
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// grpcEchoHandler answers like a gRPC server, the request message is echoed back and
+// the "Fail" method ends with a NOT_FOUND status
+func grpcEchoHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" || r.Header.Get("Te") != "trailers" {
+			t.Errorf("Not a gRPC request: %s %v", r.Proto, r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write(body)
+		if strings.HasSuffix(r.URL.Path, "/Fail") {
+			w.Header().Set("Grpc-Status", "5")
+		} else {
+			w.Header().Set("Grpc-Status", "0")
+		}
+	})
+}
+
+func grpcRequest(method string, message []byte) []byte {
+	header := http.Header{"Content-Type": {"application/grpc"}, "Te": {"trailers"}}
+	return proto.HTTP2Request("POST", "/helloworld.Greeter/"+method, "captured:50051", header, nil, message)
+}
+
+func readHTTP2Responses(t *testing.T, output *HTTP2Output, count int) map[string][]byte {
+	responses := make(map[string][]byte)
+	for len(responses) < count {
+		done := make(chan *common.Message, 1)
+		go func() {
+			msg, _ := output.PluginRead()
+			done <- msg
+		}()
+
+		select {
+		case msg := <-done:
+			if msg.Meta[0] != proto.ReplayedResponsePayload {
+				t.Errorf("Wrong payload type: %q", msg.Meta)
+			}
+			responses[string(proto.PayloadID(msg.Meta))] = msg.Data
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for responses")
+		}
+	}
+	return responses
+}
+
+func testHTTP2Output(t *testing.T, address string, config *settings.HTTP2OutputConfig) {
+	plugin, err := NewHTTP2Output(address, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := plugin.(*HTTP2Output)
+	defer output.Close()
+
+	message := []byte{0, 0, 0, 0, 3, 0x0a, 0x01, 'x'}
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte("a"), 1, -1), Data: grpcRequest("SayHello", message)})
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte("b"), 1, -1), Data: grpcRequest("Fail", message)})
+	// responses are not replayed
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.ResponsePayload, []byte("a"), 1, -1), Data: grpcRequest("SayHello", message)})
+
+	responses := readHTTP2Responses(t, output, 2)
+	for id, status := range map[string]string{"a": "0", "b": "5"} {
+		resp := responses[id]
+		if string(proto.Status(resp)) != "200" || !bytes.Equal(proto.Body(resp), message) {
+			t.Errorf("Unexpected response %q", resp)
+		}
+		if _, trailer := proto.SplitHTTP2Trailer(resp); trailer.Get("Grpc-Status") != status {
+			t.Errorf("Expected grpc status %s, got %q", status, resp)
+		}
+	}
+
+	if total := output.LatencyTotal(); total.Requests != 2 || total.Errors != 1 {
+		t.Errorf("Expected 2 requests and 1 failure, got %+v", total)
+	}
+}
+
+func TestHTTP2OutputH2C(t *testing.T) {
+	server := httptest.NewServer(h2c.NewHandler(grpcEchoHandler(t), &http2.Server{}))
+	defer server.Close()
+
+	testHTTP2Output(t, strings.TrimPrefix(server.URL, "http://"), &settings.HTTP2OutputConfig{TrackResponses: true})
+}
+
+func TestHTTP2OutputTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(grpcEchoHandler(t))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	testHTTP2Output(t, server.URL, &settings.HTTP2OutputConfig{TrackResponses: true, SkipVerify: true})
+}
+
+func TestHTTP2OutputInvalidAddress(t *testing.T) {
+	if _, err := NewHTTP2Output("http://[::1", &settings.HTTP2OutputConfig{}); err == nil {
+		t.Error("Should fail on an invalid address")
+	}
+}
@@ -0,0 +1,118 @@
+package output
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/core"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHTTPOutputOpenLoop(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	output := NewHTTPOutput(server.URL, &settings.HTTPOutputConfig{OpenLoop: true, WorkersMin: 1, WorkersMax: 1}).(*HTTPOutput)
+	defer output.Close()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		id := []byte(strconv.Itoa(i))
+		output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, id, 1, -1), Data: []byte("GET / HTTP/1.1\r\n\r\n")})
+	}
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Errorf("writes must not wait for the target, took %s", elapsed)
+	}
+
+	var reports []core.LatencyReport
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		reports = output.LatencyReports()
+		if len(reports) == 1 && reports[0].Count == 5 {
+			break
+		}
+	}
+	if len(reports) != 1 || reports[0].Count != 5 {
+		t.Fatalf("expected 5 requests, got %+v", reports)
+	}
+
+	// the last request waited behind the 4 others
+	if reports[0].Max < 200*time.Millisecond {
+		t.Errorf("expected the latency to include the wait behind the backlog, max is %s", reports[0].Max)
+	}
+
+	lag, ok := output.ScheduleLag()
+	if !ok {
+		t.Fatal("expected an open-loop output")
+	}
+	if lag.Max < 150*time.Millisecond || lag.Dropped != 0 {
+		t.Errorf("unexpected schedule lag %+v", lag)
+	}
+}
+
+func TestHTTPOutputOpenLoopBacklog(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+
+	output := NewHTTPOutput(server.URL, &settings.HTTPOutputConfig{OpenLoop: true, OpenLoopBacklog: 1, WorkersMin: 1, WorkersMax: 1}).(*HTTPOutput)
+	defer output.Close()
+
+	for i := 0; i < 5; i++ {
+		id := []byte(strconv.Itoa(i))
+		output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, id, 1, -1), Data: []byte("GET / HTTP/1.1\r\n\r\n")})
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// one request is being sent, one waits in the backlog
+	lag, _ := output.ScheduleLag()
+	if lag.Dropped != 3 || lag.Backlog != 1 {
+		t.Errorf("expected 3 dropped requests and 1 waiting, got %+v", lag)
+	}
+
+	reports := output.LatencyReports()
+	if len(reports) != 1 || reports[0].Errors != 3 {
+		t.Errorf("expected the dropped requests to be counted as errors, got %+v", reports)
+	}
+}
+
+func TestHTTPOutputOpenLoopSchedule(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	output := NewHTTPOutput(server.URL, &settings.HTTPOutputConfig{OpenLoop: true, WorkersMin: 1, WorkersMax: 1}).(*HTTPOutput)
+	defer output.Close()
+	output.SetSpeedFactor(2)
+
+	// captured 100ms apart, so at twice the speed the second request is intended 50ms after the first
+	captured := time.Now().UnixNano()
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte("a"), captured, -1), Data: []byte("GET / HTTP/1.1\r\n\r\n")})
+	time.Sleep(200 * time.Millisecond)
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte("b"), captured+int64(100*time.Millisecond), -1), Data: []byte("GET / HTTP/1.1\r\n\r\n")})
+
+	var reports []core.LatencyReport
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if reports = output.LatencyReports(); len(reports) == 1 && reports[0].Count == 2 {
+			break
+		}
+	}
+	if len(reports) != 1 || reports[0].Count != 2 {
+		t.Fatalf("expected 2 requests, got %+v", reports)
+	}
+
+	// the second request was written 150ms after its intended send time
+	if lag, _ := output.ScheduleLag(); lag.Max < 140*time.Millisecond || lag.Max > time.Second {
+		t.Errorf("expected a lag of about 150ms, got %+v", lag)
+	}
+	if reports[0].Max < 140*time.Millisecond {
+		t.Errorf("expected the latency to be measured from the intended send time, max is %s", reports[0].Max)
+	}
+}
@@ -0,0 +1,79 @@
+package output
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPOutputSession(t *testing.T) {
+	var mu sync.Mutex
+	paths := make(map[string][]string) // session -> paths in arrival order
+	conns := make(map[string]map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// the requests of a session would overtake each other without a worker of their own
+		time.Sleep(time.Duration(len(req.URL.Path)%3) * time.Millisecond)
+
+		session := req.Header.Get("Session")
+		mu.Lock()
+		paths[session] = append(paths[session], req.URL.Path)
+		if conns[session] == nil {
+			conns[session] = make(map[string]bool)
+		}
+		conns[session][req.RemoteAddr] = true
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	output := NewHTTPOutput(server.URL, &settings.HTTPOutputConfig{Session: proto.SessionByConnection}).(*HTTPOutput)
+	defer output.Close()
+
+	const sessions, steps = 5, 20
+	for step := 0; step < steps; step++ {
+		for session := 0; session < sessions; session++ {
+			// the connection part of the ID is the same, the ack differs for every request
+			id := []byte(fmt.Sprintf("%016x%08x", session, step))
+			data := fmt.Sprintf("GET /%d%s HTTP/1.1\r\nSession: %d\r\n\r\n", step, "xx"[:step%3], session)
+			output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, id, 1, -1), Data: []byte(data)})
+		}
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		done := 0
+		for _, p := range paths {
+			done += len(p)
+		}
+		mu.Unlock()
+		if done == sessions*steps {
+			break
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != sessions {
+		t.Fatalf("expected %d sessions, got %d", sessions, len(paths))
+	}
+	for session, p := range paths {
+		if len(p) != steps {
+			t.Errorf("session %s: expected %d requests, got %d", session, steps, len(p))
+			continue
+		}
+		for step, path := range p {
+			if want := fmt.Sprintf("/%d%s", step, "xx"[:step%3]); path != want {
+				t.Errorf("session %s: expected %s at step %d, got %s", session, want, step, path)
+				break
+			}
+		}
+		if len(conns[session]) != 1 {
+			t.Errorf("session %s: expected a single connection, got %d", session, len(conns[session]))
+		}
+	}
+}
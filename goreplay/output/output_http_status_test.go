@@ -0,0 +1,35 @@
+package output
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"testing"
+	"time"
+)
+
+func TestHTTPOutputServerErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	// the responses are not tracked, the status must count anyway
+	output := NewHTTPOutput(server.URL, &settings.HTTPOutputConfig{WorkersMin: 1, WorkersMax: 1}).(*HTTPOutput)
+	defer output.Close()
+
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte("a"), 1, -1), Data: []byte("GET / HTTP/1.1\r\n\r\n")})
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte("b"), 1, -1), Data: []byte("GET /fail HTTP/1.1\r\n\r\n")})
+
+	total := output.LatencyTotal()
+	for deadline := time.Now().Add(2 * time.Second); total.Requests < 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		total = output.LatencyTotal()
+	}
+	if total.Requests != 2 || total.Errors != 1 {
+		t.Errorf("Expected 2 requests and 1 failure, got %+v", total)
+	}
+}
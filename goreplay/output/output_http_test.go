@@ -4,21 +4,41 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	_ "net/http/httputil"
-	"record-traffic-press/goreplay"
-	"record-traffic-press/goreplay/bootstrap"
+	"record-traffic-press/goreplay/common"
 	"record-traffic-press/goreplay/core"
-	"record-traffic-press/goreplay/plugins"
+	"record-traffic-press/goreplay/proto"
 	"record-traffic-press/goreplay/settings"
 	"sync"
 	"testing"
 )
 
+const (
+	testGET     = "GET / HTTP/1.1\r\n\r\n"
+	testPOST    = "POST /pub/WWW/ HTTP/1.1\r\nContent-Length: 7\r\nHost: www.w3.org\r\n\r\na=1&b=2"
+	testOPTIONS = "OPTIONS / HTTP/1.1\r\nHost: www.w3.org\r\n\r\n"
+)
+
+// requestMessage frames a request the way the inputs do, with a fresh ID
+func requestMessage(data string) *common.Message {
+	return &common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, proto.Uuid(), 1, -1), Data: []byte(data)}
+}
+
+// writeRequests writes the requests rewritten by modifier, skipping the ones it filters out, like the emitter
+func writeRequests(output core.PluginWriter, modifier *core.HTTPModifier, requests ...string) {
+	for _, data := range requests {
+		msg := requestMessage(data)
+		if modifier != nil {
+			if msg.Data = modifier.Rewrite(msg.Data); len(msg.Data) == 0 {
+				continue
+			}
+		}
+		output.PluginWrite(msg)
+	}
+}
+
 func TestHTTPOutput(t *testing.T) {
 	wg := new(sync.WaitGroup)
 
-	input := bootstrap.NewTestInput()
-
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		if req.Header.Get("User-Agent") != "Gor" {
 			t.Error("Wrong header")
@@ -41,43 +61,27 @@ func TestHTTPOutput(t *testing.T) {
 	}))
 	defer server.Close()
 
-	headers := settings.HTTPHeaders{goreplay.httpHeader{"User-Agent", "Gor"}}
-	methods := settings.HTTPMethods{[]byte("GET"), []byte("PUT"), []byte("POST")}
-	settings.Settings.ModifierConfig = settings.HTTPModifierConfig{Headers: headers, Methods: methods}
-
-	httpOutput := NewHTTPOutput(server.URL, &HTTPOutputConfig{TrackResponses: false})
-	output := bootstrap.NewTestOutput(func(*plugins.Message) {
-		wg.Done()
-	})
-
-	plugins := &core.InOutPlugins{
-		Inputs:  []core.PluginReader{input},
-		Outputs: []core.PluginWriter{httpOutput, output},
-	}
-	plugins.All = append(plugins.All, input, output, httpOutput)
+	config := settings.HTTPModifierConfig{}
+	config.Headers.Set("User-Agent: Gor")
+	config.Methods.Set("GET")
+	config.Methods.Set("PUT")
+	config.Methods.Set("POST")
+	modifier := core.NewHTTPModifier(&config)
 
-	emitter := bootstrap.NewEmitter()
-	go emitter.Start(plugins, settings.Settings.Middleware)
+	output := NewHTTPOutput(server.URL, &settings.HTTPOutputConfig{TrackResponses: false})
+	defer output.(*HTTPOutput).Close()
 
 	for i := 0; i < 10; i++ {
-		// 2 http-output, 2 - test output request
-		wg.Add(4) // OPTIONS should be ignored
-		input.EmitPOST()
-		input.EmitOPTIONS()
-		input.EmitGET()
+		wg.Add(2) // OPTIONS should be ignored
+		writeRequests(output, modifier, testPOST, testOPTIONS, testGET)
 	}
 
 	wg.Wait()
-	emitter.Close()
-
-	settings.Settings.ModifierConfig = settings.HTTPModifierConfig{}
 }
 
 func TestHTTPOutputKeepOriginalHost(t *testing.T) {
 	wg := new(sync.WaitGroup)
 
-	input := bootstrap.NewTestInput()
-
 	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		if req.Host != "custom-host.com" {
 			t.Error("Wrong header", req.Host)
@@ -87,26 +91,17 @@ func TestHTTPOutputKeepOriginalHost(t *testing.T) {
 	}))
 	defer server.Close()
 
-	headers := settings.HTTPHeaders{goreplay.httpHeader{"Host", "custom-host.com"}}
-	settings.Settings.ModifierConfig = settings.HTTPModifierConfig{Headers: headers}
+	config := settings.HTTPModifierConfig{}
+	config.Headers.Set("Host: custom-host.com")
+	modifier := core.NewHTTPModifier(&config)
 
-	output := NewHTTPOutput(server.URL, &HTTPOutputConfig{OriginalHost: true, SkipVerify: true})
-
-	plugins := &core.InOutPlugins{
-		Inputs:  []core.PluginReader{input},
-		Outputs: []core.PluginWriter{output},
-	}
-	plugins.All = append(plugins.All, input, output)
-
-	emitter := bootstrap.NewEmitter()
-	go emitter.Start(plugins, settings.Settings.Middleware)
+	output := NewHTTPOutput(server.URL, &settings.HTTPOutputConfig{OriginalHost: true, SkipVerify: true})
+	defer output.(*HTTPOutput).Close()
 
 	wg.Add(1)
-	input.EmitGET()
+	writeRequests(output, modifier, testGET)
 
 	wg.Wait()
-	emitter.Close()
-	settings.Settings.ModifierConfig = settings.HTTPModifierConfig{}
 }
 
 func TestHTTPOutputSSL(t *testing.T) {
@@ -116,72 +111,15 @@ func TestHTTPOutputSSL(t *testing.T) {
 	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		wg.Done()
 	}))
-
-	input := bootstrap.NewTestInput()
-	output := NewHTTPOutput(server.URL, &HTTPOutputConfig{SkipVerify: true})
-
-	plugins := &core.InOutPlugins{
-		Inputs:  []core.PluginReader{input},
-		Outputs: []core.PluginWriter{output},
-	}
-	plugins.All = append(plugins.All, input, output)
-
-	emitter := bootstrap.NewEmitter()
-	go emitter.Start(plugins, settings.Settings.Middleware)
-
-	wg.Add(2)
-
-	input.EmitPOST()
-	input.EmitGET()
-
-	wg.Wait()
-	emitter.Close()
-}
-
-func TestHTTPOutputSessions(t *testing.T) {
-	wg := new(sync.WaitGroup)
-
-	input := bootstrap.NewTestInput()
-	input.skipHeader = true
-
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		wg.Done()
-	}))
 	defer server.Close()
 
-	settings.Settings.RecognizeTCPSessions = true
-	settings.Settings.SplitOutput = true
-	output := NewHTTPOutput(server.URL, &HTTPOutputConfig{})
-
-	plugins := &core.InOutPlugins{
-		Inputs:  []core.PluginReader{input},
-		Outputs: []core.PluginWriter{output},
-	}
-	plugins.All = append(plugins.All, input, output)
-	emitter := bootstrap.NewEmitter()
-	go emitter.Start(plugins, settings.Settings.Middleware)
-
-	uuid1 := []byte("1234567890123456789a0000")
-	uuid2 := []byte("1234567890123456789d0000")
-
-	for i := 0; i < 10; i++ {
-		wg.Add(1) // OPTIONS should be ignored
-		copy(uuid1[20:], goreplay.randByte(4))
-		input.EmitBytes([]byte("1 " + string(uuid1) + " 1\n" + "GET / HTTP/1.1\r\n\r\n"))
-	}
+	output := NewHTTPOutput(server.URL, &settings.HTTPOutputConfig{SkipVerify: true})
+	defer output.(*HTTPOutput).Close()
 
-	for i := 0; i < 10; i++ {
-		wg.Add(1) // OPTIONS should be ignored
-		copy(uuid2[20:], goreplay.randByte(4))
-		input.EmitBytes([]byte("1 " + string(uuid2) + " 1\n" + "GET / HTTP/1.1\r\n\r\n"))
-	}
+	wg.Add(2)
+	writeRequests(output, nil, testPOST, testGET)
 
 	wg.Wait()
-
-	emitter.Close()
-
-	settings.Settings.RecognizeTCPSessions = false
-	settings.Settings.SplitOutput = false
 }
 
 func BenchmarkHTTPOutput(b *testing.B) {
@@ -192,25 +130,15 @@ func BenchmarkHTTPOutput(b *testing.B) {
 	}))
 	defer server.Close()
 
-	input := bootstrap.NewTestInput()
-	output := NewHTTPOutput(server.URL, &HTTPOutputConfig{WorkersMax: 1})
-
-	plugins := &core.InOutPlugins{
-		Inputs:  []core.PluginReader{input},
-		Outputs: []core.PluginWriter{output},
-	}
-	plugins.All = append(plugins.All, input, output)
-
-	emitter := bootstrap.NewEmitter()
-	go emitter.Start(plugins, settings.Settings.Middleware)
+	output := NewHTTPOutput(server.URL, &settings.HTTPOutputConfig{WorkersMax: 1})
+	defer output.(*HTTPOutput).Close()
 
 	for i := 0; i < b.N; i++ {
 		wg.Add(1)
-		input.EmitPOST()
+		writeRequests(output, nil, testPOST)
 	}
 
 	wg.Wait()
-	emitter.Close()
 }
 
 func BenchmarkHTTPOutputTLS(b *testing.B) {
@@ -221,23 +149,13 @@ func BenchmarkHTTPOutputTLS(b *testing.B) {
 	}))
 	defer server.Close()
 
-	input := bootstrap.NewTestInput()
-	output := NewHTTPOutput(server.URL, &HTTPOutputConfig{SkipVerify: true, WorkersMax: 1})
-
-	plugins := &core.InOutPlugins{
-		Inputs:  []core.PluginReader{input},
-		Outputs: []core.PluginWriter{output},
-	}
-	plugins.All = append(plugins.All, input, output)
-
-	emitter := bootstrap.NewEmitter()
-	go emitter.Start(plugins, settings.Settings.Middleware)
+	output := NewHTTPOutput(server.URL, &settings.HTTPOutputConfig{SkipVerify: true, WorkersMax: 1})
+	defer output.(*HTTPOutput).Close()
 
 	for i := 0; i < b.N; i++ {
 		wg.Add(1)
-		input.EmitPOST()
+		writeRequests(output, nil, testPOST)
 	}
 
 	wg.Wait()
-	emitter.Close()
 }
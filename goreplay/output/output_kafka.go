@@ -0,0 +1,169 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/core"
+	"record-traffic-press/goreplay/glogs"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// KafkaOutputFrequency in milliseconds
+const KafkaOutputFrequency = 500
+
+// KafkaOutput is used for sending payloads to kafka, either raw or in the JSON format read by KafkaInput
+type KafkaOutput struct {
+	// Keep this as first element of struct because it guarantees 64bit
+	// alignment. atomic.* functions crash on 32bit machines if operand is not
+	// aligned at 64bit. See https://github.com/golang/go/issues/599
+	errors int64 // messages the producer failed to send
+
+	config       *settings.OutputKafkaConfig
+	producer     sarama.AsyncProducer
+	syncProducer sarama.SyncProducer
+}
+
+// NewKafkaOutput creates instance of kafka producer client with TLS config
+func NewKafkaOutput(_ string, config *settings.OutputKafkaConfig, tlsConfig *settings.KafkaTLSConfig) (core.PluginWriter, error) {
+	o := &KafkaOutput{
+		config:       config,
+		producer:     config.Producer,
+		syncProducer: config.SyncProducer,
+	}
+
+	if config.Key != settings.KafkaKeyNone && config.Key != settings.KafkaKeyPayloadID {
+		return nil, fmt.Errorf("unknown Kafka output key %q, expected %q or %q", config.Key, settings.KafkaKeyNone, settings.KafkaKeyPayloadID)
+	}
+
+	if (config.Sync && o.syncProducer == nil) || (!config.Sync && o.producer == nil) {
+		c, err := settings.NewKafkaConfig(&config.SASLConfig, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		c.Producer.RequiredAcks = sarama.WaitForLocal
+		c.Producer.Compression = sarama.CompressionSnappy
+		c.Producer.Flush.Frequency = KafkaOutputFrequency * time.Millisecond
+
+		brokerList := strings.Split(config.Host, ",")
+
+		if config.Sync {
+			// sync producer requires successes to be reported
+			c.Producer.Return.Successes = true
+			o.syncProducer, err = sarama.NewSyncProducer(brokerList, c)
+		} else {
+			o.producer, err = sarama.NewAsyncProducer(brokerList, c)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to start Sarama(Kafka) producer: %w", err)
+		}
+	}
+
+	if !config.Sync {
+		// Start infinite loop for tracking errors for kafka producer.
+		go o.ErrorHandler()
+	}
+
+	return o, nil
+}
+
+// ErrorHandler should receive errors
+func (o *KafkaOutput) ErrorHandler() {
+	for err := range o.producer.Errors() {
+		atomic.AddInt64(&o.errors, 1)
+		glogs.Debug(1, "Failed to write access log entry:", err)
+	}
+}
+
+// Errors returns the number of messages the producer failed to send
+func (o *KafkaOutput) Errors() int64 {
+	return atomic.LoadInt64(&o.errors)
+}
+
+// PluginWrite writes a message to this plugin
+func (o *KafkaOutput) PluginWrite(msg *common.Message) (n int, err error) {
+	var message sarama.ByteEncoder
+
+	if !o.config.UseJSON {
+		message = append(append([]byte{}, msg.Meta...), msg.Data...)
+	} else {
+		meta := proto.PayloadMeta(msg.Meta)
+		// the JSON format only describes requests, see proto.KafkaMessage
+		if len(meta) < 3 || meta[0][0] != proto.RequestPayload {
+			return 0, nil
+		}
+
+		message, err = json.Marshal(kafkaMessage(meta, msg.Data))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	producerMessage := &sarama.ProducerMessage{
+		Topic: o.config.Topic,
+		Value: message,
+	}
+	if o.config.Key == settings.KafkaKeyPayloadID {
+		if id := proto.PayloadID(msg.Meta); len(id) > 0 {
+			producerMessage.Key = sarama.StringEncoder(id)
+		}
+	}
+
+	if o.config.Sync {
+		// a failed message doesn't stop the copy to the other outputs
+		if _, _, err = o.syncProducer.SendMessage(producerMessage); err != nil {
+			atomic.AddInt64(&o.errors, 1)
+			glogs.Debug(1, "[OUTPUT-KAFKA] failed to send message:", err)
+			return 0, nil
+		}
+	} else {
+		o.producer.Input() <- producerMessage
+	}
+
+	return len(message), nil
+}
+
+func kafkaMessage(meta [][]byte, req []byte) *proto.KafkaMessage {
+	var headers map[string]string
+	if mimeHeader := proto.ParseHeaders(req); len(mimeHeader) > 0 {
+		headers = make(map[string]string, len(mimeHeader))
+		for k, v := range mimeHeader {
+			headers[k] = strings.Join(v, ", ")
+		}
+	}
+
+	return &proto.KafkaMessage{
+		ReqURL:     string(proto.Path(req)),
+		ReqType:    string(meta[0]),
+		ReqID:      string(meta[1]),
+		ReqTs:      string(meta[2]),
+		ReqMethod:  string(proto.Method(req)),
+		ReqBody:    string(proto.Body(req)),
+		ReqHeaders: headers,
+	}
+}
+
+func (o *KafkaOutput) String() string {
+	return fmt.Sprintf("Kafka output: %s/%s", o.config.Host, o.config.Topic)
+}
+
+// Close closes the producer, injected producers are owned by the caller
+func (o *KafkaOutput) Close() error {
+	if o.config.Sync {
+		if o.config.SyncProducer == nil {
+			return o.syncProducer.Close()
+		}
+		return nil
+	}
+
+	if o.config.Producer == nil {
+		return o.producer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package output
+
+import (
+	"encoding/json"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+func TestOutputKafkaRAW(t *testing.T) {
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Successes = true
+	producer := mocks.NewAsyncProducer(t, config)
+	producer.ExpectInputAndSucceed()
+
+	output, err := NewKafkaOutput("", &settings.OutputKafkaConfig{
+		Producer: producer,
+		Topic:    "test",
+		UseJSON:  false,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output.PluginWrite(&common.Message{Meta: []byte("1 2 3\n"), Data: []byte("GET / HTTP1.1\r\nHeader: 1\r\n\r\n")})
+
+	resp := <-producer.Successes()
+
+	data, _ := resp.Value.Encode()
+
+	if string(data) != "1 2 3\nGET / HTTP1.1\r\nHeader: 1\r\n\r\n" {
+		t.Errorf("Message not properly encoded: %q", data)
+	}
+
+	if resp.Key != nil {
+		t.Errorf("Message should not have a key: %v", resp.Key)
+	}
+
+	producer.Close()
+}
+
+func TestOutputKafkaJSON(t *testing.T) {
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Successes = true
+	producer := mocks.NewAsyncProducer(t, config)
+	producer.ExpectInputAndSucceed()
+
+	output, err := NewKafkaOutput("", &settings.OutputKafkaConfig{
+		Producer: producer,
+		Topic:    "test",
+		UseJSON:  true,
+		Key:      settings.KafkaKeyPayloadID,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output.PluginWrite(&common.Message{Meta: []byte("1 2 3\n"), Data: []byte("GET / HTTP/1.1\r\nHeader: 1\r\n\r\n")})
+
+	resp := <-producer.Successes()
+
+	data, _ := resp.Value.Encode()
+
+	var msg proto.KafkaMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// the JSON format must be readable by KafkaInput
+	dump, _ := msg.Dump()
+	if string(dump) != "1 2 3\nGET / HTTP/1.1\r\nHeader: 1\r\n\r\n" {
+		t.Errorf("Message not properly encoded: %q", data)
+	}
+
+	if key, _ := resp.Key.Encode(); string(key) != "2" {
+		t.Errorf("Message should be keyed by payload ID: %q", key)
+	}
+
+	// responses are not part of the JSON format
+	if n, err := output.PluginWrite(&common.Message{Meta: []byte("2 2 3\n"), Data: []byte("HTTP/1.1 200 OK\r\n\r\n")}); n != 0 || err != nil {
+		t.Errorf("Response should be skipped, written %d, err %v", n, err)
+	}
+
+	producer.Close()
+}
+
+func TestOutputKafkaSync(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(val []byte) error {
+		if string(val) != "1 abc 3\nGET / HTTP/1.1\r\n\r\n" {
+			t.Errorf("Message not properly encoded: %q", val)
+		}
+		return nil
+	})
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+
+	output, err := NewKafkaOutput("", &settings.OutputKafkaConfig{
+		SyncProducer: producer,
+		Topic:        "test",
+		Sync:         true,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &common.Message{Meta: []byte("1 abc 3\n"), Data: []byte("GET / HTTP/1.1\r\n\r\n")}
+
+	if _, err := output.PluginWrite(msg); err != nil {
+		t.Error(err)
+	}
+
+	// the failure is counted, not returned, so the other outputs still get the message
+	if _, err := output.PluginWrite(msg); err != nil {
+		t.Errorf("Producer error should not be returned, got %v", err)
+	}
+	if errors := output.(*KafkaOutput).Errors(); errors != 1 {
+		t.Errorf("Expected 1 error, got %d", errors)
+	}
+
+	producer.Close()
+}
+
+func TestOutputKafkaUnknownKey(t *testing.T) {
+	producer := mocks.NewAsyncProducer(t, nil)
+	defer producer.Close()
+
+	if _, err := NewKafkaOutput("", &settings.OutputKafkaConfig{
+		Producer: producer,
+		Topic:    "test",
+		Key:      "session",
+	}, nil); err == nil {
+		t.Error("Should fail on an unknown key")
+	}
+}
@@ -0,0 +1,283 @@
+package output
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/core"
+	"record-traffic-press/goreplay/glogs"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	_ core.PluginReadWriter = (*MySQLOutput)(nil)
+	_ core.LatencyReporter  = (*MySQLOutput)(nil)
+)
+
+// MySQLOutput replays the queries captured by the raw input with the mysql protocol. The queries of a
+// captured connection are sent in order over a connection of their own, so its transactions and session
+// variables are replayed as they were captured. The results are emitted as replayed responses, encoded
+// like the captured ones, see proto.MySQLResult.
+//
+// By default only the queries reading data are replayed, and within a read-only transaction, so the
+// production traffic can't modify the target database. `--output-mysql-allow-writes` replays them all.
+type MySQLOutput struct {
+	address   string // host:port/db, without the credentials of the DSN
+	config    *settings.MySQLOutputConfig
+	db        *sql.DB
+	queue     chan *common.Message
+	responses chan response
+	sessions  *core.SessionDispatcher[*common.Message]
+	latency   *core.LatencyRecorder
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+// mysqlSession replays the queries of one captured connection
+type mysqlSession struct {
+	output *MySQLOutput
+	conn   *sql.Conn
+}
+
+// NewMySQLOutput constructor for MySQLOutput, dsn is the one of go-sql-driver/mysql
+func NewMySQLOutput(dsn string, config *settings.MySQLOutputConfig) (core.PluginReadWriter, error) {
+	o := new(MySQLOutput)
+
+	c := *config
+	if c.Timeout < time.Millisecond*100 {
+		c.Timeout = 5 * time.Second
+	}
+
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("[OUTPUT-MYSQL] parse MySQL output DSN error: %w", err)
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("[OUTPUT-MYSQL] open MySQL output error: %w", err)
+	}
+	db.SetMaxIdleConns(100)
+
+	o.address = cfg.Addr + "/" + cfg.DBName
+	o.config = &c
+	o.db = db
+	o.queue = make(chan *common.Message, 1000)
+	o.responses = make(chan response, 1000)
+	o.quit = make(chan struct{})
+	o.latency = core.NewLatencyRecorder(o.String(), c.LatencyWindow)
+	o.sessions = core.NewSessionDispatcher(o.queue, connectionOf, func() core.SessionWorker[*common.Message] {
+		return &mysqlSession{output: o}
+	})
+
+	return o, nil
+}
+
+// PluginWrite writes a message to this plugin
+func (o *MySQLOutput) PluginWrite(msg *common.Message) (n int, err error) {
+	if !proto.IsRequestPayload(msg.Meta) {
+		return len(msg.Data), nil
+	}
+
+	req := &common.Message{Meta: append([]byte{}, msg.Meta...), Data: append([]byte{}, msg.Data...)}
+	select {
+	case <-o.quit:
+		return 0, common.ErrorStopped
+	case o.queue <- req:
+	}
+
+	return len(msg.Data) + len(msg.Meta), nil
+}
+
+// PluginRead reads a message from this plugin
+func (o *MySQLOutput) PluginRead() (*common.Message, error) {
+	var resp response
+	var msg common.Message
+	select {
+	case <-o.quit:
+		return nil, common.ErrorStopped
+	case resp = <-o.responses:
+	}
+	msg.Data = resp.payload
+	msg.Meta = proto.PayloadHeader(proto.ReplayedResponsePayload, resp.uuid, resp.startedAt, resp.roundTripTime)
+
+	return &msg, nil
+}
+
+func (o *MySQLOutput) String() string {
+	return "MySQL output: " + o.address
+}
+
+// LatencyReports returns the latency percentiles of the replayed queries per digest
+func (o *MySQLOutput) LatencyReports() []core.LatencyReport {
+	return o.latency.Reports()
+}
+
+// LatencySnapshots returns the raw latencies of the replayed queries, to be merged with other processes
+func (o *MySQLOutput) LatencySnapshots() []core.LatencySnapshot {
+	return o.latency.Snapshots()
+}
+
+// LatencyTotal returns the latency percentiles of all the replayed queries
+func (o *MySQLOutput) LatencyTotal() core.LatencyReport {
+	return o.latency.Total()
+}
+
+// Close closes this plugin, the connections are closed by their sessions
+func (o *MySQLOutput) Close() error {
+	o.closeOnce.Do(func() {
+		close(o.quit)
+		o.sessions.Close()
+		o.db.Close()
+		o.latency.Close()
+	})
+	return nil
+}
+
+// Handle replays a query of the connection
+func (s *mysqlSession) Handle(msg *common.Message) {
+	o := s.output
+
+	q, err := proto.ParseMySQLQuery(msg.Data)
+	if err != nil {
+		glogs.Debug(1, "[MYSQL-OUTPUT] not a MySQL query:", err)
+		return
+	}
+	endpoint := proto.MySQLDigest(q.SQL)
+	readOnly := proto.IsMySQLReadOnly(q.SQL)
+	if !o.config.AllowWrites && !readOnly {
+		if o.config.Debug {
+			glogs.Debug(1, "[MYSQL-OUTPUT] skipped", endpoint, "as it may modify data")
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	result, err := s.query(ctx, q, readOnly)
+	stop := time.Now()
+
+	var serverErr *mysql.MySQLError
+	switch {
+	case errors.As(err, &serverErr):
+		result = &proto.MySQLResult{Error: &proto.MySQLError{
+			Code:    serverErr.Number,
+			State:   strings.TrimRight(string(serverErr.SQLState[:]), "\x00"),
+			Message: serverErr.Message,
+		}}
+		o.latency.RecordFailure(endpoint, stop.Sub(start), err)
+	case err != nil:
+		s.Close()
+		o.latency.RecordError(endpoint, err)
+		glogs.Debug(1, fmt.Sprintf("[MYSQL-OUTPUT] error when sending %s: %q", endpoint, err))
+		return
+	default:
+		o.latency.Record(endpoint, stop.Sub(start))
+	}
+
+	if o.config.Debug {
+		glogs.Debug(1, "[MYSQL-OUTPUT]", endpoint, "columns:", len(result.Columns), "rows:", len(result.Rows), "affected:", result.AffectedRows)
+	}
+
+	if !o.config.TrackResponses {
+		return
+	}
+	select {
+	case <-o.quit:
+	case o.responses <- response{proto.EncodeMySQLResult(result), proto.PayloadID(msg.Meta), start.UnixNano(), stop.UnixNano() - start.UnixNano()}:
+	}
+}
+
+// query runs a query on the connection of the session, the ones reading data return their result set
+// and the others their affected rows
+func (s *mysqlSession) query(ctx context.Context, q *proto.MySQLQuery, readOnly bool) (*proto.MySQLResult, error) {
+	if s.conn == nil {
+		conn, err := s.output.db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.conn = conn
+	}
+
+	if !readOnly {
+		res, err := s.conn.ExecContext(ctx, q.SQL, q.Args...)
+		if err != nil {
+			return nil, err
+		}
+		result := new(proto.MySQLResult)
+		if n, err := res.RowsAffected(); err == nil {
+			result.AffectedRows = uint64(n)
+		}
+		if id, err := res.LastInsertId(); err == nil {
+			result.LastInsertID = uint64(id)
+		}
+		return result, nil
+	}
+
+	if s.output.config.AllowWrites {
+		rows, err := s.conn.QueryContext(ctx, q.SQL, q.Args...)
+		if err != nil {
+			return nil, err
+		}
+		return mysqlRows(rows)
+	}
+
+	// the server rejects the writes hidden in a query, e.g. by a function
+	tx, err := s.conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	rows, err := tx.QueryContext(ctx, q.SQL, q.Args...)
+	if err != nil {
+		return nil, err
+	}
+	return mysqlRows(rows)
+}
+
+// mysqlRows reads a result set, the values as text like the text protocol, NULL as nil
+func mysqlRows(rows *sql.Rows) (*proto.MySQLResult, error) {
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := &proto.MySQLResult{Columns: columns}
+
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make([]interface{}, len(columns))
+		for i, v := range values {
+			if v != nil {
+				row[i] = string(v)
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result, rows.Err()
+}
+
+// Close returns the connection, the next query gets a new one
+func (s *mysqlSession) Close() {
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+}
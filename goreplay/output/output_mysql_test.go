@@ -0,0 +1,190 @@
+package output
+
+import (
+	"io"
+	"net"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMySQL authenticates any user and answers a few queries like a MySQL server with the text protocol,
+// the queries received are recorded
+type fakeMySQL struct {
+	listener net.Listener
+	mu       sync.Mutex
+	queries  []string
+}
+
+func newFakeMySQL(t *testing.T) *fakeMySQL {
+	s := &fakeMySQL{}
+	s.listener = serveTCP(t, s.serve)
+	return s
+}
+
+func (s *fakeMySQL) serve(conn net.Conn) {
+	var seq byte
+	write := func(payloads ...[]byte) {
+		for _, p := range payloads {
+			header := []byte{byte(len(p)), byte(len(p) >> 8), byte(len(p) >> 16), seq}
+			_, _ = conn.Write(append(header, p...))
+			seq++
+		}
+	}
+	read := func() ([]byte, error) {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return nil, err
+		}
+		seq = header[3] + 1
+		p := make([]byte, int(header[0])|int(header[1])<<8|int(header[2])<<16)
+		_, err := io.ReadFull(conn, p)
+		return p, err
+	}
+	ok := func(affected byte) []byte { return []byte{0x00, affected, 0x00, 0x02, 0x00, 0x00, 0x00} }
+	eof := []byte{0xfe, 0x00, 0x00, 0x02, 0x00}
+
+	// protocol 10 greeting with CLIENT_PROTOCOL_41, CLIENT_SECURE_CONNECTION and CLIENT_PLUGIN_AUTH
+	greeting := append([]byte{10}, "8.0.0-fake\x00"...)
+	greeting = append(greeting, 1, 0, 0, 0)
+	greeting = append(greeting, "abcdefgh\x00"...)
+	greeting = append(greeting, 0x01, 0xa2, 0x21, 0x02, 0x00, 0x08, 0x00, 21)
+	greeting = append(greeting, make([]byte, 10)...)
+	greeting = append(greeting, "ijklmnopqrst\x00mysql_native_password\x00"...)
+	write(greeting)
+	if _, err := read(); err != nil {
+		return
+	}
+	write(ok(0))
+
+	for {
+		p, err := read()
+		if err != nil || len(p) == 0 || p[0] != proto.MySQLComQuery {
+			return
+		}
+		query := string(p[1:])
+		s.mu.Lock()
+		s.queries = append(s.queries, query)
+		s.mu.Unlock()
+
+		switch {
+		case strings.HasPrefix(query, "SELECT id, name FROM users"):
+			var columns [][]byte
+			for _, name := range []string{"id", "name"} {
+				var c []byte
+				for _, f := range []string{"def", "db", "users", "users", name, name} {
+					c = append(append(c, byte(len(f))), f...)
+				}
+				columns = append(columns, append(c, 0x0c, 0x21, 0x00, 0x0b, 0x00, 0x00, 0x00, 0xfd, 0x00, 0x00, 0x00, 0x00, 0x00))
+			}
+			write([]byte{0x02})
+			write(columns...)
+			write(eof, []byte("\x011\x05alice"), []byte("\x012\xfb"), eof)
+		case strings.HasPrefix(query, "UPDATE"):
+			write(ok(2))
+		case strings.HasPrefix(query, "SELECT"):
+			write(append([]byte{0xff, 0x7a, 0x04}, "#42S02Table 'db.missing' doesn't exist"...))
+		default:
+			write(ok(0))
+		}
+	}
+}
+
+func (s *fakeMySQL) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.queries...)
+}
+
+func mysqlRequest(connection string, seq int, sql string) *common.Message {
+	data := proto.EncodeMySQLQuery(&proto.MySQLQuery{Command: "query", SQL: sql})
+	return &common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte(redisID(connection, seq)), 1, -1), Data: data}
+}
+
+func readMySQLResults(t *testing.T, output *MySQLOutput, count int) map[string]string {
+	results := make(map[string]string)
+	for len(results) < count {
+		done := make(chan *common.Message, 1)
+		go func() {
+			msg, _ := output.PluginRead()
+			done <- msg
+		}()
+
+		select {
+		case msg := <-done:
+			results[string(proto.PayloadID(msg.Meta))] = string(msg.Data)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for results")
+		}
+	}
+	return results
+}
+
+func TestMySQLOutputReadOnly(t *testing.T) {
+	server := newFakeMySQL(t)
+
+	plugin, err := NewMySQLOutput("replay@tcp("+server.listener.Addr().String()+")/db", &settings.MySQLOutputConfig{TrackResponses: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := plugin.(*MySQLOutput)
+	defer output.Close()
+	if output.String() != "MySQL output: "+server.listener.Addr().String()+"/db" {
+		t.Errorf("Unexpected name %q", output.String())
+	}
+
+	output.PluginWrite(mysqlRequest("a", 1, "SELECT id, name FROM users"))
+	output.PluginWrite(mysqlRequest("a", 2, "UPDATE users SET name = 'bob'"))
+	output.PluginWrite(mysqlRequest("a", 3, "SELECT * FROM missing"))
+
+	results := readMySQLResults(t, output, 2)
+	expected := map[string]string{
+		redisID("a", 1): `{"columns":["id","name"],"rows":[["1","alice"],["2",null]]}`,
+		redisID("a", 3): `{"error":{"code":1146,"state":"42S02","message":"Table 'db.missing' doesn't exist"}}`,
+	}
+	for id, result := range expected {
+		if results[id] != result {
+			t.Errorf("%s: expected %s, got %s", id, result, results[id])
+		}
+	}
+
+	received := strings.Join(server.received(), "; ")
+	if received != "START TRANSACTION READ ONLY; SELECT id, name FROM users; ROLLBACK; START TRANSACTION READ ONLY; SELECT * FROM missing; ROLLBACK" {
+		t.Errorf("Expected the reads only, within read-only transactions, got %q", received)
+	}
+	if total := output.LatencyTotal(); total.Requests != 2 || total.Errors != 1 {
+		t.Errorf("Expected 2 queries and 1 failure, got %+v", total)
+	}
+}
+
+func TestMySQLOutputAllowWrites(t *testing.T) {
+	server := newFakeMySQL(t)
+
+	plugin, err := NewMySQLOutput("replay@tcp("+server.listener.Addr().String()+")/db", &settings.MySQLOutputConfig{AllowWrites: true, TrackResponses: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := plugin.(*MySQLOutput)
+	defer output.Close()
+
+	output.PluginWrite(mysqlRequest("a", 1, "UPDATE users SET name = 'bob'"))
+	output.PluginWrite(mysqlRequest("a", 2, "SELECT id, name FROM users"))
+
+	results := readMySQLResults(t, output, 2)
+	if results[redisID("a", 1)] != `{"affected_rows":2}` {
+		t.Errorf("Unexpected result %s", results[redisID("a", 1)])
+	}
+	if received := strings.Join(server.received(), "; "); received != "UPDATE users SET name = 'bob'; SELECT id, name FROM users" {
+		t.Errorf("Expected the queries as they were captured, got %q", received)
+	}
+}
+
+func TestMySQLOutputInvalidDSN(t *testing.T) {
+	if _, err := NewMySQLOutput("replay@localhost:3306/db", &settings.MySQLOutputConfig{}); err == nil {
+		t.Error("Should fail on an invalid DSN")
+	}
+}
@@ -0,0 +1,350 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/core"
+	"record-traffic-press/goreplay/core/tcp"
+	"record-traffic-press/goreplay/glogs"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var _ core.PluginWriter = (*PcapOutput)(nil)
+
+const (
+	pcapSnaplen       = 262144
+	pcapSegmentSize   = 1460 // payload of the synthesized segments
+	pcapFlushInterval = time.Second
+)
+
+var (
+	pcapClientMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	pcapServerMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+// PcapOutput writes the messages as pcap, or pcapng when the path ends with ".pcapng", so the recordings
+// can be inspected with Wireshark or tcpdump and read again by the raw input with the pcap_file engine.
+//
+// The messages of the raw input are written with the packets they were captured in. The other ones, e.g.
+// read by the file input, are framed over synthesized TCP connections: the payloads sharing a connection
+// ID, see proto.SessionID, are sent over the same connection, opened by a handshake when it is first seen,
+// the requests by the client and the responses by the server. A captured message rewritten on its way,
+// e.g. by the modifier, is framed with the addresses and sequence number of its first packet.
+// The replayed responses are not written.
+type PcapOutput struct {
+	mu       sync.Mutex
+	path     string
+	config   *settings.PcapOutputConfig
+	clientIP net.IP
+	serverIP net.IP
+	file     *os.File
+	writer   interface {
+		WritePacket(gopacket.CaptureInfo, []byte) error
+	}
+	flush    func() error
+	frame    gopacket.SerializeBuffer
+	sessions map[string]*pcapSession
+	nextPort uint16
+	swept    time.Time
+	quit     chan struct{}
+	closed   bool
+}
+
+// pcapSession is a synthesized TCP connection
+type pcapSession struct {
+	port      uint16
+	clientSeq uint32
+	serverSeq uint32
+	seen      time.Time
+}
+
+// NewPcapOutput constructor for PcapOutput
+func NewPcapOutput(path string, config *settings.PcapOutputConfig) (core.PluginWriter, error) {
+	o := new(PcapOutput)
+
+	c := *config
+	if c.ClientIP == "" {
+		c.ClientIP = "10.0.0.1"
+	}
+	if c.ServerIP == "" {
+		c.ServerIP = "10.0.0.2"
+	}
+	if c.ServerPort == 0 {
+		c.ServerPort = 80
+	}
+	if o.clientIP = net.ParseIP(c.ClientIP); o.clientIP == nil {
+		return nil, fmt.Errorf("[OUTPUT-PCAP] invalid client IP %q", c.ClientIP)
+	}
+	if o.serverIP = net.ParseIP(c.ServerIP); o.serverIP == nil {
+		return nil, fmt.Errorf("[OUTPUT-PCAP] invalid server IP %q", c.ServerIP)
+	}
+
+	var err error
+	o.path = path
+	o.config = &c
+	o.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return nil, fmt.Errorf("[OUTPUT-PCAP] cannot open file %q: %w", path, err)
+	}
+
+	if strings.HasSuffix(path, ".pcapng") {
+		var w *pcapgo.NgWriter
+		w, err = pcapgo.NewNgWriter(o.file, layers.LinkTypeEthernet)
+		o.writer, o.flush = w, w.Flush
+	} else {
+		buf := bufio.NewWriter(o.file)
+		w := pcapgo.NewWriterNanos(buf)
+		err = w.WriteFileHeader(pcapSnaplen, layers.LinkTypeEthernet)
+		o.writer, o.flush = w, buf.Flush
+	}
+	if err != nil {
+		o.file.Close()
+		return nil, fmt.Errorf("[OUTPUT-PCAP] cannot write file %q: %w", path, err)
+	}
+
+	o.frame = gopacket.NewSerializeBuffer()
+	o.sessions = make(map[string]*pcapSession)
+	o.nextPort = 1024
+	o.quit = make(chan struct{})
+
+	go o.flusher()
+
+	return o, nil
+}
+
+// PluginWrite writes a message to this plugin
+func (o *PcapOutput) PluginWrite(msg *common.Message) (n int, err error) {
+	meta := proto.PayloadMeta(msg.Meta)
+	if len(meta) < 3 || (msg.Meta[0] != proto.RequestPayload && msg.Meta[0] != proto.ResponsePayload) {
+		return len(msg.Data), nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return 0, common.ErrorStopped
+	}
+
+	packets, _ := msg.Capture.([]*tcp.Packet)
+	switch {
+	case capturedAs(packets, msg.Data):
+		for _, pckt := range packets {
+			if err = o.writePacket(pckt); err != nil {
+				break
+			}
+		}
+	case len(packets) > 0:
+		first := *packets[0]
+		first.Timestamp = payloadTime(meta)
+		err = o.writeSegments(first, msg.Data)
+	default:
+		err = o.writeSynthesized(msg.Meta[0], meta, msg.Data)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return len(msg.Data) + len(msg.Meta), nil
+}
+
+// capturedAs reports if the payloads of packets are data, i.e. the message was not rewritten
+func capturedAs(packets []*tcp.Packet, data []byte) bool {
+	if len(packets) == 0 {
+		return false
+	}
+	for _, pckt := range packets {
+		if !bytes.HasPrefix(data, pckt.Payload) {
+			return false
+		}
+		data = data[len(pckt.Payload):]
+	}
+	return len(data) == 0
+}
+
+// payloadTime returns the time the payload was captured at, see proto.PayloadHeader
+func payloadTime(meta [][]byte) time.Time {
+	if ns, err := strconv.ParseInt(string(meta[2]), 10, 64); err == nil && ns > 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Now()
+}
+
+// writeSynthesized writes the payload over the synthesized connection of its session
+func (o *PcapOutput) writeSynthesized(payloadType byte, meta [][]byte, data []byte) error {
+	now := time.Now()
+	o.sweepSessions(now)
+
+	ts := payloadTime(meta)
+	key := string(proto.SessionID(meta[1], proto.SessionByConnection))
+	s, ok := o.sessions[key]
+	if !ok {
+		s = &pcapSession{port: o.nextPort, clientSeq: 1, serverSeq: 1}
+		o.sessions[key] = s
+		if o.nextPort++; o.nextPort == 0 {
+			o.nextPort = 1024
+		}
+
+		syn := o.synthesized(s, tcp.DirIncoming, ts)
+		syn.Seq, syn.Ack, syn.SYN, syn.ACK = 0, 0, true, false
+		synAck := o.synthesized(s, tcp.DirOutcoming, ts)
+		synAck.Seq, synAck.SYN = 0, true
+		for _, pckt := range []tcp.Packet{syn, synAck, o.synthesized(s, tcp.DirIncoming, ts)} {
+			if err := o.writePacket(&pckt); err != nil {
+				return err
+			}
+		}
+	}
+	s.seen = now
+
+	if payloadType == proto.RequestPayload {
+		if err := o.writeSegments(o.synthesized(s, tcp.DirIncoming, ts), data); err != nil {
+			return err
+		}
+		s.clientSeq += uint32(len(data))
+		return nil
+	}
+	if err := o.writeSegments(o.synthesized(s, tcp.DirOutcoming, ts), data); err != nil {
+		return err
+	}
+	s.serverSeq += uint32(len(data))
+	return nil
+}
+
+// synthesized returns an empty ACK segment of the session, sent by the client when dir is tcp.DirIncoming
+func (o *PcapOutput) synthesized(s *pcapSession, dir tcp.Dir, ts time.Time) tcp.Packet {
+	if dir == tcp.DirIncoming {
+		return tcp.Packet{
+			Direction: dir, SrcIP: o.clientIP, DstIP: o.serverIP, SrcPort: s.port, DstPort: o.config.ServerPort,
+			Seq: s.clientSeq, Ack: s.serverSeq, ACK: true, Timestamp: ts,
+		}
+	}
+	return tcp.Packet{
+		Direction: dir, SrcIP: o.serverIP, DstIP: o.clientIP, SrcPort: o.config.ServerPort, DstPort: s.port,
+		Seq: s.serverSeq, Ack: s.clientSeq, ACK: true, Timestamp: ts,
+	}
+}
+
+// writeSegments writes data in segments of pcapSegmentSize sent like first, starting at its sequence number
+func (o *PcapOutput) writeSegments(first tcp.Packet, data []byte) error {
+	segment := first
+	segment.SYN, segment.FIN, segment.RST, segment.ACK = false, false, false, true
+	for len(data) > 0 {
+		size := len(data)
+		if size > pcapSegmentSize {
+			size = pcapSegmentSize
+		}
+		segment.Payload = data[:size]
+		if err := o.writePacket(&segment); err != nil {
+			return err
+		}
+		segment.Seq += uint32(size)
+		data = data[size:]
+	}
+	return nil
+}
+
+// writePacket frames the TCP segment of pckt in an ethernet frame
+func (o *PcapOutput) writePacket(pckt *tcp.Packet) error {
+	eth := &layers.Ethernet{SrcMAC: pcapClientMAC, DstMAC: pcapServerMAC, EthernetType: layers.EthernetTypeIPv4}
+	if pckt.Direction == tcp.DirOutcoming {
+		eth.SrcMAC, eth.DstMAC = pcapServerMAC, pcapClientMAC
+	}
+	segment := &layers.TCP{
+		SrcPort: layers.TCPPort(pckt.SrcPort),
+		DstPort: layers.TCPPort(pckt.DstPort),
+		Seq:     pckt.Seq,
+		Ack:     pckt.Ack,
+		ACK:     pckt.ACK,
+		SYN:     pckt.SYN,
+		FIN:     pckt.FIN,
+		RST:     pckt.RST,
+		PSH:     len(pckt.Payload) > 0,
+		Window:  65535,
+	}
+
+	var network gopacket.SerializableLayer
+	if src, dst := pckt.SrcIP.To4(), pckt.DstIP.To4(); src != nil && dst != nil {
+		ip := &layers.IPv4{Version: 4, TTL: 64, Flags: layers.IPv4DontFragment, Protocol: layers.IPProtocolTCP, SrcIP: src, DstIP: dst}
+		segment.SetNetworkLayerForChecksum(ip)
+		network = ip
+	} else {
+		ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolTCP, SrcIP: pckt.SrcIP, DstIP: pckt.DstIP}
+		segment.SetNetworkLayerForChecksum(ip)
+		eth.EthernetType = layers.EthernetTypeIPv6
+		network = ip
+	}
+
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(o.frame, opts, eth, network, segment, gopacket.Payload(pckt.Payload)); err != nil {
+		return err
+	}
+	data := o.frame.Bytes()
+	return o.writer.WritePacket(gopacket.CaptureInfo{Timestamp: pckt.Timestamp, CaptureLength: len(data), Length: len(data)}, data)
+}
+
+// sweepSessions forgets the synthesized connections idle for core.SessionIdleTimeout
+func (o *PcapOutput) sweepSessions(now time.Time) {
+	if now.Sub(o.swept) < time.Minute {
+		return
+	}
+	o.swept = now
+
+	for key, s := range o.sessions {
+		if now.Sub(s.seen) >= core.SessionIdleTimeout {
+			delete(o.sessions, key)
+		}
+	}
+}
+
+func (o *PcapOutput) flusher() {
+	ticker := time.NewTicker(pcapFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.quit:
+			return
+		case <-ticker.C:
+			o.mu.Lock()
+			if !o.closed {
+				if err := o.flush(); err != nil {
+					glogs.Debug(0, "[OUTPUT-PCAP] error flushing", o.path, err)
+				}
+			}
+			o.mu.Unlock()
+		}
+	}
+}
+
+func (o *PcapOutput) String() string {
+	return "Pcap output: " + o.path
+}
+
+// Close flushes and closes the pcap file
+func (o *PcapOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+	close(o.quit)
+
+	err := o.flush()
+	if cerr := o.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
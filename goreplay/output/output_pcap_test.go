@@ -0,0 +1,175 @@
+package output
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/core/tcp"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// readPcapPackets parses the frames of a pcap or pcapng file written by PcapOutput
+func readPcapPackets(t *testing.T, path string) (packets []*tcp.PcapPacket) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var source interface {
+		ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	}
+	if strings.HasSuffix(path, ".pcapng") {
+		source, err = pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	} else {
+		source, err = pcapgo.NewReader(f)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		data, ci, err := source.ReadPacketData()
+		if err != nil {
+			return
+		}
+		packets = append(packets, &tcp.PcapPacket{Data: data, LType: int(layers.LinkTypeEthernet), LTypeLen: 14, Ci: &ci})
+	}
+}
+
+func TestPcapOutputSynthesized(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.pcap")
+	plugin, err := NewPcapOutput(path, &settings.PcapOutputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := plugin.(*PcapOutput)
+	if output.String() != "Pcap output: "+path {
+		t.Errorf("Unexpected name %q", output.String())
+	}
+
+	body := strings.Repeat("b", 4000)
+	payloads := map[string][2]string{
+		redisID("a", 1): {"GET /a HTTP/1.1\r\nHost: test\r\n\r\n", "HTTP/1.1 200 OK\r\nContent-Length: 4000\r\n\r\n" + body},
+		redisID("a", 2): {"GET /b HTTP/1.1\r\nHost: test\r\n\r\n", "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"},
+		redisID("b", 1): {"POST /c HTTP/1.1\r\nHost: test\r\nContent-Length: 4000\r\n\r\n" + body, "HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n"},
+	}
+	started := time.Now().UnixNano()
+	for i, id := range []string{redisID("a", 1), redisID("b", 1), redisID("a", 2)} {
+		ts := started + int64(i)*int64(time.Millisecond)
+		output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte(id), ts, -1), Data: []byte(payloads[id][0])})
+		output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.ResponsePayload, []byte(id), ts+1, 1), Data: []byte(payloads[id][1])})
+		output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.ReplayedResponsePayload, []byte(id), ts+2, 1), Data: []byte("replayed")})
+	}
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the recording is read again like the pcap_file engine does
+	messages := make(chan *tcp.TcpMessage, 10)
+	parser := tcp.NewMessageParser(messages, []uint16{80}, nil, 100*time.Millisecond, false)
+	defer parser.Close()
+	packets := readPcapPackets(t, path)
+	for _, pckt := range packets {
+		parser.PacketHandler(pckt)
+	}
+	// 2 handshakes, 3 requests and 3 responses with 2 bodies of 3 segments
+	if len(packets) != 2*3+3+3+2*2 {
+		t.Errorf("Expected 16 packets, got %d", len(packets))
+	}
+
+	requests := make(map[string]string)
+	responses := make(map[string]string)
+	for len(requests)+len(responses) < 6 {
+		select {
+		case m := <-messages:
+			if m.Direction == tcp.DirIncoming {
+				requests[string(m.UUID())] = string(m.Data())
+			} else {
+				responses[string(m.UUID())] = string(m.Data())
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for the messages, got %d requests and %d responses", len(requests), len(responses))
+		}
+	}
+
+	found := 0
+	for id, request := range requests {
+		for _, payload := range payloads {
+			if payload[0] == request {
+				found++
+				if responses[id] != payload[1] {
+					t.Errorf("Expected the response of %q to be paired with it, got %q", request, responses[id])
+				}
+			}
+		}
+	}
+	if found != 3 {
+		t.Errorf("Expected the 3 requests, got %q", requests)
+	}
+}
+
+func TestPcapOutputCaptured(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.pcapng")
+	output, err := NewPcapOutput(path, &settings.PcapOutputConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.ParseIP("192.168.1.10"), net.ParseIP("192.168.1.20")
+	captured := time.Now()
+	segment := func(seq uint32, payload string) *tcp.Packet {
+		return &tcp.Packet{
+			Direction: tcp.DirIncoming, SrcIP: client, DstIP: server, Version: 4, SrcPort: 40000, DstPort: 8080,
+			Seq: seq, Ack: 7, ACK: true, Timestamp: captured, Payload: []byte(payload),
+		}
+	}
+	packets := []*tcp.Packet{segment(100, "GET / HTTP/1.1\r\n"), segment(116, "Host: test\r\n\r\n")}
+
+	meta := proto.PayloadHeader(proto.RequestPayload, []byte(redisID("a", 1)), captured.UnixNano(), -1)
+	output.PluginWrite(&common.Message{Meta: meta, Data: []byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n"), Capture: packets})
+	// rewritten on its way, e.g. by the modifier
+	output.PluginWrite(&common.Message{Meta: meta, Data: []byte("GET /rewritten HTTP/1.1\r\nHost: test\r\n\r\n"), Capture: packets})
+	output.(*PcapOutput).Close()
+
+	var frames []*tcp.Packet
+	for _, pckt := range readPcapPackets(t, path) {
+		frame, err := tcp.ParsePacket(pckt.Data, pckt.LType, pckt.LTypeLen, pckt.Ci, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("Expected the 2 captured packets and 1 rewritten, got %d", len(frames))
+	}
+
+	expected := []*tcp.Packet{packets[0], packets[1], segment(100, "GET /rewritten HTTP/1.1\r\nHost: test\r\n\r\n")}
+	for i, frame := range frames {
+		e := expected[i]
+		if !frame.SrcIP.Equal(e.SrcIP) || !frame.DstIP.Equal(e.DstIP) || frame.SrcPort != e.SrcPort || frame.DstPort != e.DstPort ||
+			frame.Seq != e.Seq || frame.Ack != e.Ack || string(frame.Payload) != string(e.Payload) {
+			t.Errorf("%d: expected %s:%d > %s:%d seq %d ack %d %q, got %s:%d > %s:%d seq %d ack %d %q", i,
+				e.SrcIP, e.SrcPort, e.DstIP, e.DstPort, e.Seq, e.Ack, e.Payload,
+				frame.SrcIP, frame.SrcPort, frame.DstIP, frame.DstPort, frame.Seq, frame.Ack, frame.Payload)
+		}
+		if !frame.Timestamp.Equal(captured) {
+			t.Errorf("%d: expected the capture time %v, got %v", i, captured, frame.Timestamp)
+		}
+	}
+}
+
+func TestPcapOutputInvalidIP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.pcap")
+	if _, err := NewPcapOutput(path, &settings.PcapOutputConfig{ClientIP: "10.0.0"}); err == nil {
+		t.Error("Should fail on an invalid client IP")
+	}
+}
@@ -0,0 +1,236 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/core"
+	"record-traffic-press/goreplay/glogs"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	_ core.PluginReadWriter = (*RedisOutput)(nil)
+	_ core.LatencyReporter  = (*RedisOutput)(nil)
+)
+
+// RedisOutput replays the redis commands captured by the raw input with the redis protocol.
+// The commands of a captured connection are sent in order over a connection of their own, so the
+// state bound to a connection, like SELECT or MULTI/EXEC, is replayed as it was captured. The replies
+// are read back as they were sent by the server, RESP2 or RESP3, and emitted as replayed responses.
+//
+// In read-only mode (`--output-redis-read-only`) the commands which modify the data are skipped,
+// so the production traffic can be replayed against a shared server. The keys can be moved to
+// another namespace with `--output-redis-key-prefix from=to`.
+type RedisOutput struct {
+	address   string
+	config    *settings.RedisOutputConfig
+	prefixes  map[string]string
+	queue     chan *common.Message
+	responses chan response
+	sessions  *core.SessionDispatcher[*common.Message]
+	latency   *core.LatencyRecorder
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+// redisSession replays the commands of one captured connection
+type redisSession struct {
+	output *RedisOutput
+	conn   net.Conn
+	buf    []byte // read but not parsed yet
+}
+
+// NewRedisOutput constructor for RedisOutput
+func NewRedisOutput(address string, config *settings.RedisOutputConfig) (core.PluginReadWriter, error) {
+	o := new(RedisOutput)
+
+	c := *config
+	if c.Timeout < time.Millisecond*100 {
+		c.Timeout = 5 * time.Second
+	}
+
+	prefixes, err := proto.ParseRedisKeyPrefixes(c.KeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("[OUTPUT-REDIS] %w", err)
+	}
+
+	o.address = address
+	o.config = &c
+	o.prefixes = prefixes
+	o.queue = make(chan *common.Message, 1000)
+	o.responses = make(chan response, 1000)
+	o.quit = make(chan struct{})
+	o.latency = core.NewLatencyRecorder(o.String(), c.LatencyWindow)
+	o.sessions = core.NewSessionDispatcher(o.queue, connectionOf, func() core.SessionWorker[*common.Message] {
+		return &redisSession{output: o}
+	})
+
+	return o, nil
+}
+
+// PluginWrite writes a message to this plugin
+func (o *RedisOutput) PluginWrite(msg *common.Message) (n int, err error) {
+	if !proto.IsRequestPayload(msg.Meta) {
+		return len(msg.Data), nil
+	}
+
+	req := &common.Message{Meta: append([]byte{}, msg.Meta...), Data: append([]byte{}, msg.Data...)}
+	select {
+	case <-o.quit:
+		return 0, common.ErrorStopped
+	case o.queue <- req:
+	}
+
+	return len(msg.Data) + len(msg.Meta), nil
+}
+
+// PluginRead reads a message from this plugin
+func (o *RedisOutput) PluginRead() (*common.Message, error) {
+	var resp response
+	var msg common.Message
+	select {
+	case <-o.quit:
+		return nil, common.ErrorStopped
+	case resp = <-o.responses:
+	}
+	msg.Data = resp.payload
+	msg.Meta = proto.PayloadHeader(proto.ReplayedResponsePayload, resp.uuid, resp.startedAt, resp.roundTripTime)
+
+	return &msg, nil
+}
+
+func (o *RedisOutput) String() string {
+	return "Redis output: " + o.address
+}
+
+// LatencyReports returns the latency percentiles of the replayed commands per command name
+func (o *RedisOutput) LatencyReports() []core.LatencyReport {
+	return o.latency.Reports()
+}
+
+// LatencySnapshots returns the raw latencies of the replayed commands, to be merged with other processes
+func (o *RedisOutput) LatencySnapshots() []core.LatencySnapshot {
+	return o.latency.Snapshots()
+}
+
+// LatencyTotal returns the latency percentiles of all the replayed commands
+func (o *RedisOutput) LatencyTotal() core.LatencyReport {
+	return o.latency.Total()
+}
+
+// Close closes this plugin, the connections are closed by their sessions
+func (o *RedisOutput) Close() error {
+	o.closeOnce.Do(func() {
+		close(o.quit)
+		o.sessions.Close()
+		o.latency.Close()
+	})
+	return nil
+}
+
+// Handle replays the commands of a captured request
+func (s *redisSession) Handle(msg *common.Message) {
+	frames, _ := proto.RedisFrames(msg.Data)
+	for _, frame := range frames {
+		s.send(proto.PayloadID(msg.Meta), frame)
+	}
+}
+
+func (s *redisSession) send(uuid []byte, frame []byte) {
+	o := s.output
+
+	args, ok := proto.RedisCommandArgs(frame)
+	if !ok {
+		glogs.Debug(1, "[REDIS-OUTPUT] not a redis command:", string(frame))
+		return
+	}
+	endpoint := strings.ToUpper(string(args[0]))
+	if o.config.ReadOnly && !proto.IsRedisReadOnly(args) {
+		if o.config.Debug {
+			glogs.Debug(1, "[REDIS-OUTPUT] skipped", endpoint, "in read-only mode")
+		}
+		return
+	}
+	if len(o.prefixes) > 0 {
+		proto.RewriteRedisKeys(args, o.prefixes)
+	}
+
+	start := time.Now()
+	reply, err := s.roundTrip(proto.EncodeRedisCommand(args))
+	stop := time.Now()
+	if err != nil {
+		s.Close()
+		o.latency.RecordError(endpoint, err)
+		glogs.Debug(1, fmt.Sprintf("[REDIS-OUTPUT] error when sending %s: %q", endpoint, err))
+		return
+	}
+
+	if reply[0] == proto.RedisError || reply[0] == proto.RedisBlobError {
+		o.latency.RecordFailure(endpoint, stop.Sub(start), errors.New(strings.TrimSpace(string(reply[1:]))))
+	} else {
+		o.latency.Record(endpoint, stop.Sub(start))
+	}
+
+	if o.config.Debug {
+		glogs.Debug(1, "[REDIS-OUTPUT]", endpoint, "reply:", string(reply))
+	}
+
+	if !o.config.TrackResponses {
+		return
+	}
+	select {
+	case <-o.quit:
+	case o.responses <- response{reply, uuid, start.UnixNano(), stop.UnixNano() - start.UnixNano()}:
+	}
+}
+
+// roundTrip sends a command and reads its reply, the RESP3 pushes received meanwhile are skipped
+func (s *redisSession) roundTrip(command []byte) ([]byte, error) {
+	if s.conn == nil {
+		conn, err := net.DialTimeout("tcp", s.output.address, s.output.config.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		s.conn = conn
+	}
+
+	if err := s.conn.SetDeadline(time.Now().Add(s.output.config.Timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := s.conn.Write(command); err != nil {
+		return nil, err
+	}
+
+	chunk := make([]byte, 64*1024)
+	for {
+		if frames, _ := proto.RedisFrames(s.buf); len(frames) > 0 {
+			reply := append([]byte{}, frames[0]...)
+			s.buf = s.buf[len(frames[0]):]
+			if reply[0] == proto.RedisPush {
+				continue
+			}
+			return reply, nil
+		}
+
+		n, err := s.conn.Read(chunk)
+		if err != nil {
+			return nil, err
+		}
+		s.buf = append(s.buf, chunk[:n]...)
+	}
+}
+
+// Close closes the connection, the next command opens a new one
+func (s *redisSession) Close() {
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	s.buf = nil
+}
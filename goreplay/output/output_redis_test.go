@@ -0,0 +1,202 @@
+package output
+
+import (
+	"fmt"
+	"net"
+	"record-traffic-press/goreplay/common"
+	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis answers GET, SET and PING like a redis server, each connection selects its own database,
+// the commands received are recorded
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	data     map[string]string
+	commands []string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	s := &fakeRedis{data: make(map[string]string)}
+	s.listener = serveTCP(t, s.serve)
+	return s
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	db := "0"
+	var buf []byte
+	chunk := make([]byte, 4096)
+	for {
+		n, err := conn.Read(chunk)
+		if err != nil {
+			return
+		}
+		buf = append(buf, chunk[:n]...)
+		frames, _ := proto.RedisFrames(buf)
+		for _, frame := range frames {
+			buf = buf[len(frame):]
+			args, _ := proto.RedisCommandArgs(frame)
+
+			s.mu.Lock()
+			var fields []string
+			for _, arg := range args {
+				fields = append(fields, string(arg))
+			}
+			s.commands = append(s.commands, strings.Join(fields, " "))
+
+			var reply string
+			switch strings.ToUpper(fields[0]) {
+			case "PING":
+				// a push sent before the reply, like a client tracking invalidation
+				reply = ">2\r\n+invalidate\r\n*0\r\n+PONG\r\n"
+			case "SELECT":
+				db = fields[1]
+				reply = "+OK\r\n"
+			case "SET":
+				s.data[db+":"+fields[1]] = fields[2]
+				reply = "+OK\r\n"
+			case "GET":
+				if v, ok := s.data[db+":"+fields[1]]; ok {
+					reply = "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+				} else {
+					reply = "$-1\r\n"
+				}
+			default:
+				reply = "-ERR unknown command '" + fields[0] + "'\r\n"
+			}
+			s.mu.Unlock()
+
+			if _, err = conn.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *fakeRedis) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+// redisID is the ID of a command captured on connection, the connection followed by the position of the command
+func redisID(connection string, seq int) string {
+	return connection + strings.Repeat("0", 16-len(connection)) + fmt.Sprintf("%016x", seq)
+}
+
+func redisRequest(connection string, seq int, args ...string) *common.Message {
+	var command [][]byte
+	for _, arg := range args {
+		command = append(command, []byte(arg))
+	}
+	return &common.Message{Meta: proto.PayloadHeader(proto.RequestPayload, []byte(redisID(connection, seq)), 1, -1), Data: proto.EncodeRedisCommand(command)}
+}
+
+func readRedisReplies(t *testing.T, output *RedisOutput, count int) map[string]string {
+	replies := make(map[string]string)
+	for len(replies) < count {
+		done := make(chan *common.Message, 1)
+		go func() {
+			msg, _ := output.PluginRead()
+			done <- msg
+		}()
+
+		select {
+		case msg := <-done:
+			if msg.Meta[0] != proto.ReplayedResponsePayload {
+				t.Errorf("Wrong payload type: %q", msg.Meta)
+			}
+			replies[string(proto.PayloadID(msg.Meta))] = string(msg.Data)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timeout waiting for replies")
+		}
+	}
+	return replies
+}
+
+func TestRedisOutput(t *testing.T) {
+	server := newFakeRedis(t)
+
+	plugin, err := NewRedisOutput(server.listener.Addr().String(), &settings.RedisOutputConfig{
+		KeyPrefix:      []string{"user:=replay:user:"},
+		TrackResponses: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := plugin.(*RedisOutput)
+	defer output.Close()
+
+	// two captured connections, the first one selects another database
+	output.PluginWrite(redisRequest("a", 1, "SELECT", "1"))
+	output.PluginWrite(redisRequest("a", 2, "SET", "user:1", "alice"))
+	output.PluginWrite(redisRequest("a", 3, "GET", "user:1"))
+	output.PluginWrite(redisRequest("b", 1, "GET", "user:1"))
+	output.PluginWrite(redisRequest("b", 2, "PING"))
+	output.PluginWrite(redisRequest("b", 3, "HGET", "user:1", "name"))
+	// replies are not replayed
+	output.PluginWrite(&common.Message{Meta: proto.PayloadHeader(proto.ResponsePayload, []byte("a"), 1, -1), Data: []byte("+OK\r\n")})
+
+	replies := readRedisReplies(t, output, 6)
+	expected := map[string]string{
+		redisID("a", 1): "+OK\r\n",
+		redisID("a", 2): "+OK\r\n",
+		redisID("a", 3): "$5\r\nalice\r\n",
+		redisID("b", 1): "$-1\r\n",
+		redisID("b", 2): "+PONG\r\n",
+		redisID("b", 3): "-ERR unknown command 'HGET'\r\n",
+	}
+	for id, reply := range expected {
+		if replies[id] != reply {
+			t.Errorf("%s: expected %q, got %q", id, reply, replies[id])
+		}
+	}
+
+	var sets []string
+	for _, command := range server.received() {
+		if strings.HasPrefix(command, "SET") {
+			sets = append(sets, command)
+		}
+	}
+	if len(sets) != 1 || sets[0] != "SET replay:user:1 alice" {
+		t.Errorf("Expected the key prefix to be rewritten, got %q", sets)
+	}
+
+	if total := output.LatencyTotal(); total.Requests != 6 || total.Errors != 1 {
+		t.Errorf("Expected 6 commands and 1 failure, got %+v", total)
+	}
+}
+
+func TestRedisOutputReadOnly(t *testing.T) {
+	server := newFakeRedis(t)
+
+	plugin, err := NewRedisOutput(server.listener.Addr().String(), &settings.RedisOutputConfig{ReadOnly: true, TrackResponses: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := plugin.(*RedisOutput)
+	defer output.Close()
+
+	output.PluginWrite(redisRequest("a", 1, "SET", "user:1", "alice"))
+	output.PluginWrite(redisRequest("a", 2, "GET", "user:1"))
+
+	replies := readRedisReplies(t, output, 1)
+	if reply, ok := replies[redisID("a", 2)]; !ok || reply != "$-1\r\n" {
+		t.Errorf("Expected only GET to be replayed, got %q", replies)
+	}
+	if received := server.received(); len(received) != 1 || received[0] != "GET user:1" {
+		t.Errorf("Expected only GET to be received, got %q", received)
+	}
+}
+
+func TestRedisOutputInvalidKeyPrefix(t *testing.T) {
+	if _, err := NewRedisOutput("localhost:6379", &settings.RedisOutputConfig{KeyPrefix: []string{"user:"}}); err == nil {
+		t.Error("Should fail on a key prefix without replacement")
+	}
+}
@@ -37,7 +37,7 @@ func NewTCPOutput(address string, config *settings.TCPOutputConfig) core.PluginW
 	o.address = address
 	o.config = config
 
-	if settings.Settings.OutputTCPStats {
+	if o.config.Stats {
 		o.bufStats = core.NewGorStat("output_tcp", 5000)
 	}
 
@@ -129,7 +129,7 @@ func (o *TCPOutput) PluginWrite(msg *common.Message) (n int, err error) {
 	bufferIndex := o.getBufferIndex(msg)
 	o.buf[bufferIndex] <- msg
 
-	if settings.Settings.OutputTCPStats {
+	if o.config.Stats {
 		o.bufStats.Write(len(o.buf[bufferIndex]))
 	}
 
@@ -159,4 +159,7 @@ func (o *TCPOutput) String() string {
 
 func (o *TCPOutput) Close() {
 	o.close = true
+	if o.bufStats != nil {
+		o.bufStats.Close()
+	}
 }
@@ -4,10 +4,8 @@ import (
 	"bufio"
 	"log"
 	"net"
-	"record-traffic-press/goreplay"
-	"record-traffic-press/goreplay/bootstrap"
+	"record-traffic-press/goreplay/common"
 	"record-traffic-press/goreplay/core"
-	"record-traffic-press/goreplay/plugins"
 	"record-traffic-press/goreplay/proto"
 	"record-traffic-press/goreplay/settings"
 	"strings"
@@ -24,7 +22,7 @@ func TestTCPOutput(t *testing.T) {
 	listener := startTCP(func(data []byte) {
 		wg.Done()
 	})
-	output := NewTCPOutput(listener.Addr().String(), &TCPOutputConfig{Workers: 10})
+	output := NewTCPOutput(listener.Addr().String(), &settings.TCPOutputConfig{Workers: 10})
 	runTCPOutput(wg, output, 10, false)
 }
 
@@ -43,7 +41,7 @@ func startTCP(cb func([]byte)) net.Listener {
 				defer conn.Close()
 				reader := bufio.NewReader(conn)
 				scanner := bufio.NewScanner(reader)
-				scanner.Split(goreplay.payloadScanner)
+				scanner.Split(proto.PayloadScanner)
 
 				for scanner.Scan() {
 					cb(scanner.Bytes())
@@ -61,30 +59,21 @@ func BenchmarkTCPOutput(b *testing.B) {
 	listener := startTCP(func(data []byte) {
 		wg.Done()
 	})
-	input := bootstrap.NewTestInput()
-	input.data = make(chan []byte, b.N)
-	for i := 0; i < b.N; i++ {
-		input.EmitGET()
-	}
 	wg.Add(b.N)
-	output := NewTCPOutput(listener.Addr().String(), &TCPOutputConfig{Workers: 10})
-
-	plugins := &core.InOutPlugins{
-		Inputs:  []core.PluginReader{input},
-		Outputs: []core.PluginWriter{output},
-	}
-
-	emitter := bootstrap.NewEmitter()
+	output := NewTCPOutput(listener.Addr().String(), &settings.TCPOutputConfig{Workers: 10})
 	// avoid counting above initialization
 	b.ResetTimer()
-	go emitter.Start(plugins, settings.Settings.Middleware)
+
+	for i := 0; i < b.N; i++ {
+		writeRequests(output, nil, testGET)
+	}
 
 	wg.Wait()
-	emitter.Close()
+	output.(*TCPOutput).Close()
 }
 
 func TestStickyDisable(t *testing.T) {
-	tcpOutput := TCPOutput{config: &TCPOutputConfig{Sticky: false, Workers: 10}}
+	tcpOutput := TCPOutput{config: &settings.TCPOutputConfig{Sticky: false, Workers: 10}}
 
 	for i := 0; i < 10; i++ {
 		index := tcpOutput.getBufferIndex(getTestBytes())
@@ -100,7 +89,7 @@ func TestBufferDistribution(t *testing.T) {
 	percentDistributionErrorRange := 20
 
 	buffer := make([]int, numberOfWorkers)
-	tcpOutput := TCPOutput{config: &TCPOutputConfig{Sticky: true, Workers: 10}}
+	tcpOutput := TCPOutput{config: &settings.TCPOutputConfig{Sticky: true, Workers: 10}}
 	for i := 0; i < numberOfMessages; i++ {
 		buffer[tcpOutput.getBufferIndex(getTestBytes())]++
 	}
@@ -118,9 +107,9 @@ func TestBufferDistribution(t *testing.T) {
 	}
 }
 
-func getTestBytes() *plugins.Message {
-	return &plugins.Message{
-		Meta: goreplay.payloadHeader(proto.RequestPayload, goreplay.uuid(), time.Now().UnixNano(), -1),
+func getTestBytes() *common.Message {
+	return &common.Message{
+		Meta: proto.PayloadHeader(proto.RequestPayload, proto.Uuid(), time.Now().UnixNano(), -1),
 		Data: []byte("GET / HTTP/1.1\r\nHost: www.w3.org\r\nUser-Agent: Go 1.1 package http\r\nAccept-Encoding: gzip\r\n\r\n"),
 	}
 }
@@ -133,13 +122,13 @@ func TestTCPOutputGetInitMessage(t *testing.T) {
 		dataList = append(dataList, data)
 		wg.Done()
 	})
-	getInitMessage := func() *plugins.Message {
-		return &plugins.Message{
+	getInitMessage := func() *common.Message {
+		return &common.Message{
 			Meta: []byte{},
 			Data: []byte("test1"),
 		}
 	}
-	output := NewTCPOutput(listener.Addr().String(), &TCPOutputConfig{Workers: 1, GetInitMessage: getInitMessage})
+	output := NewTCPOutput(listener.Addr().String(), &settings.TCPOutputConfig{Workers: 1, GetInitMessage: getInitMessage})
 
 	runTCPOutput(wg, output, 1, true)
 
@@ -156,17 +145,17 @@ func TestTCPOutputGetInitMessageAndWriteBeforeMessage(t *testing.T) {
 		dataList = append(dataList, data)
 		wg.Done()
 	})
-	getInitMessage := func() *plugins.Message {
-		return &plugins.Message{
+	getInitMessage := func() *common.Message {
+		return &common.Message{
 			Meta: []byte{},
 			Data: []byte("test2"),
 		}
 	}
-	writeBeforeMessage := func(conn net.Conn, _ *plugins.Message) error {
+	writeBeforeMessage := func(conn net.Conn, _ *common.Message) error {
 		_, err := conn.Write([]byte("before"))
 		return err
 	}
-	output := NewTCPOutput(listener.Addr().String(), &TCPOutputConfig{Workers: 1, GetInitMessage: getInitMessage, WriteBeforeMessage: writeBeforeMessage})
+	output := NewTCPOutput(listener.Addr().String(), &settings.TCPOutputConfig{Workers: 1, GetInitMessage: getInitMessage, WriteBeforeMessage: writeBeforeMessage})
 
 	runTCPOutput(wg, output, 1, true)
 
@@ -177,23 +166,14 @@ func TestTCPOutputGetInitMessageAndWriteBeforeMessage(t *testing.T) {
 }
 
 func runTCPOutput(wg *sync.WaitGroup, output core.PluginWriter, repeat int, initMessage bool) {
-	input := bootstrap.NewTestInput()
-	plugins := &core.InOutPlugins{
-		Inputs:  []core.PluginReader{input},
-		Outputs: []core.PluginWriter{output},
-	}
-
-	emitter := bootstrap.NewEmitter()
-	go emitter.Start(plugins, settings.Settings.Middleware)
-
 	if initMessage {
 		wg.Add(1)
 	}
 	for i := 0; i < repeat; i++ {
 		wg.Add(1)
-		input.EmitGET()
+		writeRequests(output, nil, testGET)
 	}
 
 	wg.Wait()
-	emitter.Close()
+	output.(*TCPOutput).Close()
 }
@@ -57,7 +57,7 @@ func NewWebSocketOutput(address string, config *settings.WebSocketOutputConfig)
 	u.User = nil // must be after creating the headers
 	o.address = u.String()
 
-	if settings.Settings.OutputWebSocketStats {
+	if o.config.Stats {
 		o.bufStats = core.NewGorStat("output_ws", 5000)
 	}
 
@@ -109,14 +109,18 @@ func (o *WebSocketOutput) worker(bufferIndex int) {
 }
 
 func (o *WebSocketOutput) getBufferIndex(msg *common.Message) int {
-	if !o.config.Sticky {
+	hasher := fnv.New32a()
+	switch {
+	case o.config.Session != "":
+		// every payload of a session goes over the connection of the same worker, in captured order
+		hasher.Write(proto.SessionID(proto.PayloadID(msg.Meta), o.config.Session))
+	case o.config.Sticky:
+		hasher.Write(proto.PayloadID(msg.Meta))
+	default:
 		o.workerIndex++
 		return int(o.workerIndex) % o.config.Workers
 	}
-
-	hasher := fnv.New32a()
-	hasher.Write(proto.PayloadID(msg.Meta))
-	return int(hasher.Sum32()) % o.config.Workers
+	return int(hasher.Sum32() % uint32(o.config.Workers))
 }
 
 // PluginWrite writes message to this plugin
@@ -128,7 +132,7 @@ func (o *WebSocketOutput) PluginWrite(msg *common.Message) (n int, err error) {
 	bufferIndex := o.getBufferIndex(msg)
 	o.buf[bufferIndex] <- msg
 
-	if settings.Settings.OutputTCPStats {
+	if o.config.Stats {
 		o.bufStats.Write(len(o.buf[bufferIndex]))
 	}
 
@@ -156,4 +160,7 @@ func (o *WebSocketOutput) String() string {
 // Close closes the output
 func (o *WebSocketOutput) Close() {
 	o.close = true
+	if o.bufStats != nil {
+		o.bufStats.Close()
+	}
 }
@@ -3,8 +3,7 @@ package output
 import (
 	"log"
 	"net/http"
-	"record-traffic-press/goreplay/bootstrap"
-	"record-traffic-press/goreplay/core"
+	"net/http/httptest"
 	"record-traffic-press/goreplay/settings"
 	"sync"
 	"testing"
@@ -17,33 +16,24 @@ func TestWebSocketOutput(t *testing.T) {
 	wg := new(sync.WaitGroup)
 
 	var gotHeader http.Header
-	wsAddr := startWebsocket(func(data []byte) {
+	wsAddr := startWebsocket(t, func(data []byte) {
 		wg.Done()
 	}, func(header http.Header) {
 		gotHeader = header
 	})
-	input := bootstrap.NewTestInput()
 	headers := map[string][]string{
 		"key1": {"value1"},
 		"key2": {"value2"},
 	}
-	output := NewWebSocketOutput(wsAddr, &WebSocketOutputConfig{Workers: 1, Headers: headers})
-
-	plugins := &core.InOutPlugins{
-		Inputs:  []core.PluginReader{input},
-		Outputs: []core.PluginWriter{output},
-	}
-
-	emitter := bootstrap.NewEmitter()
-	go emitter.Start(plugins, settings.Settings.Middleware)
+	output := NewWebSocketOutput(wsAddr, &settings.WebSocketOutputConfig{Workers: 1, Headers: headers})
 
 	for i := 0; i < 10; i++ {
 		wg.Add(1)
-		input.EmitGET()
+		writeRequests(output, nil, testGET)
 	}
 
 	wg.Wait()
-	emitter.Close()
+	output.(*WebSocketOutput).Close()
 
 	if assert.NotNil(t, gotHeader) {
 		assert.Equal(t, "Basic dXNlcjE=", gotHeader.Get("Authorization"))
@@ -54,10 +44,11 @@ func TestWebSocketOutput(t *testing.T) {
 	}
 }
 
-func startWebsocket(cb func([]byte), headercb func(http.Header)) string {
+func startWebsocket(t *testing.T, cb func([]byte), headercb func(http.Header)) string {
 	upgrader := websocket.Upgrader{}
 
-	http.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
 		headercb(r.Header)
 		c, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
@@ -68,18 +59,17 @@ func startWebsocket(cb func([]byte), headercb func(http.Header)) string {
 		go func(conn *websocket.Conn) {
 			defer conn.Close()
 			for {
-				_, msg, _ := conn.ReadMessage()
+				_, msg, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
 				cb(msg)
 			}
 		}(c)
 	})
 
-	go func() {
-		err := http.ListenAndServe("localhost:8081", nil)
-		if err != nil {
-			log.Fatal("Can't start:", err)
-		}
-	}()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
 
-	return "ws://user1@localhost:8081/test"
+	return "ws://user1@" + server.Listener.Addr().String() + "/test"
 }
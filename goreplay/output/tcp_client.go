@@ -1,4 +1,4 @@
-package bootstrap
+package output
 
 import (
 	"crypto/tls"
@@ -6,7 +6,6 @@ import (
 	"net"
 	"record-traffic-press/goreplay/common"
 	"record-traffic-press/goreplay/glogs"
-	//"record-traffic-press/goreplay/output"
 	"runtime/debug"
 	"syscall"
 	"time"
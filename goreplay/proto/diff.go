@@ -0,0 +1,312 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiffRecord is the comparison of an original response and its replayed response
+type DiffRecord struct {
+	ID              string       `json:"id"`
+	Protocol        string       `json:"protocol"`
+	Endpoint        string       `json:"endpoint"`
+	Timestamp       int64        `json:"timestamp"`
+	Match           bool         `json:"match"`
+	OriginalLatency int64        `json:"original_latency"`
+	ReplayedLatency int64        `json:"replayed_latency"`
+	Diffs           []Difference `json:"diffs,omitempty"`
+}
+
+// Difference is a single mismatched field, Field is "status", "header.<name>", "exception", "body[.<path>]", "reply[.<path>]" or "result[.<path>]"
+type Difference struct {
+	Field    string `json:"field"`
+	Original string `json:"original"`
+	Replayed string `json:"replayed"`
+}
+
+// DiffIgnore holds fields skipped by DiffValues. An entry matches either the
+// field name anywhere in the document, e.g. "timestamp", or its path without
+// array indexes, e.g. "data.items.traceId".
+type DiffIgnore map[string]struct{}
+
+// NewDiffIgnore creates DiffIgnore from the list of fields
+func NewDiffIgnore(fields []string) DiffIgnore {
+	ignore := make(DiffIgnore, len(fields))
+	for _, f := range fields {
+		ignore[f] = struct{}{}
+	}
+	return ignore
+}
+
+func (ignore DiffIgnore) has(name, path string) bool {
+	if _, ok := ignore[name]; ok {
+		return true
+	}
+	_, ok := ignore[path]
+	return ok
+}
+
+// DiffJSON compares two JSON documents, falling back to bytes comparison when any of them is not JSON
+func DiffJSON(field string, original, replayed []byte, ignore DiffIgnore) []Difference {
+	var a, b interface{}
+	if json.Unmarshal(original, &a) != nil || json.Unmarshal(replayed, &b) != nil {
+		if bytes.Equal(original, replayed) {
+			return nil
+		}
+		return []Difference{{Field: field, Original: string(original), Replayed: string(replayed)}}
+	}
+	return DiffValues(field, a, b, ignore)
+}
+
+// DiffValues compares decoded JSON or hessian values and returns the mismatched fields sorted by path
+func DiffValues(field string, original, replayed interface{}, ignore DiffIgnore) []Difference {
+	var diffs []Difference
+	diffValue(&diffs, field, "", normalizeValue(original), normalizeValue(replayed), ignore)
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Field < diffs[j].Field })
+	return diffs
+}
+
+func diffValue(diffs *[]Difference, field, path string, a, b interface{}, ignore DiffIgnore) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]struct{}, len(av)+len(bv))
+		for k := range av {
+			keys[k] = struct{}{}
+		}
+		for k := range bv {
+			keys[k] = struct{}{}
+		}
+		for k := range keys {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			if ignore.has(k, p) {
+				continue
+			}
+			diffValue(diffs, field+"."+k, p, av[k], bv[k], ignore)
+		}
+		return
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			break
+		}
+		for i := range av {
+			diffValue(diffs, field+"["+strconv.Itoa(i)+"]", path, av[i], bv[i], ignore)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*diffs = append(*diffs, Difference{Field: field, Original: formatValue(a), Replayed: formatValue(b)})
+	}
+}
+
+// normalizeValue converts hessian maps and slices into the shapes produced by encoding/json,
+// so both protocols share the same comparison
+func normalizeValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[k] = normalizeValue(item)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[fmt.Sprint(k)] = normalizeValue(item)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(val))
+		for i, item := range val {
+			s[i] = normalizeValue(item)
+		}
+		return s
+	case float64, string, bool:
+		return val
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32:
+		return rv.Float()
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return normalizeValue(rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return fmt.Sprint(v)
+		}
+		s := make([]interface{}, rv.Len())
+		for i := range s {
+			s[i] = normalizeValue(rv.Index(i).Interface())
+		}
+		return s
+	case reflect.Map:
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = normalizeValue(iter.Value().Interface())
+		}
+		return m
+	case reflect.Struct:
+		// registered hessian POJOs, compared by their exported fields
+		m := make(map[string]interface{}, rv.NumField())
+		for i := 0; i < rv.NumField(); i++ {
+			if f := rv.Type().Field(i); f.IsExported() {
+				m[f.Name] = normalizeValue(rv.Field(i).Interface())
+			}
+		}
+		if len(m) > 0 {
+			return m
+		}
+	}
+
+	return fmt.Sprint(v)
+}
+
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	}
+	if b, err := json.Marshal(v); err == nil {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// DiffHTTP compares status, the given headers and bodies of two HTTP responses
+func DiffHTTP(original, replayed []byte, headers []string, ignore DiffIgnore) []Difference {
+	var diffs []Difference
+
+	if a, b := Status(original), Status(replayed); !bytes.Equal(a, b) {
+		diffs = append(diffs, Difference{Field: "status", Original: string(a), Replayed: string(b)})
+	}
+
+	for _, name := range headers {
+		a, b := Header(original, []byte(name)), Header(replayed, []byte(name))
+		if !bytes.Equal(a, b) {
+			diffs = append(diffs, Difference{Field: "header." + name, Original: string(a), Replayed: string(b)})
+		}
+	}
+
+	return append(diffs, DiffJSON("body", Body(original), Body(replayed), ignore)...)
+}
+
+// DiffDubbo compares status, exception and return value of two dubbo responses
+func DiffDubbo(original, replayed []byte, ignore DiffIgnore) []Difference {
+	_, a, err := DecodeDubboResponse(original)
+	if err != nil {
+		return []Difference{{Field: "body", Original: err.Error()}}
+	}
+	_, b, err := DecodeDubboResponse(replayed)
+	if err != nil {
+		return []Difference{{Field: "body", Replayed: err.Error()}}
+	}
+
+	var diffs []Difference
+	if a.Status != b.Status {
+		diffs = append(diffs, Difference{Field: "status", Original: strconv.Itoa(int(a.Status)), Replayed: strconv.Itoa(int(b.Status))})
+	}
+	if a.Exception != b.Exception {
+		diffs = append(diffs, Difference{Field: "exception", Original: a.Exception, Replayed: b.Exception})
+	}
+
+	return append(diffs, DiffValues("body", a.Value, b.Value, ignore)...)
+}
+
+// DubboEndpoint returns "service.method" of a dubbo request, empty if it can't be decoded
+func DubboEndpoint(request []byte) string {
+	_, body, err := DecodeDubboRequest(request)
+	if err != nil || body == nil {
+		return ""
+	}
+	return body.ServiceName + "." + body.MethodName
+}
+
+// HTTPEndpoint returns "METHOD /path" of a HTTP request, without the query string
+func HTTPEndpoint(request []byte) string {
+	path := string(Path(request))
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	return string(Method(request)) + " " + path
+}
+
+// DiffStats aggregates the comparisons of an endpoint, latencies are sums in nanoseconds
+type DiffStats struct {
+	Protocol        string `json:"protocol"`
+	Endpoint        string `json:"endpoint"`
+	Total           int64  `json:"total"`
+	Matched         int64  `json:"matched"`
+	Mismatched      int64  `json:"mismatched"`
+	Missing         int64  `json:"missing"` // requests whose original or replayed response never arrived
+	OriginalLatency int64  `json:"original_latency"`
+	ReplayedLatency int64  `json:"replayed_latency"`
+}
+
+// DiffStatsKey keys the DiffStats of an endpoint, the endpoints of different protocols may have the same text
+func DiffStatsKey(protocol, endpoint string) string {
+	return protocol + " " + endpoint
+}
+
+// Add counts a comparison
+func (s *DiffStats) Add(record *DiffRecord) {
+	s.Total++
+	if record.Match {
+		s.Matched++
+	} else {
+		s.Mismatched++
+	}
+	s.OriginalLatency += record.OriginalLatency
+	s.ReplayedLatency += record.ReplayedLatency
+}
+
+// MismatchRate is the share of mismatched comparisons, missing responses excluded
+func (s *DiffStats) MismatchRate() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Mismatched) / float64(s.Total)
+}
+
+// DiffRedis compares the decoded values of two redis replies
+func DiffRedis(original, replayed []byte, ignore DiffIgnore) []Difference {
+	a, err := DecodeRedisValue(original)
+	if err != nil {
+		return []Difference{{Field: "reply", Original: err.Error()}}
+	}
+	b, err := DecodeRedisValue(replayed)
+	if err != nil {
+		return []Difference{{Field: "reply", Replayed: err.Error()}}
+	}
+	return DiffValues("reply", a, b, ignore)
+}
+
+// DiffMySQL compares the results of two queries
+func DiffMySQL(original, replayed []byte, ignore DiffIgnore) []Difference {
+	return DiffJSON("result", original, replayed, ignore)
+}
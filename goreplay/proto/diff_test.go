@@ -0,0 +1,46 @@
+package proto
+
+import (
+	"testing"
+)
+
+func TestDiffJSON(t *testing.T) {
+	original := []byte(`{"code":0,"traceId":"a1","data":{"items":[{"id":1,"ts":100},{"id":2,"ts":100}],"total":2}}`)
+	replayed := []byte(`{"code":0,"traceId":"b2","data":{"items":[{"id":1,"ts":200},{"id":3,"ts":200}],"total":2}}`)
+
+	diffs := DiffJSON("body", original, replayed, NewDiffIgnore([]string{"traceId", "data.items.ts"}))
+	if len(diffs) != 1 || diffs[0].Field != "body.data.items[1].id" || diffs[0].Original != "2" || diffs[0].Replayed != "3" {
+		t.Errorf("Wrong diffs: %+v", diffs)
+	}
+
+	if diffs = DiffJSON("body", []byte("plain"), []byte("plain"), nil); len(diffs) != 0 {
+		t.Errorf("Equal plain bodies should match: %+v", diffs)
+	}
+	if diffs = DiffJSON("body", []byte("plain"), []byte(`{"a":1}`), nil); len(diffs) != 1 || diffs[0].Field != "body" {
+		t.Errorf("Different plain bodies should not match: %+v", diffs)
+	}
+}
+
+func TestDiffHTTP(t *testing.T) {
+	original := []byte("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nDate: Mon\r\n\r\n{\"a\":1}")
+	replayed := []byte("HTTP/1.1 500 Internal Server Error\r\nContent-Type: application/json\r\nDate: Tue\r\n\r\n{\"a\":1}")
+
+	diffs := DiffHTTP(original, replayed, []string{"Content-Type"}, nil)
+	if len(diffs) != 1 || diffs[0].Field != "status" || diffs[0].Original != "200" || diffs[0].Replayed != "500" {
+		t.Errorf("Wrong diffs: %+v", diffs)
+	}
+}
+
+func TestDiffDubbo(t *testing.T) {
+	original := dubboResponse(t, 1, map[interface{}]interface{}{"name": "alice", "updated": int64(1)})
+	replayed := dubboResponse(t, 1, map[interface{}]interface{}{"name": "bob", "updated": int64(2)})
+
+	diffs := DiffDubbo(original, replayed, NewDiffIgnore([]string{"updated"}))
+	if len(diffs) != 1 || diffs[0].Field != "body.name" || diffs[0].Original != "alice" || diffs[0].Replayed != "bob" {
+		t.Errorf("Wrong diffs: %+v", diffs)
+	}
+
+	if endpoint := DubboEndpoint(dubboRequest(t, 1, "alice")); endpoint != "com.example.UserService.getUser" {
+		t.Errorf("Wrong endpoint: %s", endpoint)
+	}
+}
@@ -1,10 +1,36 @@
 package proto
 
-import hessian "github.com/apache/dubbo-go-hessian2"
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	hessian "github.com/apache/dubbo-go-hessian2"
+)
+
+// Dubbo 协议常量, 协议头共16字节:
+// magic(2) | flag(1) | status(1) | request id(8) | data length(4)
+const (
+	DubboHeaderLength = 16
+
+	DubboMagicHigh = byte(0xda)
+	DubboMagicLow  = byte(0xbb)
+
+	DubboFlagRequest = byte(0x80) // 请求, 否则为响应
+	DubboFlagTwoWay  = byte(0x40) // 需要响应
+	DubboFlagEvent   = byte(0x20) // 事件, 例如心跳
+	DubboSerialMask  = byte(0x1f) // 序列化方式
+
+	DubboSerialHessian2 = byte(2)
+	DubboResponseOK     = byte(20)
+
+	// DubboMaxDataLength dubbo 默认的最大报文长度 8M
+	DubboMaxDataLength = 8 << 20
+)
 
 // DubboHeader Dubbo 协议头
 type DubboHeader struct {
-	hessian.POJO
 	Magic      [2]byte // 魔数
 	Flag       byte    // 标志位
 	Status     byte    // 状态
@@ -12,12 +38,297 @@ type DubboHeader struct {
 	DataLength int32   // 数据长度
 }
 
+// IsRequest 是否为请求
+func (h *DubboHeader) IsRequest() bool {
+	return h.Flag&DubboFlagRequest != 0
+}
+
+// IsTwoWay 请求是否需要响应
+func (h *DubboHeader) IsTwoWay() bool {
+	return h.Flag&DubboFlagTwoWay != 0
+}
+
+// IsEvent 是否为事件(心跳等)
+func (h *DubboHeader) IsEvent() bool {
+	return h.Flag&DubboFlagEvent != 0
+}
+
+// SerialID 序列化方式ID, 2 为 hessian2
+func (h *DubboHeader) SerialID() byte {
+	return h.Flag & DubboSerialMask
+}
+
+// FrameLength 包含协议头的完整报文长度
+func (h *DubboHeader) FrameLength() int {
+	return DubboHeaderLength + int(h.DataLength)
+}
+
 // DubboBody Dubbo 请求体
 type DubboBody struct {
-	DubboVersion   string        // Dubbo 版本
-	ServiceName    string        // 服务接口名
-	ServiceVersion string        // 服务版本
-	MethodName     string        // 方法名
-	ParameterTypes []string      // 参数类型
-	Arguments      []interface{} // 参数值
+	DubboVersion   string            // Dubbo 版本
+	ServiceName    string            // 服务接口名
+	ServiceVersion string            // 服务版本
+	MethodName     string            // 方法名
+	ParameterTypes []string          // 参数类型
+	Arguments      []interface{}     // 参数值
+	Attachments    map[string]string // 隐式参数
+}
+
+// DubboResult Dubbo 响应体
+type DubboResult struct {
+	Status      byte              // 响应状态, 20 为成功
+	Value       interface{}       // 返回值
+	Exception   string            // 异常信息
+	Attachments map[string]string // 隐式参数
+}
+
+// ParseDubboHeader 解析报文开头的 Dubbo 协议头, 魔数或长度不合法时返回 false
+func ParseDubboHeader(payload []byte) (header DubboHeader, ok bool) {
+	if len(payload) < DubboHeaderLength || payload[0] != DubboMagicHigh || payload[1] != DubboMagicLow {
+		return header, false
+	}
+
+	header.Magic = [2]byte{payload[0], payload[1]}
+	header.Flag = payload[2]
+	header.Status = payload[3]
+	header.RequestID = int64(binary.BigEndian.Uint64(payload[4:12]))
+	header.DataLength = int32(binary.BigEndian.Uint32(payload[12:16]))
+
+	if header.DataLength < 0 || header.DataLength > DubboMaxDataLength {
+		return header, false
+	}
+
+	return header, true
+}
+
+// HasDubboHeader 报文是否以 Dubbo 协议头开始
+func HasDubboHeader(payload []byte) bool {
+	_, ok := ParseDubboHeader(payload)
+	return ok
+}
+
+// DubboFrames 按协议头中的长度切分报文, complete 表示报文恰好由完整的帧组成
+func DubboFrames(payload []byte) (frames [][]byte, complete bool) {
+	for len(payload) > 0 {
+		header, ok := ParseDubboHeader(payload)
+		if !ok || len(payload) < header.FrameLength() {
+			return frames, false
+		}
+
+		frames = append(frames, payload[:header.FrameLength()])
+		payload = payload[header.FrameLength():]
+	}
+
+	return frames, len(frames) > 0
+}
+
+// DecodeDubboRequest 解码 hessian2 序列化的请求报文
+func DecodeDubboRequest(frame []byte) (*DubboHeader, *DubboBody, error) {
+	header, data, err := dubboFrameData(frame)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !header.IsRequest() {
+		return header, nil, errors.New("dubbo: not a request")
+	}
+	if header.IsEvent() {
+		return header, nil, nil
+	}
+
+	decoder := hessian.NewDecoder(data)
+	var fields [5]string
+	for i := range fields {
+		v, err := decoder.Decode()
+		if err != nil {
+			return header, nil, fmt.Errorf("dubbo: decode request: %w", err)
+		}
+		fields[i], _ = v.(string)
+	}
+
+	body := &DubboBody{
+		DubboVersion:   fields[0],
+		ServiceName:    fields[1],
+		ServiceVersion: fields[2],
+		MethodName:     fields[3],
+		ParameterTypes: hessian.DescRegex.FindAllString(fields[4], -1),
+	}
+
+	for range body.ParameterTypes {
+		arg, err := decoder.Decode()
+		if err != nil {
+			return header, nil, fmt.Errorf("dubbo: decode argument: %w", err)
+		}
+		body.Arguments = append(body.Arguments, arg)
+	}
+
+	if attachments, err := decoder.Decode(); err == nil {
+		if v, ok := attachments.(map[interface{}]interface{}); ok {
+			body.Attachments = hessian.ToMapStringString(v)
+		}
+	}
+
+	return header, body, nil
+}
+
+// DecodeDubboResponse 解码 hessian2 序列化的响应报文
+func DecodeDubboResponse(frame []byte) (*DubboHeader, *DubboResult, error) {
+	header, data, err := dubboFrameData(frame)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.IsRequest() {
+		return header, nil, errors.New("dubbo: not a response")
+	}
+
+	result := &DubboResult{Status: header.Status}
+	if header.IsEvent() {
+		return header, result, nil
+	}
+
+	decoder := hessian.NewDecoder(data)
+
+	// 非成功状态时响应体为错误信息
+	if header.Status != DubboResponseOK {
+		v, err := decoder.Decode()
+		if err != nil {
+			return header, nil, fmt.Errorf("dubbo: decode error message: %w", err)
+		}
+		result.Exception = fmt.Sprint(v)
+		return header, result, nil
+	}
+
+	rspType, err := decoder.Decode()
+	if err != nil {
+		return header, nil, fmt.Errorf("dubbo: decode response type: %w", err)
+	}
+
+	switch rspType {
+	case hessian.RESPONSE_WITH_EXCEPTION, hessian.RESPONSE_WITH_EXCEPTION_WITH_ATTACHMENTS:
+		v, err := decoder.Decode()
+		if err != nil {
+			return header, nil, fmt.Errorf("dubbo: decode exception: %w", err)
+		}
+		result.Exception = fmt.Sprint(v)
+	case hessian.RESPONSE_VALUE, hessian.RESPONSE_VALUE_WITH_ATTACHMENTS:
+		if result.Value, err = decoder.Decode(); err != nil {
+			return header, nil, fmt.Errorf("dubbo: decode value: %w", err)
+		}
+	case hessian.RESPONSE_NULL_VALUE, hessian.RESPONSE_NULL_VALUE_WITH_ATTACHMENTS:
+	default:
+		return header, nil, fmt.Errorf("dubbo: unknown response type %v", rspType)
+	}
+
+	switch rspType {
+	case hessian.RESPONSE_WITH_EXCEPTION_WITH_ATTACHMENTS, hessian.RESPONSE_VALUE_WITH_ATTACHMENTS, hessian.RESPONSE_NULL_VALUE_WITH_ATTACHMENTS:
+		if attachments, err := decoder.Decode(); err == nil {
+			if v, ok := attachments.(map[interface{}]interface{}); ok {
+				result.Attachments = hessian.ToMapStringString(v)
+			}
+		}
+	}
+
+	return header, result, nil
+}
+
+func dubboFrameData(frame []byte) (*DubboHeader, []byte, error) {
+	header, ok := ParseDubboHeader(frame)
+	if !ok {
+		return nil, nil, errors.New("dubbo: invalid header")
+	}
+	if len(frame) < header.FrameLength() {
+		return &header, nil, errors.New("dubbo: incomplete frame")
+	}
+	if header.SerialID() != DubboSerialHessian2 {
+		return &header, nil, fmt.Errorf("dubbo: unsupported serialization %d", header.SerialID())
+	}
+
+	return &header, frame[DubboHeaderLength:header.FrameLength()], nil
+}
+
+// SetDubboRequestID 替换报文中的请求ID
+func SetDubboRequestID(frame []byte, requestID int64) {
+	binary.BigEndian.PutUint64(frame[4:12], uint64(requestID))
+}
+
+// RewriteDubboRequest 改写请求的服务版本及分组, 参数部分保持原始字节不变, 空值表示不改写
+func RewriteDubboRequest(frame []byte, version, group string) ([]byte, error) {
+	header, data, err := dubboFrameData(frame)
+	if err != nil {
+		return nil, err
+	}
+	if !header.IsRequest() || header.IsEvent() {
+		return nil, errors.New("dubbo: not an invocation")
+	}
+
+	decoder := hessian.NewDecoder(data)
+	var fields [5]interface{}
+	for i := range fields {
+		if fields[i], err = decoder.Decode(); err != nil {
+			return nil, fmt.Errorf("dubbo: decode request: %w", err)
+		}
+	}
+	types, _ := fields[4].(string)
+	for range hessian.DescRegex.FindAllString(types, -1) {
+		if _, err = decoder.Decode(); err != nil {
+			return nil, fmt.Errorf("dubbo: decode argument: %w", err)
+		}
+	}
+	value, err := decoder.Decode()
+	if err != nil {
+		return nil, fmt.Errorf("dubbo: decode attachments: %w", err)
+	}
+	attachments, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("dubbo: wrong attachments")
+	}
+
+	// the decoder does not expose offsets, so locate the arguments by encoding the fields around them again
+	prefix, err := hessianEncode(fields[:]...)
+	if err != nil || !bytes.HasPrefix(data, prefix) {
+		return nil, errors.New("dubbo: can't locate arguments")
+	}
+	suffix, err := hessianEncode(attachments)
+	if err != nil || len(data)-len(suffix) < len(prefix) {
+		return nil, errors.New("dubbo: can't locate attachments")
+	}
+	if v, err := hessian.NewDecoder(data[len(data)-len(suffix):]).Decode(); err != nil {
+		return nil, errors.New("dubbo: can't locate attachments")
+	} else if m, ok := v.(map[interface{}]interface{}); !ok || len(m) != len(attachments) {
+		return nil, errors.New("dubbo: can't locate attachments")
+	}
+	args := data[len(prefix) : len(data)-len(suffix)]
+
+	if version != "" {
+		fields[2] = version
+		attachments[hessian.VERSION_KEY] = version
+	}
+	if group != "" {
+		attachments[hessian.GROUP_KEY] = group
+	}
+
+	if prefix, err = hessianEncode(fields[:]...); err != nil {
+		return nil, err
+	}
+	if suffix, err = hessianEncode(attachments); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, DubboHeaderLength+len(prefix)+len(args)+len(suffix))
+	out = append(out, frame[:DubboHeaderLength]...)
+	out = append(out, prefix...)
+	out = append(out, args...)
+	out = append(out, suffix...)
+	binary.BigEndian.PutUint32(out[12:16], uint32(len(out)-DubboHeaderLength))
+
+	return out, nil
+}
+
+func hessianEncode(values ...interface{}) ([]byte, error) {
+	encoder := hessian.NewEncoder()
+	for _, v := range values {
+		if err := encoder.Encode(v); err != nil {
+			return nil, err
+		}
+	}
+	return encoder.Buffer(), nil
 }
@@ -0,0 +1,147 @@
+package proto
+
+import (
+	"bytes"
+	"testing"
+
+	hessian "github.com/apache/dubbo-go-hessian2"
+)
+
+func dubboRequest(t *testing.T, id int64, args ...interface{}) []byte {
+	codec := hessian.NewHessianCodec(nil)
+	frame, err := codec.Write(hessian.Service{
+		Path:    "com.example.UserService",
+		Version: "1.0.0",
+		Method:  "getUser",
+	}, hessian.DubboHeader{
+		SerialID: DubboSerialHessian2,
+		Type:     hessian.PackageRequest_TwoWay,
+		ID:       id,
+	}, hessian.NewRequest(args, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func dubboResponse(t *testing.T, id int64, value interface{}) []byte {
+	codec := hessian.NewHessianCodec(nil)
+	frame, err := codec.Write(hessian.Service{}, hessian.DubboHeader{
+		SerialID:       DubboSerialHessian2,
+		Type:           hessian.PackageResponse,
+		ID:             id,
+		ResponseStatus: hessian.Response_OK,
+	}, hessian.NewResponse(value, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestParseDubboHeader(t *testing.T) {
+	frame := dubboRequest(t, 42, "alice", int32(18))
+
+	header, ok := ParseDubboHeader(frame)
+	if !ok {
+		t.Fatal("Dubbo header not recognized")
+	}
+	if !header.IsRequest() || !header.IsTwoWay() || header.IsEvent() {
+		t.Errorf("Wrong flags: %08b", header.Flag)
+	}
+	if header.RequestID != 42 {
+		t.Errorf("Expected request ID 42, got %d", header.RequestID)
+	}
+	if header.FrameLength() != len(frame) {
+		t.Errorf("Expected frame length %d, got %d", len(frame), header.FrameLength())
+	}
+
+	if HasDubboHeader([]byte("GET / HTTP/1.1\r\nHost: www.w3.org\r\n\r\n")) {
+		t.Error("HTTP payload should not be recognized as dubbo")
+	}
+}
+
+func TestDubboFrames(t *testing.T) {
+	first := dubboRequest(t, 1, "alice")
+	second := dubboRequest(t, 2, "bob")
+	payload := append(append([]byte{}, first...), second...)
+
+	frames, complete := DubboFrames(payload)
+	if !complete || len(frames) != 2 {
+		t.Fatalf("Expected 2 complete frames, got %d, complete: %v", len(frames), complete)
+	}
+	if !bytes.Equal(frames[0], first) || !bytes.Equal(frames[1], second) {
+		t.Error("Frames not properly split")
+	}
+
+	// the second frame is still being received
+	frames, complete = DubboFrames(payload[:len(payload)-1])
+	if complete || len(frames) != 1 {
+		t.Errorf("Expected 1 frame of an incomplete payload, got %d, complete: %v", len(frames), complete)
+	}
+
+	if _, complete = DubboFrames(first[:DubboHeaderLength-1]); complete {
+		t.Error("Partial header should not be complete")
+	}
+}
+
+func TestDecodeDubboRequest(t *testing.T) {
+	header, body, err := DecodeDubboRequest(dubboRequest(t, 7, "alice", int32(18)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.RequestID != 7 {
+		t.Errorf("Expected request ID 7, got %d", header.RequestID)
+	}
+	if body.ServiceName != "com.example.UserService" || body.ServiceVersion != "1.0.0" || body.MethodName != "getUser" {
+		t.Errorf("Wrong service: %+v", body)
+	}
+	if len(body.ParameterTypes) != 2 || body.ParameterTypes[0] != "Ljava/lang/String;" || body.ParameterTypes[1] != "I" {
+		t.Errorf("Wrong parameter types: %v", body.ParameterTypes)
+	}
+	if len(body.Arguments) != 2 || body.Arguments[0] != "alice" || body.Arguments[1] != int32(18) {
+		t.Errorf("Wrong arguments: %v", body.Arguments)
+	}
+	if body.Attachments["path"] != "com.example.UserService" {
+		t.Errorf("Wrong attachments: %v", body.Attachments)
+	}
+}
+
+func TestDecodeDubboResponse(t *testing.T) {
+	header, result, err := DecodeDubboResponse(dubboResponse(t, 7, "ok"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.IsRequest() || header.RequestID != 7 {
+		t.Errorf("Wrong header: %+v", header)
+	}
+	if result.Status != DubboResponseOK || result.Value != "ok" || result.Exception != "" {
+		t.Errorf("Wrong result: %+v", result)
+	}
+
+	if _, _, err = DecodeDubboResponse(dubboRequest(t, 7)); err == nil {
+		t.Error("Request should not be decoded as response")
+	}
+}
+
+func TestRewriteDubboRequest(t *testing.T) {
+	frame, err := RewriteDubboRequest(dubboRequest(t, 7, "alice", int32(18)), "2.0.0", "green")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, body, err := DecodeDubboRequest(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.RequestID != 7 || header.FrameLength() != len(frame) {
+		t.Errorf("Wrong header: %+v", header)
+	}
+	if body.ServiceVersion != "2.0.0" || body.Attachments["version"] != "2.0.0" || body.Attachments["group"] != "green" {
+		t.Errorf("Version and group not rewritten: %+v", body)
+	}
+	if len(body.Arguments) != 2 || body.Arguments[0] != "alice" || body.Arguments[1] != int32(18) {
+		t.Errorf("Arguments should be kept: %v", body.Arguments)
+	}
+}
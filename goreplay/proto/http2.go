@@ -0,0 +1,368 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// HTTP2Preface is sent by the client before its first frame
+var HTTP2Preface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+// HTTP2FrameHeaderLength is the length of the header of every HTTP/2 frame
+const HTTP2FrameHeaderLength = 9
+
+// HTTP/2 frame types
+const (
+	HTTP2FrameData         = 0x0
+	HTTP2FrameHeaders      = 0x1
+	HTTP2FrameRSTStream    = 0x3
+	HTTP2FramePushPromise  = 0x5
+	HTTP2FrameContinuation = 0x9
+)
+
+// HTTP/2 frame flags
+const (
+	HTTP2FlagEndStream  = 0x1
+	HTTP2FlagEndHeaders = 0x4
+	HTTP2FlagPadded     = 0x8
+	HTTP2FlagPriority   = 0x20
+)
+
+// http2MaxStreams caps the streams in progress of a connection, the oldest are dropped first
+const http2MaxStreams = 1000
+
+// HTTP2FrameHeader is the 9 bytes header of a HTTP/2 frame
+type HTTP2FrameHeader struct {
+	Length   int
+	Type     byte
+	Flags    byte
+	StreamID uint32
+}
+
+// ParseHTTP2FrameHeader parses the header of a frame, ok is false when data is too short
+func ParseHTTP2FrameHeader(data []byte) (h HTTP2FrameHeader, ok bool) {
+	if len(data) < HTTP2FrameHeaderLength {
+		return h, false
+	}
+	h.Length = int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+	h.Type = data[3]
+	h.Flags = data[4]
+	h.StreamID = binary.BigEndian.Uint32(data[5:9]) & 0x7fffffff
+	return h, true
+}
+
+// HTTP2Frames splits data into HTTP/2 frames, the client preface is skipped.
+// complete is true when data ends with a whole frame.
+func HTTP2Frames(data []byte) (frames [][]byte, complete bool) {
+	data = bytes.TrimPrefix(data, HTTP2Preface)
+	for len(data) > 0 {
+		h, ok := ParseHTTP2FrameHeader(data)
+		if !ok || len(data) < HTTP2FrameHeaderLength+h.Length {
+			return frames, false
+		}
+		frames = append(frames, data[:HTTP2FrameHeaderLength+h.Length])
+		data = data[HTTP2FrameHeaderLength+h.Length:]
+	}
+	return frames, true
+}
+
+// HTTP2Message is a request or a response carried by a HTTP/2 stream, rebuilt as text, see HTTP2Request
+type HTTP2Message struct {
+	StreamID uint32
+	Payload  []byte
+}
+
+type http2Stream struct {
+	header  http.Header
+	pseudo  map[string]string
+	trailer http.Header
+	body    []byte
+}
+
+// HTTP2Demuxer rebuilds the requests or the responses sent over one direction of a HTTP/2 connection.
+// It keeps the HPACK state of that direction, so it must be fed all its frames in order.
+type HTTP2Demuxer struct {
+	request bool
+	decoder *hpack.Decoder
+	streams map[uint32]*http2Stream
+	order   []uint32 // streams in progress, oldest first
+
+	// header block waiting for its CONTINUATION frames
+	block       []byte
+	blockStream uint32
+	blockEnd    bool
+	blockPush   bool
+}
+
+// NewHTTP2Demuxer constructor for HTTP2Demuxer, request tells whether the direction is client to server
+func NewHTTP2Demuxer(request bool) *HTTP2Demuxer {
+	d := &HTTP2Demuxer{request: request, streams: make(map[uint32]*http2Stream)}
+	d.decoder = hpack.NewDecoder(4096, nil)
+	// the table size allowed by the peer is in its SETTINGS, which aren't tracked
+	d.decoder.SetAllowedMaxDynamicTableSize(1 << 20)
+	return d
+}
+
+// Feed parses the frames of data and returns the messages of the streams ended by them. The frames of the
+// streams it doesn't know, e.g. started before the capture, are ignored. An error means the HPACK state is
+// lost and the demuxer can't be used anymore.
+func (d *HTTP2Demuxer) Feed(data []byte) (messages []HTTP2Message, err error) {
+	frames, _ := HTTP2Frames(data)
+	for _, frame := range frames {
+		h, _ := ParseHTTP2FrameHeader(frame)
+		payload := frame[HTTP2FrameHeaderLength:]
+
+		switch h.Type {
+		case HTTP2FrameData:
+			payload, ok := http2Unpad(h.Flags, payload)
+			s := d.streams[h.StreamID]
+			if !ok || s == nil {
+				continue
+			}
+			s.body = append(s.body, payload...)
+			if h.Flags&HTTP2FlagEndStream != 0 {
+				messages = append(messages, d.end(h.StreamID))
+			}
+		case HTTP2FrameHeaders, HTTP2FramePushPromise:
+			payload, ok := http2Unpad(h.Flags, payload)
+			if h.Type == HTTP2FrameHeaders && h.Flags&HTTP2FlagPriority != 0 {
+				ok = ok && len(payload) >= 5
+				if ok {
+					payload = payload[5:]
+				}
+			}
+			if h.Type == HTTP2FramePushPromise {
+				ok = ok && len(payload) >= 4
+				if ok {
+					payload = payload[4:]
+				}
+			}
+			if !ok {
+				return messages, fmt.Errorf("malformed header frame on stream %d", h.StreamID)
+			}
+			d.block = append([]byte{}, payload...)
+			d.blockStream = h.StreamID
+			d.blockEnd = h.Type == HTTP2FrameHeaders && h.Flags&HTTP2FlagEndStream != 0
+			d.blockPush = h.Type == HTTP2FramePushPromise
+			if h.Flags&HTTP2FlagEndHeaders == 0 {
+				continue
+			}
+			msg, err := d.endHeaders()
+			if err != nil {
+				return messages, err
+			}
+			if msg != nil {
+				messages = append(messages, *msg)
+			}
+		case HTTP2FrameContinuation:
+			if h.StreamID != d.blockStream || d.block == nil {
+				continue
+			}
+			d.block = append(d.block, payload...)
+			if h.Flags&HTTP2FlagEndHeaders == 0 {
+				continue
+			}
+			msg, err := d.endHeaders()
+			if err != nil {
+				return messages, err
+			}
+			if msg != nil {
+				messages = append(messages, *msg)
+			}
+		case HTTP2FrameRSTStream:
+			d.drop(h.StreamID)
+		}
+	}
+	return messages, nil
+}
+
+// endHeaders decodes a complete header block, every block must be decoded to keep the HPACK state
+func (d *HTTP2Demuxer) endHeaders() (*HTTP2Message, error) {
+	fields, err := d.decoder.DecodeFull(d.block)
+	d.block = nil
+	if err != nil {
+		return nil, fmt.Errorf("hpack stream %d: %w", d.blockStream, err)
+	}
+	if d.blockPush {
+		// pushed responses were not requested by the client, they are not replayed
+		return nil, nil
+	}
+
+	id := d.blockStream
+	header, pseudo := make(http.Header), make(map[string]string)
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") {
+			pseudo[f.Name] = f.Value
+		} else {
+			header.Add(f.Name, f.Value)
+		}
+	}
+
+	s, ok := d.streams[id]
+	switch {
+	case !ok:
+		if !d.request && strings.HasPrefix(pseudo[":status"], "1") {
+			// informational responses are followed by the final one
+			return nil, nil
+		}
+		s = &http2Stream{header: header, pseudo: pseudo}
+		d.streams[id] = s
+		d.order = append(d.order, id)
+		if len(d.order) > http2MaxStreams {
+			d.drop(d.order[0])
+		}
+	default:
+		if s.trailer == nil {
+			s.trailer = make(http.Header)
+		}
+		for name, values := range header {
+			s.trailer[name] = append(s.trailer[name], values...)
+		}
+	}
+
+	if !d.blockEnd {
+		return nil, nil
+	}
+	msg := d.end(id)
+	return &msg, nil
+}
+
+// end rebuilds the message of a stream which is complete
+func (d *HTTP2Demuxer) end(id uint32) HTTP2Message {
+	s := d.streams[id]
+	d.drop(id)
+
+	var payload []byte
+	if d.request {
+		payload = HTTP2Request(s.pseudo[":method"], s.pseudo[":path"], s.pseudo[":authority"], s.header, s.trailer, s.body)
+	} else {
+		status, _ := strconv.Atoi(s.pseudo[":status"])
+		payload = HTTP2Response(status, s.header, s.trailer, s.body)
+	}
+	return HTTP2Message{StreamID: id, Payload: payload}
+}
+
+func (d *HTTP2Demuxer) drop(id uint32) {
+	delete(d.streams, id)
+	for i, v := range d.order {
+		if v == id {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// http2Unpad removes the padding of a DATA, HEADERS or PUSH_PROMISE frame payload
+func http2Unpad(flags byte, payload []byte) ([]byte, bool) {
+	if flags&HTTP2FlagPadded == 0 {
+		return payload, true
+	}
+	if len(payload) == 0 || int(payload[0]) > len(payload)-1 {
+		return nil, false
+	}
+	return payload[1 : len(payload)-int(payload[0])], true
+}
+
+// HTTP2Request rebuilds a HTTP/2 request as text, e.g.
+//
+//	POST /helloworld.Greeter/SayHello HTTP/2.0\r\n
+//	Host: localhost:50051\r\n
+//	Content-Type: application/grpc\r\n
+//	Te: trailers\r\n
+//	\r\n
+//	<length-prefixed gRPC messages>
+//
+// so it can be handled like a HTTP/1 request. The trailers, if any, follow the headers and are
+// listed by the Trailer header.
+func HTTP2Request(method, path, authority string, header, trailer http.Header, body []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(method + " " + path + " HTTP/2.0\r\n")
+	if authority != "" {
+		buf.WriteString("Host: " + authority + "\r\n")
+	}
+	writeHTTP2Header(&buf, header, trailer)
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// HTTP2Response rebuilds a HTTP/2 response as text, like HTTP2Request
+func HTTP2Response(status int, header, trailer http.Header, body []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("HTTP/2.0 " + strconv.Itoa(status) + " " + http.StatusText(status) + "\r\n")
+	writeHTTP2Header(&buf, header, trailer)
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// HasHTTP2RequestTitle reports whether this payload has the title of a request rebuilt by HTTP2Request,
+// only the messages of HTTP/2 streams have one, the HTTP/1 traffic is checked with HasRequestTitle
+func HasHTTP2RequestTitle(payload []byte) bool {
+	return hasRequestTitle(payload, 2)
+}
+
+// HasHTTP2ResponseTitle reports whether this payload has the title of a response rebuilt by HTTP2Response
+func HasHTTP2ResponseTitle(payload []byte) bool {
+	return hasResponseTitle(payload, 2)
+}
+
+func writeHTTP2Header(buf *bytes.Buffer, header, trailer http.Header) {
+	writeHeader := func(h http.Header) {
+		names := make([]string, 0, len(h))
+		for name := range h {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, value := range h[name] {
+				buf.WriteString(http.CanonicalHeaderKey(name) + ": " + value + "\r\n")
+			}
+		}
+	}
+
+	writeHeader(header)
+	// only the trailers received, a response may declare trailers it doesn't send
+	names := make([]string, 0, len(trailer))
+	for name, values := range trailer {
+		if len(values) > 0 {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		buf.WriteString("Trailer: " + strings.Join(names, ", ") + "\r\n")
+		writeHeader(trailer)
+	}
+	buf.WriteString("\r\n")
+}
+
+// SplitHTTP2Trailer separates the headers of a HTTP/2 message rebuilt as text from its trailers
+func SplitHTTP2Trailer(payload []byte) (header, trailer http.Header) {
+	header = http.Header(ParseHeaders(payload))
+	if header == nil {
+		return make(http.Header), nil
+	}
+	names := header.Values("Trailer")
+	if len(names) == 0 {
+		return header, nil
+	}
+	header.Del("Trailer")
+
+	trailer = make(http.Header)
+	for _, value := range names {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if values, ok := header[name]; ok {
+				trailer[name] = values
+				delete(header, name)
+			}
+		}
+	}
+	return header, trailer
+}
@@ -0,0 +1,176 @@
+package proto
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+type http2Writer struct {
+	buf     bytes.Buffer
+	framer  *http2.Framer
+	encoder *hpack.Encoder
+	block   bytes.Buffer
+}
+
+func newHTTP2Writer() *http2Writer {
+	w := &http2Writer{}
+	w.framer = http2.NewFramer(&w.buf, nil)
+	w.encoder = hpack.NewEncoder(&w.block)
+	return w
+}
+
+func (w *http2Writer) headerBlock(fields ...string) []byte {
+	w.block.Reset()
+	for i := 0; i < len(fields); i += 2 {
+		_ = w.encoder.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]})
+	}
+	return append([]byte{}, w.block.Bytes()...)
+}
+
+func (w *http2Writer) headers(stream uint32, endStream bool, fields ...string) {
+	_ = w.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      stream,
+		BlockFragment: w.headerBlock(fields...),
+		EndStream:     endStream,
+		EndHeaders:    true,
+	})
+}
+
+func (w *http2Writer) flush() []byte {
+	data := append([]byte{}, w.buf.Bytes()...)
+	w.buf.Reset()
+	return data
+}
+
+func TestHTTP2Frames(t *testing.T) {
+	w := newHTTP2Writer()
+	_ = w.framer.WriteSettings()
+	_ = w.framer.WriteData(1, true, []byte("hello"))
+	data := append(append([]byte{}, HTTP2Preface...), w.flush()...)
+
+	frames, complete := HTTP2Frames(data)
+	if !complete || len(frames) != 2 {
+		t.Fatalf("expected 2 whole frames, got %d %v", len(frames), complete)
+	}
+	if h, _ := ParseHTTP2FrameHeader(frames[1]); h.Type != HTTP2FrameData || h.StreamID != 1 || h.Length != 5 {
+		t.Errorf("unexpected frame header %+v", h)
+	}
+
+	if frames, complete = HTTP2Frames(data[:len(data)-2]); complete || len(frames) != 1 {
+		t.Errorf("expected 1 whole frame of an incomplete message, got %d %v", len(frames), complete)
+	}
+}
+
+func TestHTTP2DemuxerRequests(t *testing.T) {
+	w := newHTTP2Writer()
+	d := NewHTTP2Demuxer(true)
+	grpcMessage := []byte{0, 0, 0, 0, 3, 0x0a, 0x01, 'x'}
+
+	// a gRPC call, its headers end in the next tcp message
+	_ = w.framer.WriteSettings()
+	w.headers(1, false,
+		":method", "POST", ":scheme", "http", ":path", "/helloworld.Greeter/SayHello", ":authority", "localhost:50051",
+		"content-type", "application/grpc", "te", "trailers")
+	_ = w.framer.WriteData(1, false, grpcMessage[:4])
+	messages, err := d.Feed(append(append([]byte{}, HTTP2Preface...), w.flush()...))
+	if err != nil || len(messages) != 0 {
+		t.Fatalf("expected no message yet, got %d %v", len(messages), err)
+	}
+
+	_ = w.framer.WriteData(1, true, grpcMessage[4:])
+	// the same headers again, encoded with the dynamic table, split over a CONTINUATION frame
+	block := w.headerBlock(
+		":method", "POST", ":scheme", "http", ":path", "/helloworld.Greeter/SayHello", ":authority", "localhost:50051",
+		"content-type", "application/grpc", "te", "trailers")
+	_ = w.framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 3, BlockFragment: block[:2], EndStream: true})
+	_ = w.framer.WriteContinuation(3, true, block[2:])
+	// reset before its end
+	w.headers(5, false, ":method", "GET", ":scheme", "http", ":path", "/", ":authority", "localhost:50051")
+	_ = w.framer.WriteRSTStream(5, http2.ErrCodeCancel)
+	_ = w.framer.WriteData(5, true, []byte("ignored"))
+
+	messages, err = d.Feed(w.flush())
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d %v", len(messages), err)
+	}
+
+	want := "POST /helloworld.Greeter/SayHello HTTP/2.0\r\nHost: localhost:50051\r\nContent-Type: application/grpc\r\nTe: trailers\r\n\r\n"
+	if messages[0].StreamID != 1 || string(messages[0].Payload) != want+string(grpcMessage) {
+		t.Errorf("unexpected request of stream %d: %q", messages[0].StreamID, messages[0].Payload)
+	}
+	if messages[1].StreamID != 3 || string(messages[1].Payload) != want {
+		t.Errorf("unexpected request of stream %d: %q", messages[1].StreamID, messages[1].Payload)
+	}
+
+	p := messages[0].Payload
+	if !HasHTTP2RequestTitle(p) || HasRequestTitle(p) || string(Method(p)) != "POST" || string(Path(p)) != "/helloworld.Greeter/SayHello" ||
+		string(Header(p, []byte("Content-Type"))) != "application/grpc" || !bytes.Equal(Body(p), grpcMessage) {
+		t.Errorf("request should be handled like a HTTP/1 one: %q", p)
+	}
+
+	// a header block which can't be decoded, e.g. the capture started after the connection
+	_ = w.framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 7, BlockFragment: []byte{0xff, 0x64}, EndHeaders: true})
+	if _, err = d.Feed(w.flush()); err == nil {
+		t.Error("expected an hpack error")
+	}
+}
+
+func TestHTTP2DemuxerResponses(t *testing.T) {
+	w := newHTTP2Writer()
+	d := NewHTTP2Demuxer(false)
+
+	w.headers(1, false, ":status", "100")
+	w.headers(1, false, ":status", "200", "content-type", "application/grpc")
+	_ = w.framer.WriteData(1, false, []byte{0, 0, 0, 0, 0})
+	w.headers(1, true, "grpc-status", "0", "grpc-message", "")
+	// trailers-only, a gRPC error
+	w.headers(3, true, ":status", "200", "content-type", "application/grpc", "grpc-status", "5")
+
+	messages, err := d.Feed(w.flush())
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d %v", len(messages), err)
+	}
+
+	want := "HTTP/2.0 200 OK\r\nContent-Type: application/grpc\r\nTrailer: Grpc-Message, Grpc-Status\r\n" +
+		"Grpc-Message: \r\nGrpc-Status: 0\r\n\r\n\x00\x00\x00\x00\x00"
+	if string(messages[0].Payload) != want {
+		t.Errorf("unexpected response %q", messages[0].Payload)
+	}
+	if !HasHTTP2ResponseTitle(messages[0].Payload) || HasResponseTitle(messages[0].Payload) || string(Status(messages[0].Payload)) != "200" {
+		t.Errorf("response should be handled like a HTTP/1 one")
+	}
+
+	header, trailer := SplitHTTP2Trailer(messages[0].Payload)
+	if header.Get("Content-Type") != "application/grpc" || header.Get("Grpc-Status") != "" || header.Get("Trailer") != "" {
+		t.Errorf("unexpected header %v", header)
+	}
+	if trailer.Get("Grpc-Status") != "0" || len(trailer) != 2 {
+		t.Errorf("unexpected trailer %v", trailer)
+	}
+
+	header, trailer = SplitHTTP2Trailer(messages[1].Payload)
+	if header.Get("Grpc-Status") != "5" || trailer != nil {
+		t.Errorf("unexpected trailers-only response %v %v", header, trailer)
+	}
+}
+
+func TestHasHTTP2Title(t *testing.T) {
+	var requests = map[string]bool{
+		"POST /helloworld.Greeter/SayHello HTTP/2.0\r\n": true,
+		"GET / HTTP/1.1\r\n":                             false,
+		"PRI * HTTP/2.0\r\n":                             false,
+		string(HTTP2Preface):                             false,
+	}
+	for k, v := range requests {
+		if HasHTTP2RequestTitle([]byte(k)) != v {
+			t.Errorf("%q should yield %v", k, v)
+		}
+	}
+
+	if !HasHTTP2ResponseTitle([]byte("HTTP/2.0 200 OK\r\n")) || HasHTTP2ResponseTitle([]byte("HTTP/1.1 200 OK\r\n")) {
+		t.Error("only the HTTP/2.0 response titles should be accepted")
+	}
+}
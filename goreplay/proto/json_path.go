@@ -0,0 +1,56 @@
+package proto
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseJSONPath parses "$.a.b[0].c" into string keys and int indexes, the leading "$." is optional
+func ParseJSONPath(path string) ([]interface{}, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+
+	var segments []interface{}
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			key = part[:i]
+		}
+		if key != "" {
+			segments = append(segments, key)
+		}
+
+		for rest := part[len(key):]; rest != ""; {
+			end := strings.IndexByte(rest, ']')
+			if rest[0] != '[' || end < 0 {
+				return nil, fmt.Errorf("invalid path segment %q", part)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index in %q", part)
+			}
+			segments = append(segments, index)
+			rest = rest[end+1:]
+		}
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return segments, nil
+}
+
+// FormatJSONPath is the reverse of ParseJSONPath, e.g. "$.a.b[0].c"
+func FormatJSONPath(path []interface{}) string {
+	var b strings.Builder
+	b.WriteByte('$')
+	for _, segment := range path {
+		switch s := segment.(type) {
+		case int:
+			b.WriteString("[" + strconv.Itoa(s) + "]")
+		default:
+			b.WriteString("." + fmt.Sprint(s))
+		}
+	}
+	return b.String()
+}
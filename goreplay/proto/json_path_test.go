@@ -0,0 +1,41 @@
+package proto
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []interface{}
+	}{
+		{"$.a.b", []interface{}{"a", "b"}},
+		{"a[0].b[1][2]", []interface{}{"a", 0, "b", 1, 2}},
+		{"$[3]", []interface{}{3}},
+	}
+	for _, tt := range tests {
+		got, err := ParseJSONPath(tt.path)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v %v", tt.path, tt.want, got, err)
+		}
+	}
+
+	for _, path := range []string{"$", "a[x]", "a[-1]"} {
+		if _, err := ParseJSONPath(path); err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+}
+
+func TestFormatJSONPath(t *testing.T) {
+	for _, path := range []string{"$.a.b", "$.a[0].b[1][2]", "$[3]"} {
+		segments, err := ParseJSONPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FormatJSONPath(segments); got != path {
+			t.Errorf("expected %s, got %s", path, got)
+		}
+	}
+}
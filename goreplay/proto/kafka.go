@@ -0,0 +1,46 @@
+package proto
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// KafkaMessage should contains catched request information that should be
+// passed as Json to Apache Kafka.
+type KafkaMessage struct {
+	ReqURL     string            `json:"Req_URL"`
+	ReqType    string            `json:"Req_Type"`
+	ReqID      string            `json:"Req_ID"`
+	ReqTs      string            `json:"Req_Ts"`
+	ReqMethod  string            `json:"Req_Method"`
+	ReqBody    string            `json:"Req_Body,omitempty"`
+	ReqHeaders map[string]string `json:"Req_Headers,omitempty"`
+}
+
+// Dump returns the given request in its HTTP/1.x wire
+// representation, prefixed with the payload meta line.
+func (m KafkaMessage) Dump() ([]byte, error) {
+	var b bytes.Buffer
+
+	b.WriteString(fmt.Sprintf("%s %s %s\n", m.ReqType, m.ReqID, m.ReqTs))
+	b.WriteString(fmt.Sprintf("%s %s HTTP/1.1", m.ReqMethod, m.ReqURL))
+	b.Write(CRLF)
+
+	// keep headers in a stable order, map iteration is random
+	keys := make([]string, 0, len(m.ReqHeaders))
+	for key := range m.ReqHeaders {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		b.WriteString(fmt.Sprintf("%s: %s", key, m.ReqHeaders[key]))
+		b.Write(CRLF)
+	}
+
+	b.Write(CRLF)
+	b.WriteString(m.ReqBody)
+
+	return b.Bytes(), nil
+}
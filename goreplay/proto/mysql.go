@@ -0,0 +1,876 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MySQLPacketHeaderLength is the 3 bytes payload length and the sequence ID preceding every packet
+const MySQLPacketHeaderLength = 4
+
+// mysqlMaxPayload is the length of a packet followed by the rest of its payload
+const mysqlMaxPayload = 0xffffff
+
+// MySQL client commands
+const (
+	MySQLComQuit             = 0x01
+	MySQLComInitDB           = 0x02
+	MySQLComQuery            = 0x03
+	MySQLComPing             = 0x0e
+	MySQLComStmtPrepare      = 0x16
+	MySQLComStmtExecute      = 0x17
+	MySQLComStmtSendLongData = 0x18
+	MySQLComStmtClose        = 0x19
+	MySQLComStmtReset        = 0x1a
+)
+
+// MySQL column and parameter types
+const (
+	mysqlTypeDecimal    = 0x00
+	mysqlTypeTiny       = 0x01
+	mysqlTypeShort      = 0x02
+	mysqlTypeLong       = 0x03
+	mysqlTypeFloat      = 0x04
+	mysqlTypeDouble     = 0x05
+	mysqlTypeNull       = 0x06
+	mysqlTypeTimestamp  = 0x07
+	mysqlTypeLongLong   = 0x08
+	mysqlTypeInt24      = 0x09
+	mysqlTypeDate       = 0x0a
+	mysqlTypeTime       = 0x0b
+	mysqlTypeDateTime   = 0x0c
+	mysqlTypeYear       = 0x0d
+	mysqlTypeNewDate    = 0x0e
+	mysqlTypeTimestamp2 = 0x11
+	mysqlTypeDateTime2  = 0x12
+	mysqlTypeTime2      = 0x13
+)
+
+const (
+	mysqlUnsignedFlag      = 0x20
+	mysqlMoreResultsExists = 0x0008
+)
+
+// ErrMySQLIncomplete means more packets are needed to parse a response
+var ErrMySQLIncomplete = errors.New("incomplete MySQL response")
+
+// MySQLPackets splits data into MySQL packets, header included.
+// complete is true when data consists of whole packets only.
+func MySQLPackets(data []byte) (packets [][]byte, complete bool) {
+	for len(data) > 0 {
+		if len(data) < MySQLPacketHeaderLength {
+			return packets, false
+		}
+		n := MySQLPacketHeaderLength + (int(data[0]) | int(data[1])<<8 | int(data[2])<<16)
+		if len(data) < n {
+			return packets, false
+		}
+		packets = append(packets, data[:n])
+		data = data[n:]
+	}
+	return packets, len(packets) > 0
+}
+
+// MySQLPayload is the payload of a packet, or of the packets it was split into when larger than 16MB
+type MySQLPayload struct {
+	Seq  byte
+	Data []byte
+}
+
+// MySQLPayloads returns the whole payloads at the start of data and the length they take
+func MySQLPayloads(data []byte) (payloads []MySQLPayload, n int) {
+	r := &mysqlReader{data: data}
+	for {
+		seq, payload, err := r.next()
+		if err != nil {
+			return payloads, r.pos
+		}
+		payloads = append(payloads, MySQLPayload{Seq: seq, Data: payload})
+	}
+}
+
+// mysqlReader reads the payloads of data one by one
+type mysqlReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *mysqlReader) next() (seq byte, payload []byte, err error) {
+	pos := r.pos
+	for {
+		if len(r.data)-pos < MySQLPacketHeaderLength {
+			return 0, nil, ErrMySQLIncomplete
+		}
+		h := r.data[pos:]
+		length := int(h[0]) | int(h[1])<<8 | int(h[2])<<16
+		if len(r.data)-pos < MySQLPacketHeaderLength+length {
+			return 0, nil, ErrMySQLIncomplete
+		}
+		if payload == nil {
+			seq = h[3]
+			payload = []byte{}
+		}
+		payload = append(payload, h[MySQLPacketHeaderLength:MySQLPacketHeaderLength+length]...)
+		pos += MySQLPacketHeaderLength + length
+		if length < mysqlMaxPayload {
+			r.pos = pos
+			return seq, payload, nil
+		}
+	}
+}
+
+// MySQLQuery is a query captured from a MySQL connection, the request payload of the mysql protocol.
+// The statements executed by COM_STMT_EXECUTE carry the SQL of their COM_STMT_PREPARE and their
+// arguments, so they can be replayed like a query.
+type MySQLQuery struct {
+	Command string        `json:"command"` // "query" or "execute"
+	SQL     string        `json:"sql"`
+	Args    []interface{} `json:"args,omitempty"`
+}
+
+// MySQLResult is the response of a query: the columns and rows of its result set, values as text and
+// NULL as nil, or the affected rows of a statement, or an error. Only the first result of a multi
+// statements query or of a stored procedure is kept.
+type MySQLResult struct {
+	Columns      []string        `json:"columns,omitempty"`
+	Rows         [][]interface{} `json:"rows,omitempty"`
+	AffectedRows uint64          `json:"affected_rows,omitempty"`
+	LastInsertID uint64          `json:"last_insert_id,omitempty"`
+	Error        *MySQLError     `json:"error,omitempty"`
+}
+
+// MySQLError is an error returned by the server
+type MySQLError struct {
+	Code    uint16 `json:"code"`
+	State   string `json:"state,omitempty"`
+	Message string `json:"message"`
+}
+
+// EncodeMySQLQuery encodes a query as a request payload
+func EncodeMySQLQuery(q *MySQLQuery) []byte {
+	return encodeJSON(q)
+}
+
+// EncodeMySQLResult encodes a result as a response payload
+func EncodeMySQLResult(r *MySQLResult) []byte {
+	return encodeJSON(r)
+}
+
+// encodeJSON keeps <, > and & of the SQL and the values readable
+func encodeJSON(v interface{}) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// HasMySQLQuery reports whether payload is a query encoded by EncodeMySQLQuery
+func HasMySQLQuery(payload []byte) bool {
+	if !bytes.HasPrefix(payload, []byte(`{"command":"`)) {
+		return false
+	}
+	_, err := ParseMySQLQuery(payload)
+	return err == nil
+}
+
+// ParseMySQLQuery decodes a query encoded by EncodeMySQLQuery, the numeric arguments are int64,
+// uint64 or float64
+func ParseMySQLQuery(payload []byte) (*MySQLQuery, error) {
+	q := new(MySQLQuery)
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(q); err != nil {
+		return nil, err
+	}
+	if q.Command == "" || q.SQL == "" {
+		return nil, errors.New("not a MySQL query")
+	}
+	for i, arg := range q.Args {
+		n, ok := arg.(json.Number)
+		if !ok {
+			continue
+		}
+		if v, err := n.Int64(); err == nil {
+			q.Args[i] = v
+		} else if v, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+			q.Args[i] = v
+		} else {
+			q.Args[i], _ = n.Float64()
+		}
+	}
+	return q, nil
+}
+
+// MySQLEndpoint returns the digest of a query, see MySQLDigest
+func MySQLEndpoint(payload []byte) string {
+	q, err := ParseMySQLQuery(payload)
+	if err != nil {
+		return ""
+	}
+	return MySQLDigest(q.SQL)
+}
+
+var (
+	mysqlListRe   = regexp.MustCompile(`\(\?(?: ?, ?\?)+\)`)
+	mysqlTuplesRe = regexp.MustCompile(`\(\.\.\.\)(?: ?, ?\(\.\.\.\))+`)
+)
+
+// MySQLDigest normalizes a query so the queries differing only by their values share it: the literals
+// are replaced by ?, the lists of them by (...), comments are removed and spaces are collapsed, e.g.
+// "SELECT * FROM t WHERE id IN (1, 2) AND name = 'a'" becomes "SELECT * FROM t WHERE id IN (...) AND name = ?"
+func MySQLDigest(sql string) string {
+	out := make([]byte, 0, len(sql))
+	space := false
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+			continue
+		case c == '#' || strings.HasPrefix(sql[i:], "-- "):
+			if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(sql)
+			}
+			space = true
+			continue
+		case strings.HasPrefix(sql[i:], "/*"):
+			if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(sql)
+			}
+			space = true
+			continue
+		}
+
+		if space && len(out) > 0 {
+			out = append(out, ' ')
+		}
+		space = false
+
+		switch {
+		case c == '\'' || c == '"':
+			i = mysqlSkipQuoted(sql, i)
+			out = append(out, '?')
+		case c == '`':
+			j := len(sql)
+			if end := strings.IndexByte(sql[i+1:], '`'); end >= 0 {
+				j = i + end + 2
+			}
+			out = append(out, sql[i:j]...)
+			i = j
+		case c >= '0' && c <= '9' && (len(out) == 0 || !isMySQLIdentifierChar(out[len(out)-1])):
+			i = mysqlSkipNumber(sql, i)
+			out = append(out, '?')
+		default:
+			out = append(out, c)
+			i++
+		}
+	}
+
+	digest := mysqlListRe.ReplaceAllString(string(out), "(...)")
+	return mysqlTuplesRe.ReplaceAllString(digest, "(...)")
+}
+
+// mysqlSkipQuoted returns the position following the string literal starting at i
+func mysqlSkipQuoted(sql string, i int) int {
+	quote := sql[i]
+	for i++; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// mysqlSkipNumber returns the position following the number literal starting at i, e.g. 42, 1.5e-3 or 0xff
+func mysqlSkipNumber(sql string, i int) int {
+	if strings.HasPrefix(sql[i:], "0x") || strings.HasPrefix(sql[i:], "0X") {
+		i += 2
+		for i < len(sql) && strings.IndexByte("0123456789abcdefABCDEF", sql[i]) >= 0 {
+			i++
+		}
+		return i
+	}
+	for i < len(sql) && (sql[i] >= '0' && sql[i] <= '9' || sql[i] == '.') {
+		i++
+	}
+	if i+1 < len(sql) && (sql[i] == 'e' || sql[i] == 'E') {
+		j := i + 1
+		if sql[j] == '-' || sql[j] == '+' {
+			j++
+		}
+		if j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+			for i = j; i < len(sql) && sql[i] >= '0' && sql[i] <= '9'; i++ {
+			}
+		}
+	}
+	return i
+}
+
+// isMySQLIdentifierChar reports whether c may be part of an identifier, a digit following it is not a literal, e.g. t1
+func isMySQLIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || c == '@' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+var mysqlWriteRe = regexp.MustCompile(`(?i)\b(INSERT|UPDATE|DELETE|REPLACE|INTO\s+(OUTFILE|DUMPFILE))\b`)
+
+// IsMySQLReadOnly reports whether a query only reads data: SELECT, SHOW, DESCRIBE, EXPLAIN, TABLE and
+// VALUES statements and the WITH ones selecting data, without INTO OUTFILE or DUMPFILE
+func IsMySQLReadOnly(sql string) bool {
+	digest := strings.TrimRight(MySQLDigest(sql), "; ")
+	keyword := strings.ToUpper(strings.TrimLeft(digest, "( "))
+	if i := strings.IndexAny(keyword, " (;"); i >= 0 {
+		keyword = keyword[:i]
+	}
+	if strings.Contains(digest, ";") {
+		// multi statements
+		return false
+	}
+
+	switch keyword {
+	case "SELECT", "WITH", "TABLE", "VALUES":
+		return !mysqlWriteRe.MatchString(digest)
+	case "SHOW", "DESCRIBE", "DESC", "EXPLAIN":
+		return true
+	}
+	return false
+}
+
+// mysqlColumn is the part of a column definition needed to decode the binary rows
+type mysqlColumn struct {
+	name     string
+	kind     byte
+	flags    uint16
+	decimals byte
+}
+
+// ParseMySQLResponse parses the response of a COM_QUERY, or of a COM_STMT_EXECUTE when binary is set,
+// at the start of data and returns the length it takes. The results following the first one are
+// skipped, ErrMySQLIncomplete means more data is needed. Simple commands like COM_PING are answered
+// by an OK packet, parsed as a result without columns.
+func ParseMySQLResponse(data []byte, binary bool) (*MySQLResult, int, error) {
+	r := &mysqlReader{data: data}
+	var first *MySQLResult
+	for {
+		result, more, err := parseMySQLResult(r, binary)
+		if err != nil {
+			return nil, 0, err
+		}
+		if first == nil {
+			first = result
+		}
+		if !more {
+			return first, r.pos, nil
+		}
+	}
+}
+
+func parseMySQLResult(r *mysqlReader, binary bool) (result *MySQLResult, more bool, err error) {
+	_, p, err := r.next()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(p) == 0 {
+		return nil, false, errors.New("empty MySQL packet")
+	}
+
+	switch p[0] {
+	case 0x00:
+		result, status, err := parseMySQLOK(p)
+		return result, status&mysqlMoreResultsExists != 0, err
+	case 0xff:
+		return parseMySQLError(p), false, nil
+	case 0xfb:
+		// LOCAL INFILE request, the client sends the file and the server answers by an OK packet
+		return &MySQLResult{}, false, nil
+	}
+
+	count, _, ok := mysqlLengthEncodedInt(p, 0)
+	if !ok || count == 0 || count > 4096 {
+		return nil, false, fmt.Errorf("MySQL column count %x", p)
+	}
+	columns, err := parseMySQLColumns(r, int(count))
+	if err != nil {
+		return nil, false, err
+	}
+
+	result = &MySQLResult{Columns: make([]string, len(columns))}
+	for i, c := range columns {
+		result.Columns[i] = c.name
+	}
+	for {
+		_, p, err = r.next()
+		if err != nil {
+			return nil, false, err
+		}
+		if len(p) > 0 && p[0] == 0xfe && len(p) < mysqlMaxPayload {
+			// EOF, or OK when the client deprecated EOF
+			status, err := mysqlTerminatorStatus(p)
+			return result, status&mysqlMoreResultsExists != 0, err
+		}
+		if len(p) > 0 && p[0] == 0xff {
+			// the query failed while sending the rows
+			return parseMySQLError(p), false, nil
+		}
+
+		var row []interface{}
+		if binary {
+			row, err = parseMySQLBinaryRow(p, columns)
+		} else {
+			row, err = parseMySQLTextRow(p, len(columns))
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		result.Rows = append(result.Rows, row)
+	}
+}
+
+// parseMySQLColumns reads count column definitions, followed by an EOF packet unless the client deprecated EOF
+func parseMySQLColumns(r *mysqlReader, count int) ([]mysqlColumn, error) {
+	columns := make([]mysqlColumn, count)
+	for i := range columns {
+		_, p, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		if columns[i], err = parseMySQLColumn(p); err != nil {
+			return nil, err
+		}
+	}
+	pos := r.pos
+	_, p, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	// an EOF packet is shorter than the OK one ending the rows of a client which deprecated EOF
+	if len(p) == 0 || p[0] != 0xfe || len(p) >= 7 {
+		r.pos = pos
+	}
+	return columns, nil
+}
+
+// parseMySQLColumn parses a Protocol::ColumnDefinition41
+func parseMySQLColumn(p []byte) (c mysqlColumn, err error) {
+	pos := 0
+	var name []byte
+	// catalog, schema, table, org_table, name, org_name
+	for i := 0; i < 6; i++ {
+		var s []byte
+		var ok bool
+		if s, pos, ok = mysqlLengthEncodedString(p, pos); !ok {
+			return c, errors.New("malformed MySQL column definition")
+		}
+		if i == 4 {
+			name = s
+		}
+	}
+	// fixed fields length, charset, column length, type, flags, decimals
+	if len(p) < pos+1+2+4+1+2+1 {
+		return c, errors.New("malformed MySQL column definition")
+	}
+	pos += 1 + 2 + 4
+	return mysqlColumn{
+		name:     string(name),
+		kind:     p[pos],
+		flags:    binary.LittleEndian.Uint16(p[pos+1:]),
+		decimals: p[pos+3],
+	}, nil
+}
+
+func parseMySQLTextRow(p []byte, count int) ([]interface{}, error) {
+	row := make([]interface{}, count)
+	pos := 0
+	for i := range row {
+		if pos < len(p) && p[pos] == 0xfb {
+			pos++
+			continue
+		}
+		s, next, ok := mysqlLengthEncodedString(p, pos)
+		if !ok {
+			return nil, errors.New("malformed MySQL text row")
+		}
+		row[i], pos = string(s), next
+	}
+	return row, nil
+}
+
+func parseMySQLBinaryRow(p []byte, columns []mysqlColumn) ([]interface{}, error) {
+	// header and NULL bitmap, offset by 2 bits
+	nulls := (len(columns) + 7 + 2) / 8
+	if len(p) < 1+nulls || p[0] != 0x00 {
+		return nil, errors.New("malformed MySQL binary row")
+	}
+	bitmap, pos := p[1:1+nulls], 1+nulls
+
+	row := make([]interface{}, len(columns))
+	for i, c := range columns {
+		if bitmap[(i+2)/8]&(1<<((i+2)%8)) != 0 {
+			continue
+		}
+		v, next, err := mysqlBinaryValue(p, pos, c.kind, c.flags&mysqlUnsignedFlag != 0, int(c.decimals))
+		if err != nil {
+			return nil, err
+		}
+		row[i], pos = mysqlText(v, c.kind), next
+	}
+	return row, nil
+}
+
+// mysqlText formats a binary value like the text protocol does
+func mysqlText(v interface{}, kind byte) interface{} {
+	switch val := v.(type) {
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case uint64:
+		return strconv.FormatUint(val, 10)
+	case float64:
+		if kind == mysqlTypeFloat {
+			return strconv.FormatFloat(val, 'g', -1, 32)
+		}
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	}
+	return v
+}
+
+// mysqlBinaryValue decodes a value of the binary protocol: integers as int64 or uint64, floats as float64,
+// temporal values and the others as string. decimals is the precision of the fractional seconds, -1 to
+// keep the non zero ones.
+func mysqlBinaryValue(p []byte, pos int, kind byte, unsigned bool, decimals int) (interface{}, int, error) {
+	fixed := func(n int) ([]byte, error) {
+		if len(p) < pos+n {
+			return nil, errors.New("truncated MySQL binary value")
+		}
+		return p[pos : pos+n], nil
+	}
+
+	switch kind {
+	case mysqlTypeNull:
+		return nil, pos, nil
+	case mysqlTypeTiny:
+		b, err := fixed(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		if unsigned {
+			return uint64(b[0]), pos + 1, nil
+		}
+		return int64(int8(b[0])), pos + 1, nil
+	case mysqlTypeShort, mysqlTypeYear:
+		b, err := fixed(2)
+		if err != nil {
+			return nil, 0, err
+		}
+		v := binary.LittleEndian.Uint16(b)
+		if unsigned || kind == mysqlTypeYear {
+			return uint64(v), pos + 2, nil
+		}
+		return int64(int16(v)), pos + 2, nil
+	case mysqlTypeLong, mysqlTypeInt24:
+		b, err := fixed(4)
+		if err != nil {
+			return nil, 0, err
+		}
+		v := binary.LittleEndian.Uint32(b)
+		if unsigned {
+			return uint64(v), pos + 4, nil
+		}
+		return int64(int32(v)), pos + 4, nil
+	case mysqlTypeLongLong:
+		b, err := fixed(8)
+		if err != nil {
+			return nil, 0, err
+		}
+		v := binary.LittleEndian.Uint64(b)
+		if unsigned {
+			return v, pos + 8, nil
+		}
+		return int64(v), pos + 8, nil
+	case mysqlTypeFloat:
+		b, err := fixed(4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), pos + 4, nil
+	case mysqlTypeDouble:
+		b, err := fixed(8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), pos + 8, nil
+	case mysqlTypeDate, mysqlTypeNewDate, mysqlTypeDateTime, mysqlTypeTimestamp, mysqlTypeDateTime2, mysqlTypeTimestamp2:
+		b, err := fixed(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		if b, err = fixed(1 + int(b[0])); err != nil {
+			return nil, 0, err
+		}
+		return mysqlDateTime(b[1:], kind == mysqlTypeDate || kind == mysqlTypeNewDate, decimals), pos + len(b), nil
+	case mysqlTypeTime, mysqlTypeTime2:
+		b, err := fixed(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		if b, err = fixed(1 + int(b[0])); err != nil {
+			return nil, 0, err
+		}
+		return mysqlTime(b[1:], decimals), pos + len(b), nil
+	}
+
+	// DECIMAL, strings, BLOBs, JSON, BIT, ENUM, SET and GEOMETRY
+	s, next, ok := mysqlLengthEncodedString(p, pos)
+	if !ok {
+		return nil, 0, errors.New("truncated MySQL binary value")
+	}
+	return string(s), next, nil
+}
+
+// mysqlDateTime formats the 0, 4, 7 or 11 bytes of a binary DATE, DATETIME or TIMESTAMP
+func mysqlDateTime(b []byte, date bool, decimals int) string {
+	var year, month, day, hour, minute, second, micro int
+	if len(b) >= 4 {
+		year, month, day = int(binary.LittleEndian.Uint16(b)), int(b[2]), int(b[3])
+	}
+	if len(b) >= 7 {
+		hour, minute, second = int(b[4]), int(b[5]), int(b[6])
+	}
+	if len(b) >= 11 {
+		micro = int(binary.LittleEndian.Uint32(b[7:]))
+	}
+
+	s := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+	if date {
+		return s
+	}
+	return s + fmt.Sprintf(" %02d:%02d:%02d", hour, minute, second) + mysqlFraction(micro, decimals)
+}
+
+// mysqlTime formats the 0, 8 or 12 bytes of a binary TIME
+func mysqlTime(b []byte, decimals int) string {
+	var negative bool
+	var hours, minute, second, micro int
+	if len(b) >= 8 {
+		negative = b[0] == 1
+		hours = int(binary.LittleEndian.Uint32(b[1:]))*24 + int(b[5])
+		minute, second = int(b[6]), int(b[7])
+	}
+	if len(b) >= 12 {
+		micro = int(binary.LittleEndian.Uint32(b[8:]))
+	}
+
+	s := fmt.Sprintf("%02d:%02d:%02d", hours, minute, second) + mysqlFraction(micro, decimals)
+	if negative {
+		return "-" + s
+	}
+	return s
+}
+
+func mysqlFraction(micro, decimals int) string {
+	if decimals < 0 {
+		if micro == 0 {
+			return ""
+		}
+		decimals = 6
+	}
+	if decimals == 0 || decimals > 6 {
+		return ""
+	}
+	return "." + fmt.Sprintf("%06d", micro)[:decimals]
+}
+
+func parseMySQLOK(p []byte) (*MySQLResult, uint16, error) {
+	affected, pos, ok := mysqlLengthEncodedInt(p, 1)
+	if !ok {
+		return nil, 0, errors.New("malformed MySQL OK packet")
+	}
+	id, pos, ok := mysqlLengthEncodedInt(p, pos)
+	if !ok || len(p) < pos+2 {
+		return nil, 0, errors.New("malformed MySQL OK packet")
+	}
+	return &MySQLResult{AffectedRows: affected, LastInsertID: id}, binary.LittleEndian.Uint16(p[pos:]), nil
+}
+
+// mysqlTerminatorStatus returns the status flags of the EOF or OK packet ending the rows
+func mysqlTerminatorStatus(p []byte) (uint16, error) {
+	if len(p) < 7 {
+		// EOF: header, warnings and status
+		if len(p) < 5 {
+			return 0, nil
+		}
+		return binary.LittleEndian.Uint16(p[3:]), nil
+	}
+	_, status, err := parseMySQLOK(p)
+	return status, err
+}
+
+func parseMySQLError(p []byte) *MySQLResult {
+	e := &MySQLError{}
+	if len(p) >= 3 {
+		e.Code = binary.LittleEndian.Uint16(p[1:])
+	}
+	msg := p[1:]
+	if len(p) >= 3 {
+		msg = p[3:]
+	}
+	if len(msg) >= 6 && msg[0] == '#' {
+		e.State, msg = string(msg[1:6]), msg[6:]
+	}
+	e.Message = string(msg)
+	return &MySQLResult{Error: e}
+}
+
+// MySQLStmt is a statement prepared on a connection, known from the response of its COM_STMT_PREPARE
+type MySQLStmt struct {
+	ID     uint32
+	SQL    string
+	Params int
+	types  []byte // bound by the first execution and kept for the next ones
+}
+
+// ParseMySQLPrepareResponse parses the response of a COM_STMT_PREPARE at the start of data and returns the
+// length it takes. A prepare which failed returns a nil statement and the error result.
+func ParseMySQLPrepareResponse(data []byte) (*MySQLStmt, *MySQLResult, int, error) {
+	r := &mysqlReader{data: data}
+	_, p, err := r.next()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if len(p) > 0 && p[0] == 0xff {
+		return nil, parseMySQLError(p), r.pos, nil
+	}
+	if len(p) < 12 || p[0] != 0x00 {
+		return nil, nil, 0, fmt.Errorf("malformed MySQL prepare response %x", p)
+	}
+
+	stmt := &MySQLStmt{ID: binary.LittleEndian.Uint32(p[1:]), Params: int(binary.LittleEndian.Uint16(p[7:]))}
+	columns := int(binary.LittleEndian.Uint16(p[5:]))
+	for _, count := range []int{stmt.Params, columns} {
+		if count == 0 {
+			continue
+		}
+		for i := 0; i < count; i++ {
+			if _, _, err = r.next(); err != nil {
+				return nil, nil, 0, err
+			}
+		}
+		// the EOF packet, unless the client deprecated EOF. The response is sent at once,
+		// so it's complete when there is nothing more.
+		pos := r.pos
+		if _, p, err = r.next(); err != nil && err != ErrMySQLIncomplete {
+			return nil, nil, 0, err
+		}
+		if err != nil || len(p) == 0 || p[0] != 0xfe || len(p) >= 7 {
+			r.pos = pos
+		}
+	}
+	return stmt, &MySQLResult{}, r.pos, nil
+}
+
+// MySQLStmtID returns the statement ID of a COM_STMT_EXECUTE, COM_STMT_CLOSE or COM_STMT_RESET payload
+func MySQLStmtID(payload []byte) uint32 {
+	if len(payload) < 5 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(payload[1:])
+}
+
+// ExecuteArgs decodes the arguments of a COM_STMT_EXECUTE of the statement, integers as int64 or uint64,
+// floats as float64 and the others as string
+func (s *MySQLStmt) ExecuteArgs(payload []byte) ([]interface{}, error) {
+	// command, statement ID, flags and iteration count
+	pos := 1 + 4 + 1 + 4
+	if len(payload) < pos {
+		return nil, errors.New("malformed MySQL execute")
+	}
+	if s.Params == 0 {
+		return nil, nil
+	}
+
+	nulls := (s.Params + 7) / 8
+	if len(payload) < pos+nulls+1 {
+		return nil, errors.New("malformed MySQL execute")
+	}
+	bitmap := payload[pos : pos+nulls]
+	pos += nulls
+	if payload[pos] == 1 {
+		pos++
+		if len(payload) < pos+2*s.Params {
+			return nil, errors.New("malformed MySQL execute")
+		}
+		s.types = append([]byte{}, payload[pos:pos+2*s.Params]...)
+		pos += 2 * s.Params
+	} else {
+		pos++
+	}
+	if len(s.types) != 2*s.Params {
+		return nil, errors.New("MySQL execute without parameter types")
+	}
+
+	args := make([]interface{}, s.Params)
+	for i := range args {
+		if bitmap[i/8]&(1<<(i%8)) != 0 {
+			continue
+		}
+		v, next, err := mysqlBinaryValue(payload, pos, s.types[2*i], s.types[2*i+1]&0x80 != 0, -1)
+		if err != nil {
+			return nil, err
+		}
+		args[i], pos = v, next
+	}
+	return args, nil
+}
+
+func mysqlLengthEncodedInt(p []byte, pos int) (uint64, int, bool) {
+	if pos >= len(p) {
+		return 0, pos, false
+	}
+	n := 0
+	switch p[pos] {
+	case 0xfc:
+		n = 2
+	case 0xfd:
+		n = 3
+	case 0xfe:
+		n = 8
+	case 0xfb, 0xff:
+		return 0, pos, false
+	default:
+		return uint64(p[pos]), pos + 1, true
+	}
+	if len(p) < pos+1+n {
+		return 0, pos, false
+	}
+	var v uint64
+	for i := n; i > 0; i-- {
+		v = v<<8 | uint64(p[pos+i])
+	}
+	return v, pos + 1 + n, true
+}
+
+func mysqlLengthEncodedString(p []byte, pos int) ([]byte, int, bool) {
+	n, pos, ok := mysqlLengthEncodedInt(p, pos)
+	if !ok || uint64(len(p)-pos) < n {
+		return nil, pos, false
+	}
+	return p[pos : pos+int(n)], pos + int(n), true
+}
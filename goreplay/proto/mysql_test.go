@@ -0,0 +1,210 @@
+package proto
+
+import (
+	"reflect"
+	"testing"
+)
+
+// mysqlTypeVarStringTest is MYSQL_TYPE_VAR_STRING, decoded as the default string type
+const mysqlTypeVarStringTest = 0xfd
+
+// mysqlPacket frames a payload with its length and sequence
+func mysqlPacket(seq byte, payload ...byte) []byte {
+	return append([]byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}, payload...)
+}
+
+func mysqlLenEnc(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+// mysqlColumnDef is a ColumnDefinition41 payload
+func mysqlColumnDef(name string, kind byte, flags byte) []byte {
+	var p []byte
+	for _, s := range []string{"def", "db", "t", "t", name, name} {
+		p = append(p, mysqlLenEnc(s)...)
+	}
+	return append(p, 0x0c, 0x21, 0x00, 0x0b, 0x00, 0x00, 0x00, kind, flags, 0x00, 0x00, 0x00, 0x00)
+}
+
+func mysqlJoin(packets ...[]byte) []byte {
+	var data []byte
+	for _, p := range packets {
+		data = append(data, p...)
+	}
+	return data
+}
+
+func TestMySQLPackets(t *testing.T) {
+	query := mysqlPacket(0, append([]byte{MySQLComQuery}, "SELECT 1"...)...)
+	data := mysqlJoin(query, mysqlPacket(0, MySQLComPing))
+
+	packets, complete := MySQLPackets(data)
+	if !complete || len(packets) != 2 || string(packets[0]) != string(query) {
+		t.Fatalf("expected 2 packets, got %q %v", packets, complete)
+	}
+	if _, complete = MySQLPackets(data[:len(data)-1]); complete {
+		t.Error("a truncated packet should be incomplete")
+	}
+	if _, complete = MySQLPackets(data[:3]); complete {
+		t.Error("a truncated header should be incomplete")
+	}
+
+	payloads, n := MySQLPayloads(append(data, 0x05))
+	if n != len(data) || len(payloads) != 2 || payloads[1].Seq != 0 || string(payloads[0].Data) != "\x03SELECT 1" {
+		t.Errorf("unexpected payloads %+v %d", payloads, n)
+	}
+}
+
+func TestParseMySQLResponse(t *testing.T) {
+	columns := mysqlJoin(
+		mysqlPacket(1, 0x02),
+		mysqlPacket(2, mysqlColumnDef("id", mysqlTypeLongLong, 0)...),
+		mysqlPacket(3, mysqlColumnDef("name", mysqlTypeVarStringTest, 0)...),
+	)
+	rows := mysqlJoin(
+		mysqlPacket(5, append(mysqlLenEnc("1"), mysqlLenEnc("alice")...)...),
+		mysqlPacket(6, append(mysqlLenEnc("2"), 0xfb)...),
+	)
+	expected := &MySQLResult{Columns: []string{"id", "name"}, Rows: [][]interface{}{{"1", "alice"}, {"2", nil}}}
+
+	// with the EOF packets and, when the client deprecated EOF, the OK packet ending the rows
+	withEOF := mysqlJoin(columns, mysqlPacket(4, 0xfe, 0, 0, 0x02, 0), rows, mysqlPacket(7, 0xfe, 0, 0, 0x02, 0))
+	deprecateEOF := mysqlJoin(columns, rows, mysqlPacket(4, 0xfe, 0, 0, 0x02, 0, 0, 0, 0))
+	for _, data := range [][]byte{withEOF, deprecateEOF} {
+		result, n, err := ParseMySQLResponse(data, false)
+		if err != nil || n != len(data) || !reflect.DeepEqual(result, expected) {
+			t.Errorf("expected %+v, got %+v %d %v", expected, result, n, err)
+		}
+		if _, _, err = ParseMySQLResponse(data[:len(data)-2], false); err != ErrMySQLIncomplete {
+			t.Errorf("expected incomplete, got %v", err)
+		}
+	}
+
+	ok := mysqlPacket(1, 0x00, 0x03, 0x2a, 0x02, 0x00, 0x00, 0x00)
+	result, n, err := ParseMySQLResponse(ok, false)
+	if err != nil || n != len(ok) || result.AffectedRows != 3 || result.LastInsertID != 42 {
+		t.Errorf("unexpected OK %+v %v", result, err)
+	}
+
+	// an OK announcing more results is followed by them, only the first one is kept
+	multi := mysqlJoin(mysqlPacket(1, 0x00, 0x01, 0x00, 0x0a, 0x00, 0x00, 0x00), ok)
+	result, n, err = ParseMySQLResponse(multi, false)
+	if err != nil || n != len(multi) || result.AffectedRows != 1 {
+		t.Errorf("unexpected multi results %+v %d %v", result, n, err)
+	}
+
+	errPacket := mysqlPacket(1, append([]byte{0xff, 0x7a, 0x04, '#', '4', '2', 'S', '0', '2'}, "Table 'db.x' doesn't exist"...)...)
+	result, _, err = ParseMySQLResponse(errPacket, false)
+	if err != nil || result.Error == nil || result.Error.Code != 1146 || result.Error.State != "42S02" || result.Error.Message != "Table 'db.x' doesn't exist" {
+		t.Errorf("unexpected error %+v %v", result, err)
+	}
+}
+
+func TestParseMySQLBinaryResponse(t *testing.T) {
+	data := mysqlJoin(
+		mysqlPacket(1, 0x03),
+		mysqlPacket(2, mysqlColumnDef("id", mysqlTypeLongLong, 0x20)...),
+		mysqlPacket(3, mysqlColumnDef("name", mysqlTypeVarStringTest, 0)...),
+		mysqlPacket(4, mysqlColumnDef("score", mysqlTypeDouble, 0)...),
+		mysqlPacket(5, 0xfe, 0, 0, 0x02, 0),
+		// header, null bitmap with score NULL (offset 2), id and name
+		mysqlPacket(6, append([]byte{0x00, 0x10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, mysqlLenEnc("bob")...)...),
+		mysqlPacket(7, 0xfe, 0, 0, 0x02, 0),
+	)
+	result, _, err := ParseMySQLResponse(data, true)
+	expected := [][]interface{}{{"18446744073709551615", "bob", nil}}
+	if err != nil || !reflect.DeepEqual(result.Rows, expected) {
+		t.Errorf("expected %q, got %+v %v", expected, result, err)
+	}
+}
+
+func TestMySQLPreparedStatement(t *testing.T) {
+	data := mysqlJoin(
+		mysqlPacket(1, 0x00, 0x07, 0x00, 0x00, 0x00, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00),
+		mysqlPacket(2, mysqlColumnDef("?", mysqlTypeVarStringTest, 0)...),
+		mysqlPacket(3, mysqlColumnDef("?", mysqlTypeVarStringTest, 0)...),
+		mysqlPacket(4, 0xfe, 0, 0, 0x02, 0),
+		mysqlPacket(5, mysqlColumnDef("name", mysqlTypeVarStringTest, 0)...),
+		mysqlPacket(6, 0xfe, 0, 0, 0x02, 0),
+	)
+	stmt, result, n, err := ParseMySQLPrepareResponse(data)
+	if err != nil || n != len(data) || result.Error != nil || stmt.ID != 7 || stmt.Params != 2 {
+		t.Fatalf("unexpected prepare %+v %+v %d %v", stmt, result, n, err)
+	}
+
+	// the first execution binds the types, signed longlong and string
+	execute := []byte{MySQLComStmtExecute, 0x07, 0, 0, 0, 0x00, 0x01, 0, 0, 0, 0x00, 0x01, mysqlTypeLongLong, 0x00, mysqlTypeVarStringTest, 0x00}
+	execute = append(append(execute, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff), mysqlLenEnc("a")...)
+	if MySQLStmtID(execute) != 7 {
+		t.Errorf("unexpected statement ID %d", MySQLStmtID(execute))
+	}
+	args, err := stmt.ExecuteArgs(execute)
+	if err != nil || !reflect.DeepEqual(args, []interface{}{int64(-2), "a"}) {
+		t.Errorf("unexpected args %#v %v", args, err)
+	}
+
+	// the next ones don't, the second argument is NULL
+	execute = []byte{MySQLComStmtExecute, 0x07, 0, 0, 0, 0x00, 0x01, 0, 0, 0, 0x02, 0x00, 0x05, 0, 0, 0, 0, 0, 0, 0}
+	args, err = stmt.ExecuteArgs(execute)
+	if err != nil || !reflect.DeepEqual(args, []interface{}{int64(5), nil}) {
+		t.Errorf("unexpected args %#v %v", args, err)
+	}
+
+	errPacket := mysqlPacket(1, append([]byte{0xff, 0x28, 0x04, '#', '4', '2', '0', '0', '0'}, "syntax error"...)...)
+	if stmt, result, _, err = ParseMySQLPrepareResponse(errPacket); err != nil || stmt != nil || result.Error.Code != 1064 {
+		t.Errorf("unexpected failed prepare %+v %+v %v", stmt, result, err)
+	}
+}
+
+func TestMySQLDigest(t *testing.T) {
+	cases := []struct {
+		sql, digest string
+		readOnly    bool
+	}{
+		{"SELECT * FROM t WHERE id IN (1, 2,3) AND name = 'a''b'", "SELECT * FROM t WHERE id IN (...) AND name = ?", true},
+		{"select  a -- comment\n from t /* hint */ where x=-1.5e3", "select a from t where x=-?", true},
+		{"INSERT INTO t (a, b) VALUES (1, \"x\"), (2, 'y')", "INSERT INTO t (a, b) VALUES (...)", false},
+		{"SELECT id FROM t2 WHERE t2.c1 = ?", "SELECT id FROM t2 WHERE t2.c1 = ?", true},
+		{"WITH c AS (SELECT 1) SELECT * FROM c;", "WITH c AS (SELECT ?) SELECT * FROM c;", true},
+		{"SELECT * FROM t INTO OUTFILE '/tmp/t'", "SELECT * FROM t INTO OUTFILE ?", false},
+		{"SELECT 1; DELETE FROM t", "SELECT ?; DELETE FROM t", false},
+		{"(SELECT 1) UNION (SELECT 2)", "(SELECT ?) UNION (SELECT ?)", true},
+		{"SHOW TABLES", "SHOW TABLES", true},
+		{"UPDATE t SET a = 0x1F WHERE b = TRUE", "UPDATE t SET a = ? WHERE b = TRUE", false},
+	}
+	for _, c := range cases {
+		if digest := MySQLDigest(c.sql); digest != c.digest {
+			t.Errorf("%q: expected %q, got %q", c.sql, c.digest, digest)
+		}
+		if IsMySQLReadOnly(c.sql) != c.readOnly {
+			t.Errorf("%q: expected read-only %v", c.sql, c.readOnly)
+		}
+	}
+}
+
+func TestMySQLQuery(t *testing.T) {
+	payload := EncodeMySQLQuery(&MySQLQuery{Command: "execute", SQL: "SELECT * FROM t WHERE a > ? AND b = ?", Args: []interface{}{int64(-1), uint64(1 << 63), 1.5, "x", nil}})
+	if string(payload) != `{"command":"execute","sql":"SELECT * FROM t WHERE a > ? AND b = ?","args":[-1,9223372036854775808,1.5,"x",null]}` {
+		t.Fatalf("unexpected payload %s", payload)
+	}
+	if !HasMySQLQuery(payload) || HasMySQLQuery([]byte(`{"columns":["a"]}`)) || HasMySQLQuery([]byte("GET / HTTP/1.1\r\n\r\n")) {
+		t.Error("wrong query detection")
+	}
+	q, err := ParseMySQLQuery(payload)
+	if err != nil || !reflect.DeepEqual(q.Args, []interface{}{int64(-1), uint64(1 << 63), 1.5, "x", nil}) {
+		t.Errorf("unexpected query %+v %v", q, err)
+	}
+	if endpoint := MySQLEndpoint(payload); endpoint != "SELECT * FROM t WHERE a > ? AND b = ?" {
+		t.Errorf("unexpected endpoint %q", endpoint)
+	}
+}
+
+func TestDiffMySQL(t *testing.T) {
+	original := EncodeMySQLResult(&MySQLResult{Columns: []string{"id", "name"}, Rows: [][]interface{}{{"1", "alice"}}})
+	replayed := EncodeMySQLResult(&MySQLResult{Columns: []string{"id", "name"}, Rows: [][]interface{}{{"1", nil}}})
+
+	diffs := DiffMySQL(original, replayed, nil)
+	if len(diffs) != 1 || diffs[0].Field != "result.rows[0][1]" || diffs[0].Original != "alice" {
+		t.Errorf("Wrong diffs: %+v", diffs)
+	}
+}
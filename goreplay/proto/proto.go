@@ -19,10 +19,6 @@ package proto
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
-	"fmt"
-	_ "fmt"
-	hessian "github.com/apache/dubbo-go-hessian2"
 	"net/http"
 	"net/textproto"
 	"record-traffic-press/goreplay/utils"
@@ -206,7 +202,7 @@ func Body(payload []byte) []byte {
 
 // Path takes payload and returns request path: Split(firstLine, ' ')[1]
 func Path(payload []byte) []byte {
-	if !HasRequestTitle(payload) {
+	if !HasRequestTitle(payload) && !HasHTTP2RequestTitle(payload) {
 		return nil
 	}
 	start := bytes.IndexByte(payload, ' ') + 1
@@ -320,7 +316,7 @@ func Method(payload []byte) []byte {
 // Status returns response status.
 // It happens to be in same position as request payload path
 func Status(payload []byte) []byte {
-	if !HasResponseTitle(payload) {
+	if !HasResponseTitle(payload) && !HasHTTP2ResponseTitle(payload) {
 		return nil
 	}
 	start := bytes.IndexByte(payload, ' ') + 1
@@ -346,6 +342,11 @@ const (
 
 // HasResponseTitle reports whether this payload has an HTTP/1 response title
 func HasResponseTitle(payload []byte) bool {
+	return hasResponseTitle(payload, 1)
+}
+
+// hasResponseTitle reports whether this payload has a response title of the given HTTP major version
+func hasResponseTitle(payload []byte, version int) bool {
 	s := utils.SliceToString(payload)
 	if len(s) < MinResponseCount {
 		return false
@@ -355,7 +356,7 @@ func HasResponseTitle(payload []byte) bool {
 		return false
 	}
 	major, minor, ok := http.ParseHTTPVersion(s[0:VersionLen])
-	if !(ok && major == 1 && (minor == 0 || minor == 1)) {
+	if !(ok && isTitleVersion(version, major, minor)) {
 		return false
 	}
 	if s[VersionLen] != ' ' {
@@ -375,52 +376,12 @@ func HasResponseTitle(payload []byte) bool {
 
 // HasRequestTitle reports whether this payload has an HTTP/1 request title
 func HasRequestTitle(payload []byte) bool {
-	s := utils.SliceToString(payload)
-
-	// 创建一个 bytes.Reader 作为 io.Reader
-	reader := bytes.NewReader(payload)
-
-	// 手动解析 Dubbo 请求头
-	var dubboHeader DubboHeader
-	err := binary.Read(reader, binary.BigEndian, &dubboHeader.Magic)
-	if err != nil {
-		fmt.Println("Failed to read magic:", err)
-	}
-	err = binary.Read(reader, binary.BigEndian, &dubboHeader.Flag)
-	if err != nil {
-		fmt.Println("Failed to read flag:", err)
-	}
-	err = binary.Read(reader, binary.BigEndian, &dubboHeader.Status)
-	if err != nil {
-		fmt.Println("Failed to read status:", err)
-	}
-	err = binary.Read(reader, binary.BigEndian, &dubboHeader.RequestID)
-	if err != nil {
-		fmt.Println("Failed to read request ID:", err)
-	}
-	err = binary.Read(reader, binary.BigEndian, &dubboHeader.DataLength)
-	if err != nil {
-		fmt.Println("Failed to read data length:", err)
-	}
-
-	hessianData := make([]byte, dubboHeader.DataLength)
-	if _, err := reader.Read(hessianData); err != nil {
-		fmt.Println("Failed to read Hessian data:", err)
-	}
-
-	// 反序列化请求体
-	decoder := hessian.NewDecoder(hessianData)
-
-	for {
-		value, err := decoder.Decode()
-		if err != nil {
-			fmt.Println("Failed to decode Hessian data:", err)
-			break
-		}
+	return hasRequestTitle(payload, 1)
+}
 
-		// 打印反序列化结果
-		fmt.Printf("Decoded value: %+v\n", value)
-	}
+// hasRequestTitle reports whether this payload has a request title of the given HTTP major version
+func hasRequestTitle(payload []byte, version int) bool {
+	s := utils.SliceToString(payload)
 
 	if len(s) < MinRequestCount {
 		return false
@@ -447,12 +408,21 @@ func HasRequestTitle(payload []byte) bool {
 		return false
 	}
 	major, minor, ok := http.ParseHTTPVersion(s[path+len(method)+2 : titleLen])
-	return ok && major == 1 && (minor == 0 || minor == 1)
+	return ok && isTitleVersion(version, major, minor)
+}
+
+// isTitleVersion reports whether the version of a title is HTTP/1.0 or HTTP/1.1 for HTTP/1,
+// HTTP/2.0 for HTTP/2
+func isTitleVersion(version, major, minor int) bool {
+	if version == 2 {
+		return major == 2 && minor == 0
+	}
+	return major == 1 && (minor == 0 || minor == 1)
 }
 
-// HasTitle reports if this payload has an http/1 title
+// HasTitle reports if this payload has an http/1 title, or the one of a HTTP/2 message rebuilt as text
 func HasTitle(payload []byte) bool {
-	return HasRequestTitle(payload) || HasResponseTitle(payload)
+	return HasRequestTitle(payload) || HasResponseTitle(payload) || HasHTTP2RequestTitle(payload) || HasHTTP2ResponseTitle(payload)
 }
 
 // CheckChunked checks HTTP/1 chunked data integrity(https://tools.ietf.org/html/rfc7230#section-4.1)
@@ -364,6 +364,7 @@ func TestHasResponseTitle(t *testing.T) {
 		"HTTP/1.0 100Continue\r\n":  false,
 		"HTTP/1.0 10r Continue\r\n": false,
 		"HTTP/1.1 200\r\n":          true,
+		"HTTP/2.0 200 OK\r\n":       false,
 		"HTTP/1.1 200\r\nServer: Tengine\r\nContent-Length: 0\r\nConnection: close\r\n\r\n": true,
 	}
 	for k, v := range m {
@@ -383,6 +384,8 @@ func TestHasRequestTitle(t *testing.T) {
 		"GET / HTTP/1.1\r\n":      true,
 		"GET / HTTP/1.1\r":        false,
 		"GET / HTTP/1.400\r\n":    false,
+		"GET / HTTP/2.0\r\n":      false,
+		"PRI * HTTP/2.0\r\n":      false,
 	}
 	for k, v := range m {
 		if HasRequestTitle([]byte(k)) != v {
@@ -495,6 +498,21 @@ func TestHasFullPayload(t *testing.T) {
 	}
 }
 
+func TestSessionID(t *testing.T) {
+	id := []byte("1f9000500a000001deadbeef")
+
+	if got := SessionID(id, SessionByConnection); string(got) != "1f9000500a000001" {
+		t.Errorf("expected the connection of %s, got %s", id, got)
+	}
+	if got := SessionID(id, SessionByClient); string(got) != "0a000001" {
+		t.Errorf("expected the client of %s, got %s", id, got)
+	}
+	// random IDs, e.g. of the HTTP input, are sessions by themselves
+	if got := SessionID([]byte("abc"), SessionByConnection); string(got) != "abc" {
+		t.Errorf("expected abc, got %s", got)
+	}
+}
+
 func BenchmarkHasFullPayload(b *testing.B) {
 	data := []byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n1e\r\n111111111111111111111111111111\r\n0\r\n\r\n")
 	for i := 0; i < b.N; i++ {
@@ -92,3 +92,25 @@ func IsOriginPayload(payload []byte) bool {
 func IsRequestPayload(payload []byte) bool {
 	return payload[0] == RequestPayload
 }
+
+// Session keys of the sticky outputs, see SessionID
+const (
+	SessionByConnection = "connection" // requests of the same captured TCP connection
+	SessionByClient     = "client"     // requests of the same client IP
+)
+
+// SessionID returns the part of a payload ID identifying its session. The IDs of the captured
+// payloads start with 16 hex chars identifying the connection, the last 8 being the client IP,
+// see tcp.TcpMessage.UUID. Other IDs, e.g. of the HTTP input, are sessions by themselves.
+func SessionID(id []byte, by string) []byte {
+	if len(id) < 24 {
+		return id
+	}
+	switch by {
+	case SessionByConnection:
+		return id[:16]
+	case SessionByClient:
+		return id[8:16]
+	}
+	return id
+}
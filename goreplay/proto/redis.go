@@ -0,0 +1,335 @@
+package proto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RESP2 and RESP3 type bytes
+const (
+	RedisSimpleString = '+'
+	RedisError        = '-'
+	RedisInteger      = ':'
+	RedisBulkString   = '$'
+	RedisArray        = '*'
+	RedisNull         = '_'
+	RedisDouble       = ','
+	RedisBoolean      = '#'
+	RedisBlobError    = '!'
+	RedisVerbatim     = '='
+	RedisBigNumber    = '('
+	RedisMap          = '%'
+	RedisSet          = '~'
+	RedisAttribute    = '|'
+	RedisPush         = '>'
+)
+
+const (
+	// redisMaxLength caps the length of strings and aggregates, larger ones mean the data isn't RESP
+	redisMaxLength = 512 << 20
+	// redisMaxDepth caps the nesting of aggregates
+	redisMaxDepth = 64
+)
+
+var errRedisIncomplete = errors.New("incomplete RESP value")
+
+// RedisFrames splits data into RESP values, e.g. the commands pipelined by a client or their replies.
+// A line which isn't RESP is an inline command, like "PING\r\n". complete is true when data consists
+// of whole values only.
+func RedisFrames(data []byte) (frames [][]byte, complete bool) {
+	for len(data) > 0 {
+		_, n, err := parseRedisValue(data, 0, 0, false)
+		if err != nil {
+			return frames, false
+		}
+		frames = append(frames, data[:n])
+		data = data[n:]
+	}
+	return frames, len(frames) > 0
+}
+
+// DecodeRedisValue decodes a RESP value: strings, big numbers and verbatim strings as string, integers
+// as int64, doubles as float64, booleans as bool, nulls as nil, arrays, sets and pushes as []interface{},
+// maps as map[string]interface{} and errors as {"error": message}. Attributes are skipped.
+func DecodeRedisValue(frame []byte) (interface{}, error) {
+	v, n, err := parseRedisValue(frame, 0, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(frame) {
+		return nil, fmt.Errorf("%d trailing bytes after RESP value", len(frame)-n)
+	}
+	return v, nil
+}
+
+// parseRedisValue parses the value starting at pos and returns the position following it,
+// the value is only decoded when decode is set
+func parseRedisValue(data []byte, pos, depth int, decode bool) (interface{}, int, error) {
+	if depth > redisMaxDepth {
+		return nil, 0, errors.New("RESP value nested too deep")
+	}
+	line, next, err := redisLine(data, pos)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(line) == 0 {
+		if depth > 0 {
+			return nil, 0, errors.New("empty RESP line")
+		}
+		// an empty inline command, ignored by the server
+		return decoded(decode, []string{}), next, nil
+	}
+	kind, line := data[pos], line[1:]
+
+	switch kind {
+	case RedisSimpleString, RedisBigNumber:
+		return decoded(decode, string(line)), next, nil
+	case RedisError:
+		return decoded(decode, map[string]interface{}{"error": string(line)}), next, nil
+	case RedisInteger:
+		n, err := strconv.ParseInt(string(line), 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("RESP integer %q", line)
+		}
+		return n, next, nil
+	case RedisNull:
+		return nil, next, nil
+	case RedisDouble:
+		f, err := strconv.ParseFloat(string(line), 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("RESP double %q", line)
+		}
+		return f, next, nil
+	case RedisBoolean:
+		if len(line) != 1 || (line[0] != 't' && line[0] != 'f') {
+			return nil, 0, fmt.Errorf("RESP boolean %q", line)
+		}
+		return line[0] == 't', next, nil
+	case RedisBulkString, RedisBlobError, RedisVerbatim:
+		var s []byte
+		if kind == RedisBulkString && string(line) == "?" {
+			s, next, err = redisStreamedString(data, next, decode)
+		} else {
+			s, next, err = redisBlob(data, line, next)
+		}
+		if err != nil || s == nil {
+			return nil, next, err
+		}
+		switch {
+		case !decode:
+			return nil, next, nil
+		case kind == RedisBlobError:
+			return map[string]interface{}{"error": string(s)}, next, nil
+		case kind == RedisVerbatim && len(s) >= 4:
+			// "txt:" or "mkd:" followed by the string
+			return string(s[4:]), next, nil
+		}
+		return string(s), next, nil
+	case RedisArray, RedisSet, RedisPush, RedisMap, RedisAttribute:
+		return parseRedisAggregate(data, kind, line, next, depth, decode)
+	}
+
+	if depth > 0 {
+		return nil, 0, fmt.Errorf("unknown RESP type %q", kind)
+	}
+	// inline command
+	return decoded(decode, strings.Fields(string(data[pos:next]))), next, nil
+}
+
+func parseRedisAggregate(data []byte, kind byte, line []byte, next, depth int, decode bool) (interface{}, int, error) {
+	streamed := string(line) == "?"
+	count := -1
+	if !streamed {
+		n, err := strconv.Atoi(string(line))
+		if err != nil || n < -1 || n > redisMaxLength || (n == -1 && kind != RedisArray) {
+			return nil, 0, fmt.Errorf("RESP aggregate length %q", line)
+		}
+		if n == -1 {
+			return nil, next, nil
+		}
+		count = n
+		if kind == RedisMap || kind == RedisAttribute {
+			count *= 2
+		}
+	}
+
+	var values []interface{}
+	for i := 0; streamed || i < count; i++ {
+		if streamed {
+			if next >= len(data) {
+				return nil, 0, errRedisIncomplete
+			}
+			if data[next] == '.' {
+				_, end, err := redisLine(data, next)
+				if err != nil {
+					return nil, 0, err
+				}
+				next = end
+				break
+			}
+		}
+		v, end, err := parseRedisValue(data, next, depth+1, decode)
+		if err != nil {
+			return nil, 0, err
+		}
+		next = end
+		if decode {
+			values = append(values, v)
+		}
+	}
+
+	if kind == RedisAttribute {
+		// attributes are followed by the value they describe
+		return parseRedisValue(data, next, depth, decode)
+	}
+	if !decode {
+		return nil, next, nil
+	}
+	if kind == RedisMap {
+		m := make(map[string]interface{}, len(values)/2)
+		for i := 0; i+1 < len(values); i += 2 {
+			m[formatValue(normalizeValue(values[i]))] = values[i+1]
+		}
+		return m, next, nil
+	}
+	if values == nil {
+		values = []interface{}{}
+	}
+	return values, next, nil
+}
+
+// redisLine returns the line starting at pos, without its CRLF, and the position following it
+func redisLine(data []byte, pos int) ([]byte, int, error) {
+	if pos >= len(data) {
+		return nil, 0, errRedisIncomplete
+	}
+	i := bytes.Index(data[pos:], CRLF)
+	if i < 0 {
+		if len(data)-pos > redisMaxLength {
+			return nil, 0, errors.New("RESP line too long")
+		}
+		return nil, 0, errRedisIncomplete
+	}
+	return data[pos : pos+i], pos + i + 2, nil
+}
+
+// redisBlob reads the string of the given length line at next, nil for a null bulk string
+func redisBlob(data []byte, line []byte, next int) ([]byte, int, error) {
+	n, err := strconv.Atoi(string(line))
+	if err != nil || n < -1 || n > redisMaxLength {
+		return nil, 0, fmt.Errorf("RESP string length %q", line)
+	}
+	if n == -1 {
+		return nil, next, nil
+	}
+	end := next + n + 2
+	if end > len(data) {
+		return nil, 0, errRedisIncomplete
+	}
+	if !bytes.Equal(data[end-2:end], CRLF) {
+		return nil, 0, errors.New("RESP string not terminated by CRLF")
+	}
+	return data[next : end-2], end, nil
+}
+
+// redisStreamedString reads the ";<length>" chunks of a streamed string up to the empty one
+func redisStreamedString(data []byte, next int, decode bool) ([]byte, int, error) {
+	s := []byte{}
+	for {
+		line, end, err := redisLine(data, next)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(line) == 0 || line[0] != ';' {
+			return nil, 0, fmt.Errorf("RESP streamed string chunk %q", line)
+		}
+		if string(line) == ";0" {
+			return s, end, nil
+		}
+		chunk, end, err := redisBlob(data, line[1:], end)
+		if err != nil {
+			return nil, 0, err
+		}
+		if decode {
+			s = append(s, chunk...)
+		}
+		next = end
+	}
+}
+
+func decoded(decode bool, v interface{}) interface{} {
+	if !decode {
+		return nil
+	}
+	return v
+}
+
+// HasRedisCommand reports whether payload is a command sent as an array of bulk strings
+func HasRedisCommand(payload []byte) bool {
+	if len(payload) == 0 || payload[0] != RedisArray {
+		return false
+	}
+	_, ok := RedisCommandArgs(payload)
+	return ok
+}
+
+// RedisCommandArgs returns the arguments of a command, an array of bulk strings or an inline command
+func RedisCommandArgs(frame []byte) (args [][]byte, ok bool) {
+	if len(frame) == 0 {
+		return nil, false
+	}
+	if frame[0] != RedisArray {
+		line, _, err := redisLine(frame, 0)
+		if err != nil {
+			return nil, false
+		}
+		args = bytes.Fields(line)
+		return args, len(args) > 0
+	}
+
+	line, next, err := redisLine(frame, 0)
+	if err != nil {
+		return nil, false
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n <= 0 || n > redisMaxLength {
+		return nil, false
+	}
+	for i := 0; i < n; i++ {
+		line, end, err := redisLine(frame, next)
+		if err != nil || len(line) == 0 || line[0] != RedisBulkString {
+			return nil, false
+		}
+		arg, end, err := redisBlob(frame, line[1:], end)
+		if err != nil || arg == nil {
+			return nil, false
+		}
+		args = append(args, arg)
+		next = end
+	}
+	return args, next == len(frame)
+}
+
+// EncodeRedisCommand encodes the arguments of a command as an array of bulk strings
+func EncodeRedisCommand(args [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		buf.Write(arg)
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes()
+}
+
+// RedisEndpoint returns the upper-cased name of a command, e.g. "GET", empty if it can't be parsed
+func RedisEndpoint(request []byte) string {
+	args, ok := RedisCommandArgs(request)
+	if !ok {
+		return ""
+	}
+	return strings.ToUpper(string(args[0]))
+}
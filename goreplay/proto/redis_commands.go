@@ -0,0 +1,303 @@
+package proto
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// redisCommand describes the arguments of a command holding keys, like the key specs of COMMAND INFO:
+// the keys are the arguments first to last, every step ones. A negative last counts from the end,
+// -1 being the last argument. When numKeys is set, the number of keys is the argument at that
+// index and they follow it.
+type redisCommand struct {
+	readOnly bool
+	first    int
+	last     int
+	step     int
+	numKeys  int
+}
+
+var (
+	redisRead      = redisCommand{readOnly: true}
+	redisReadKey   = redisCommand{readOnly: true, first: 1, last: 1, step: 1}
+	redisReadKeys  = redisCommand{readOnly: true, first: 1, last: -1, step: 1}
+	redisWrite     = redisCommand{}
+	redisWriteKey  = redisCommand{first: 1, last: 1, step: 1}
+	redisWriteKeys = redisCommand{first: 1, last: -1, step: 1}
+)
+
+// redisCommands holds the commands known to be read-only or to hold keys, the others are replayed
+// as is, and not at all by a read-only output
+var redisCommands = map[string]redisCommand{
+	// connection and server
+	"PING":      redisRead,
+	"ECHO":      redisRead,
+	"SELECT":    redisRead,
+	"HELLO":     redisRead,
+	"AUTH":      redisRead,
+	"CLIENT":    redisRead,
+	"INFO":      redisRead,
+	"TIME":      redisRead,
+	"DBSIZE":    redisRead,
+	"COMMAND":   redisRead,
+	"LASTSAVE":  redisRead,
+	"RANDOMKEY": redisRead,
+	"SCAN":      redisRead,
+	"KEYS":      redisRead,
+	"QUIT":      redisRead,
+	"RESET":     redisRead,
+	"READONLY":  redisRead,
+
+	// transactions, their commands are checked one by one
+	"MULTI":   redisRead,
+	"EXEC":    redisRead,
+	"DISCARD": redisRead,
+	"UNWATCH": redisRead,
+	"WATCH":   redisReadKeys,
+
+	// keys
+	"EXISTS":      redisReadKeys,
+	"TYPE":        redisReadKey,
+	"TTL":         redisReadKey,
+	"PTTL":        redisReadKey,
+	"EXPIRETIME":  redisReadKey,
+	"PEXPIRETIME": redisReadKey,
+	"DUMP":        redisReadKey,
+	"TOUCH":       redisReadKeys,
+	"OBJECT":      {readOnly: true, first: 2, last: 2, step: 1},
+	"DEL":         redisWriteKeys,
+	"UNLINK":      redisWriteKeys,
+	"EXPIRE":      redisWriteKey,
+	"PEXPIRE":     redisWriteKey,
+	"EXPIREAT":    redisWriteKey,
+	"PEXPIREAT":   redisWriteKey,
+	"PERSIST":     redisWriteKey,
+	"RENAME":      {first: 1, last: 2, step: 1},
+	"RENAMENX":    {first: 1, last: 2, step: 1},
+	"COPY":        {first: 1, last: 2, step: 1},
+	"RESTORE":     redisWriteKey,
+	"FLUSHDB":     redisWrite,
+	"FLUSHALL":    redisWrite,
+
+	// strings
+	"GET":         redisReadKey,
+	"MGET":        redisReadKeys,
+	"STRLEN":      redisReadKey,
+	"GETRANGE":    redisReadKey,
+	"SUBSTR":      redisReadKey,
+	"GETBIT":      redisReadKey,
+	"BITCOUNT":    redisReadKey,
+	"BITPOS":      redisReadKey,
+	"LCS":         {readOnly: true, first: 1, last: 2, step: 1},
+	"SET":         redisWriteKey,
+	"SETNX":       redisWriteKey,
+	"SETEX":       redisWriteKey,
+	"PSETEX":      redisWriteKey,
+	"GETSET":      redisWriteKey,
+	"GETDEL":      redisWriteKey,
+	"GETEX":       redisWriteKey,
+	"MSET":        {first: 1, last: -1, step: 2},
+	"MSETNX":      {first: 1, last: -1, step: 2},
+	"APPEND":      redisWriteKey,
+	"SETRANGE":    redisWriteKey,
+	"SETBIT":      redisWriteKey,
+	"INCR":        redisWriteKey,
+	"DECR":        redisWriteKey,
+	"INCRBY":      redisWriteKey,
+	"DECRBY":      redisWriteKey,
+	"INCRBYFLOAT": redisWriteKey,
+	"BITOP":       {first: 2, last: -1, step: 1},
+
+	// hashes
+	"HGET":         redisReadKey,
+	"HMGET":        redisReadKey,
+	"HGETALL":      redisReadKey,
+	"HKEYS":        redisReadKey,
+	"HVALS":        redisReadKey,
+	"HLEN":         redisReadKey,
+	"HEXISTS":      redisReadKey,
+	"HSTRLEN":      redisReadKey,
+	"HSCAN":        redisReadKey,
+	"HRANDFIELD":   redisReadKey,
+	"HSET":         redisWriteKey,
+	"HSETNX":       redisWriteKey,
+	"HMSET":        redisWriteKey,
+	"HDEL":         redisWriteKey,
+	"HINCRBY":      redisWriteKey,
+	"HINCRBYFLOAT": redisWriteKey,
+
+	// lists
+	"LRANGE":     redisReadKey,
+	"LLEN":       redisReadKey,
+	"LINDEX":     redisReadKey,
+	"LPOS":       redisReadKey,
+	"LPUSH":      redisWriteKey,
+	"RPUSH":      redisWriteKey,
+	"LPUSHX":     redisWriteKey,
+	"RPUSHX":     redisWriteKey,
+	"LPOP":       redisWriteKey,
+	"RPOP":       redisWriteKey,
+	"LSET":       redisWriteKey,
+	"LREM":       redisWriteKey,
+	"LTRIM":      redisWriteKey,
+	"LINSERT":    redisWriteKey,
+	"RPOPLPUSH":  {first: 1, last: 2, step: 1},
+	"LMOVE":      {first: 1, last: 2, step: 1},
+	"BLPOP":      {first: 1, last: -2, step: 1},
+	"BRPOP":      {first: 1, last: -2, step: 1},
+	"BRPOPLPUSH": {first: 1, last: 2, step: 1},
+	"BLMOVE":     {first: 1, last: 2, step: 1},
+
+	// sets
+	"SMEMBERS":    redisReadKey,
+	"SISMEMBER":   redisReadKey,
+	"SMISMEMBER":  redisReadKey,
+	"SCARD":       redisReadKey,
+	"SRANDMEMBER": redisReadKey,
+	"SSCAN":       redisReadKey,
+	"SINTER":      redisReadKeys,
+	"SUNION":      redisReadKeys,
+	"SDIFF":       redisReadKeys,
+	"SINTERCARD":  {readOnly: true, numKeys: 1},
+	"SADD":        redisWriteKey,
+	"SREM":        redisWriteKey,
+	"SPOP":        redisWriteKey,
+	"SMOVE":       {first: 1, last: 2, step: 1},
+	"SINTERSTORE": redisWriteKeys,
+	"SUNIONSTORE": redisWriteKeys,
+	"SDIFFSTORE":  redisWriteKeys,
+
+	// sorted sets
+	"ZRANGE":           redisReadKey,
+	"ZREVRANGE":        redisReadKey,
+	"ZRANGEBYSCORE":    redisReadKey,
+	"ZREVRANGEBYSCORE": redisReadKey,
+	"ZRANGEBYLEX":      redisReadKey,
+	"ZREVRANGEBYLEX":   redisReadKey,
+	"ZSCORE":           redisReadKey,
+	"ZMSCORE":          redisReadKey,
+	"ZRANK":            redisReadKey,
+	"ZREVRANK":         redisReadKey,
+	"ZCARD":            redisReadKey,
+	"ZCOUNT":           redisReadKey,
+	"ZLEXCOUNT":        redisReadKey,
+	"ZSCAN":            redisReadKey,
+	"ZRANDMEMBER":      redisReadKey,
+	"ZINTER":           {readOnly: true, numKeys: 1},
+	"ZUNION":           {readOnly: true, numKeys: 1},
+	"ZDIFF":            {readOnly: true, numKeys: 1},
+	"ZINTERCARD":       {readOnly: true, numKeys: 1},
+	"ZADD":             redisWriteKey,
+	"ZINCRBY":          redisWriteKey,
+	"ZREM":             redisWriteKey,
+	"ZPOPMIN":          redisWriteKey,
+	"ZPOPMAX":          redisWriteKey,
+	"ZREMRANGEBYSCORE": redisWriteKey,
+	"ZREMRANGEBYRANK":  redisWriteKey,
+	"ZREMRANGEBYLEX":   redisWriteKey,
+	"ZRANGESTORE":      {first: 1, last: 2, step: 1},
+	"ZINTERSTORE":      {first: 1, last: 1, step: 1, numKeys: 2},
+	"ZUNIONSTORE":      {first: 1, last: 1, step: 1, numKeys: 2},
+	"ZDIFFSTORE":       {first: 1, last: 1, step: 1, numKeys: 2},
+
+	// hyperloglogs, geo and streams
+	"PFCOUNT":              redisReadKeys,
+	"PFADD":                redisWriteKey,
+	"PFMERGE":              redisWriteKeys,
+	"GEOPOS":               redisReadKey,
+	"GEODIST":              redisReadKey,
+	"GEOHASH":              redisReadKey,
+	"GEOSEARCH":            redisReadKey,
+	"GEORADIUS_RO":         redisReadKey,
+	"GEORADIUSBYMEMBER_RO": redisReadKey,
+	"GEOADD":               redisWriteKey,
+	"XRANGE":               redisReadKey,
+	"XREVRANGE":            redisReadKey,
+	"XLEN":                 redisReadKey,
+	"XPENDING":             redisReadKey,
+	"XADD":                 redisWriteKey,
+	"XDEL":                 redisWriteKey,
+	"XTRIM":                redisWriteKey,
+	"XACK":                 redisWriteKey,
+
+	// scripts, the keys follow their number
+	"EVAL":       {numKeys: 2},
+	"EVALSHA":    {numKeys: 2},
+	"EVAL_RO":    {readOnly: true, numKeys: 2},
+	"EVALSHA_RO": {readOnly: true, numKeys: 2},
+	"FCALL":      {numKeys: 2},
+	"FCALL_RO":   {readOnly: true, numKeys: 2},
+}
+
+// IsRedisReadOnly reports whether a command, given by its arguments, doesn't modify the data
+func IsRedisReadOnly(args [][]byte) bool {
+	if len(args) == 0 {
+		return false
+	}
+	return redisCommands[strings.ToUpper(string(args[0]))].readOnly
+}
+
+// RedisKeyIndexes returns the indexes of the arguments of a command which are keys
+func RedisKeyIndexes(args [][]byte) []int {
+	if len(args) == 0 {
+		return nil
+	}
+	cmd := redisCommands[strings.ToUpper(string(args[0]))]
+
+	var indexes []int
+	if cmd.step > 0 {
+		last := cmd.last
+		if last < 0 {
+			last += len(args)
+		}
+		for i := cmd.first; i <= last && i < len(args); i += cmd.step {
+			indexes = append(indexes, i)
+		}
+	}
+	if cmd.numKeys > 0 && cmd.numKeys < len(args) {
+		n, err := strconv.Atoi(string(args[cmd.numKeys]))
+		if err != nil || n < 0 {
+			return indexes
+		}
+		for i := cmd.numKeys + 1; i <= cmd.numKeys+n && i < len(args); i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// ParseRedisKeyPrefixes parses "from=to" key prefix rewrites, e.g. "user:=replay:user:"
+func ParseRedisKeyPrefixes(rules []string) (map[string]string, error) {
+	prefixes := make(map[string]string, len(rules))
+	for _, rule := range rules {
+		i := strings.IndexByte(rule, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("redis key prefix %q, expected `from=to`", rule)
+		}
+		prefixes[rule[:i]] = rule[i+1:]
+	}
+	return prefixes, nil
+}
+
+// RewriteRedisKeys replaces the prefix of the keys of a command, prefixes maps an original prefix
+// to its replacement and the longest matching one is used. It reports whether any key changed.
+func RewriteRedisKeys(args [][]byte, prefixes map[string]string) bool {
+	changed := false
+	for _, i := range RedisKeyIndexes(args) {
+		best := -1
+		var to string
+		for from, replacement := range prefixes {
+			if len(from) > best && bytes.HasPrefix(args[i], []byte(from)) {
+				best, to = len(from), replacement
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		args[i] = append([]byte(to), args[i][best:]...)
+		changed = true
+	}
+	return changed
+}
@@ -0,0 +1,168 @@
+package proto
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedisFrames(t *testing.T) {
+	pipelined := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nhello\r\n" + "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n" + "PING\r\n"
+	frames, complete := RedisFrames([]byte(pipelined))
+	if !complete || len(frames) != 3 || string(frames[2]) != "PING\r\n" {
+		t.Fatalf("expected 3 pipelined commands, got %q %v", frames, complete)
+	}
+
+	replies := []string{
+		"+OK\r\n",
+		"-ERR wrong type\r\n",
+		":-42\r\n",
+		"$5\r\nhe\r\nl\r\n", // the length is trusted, not the CRLF
+		"$-1\r\n",
+		"*-1\r\n",
+		"*0\r\n",
+		"*2\r\n*1\r\n:1\r\n$1\r\na\r\n",
+		// RESP3
+		"_\r\n",
+		",3.14\r\n",
+		"#t\r\n",
+		"(3492890328409238509324850943850943825024385\r\n",
+		"!9\r\nSYNTAX ko\r\n",
+		"=15\r\ntxt:Some string\r\n",
+		"%2\r\n+first\r\n:1\r\n+second\r\n:2\r\n",
+		"~2\r\n+a\r\n+b\r\n",
+		"|1\r\n+ttl\r\n:3600\r\n$3\r\nval\r\n",
+		">3\r\n+message\r\n+ch\r\n+hi\r\n",
+		"$?\r\n;4\r\nHell\r\n;1\r\no\r\n;0\r\n",
+		"*?\r\n:1\r\n:2\r\n.\r\n",
+	}
+	frames, complete = RedisFrames([]byte(strings.Join(replies, "")))
+	if !complete || len(frames) != len(replies) {
+		t.Fatalf("expected %d replies, got %d %v", len(replies), len(frames), complete)
+	}
+	for i, reply := range replies {
+		if string(frames[i]) != reply {
+			t.Errorf("expected %q, got %q", reply, frames[i])
+		}
+	}
+
+	for _, incomplete := range []string{"", "+OK", "$5\r\nhel", "*2\r\n:1\r\n", "%1\r\n+a\r\n", "$?\r\n;4\r\nHell\r\n", "*?\r\n:1\r\n"} {
+		if _, complete := RedisFrames([]byte(incomplete)); complete {
+			t.Errorf("%q should be incomplete", incomplete)
+		}
+	}
+	if _, complete := RedisFrames([]byte("*1\r\n:x\r\n")); complete {
+		t.Error("a malformed value should not be complete")
+	}
+}
+
+func TestDecodeRedisValue(t *testing.T) {
+	cases := []struct {
+		reply string
+		value interface{}
+	}{
+		{"+OK\r\n", "OK"},
+		{"-ERR wrong type\r\n", map[string]interface{}{"error": "ERR wrong type"}},
+		{":-42\r\n", int64(-42)},
+		{"$-1\r\n", nil},
+		{"_\r\n", nil},
+		{",3.14\r\n", 3.14},
+		{"#f\r\n", false},
+		{"=15\r\ntxt:Some string\r\n", "Some string"},
+		{"%2\r\n+first\r\n:1\r\n:2\r\n*0\r\n", map[string]interface{}{"first": int64(1), "2": []interface{}{}}},
+		{"|1\r\n+ttl\r\n:3600\r\n$3\r\nval\r\n", "val"},
+		{"$?\r\n;4\r\nHell\r\n;1\r\no\r\n;0\r\n", "Hello"},
+		{"*?\r\n:1\r\n$-1\r\n.\r\n", []interface{}{int64(1), nil}},
+	}
+	for _, c := range cases {
+		value, err := DecodeRedisValue([]byte(c.reply))
+		if err != nil || !reflect.DeepEqual(value, c.value) {
+			t.Errorf("%q: expected %#v, got %#v %v", c.reply, c.value, value, err)
+		}
+	}
+
+	if _, err := DecodeRedisValue([]byte("+OK\r\n+OK\r\n")); err == nil {
+		t.Error("expected an error for trailing bytes")
+	}
+}
+
+func TestRedisCommandArgs(t *testing.T) {
+	command := EncodeRedisCommand([][]byte{[]byte("set"), []byte("k"), []byte("a b\r\n")})
+	if string(command) != "*3\r\n$3\r\nset\r\n$1\r\nk\r\n$5\r\na b\r\n\r\n" {
+		t.Fatalf("unexpected command %q", command)
+	}
+	args, ok := RedisCommandArgs(command)
+	if !ok || len(args) != 3 || string(args[2]) != "a b\r\n" {
+		t.Errorf("unexpected args %q", args)
+	}
+	if !HasRedisCommand(command) || RedisEndpoint(command) != "SET" {
+		t.Errorf("expected a SET command")
+	}
+
+	if args, ok = RedisCommandArgs([]byte("get  k\r\n")); !ok || len(args) != 2 || string(args[1]) != "k" {
+		t.Errorf("unexpected inline args %q", args)
+	}
+	// inline commands are recognized for replay only, not as captured requests
+	if HasRedisCommand([]byte("get k\r\n")) || HasRedisCommand([]byte("*1\r\n:1\r\n")) || HasRedisCommand([]byte("GET / HTTP/1.1\r\n\r\n")) {
+		t.Error("not an array of bulk strings")
+	}
+}
+
+func TestRewriteRedisKeys(t *testing.T) {
+	prefixes, err := ParseRedisKeyPrefixes([]string{"user:=replay:user:", "user:vip:=vip:", "cart=c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseRedisKeyPrefixes([]string{"user:"}); err == nil {
+		t.Error("expected an error without =")
+	}
+
+	cases := []struct {
+		command  string
+		expected string
+		readOnly bool
+	}{
+		{"GET user:1", "GET replay:user:1", true},
+		{"SET user:vip:1 user:2", "SET vip:1 user:2", false},
+		{"MSET user:1 cart user:2 x", "MSET replay:user:1 cart replay:user:2 x", false},
+		{"MGET user:1 cart:1 other", "MGET replay:user:1 c:1 other", true},
+		{"EVAL script 2 user:1 cart:1 user:3", "EVAL script 2 replay:user:1 c:1 user:3", false},
+		{"ZUNIONSTORE cart:all 2 cart:1 cart:2 WEIGHTS 1 2", "ZUNIONSTORE c:all 2 c:1 c:2 WEIGHTS 1 2", false},
+		{"BLPOP user:1 user:2 0", "BLPOP replay:user:1 replay:user:2 0", false},
+		{"object encoding user:1", "object encoding replay:user:1", true},
+		{"PING user:1", "PING user:1", true},
+		{"UNKNOWN user:1", "UNKNOWN user:1", false},
+	}
+	for _, c := range cases {
+		var args [][]byte
+		for _, f := range strings.Fields(c.command) {
+			args = append(args, []byte(f))
+		}
+		if IsRedisReadOnly(args) != c.readOnly {
+			t.Errorf("%s: expected read-only %v", c.command, c.readOnly)
+		}
+		RewriteRedisKeys(args, prefixes)
+		var got []string
+		for _, arg := range args {
+			got = append(got, string(arg))
+		}
+		if strings.Join(got, " ") != c.expected {
+			t.Errorf("expected %q, got %q", c.expected, strings.Join(got, " "))
+		}
+	}
+}
+
+func TestDiffRedis(t *testing.T) {
+	original := []byte("*3\r\n$5\r\nalice\r\n:1\r\n%1\r\n+updated\r\n:100\r\n")
+	replayed := []byte("*3\r\n$3\r\nbob\r\n:1\r\n%1\r\n+updated\r\n:200\r\n")
+
+	diffs := DiffRedis(original, replayed, NewDiffIgnore([]string{"updated"}))
+	if len(diffs) != 1 || diffs[0].Field != "reply[0]" || diffs[0].Original != "alice" || diffs[0].Replayed != "bob" {
+		t.Errorf("Wrong diffs: %+v", diffs)
+	}
+
+	diffs = DiffRedis([]byte("$1\r\n1\r\n"), []byte("-ERR no such key\r\n"), nil)
+	if len(diffs) != 1 || diffs[0].Field != "reply" || diffs[0].Replayed != `{"error":"ERR no such key"}` {
+		t.Errorf("Wrong diffs: %+v", diffs)
+	}
+}
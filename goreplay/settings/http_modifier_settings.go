@@ -1,8 +1,11 @@
 package settings
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"record-traffic-press/goreplay/proto"
 	"regexp"
 	"strconv"
 	"strings"
@@ -22,6 +25,11 @@ type HTTPModifierConfig struct {
 	Params                 HTTPParams                 `json:"http-set-param"`
 	Headers                HTTPHeaders                `json:"http-set-header"`
 	Methods                HTTPMethods                `json:"http-allow-method"`
+	JSONFilters            HTTPJSONFilters            `json:"http-allow-json"`
+	JSONNegativeFilters    HTTPJSONFilters            `json:"http-disallow-json"`
+	JSONSets               HTTPJSONSets               `json:"http-set-json"`
+	JSONDeletes            HTTPJSONDeletes            `json:"http-delete-json"`
+	JSONRewrite            JSONRewriteMap             `json:"http-rewrite-json"`
 }
 
 // HeaderFilter Handling of --http-allow-header, --http-disallow-header options
@@ -281,3 +289,208 @@ func (r *HTTPURLRegexp) Set(value string) error {
 
 	return err
 }
+
+// Handling of --http-allow-json, --http-disallow-json options
+type JSONFilter struct {
+	Path   []interface{}
+	Regexp *regexp.Regexp
+}
+
+// HTTPJSONFilters holds list of JSON body paths and the regexps their values must match
+type HTTPJSONFilters []JSONFilter
+
+func (f *HTTPJSONFilters) String() string {
+	return fmt.Sprint(*f)
+}
+
+// Set method to implement flags.Value
+func (f *HTTPJSONFilters) Set(value string) error {
+	valArr := strings.SplitN(value, ":", 2)
+	if len(valArr) < 2 {
+		return errors.New("need both path and value, colon-delimited (ex. $.user.id:^169$)")
+	}
+	path, err := proto.ParseJSONPath(strings.TrimSpace(valArr[0]))
+	if err != nil {
+		return err
+	}
+	r, err := regexp.Compile(strings.TrimSpace(valArr[1]))
+	if err != nil {
+		return err
+	}
+
+	*f = append(*f, JSONFilter{Path: path, Regexp: r})
+
+	return nil
+}
+
+// MarshalJSON encodes the filters as the option strings accepted by Set
+func (f HTTPJSONFilters) MarshalJSON() ([]byte, error) {
+	values := make([]string, 0, len(f))
+	for _, filter := range f {
+		values = append(values, proto.FormatJSONPath(filter.Path)+":"+filter.Regexp.String())
+	}
+	return json.Marshal(values)
+}
+
+// UnmarshalJSON parses a list of option strings with Set
+func (f *HTTPJSONFilters) UnmarshalJSON(data []byte) error {
+	*f = nil
+	return unmarshalOptions(data, f.Set)
+}
+
+// Handling of --http-set-json option
+type jsonSet struct {
+	Path  []interface{}
+	Value interface{}
+}
+
+// HTTPJSONSets is a slice of JSON body fields that must be set
+type HTTPJSONSets []jsonSet
+
+func (h *HTTPJSONSets) String() string {
+	return fmt.Sprint(*h)
+}
+
+// Set method to implement flags.Value, the value is a JSON value or else a string (ex. $.user.id=42, $.name=bob)
+func (h *HTTPJSONSets) Set(value string) error {
+	v := strings.SplitN(value, "=", 2)
+	if len(v) != 2 {
+		return errors.New("Expected `Path=Value`")
+	}
+	path, err := proto.ParseJSONPath(strings.TrimSpace(v[0]))
+	if err != nil {
+		return err
+	}
+
+	var val interface{}
+	raw := strings.TrimSpace(v[1])
+	decoder := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	decoder.UseNumber()
+	if decoder.Decode(&val) != nil || decoder.More() {
+		val = raw
+	}
+
+	*h = append(*h, jsonSet{Path: path, Value: val})
+	return nil
+}
+
+// MarshalJSON encodes the fields as the option strings accepted by Set, the values always as JSON
+func (h HTTPJSONSets) MarshalJSON() ([]byte, error) {
+	values := make([]string, 0, len(h))
+	for _, set := range h {
+		value, err := json.Marshal(set.Value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, proto.FormatJSONPath(set.Path)+"="+string(value))
+	}
+	return json.Marshal(values)
+}
+
+// UnmarshalJSON parses a list of option strings with Set
+func (h *HTTPJSONSets) UnmarshalJSON(data []byte) error {
+	*h = nil
+	return unmarshalOptions(data, h.Set)
+}
+
+// HTTPJSONDeletes holds the paths of JSON body fields that must be removed, for --http-delete-json
+type HTTPJSONDeletes [][]interface{}
+
+func (h *HTTPJSONDeletes) String() string {
+	return fmt.Sprint(*h)
+}
+
+// Set method to implement flags.Value
+func (h *HTTPJSONDeletes) Set(value string) error {
+	path, err := proto.ParseJSONPath(strings.TrimSpace(value))
+	if err != nil {
+		return err
+	}
+
+	*h = append(*h, path)
+	return nil
+}
+
+// MarshalJSON encodes the paths as the option strings accepted by Set
+func (h HTTPJSONDeletes) MarshalJSON() ([]byte, error) {
+	values := make([]string, 0, len(h))
+	for _, path := range h {
+		values = append(values, proto.FormatJSONPath(path))
+	}
+	return json.Marshal(values)
+}
+
+// UnmarshalJSON parses a list of option strings with Set
+func (h *HTTPJSONDeletes) UnmarshalJSON(data []byte) error {
+	*h = nil
+	return unmarshalOptions(data, h.Set)
+}
+
+// Handling of --http-rewrite-json option
+type JSONRewrite struct {
+	Path   []interface{}
+	Src    *regexp.Regexp
+	Target []byte
+}
+
+// JSONRewriteMap holds regexp and data to rewrite JSON body fields
+type JSONRewriteMap []JSONRewrite
+
+func (r *JSONRewriteMap) String() string {
+	return fmt.Sprint(*r)
+}
+
+// Set method to implement flags.Value
+func (r *JSONRewriteMap) Set(value string) error {
+	pathArr := strings.SplitN(value, ":", 2)
+	if len(pathArr) < 2 {
+		return errors.New("need both path, regexp and rewrite target, colon-delimited (ex. $.user.name: regexp,target)")
+	}
+
+	path, err := proto.ParseJSONPath(strings.TrimSpace(pathArr[0]))
+	if err != nil {
+		return err
+	}
+	valArr := strings.SplitN(strings.TrimSpace(pathArr[1]), ",", 2)
+
+	if len(valArr) < 2 {
+		return errors.New("need both path, regexp and rewrite target, colon-delimited (ex. $.user.name: regexp,target)")
+	}
+
+	regexp, err := regexp.Compile(valArr[0])
+	if err != nil {
+		return err
+	}
+	*r = append(*r, JSONRewrite{Path: path, Src: regexp, Target: []byte(valArr[1])})
+	return nil
+}
+
+// MarshalJSON encodes the rewrites as the option strings accepted by Set
+func (r JSONRewriteMap) MarshalJSON() ([]byte, error) {
+	values := make([]string, 0, len(r))
+	for _, rewrite := range r {
+		values = append(values, proto.FormatJSONPath(rewrite.Path)+":"+rewrite.Src.String()+","+string(rewrite.Target))
+	}
+	return json.Marshal(values)
+}
+
+// UnmarshalJSON parses a list of option strings with Set
+func (r *JSONRewriteMap) UnmarshalJSON(data []byte) error {
+	*r = nil
+	return unmarshalOptions(data, r.Set)
+}
+
+// unmarshalOptions decodes a JSON list of option strings and passes each of them to set,
+// the way they are given on the command line
+func unmarshalOptions(data []byte, set func(string) error) error {
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := set(value); err != nil {
+			return fmt.Errorf("%q: %w", value, err)
+		}
+	}
+	return nil
+}
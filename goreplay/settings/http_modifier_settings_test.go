@@ -32,8 +32,8 @@ func TestHTTPHashFilters(t *testing.T) {
 		t.Error("Should support old syntax")
 	}
 
-	if filters[0].percent != 50 {
-		t.Error("Wrong percentage", filters[0].percent)
+	if filters[0].Percent != 50 {
+		t.Error("Wrong percentage", filters[0].Percent)
 	}
 
 	err = filters.Set("Header2:1")
@@ -46,8 +46,8 @@ func TestHTTPHashFilters(t *testing.T) {
 		t.Error("Should pass")
 	}
 
-	if filters[1].percent != 10 {
-		t.Error("Wrong percentage", filters[1].percent)
+	if filters[1].Percent != 10 {
+		t.Error("Wrong percentage", filters[1].Percent)
 	}
 }
 
@@ -1,23 +1,24 @@
-package settings
+package settings_test
 
 import (
 	"bytes"
 	"record-traffic-press/goreplay/core"
 	"record-traffic-press/goreplay/proto"
+	"record-traffic-press/goreplay/settings"
 	"testing"
 )
 
 func TestHTTPModifierWithoutConfig(t *testing.T) {
-	if core.NewHTTPModifier(&HTTPModifierConfig{}) != nil {
+	if core.NewHTTPModifier(&settings.HTTPModifierConfig{}) != nil {
 		t.Error("If no config specified should not be initialized")
 	}
 }
 
 func TestHTTPModifierHeaderFilters(t *testing.T) {
-	filters := HTTPHeaderFilters{}
+	filters := settings.HTTPHeaderFilters{}
 	filters.Set("Host:^www.w3.org$")
 
-	modifier := core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier := core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		HeaderFilters: filters,
 	})
 
@@ -27,11 +28,11 @@ func TestHTTPModifierHeaderFilters(t *testing.T) {
 		t.Error("Request should pass filters")
 	}
 
-	filters = HTTPHeaderFilters{}
+	filters = settings.HTTPHeaderFilters{}
 	// Setting filter that not match our header
 	filters.Set("Host:^www.w4.org$")
 
-	modifier = core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier = core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		HeaderFilters: filters,
 	})
 
@@ -41,10 +42,10 @@ func TestHTTPModifierHeaderFilters(t *testing.T) {
 }
 
 func TestHTTPModifierHeaderNegativeFilters(t *testing.T) {
-	filters := HTTPHeaderFilters{}
+	filters := settings.HTTPHeaderFilters{}
 	filters.Set("Host:^www.w3.org$")
 
-	modifier := core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier := core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		HeaderNegativeFilters: filters,
 	})
 
@@ -54,11 +55,11 @@ func TestHTTPModifierHeaderNegativeFilters(t *testing.T) {
 		t.Error("Request should pass filters")
 	}
 
-	filters = HTTPHeaderFilters{}
+	filters = settings.HTTPHeaderFilters{}
 	// Setting filter that not match our header
 	filters.Set("Host:^www.w4.org$")
 
-	modifier = core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier = core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		HeaderNegativeFilters: filters,
 	})
 
@@ -66,11 +67,11 @@ func TestHTTPModifierHeaderNegativeFilters(t *testing.T) {
 		t.Error("Request should not pass filters")
 	}
 
-	filters = HTTPHeaderFilters{}
+	filters = settings.HTTPHeaderFilters{}
 	// Setting filter that not match our header
 	filters.Set("Host: www*")
 
-	modifier = core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier = core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		HeaderNegativeFilters: filters,
 	})
 
@@ -80,10 +81,10 @@ func TestHTTPModifierHeaderNegativeFilters(t *testing.T) {
 }
 
 func TestHTTPHeaderBasicAuthFilters(t *testing.T) {
-	filters := HTTPHeaderBasicAuthFilters{}
+	filters := settings.HTTPHeaderBasicAuthFilters{}
 	filters.Set("^customer[0-9].*")
 
-	modifier := core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier := core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		HeaderBasicAuthFilters: filters,
 	})
 
@@ -99,11 +100,11 @@ func TestHTTPHeaderBasicAuthFilters(t *testing.T) {
 		t.Error("Request should pass filters")
 	}
 
-	filters = HTTPHeaderBasicAuthFilters{}
+	filters = settings.HTTPHeaderBasicAuthFilters{}
 	// Setting filter that not match our header
 	filters.Set("^(homer simpson|mickey mouse).*")
 
-	modifier = core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier = core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		HeaderBasicAuthFilters: filters,
 	})
 
@@ -121,7 +122,7 @@ func TestHTTPHeaderBasicAuthFilters(t *testing.T) {
 func TestHTTPModifierURLRewrite(t *testing.T) {
 	var url, newURL []byte
 
-	rewrites := URLRewriteMap{}
+	rewrites := settings.URLRewriteMap{}
 
 	payload := func(url []byte) []byte {
 		return []byte("POST " + string(url) + " HTTP/1.1\r\nContent-Length: 7\r\nHost: www.w3.org\r\n\r\na=1&b=2")
@@ -132,7 +133,7 @@ func TestHTTPModifierURLRewrite(t *testing.T) {
 		t.Error("Should not error on /v1/user/([^\\/]+)/ping:/v2/user/$1/ping")
 	}
 
-	modifier := core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier := core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		URLRewrite: rewrites,
 	})
 
@@ -150,7 +151,7 @@ func TestHTTPModifierURLRewrite(t *testing.T) {
 func TestHTTPModifierHeaderRewrite(t *testing.T) {
 	var header, newHeader []byte
 
-	rewrites := HeaderRewriteMap{}
+	rewrites := settings.HeaderRewriteMap{}
 	payload := []byte("GET / HTTP/1.1\r\nContent-Length: 7\r\nHost: www.w3.org\r\n\r\na=1&b=2")
 
 	err := rewrites.Set("Host: (.*).w3.org,$1.beta.w3.org")
@@ -158,7 +159,7 @@ func TestHTTPModifierHeaderRewrite(t *testing.T) {
 		t.Error("Should not error", err)
 	}
 
-	modifier := core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier := core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		HeaderRewrite: rewrites,
 	})
 
@@ -169,10 +170,10 @@ func TestHTTPModifierHeaderRewrite(t *testing.T) {
 }
 
 func TestHTTPModifierHeaderHashFilters(t *testing.T) {
-	filters := HTTPHashFilters{}
+	filters := settings.HTTPHashFilters{}
 	filters.Set("Header2:1/2")
 
-	modifier := core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier := core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		HeaderHashFilters: filters,
 	})
 
@@ -194,10 +195,10 @@ func TestHTTPModifierHeaderHashFilters(t *testing.T) {
 }
 
 func TestHTTPModifierParamHashFilters(t *testing.T) {
-	filters := HTTPHashFilters{}
+	filters := settings.HTTPHashFilters{}
 	filters.Set("user_id:1/2")
 
-	modifier := core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier := core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		ParamHashFilters: filters,
 	})
 
@@ -219,11 +220,11 @@ func TestHTTPModifierParamHashFilters(t *testing.T) {
 }
 
 func TestHTTPModifierHeaders(t *testing.T) {
-	headers := HTTPHeaders{}
+	headers := settings.HTTPHeaders{}
 	headers.Set("Header1:1")
 	headers.Set("Host:localhost")
 
-	modifier := core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier := core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		Headers: headers,
 	})
 
@@ -236,11 +237,11 @@ func TestHTTPModifierHeaders(t *testing.T) {
 }
 
 func TestHTTPModifierURLRegexp(t *testing.T) {
-	filters := HTTPURLRegexp{}
+	filters := settings.HTTPURLRegexp{}
 	filters.Set("/v1/app")
 	filters.Set("/v1/api")
 
-	modifier := core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier := core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		URLRegexp: filters,
 	})
 
@@ -262,11 +263,11 @@ func TestHTTPModifierURLRegexp(t *testing.T) {
 }
 
 func TestHTTPModifierURLNegativeRegexp(t *testing.T) {
-	filters := HTTPURLRegexp{}
+	filters := settings.HTTPURLRegexp{}
 	filters.Set("/restricted1")
 	filters.Set("/some/restricted2")
 
-	modifier := core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier := core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		URLNegativeRegexp: filters,
 	})
 
@@ -288,10 +289,10 @@ func TestHTTPModifierURLNegativeRegexp(t *testing.T) {
 }
 
 func TestHTTPModifierSetHeader(t *testing.T) {
-	filters := HTTPHeaders{}
+	filters := settings.HTTPHeaders{}
 	filters.Set("User-Agent:Gor")
 
-	modifier := core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier := core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		Headers: filters,
 	})
 
@@ -304,10 +305,10 @@ func TestHTTPModifierSetHeader(t *testing.T) {
 }
 
 func TestHTTPModifierSetParam(t *testing.T) {
-	filters := HTTPParams{}
+	filters := settings.HTTPParams{}
 	filters.Set("api_key=1")
 
-	modifier := core.NewHTTPModifier(&HTTPModifierConfig{
+	modifier := core.NewHTTPModifier(&settings.HTTPModifierConfig{
 		Params: filters,
 	})
 
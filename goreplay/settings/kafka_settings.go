@@ -0,0 +1,145 @@
+package settings
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// InputKafkaConfig should contains required information to
+// build consumers.
+type InputKafkaConfig struct {
+	Host       string `json:"input-kafka-host"`
+	Topic      string `json:"input-kafka-topic"`
+	UseJSON    bool   `json:"input-kafka-json-format"`
+	Offset     string `json:"input-kafka-offset"`
+	SASLConfig SASLKafkaConfig
+
+	// Consumer replaces the broker connection, used by tests to inject a mock consumer
+	Consumer sarama.Consumer `json:"-"`
+}
+
+// Kafka output message keys
+const (
+	// KafkaKeyNone sends messages without a key, the producer spreads them over partitions
+	KafkaKeyNone = ""
+	// KafkaKeyPayloadID keys messages by payload ID, so a request and its response land on the same partition
+	KafkaKeyPayloadID = "id"
+)
+
+// OutputKafkaConfig is the representation of kafka output configuration
+type OutputKafkaConfig struct {
+	Host       string `json:"output-kafka-host"`
+	Topic      string `json:"output-kafka-topic"`
+	UseJSON    bool   `json:"output-kafka-json-format"`
+	Key        string `json:"output-kafka-key"`
+	Sync       bool   `json:"output-kafka-sync"`
+	SASLConfig SASLKafkaConfig
+
+	// Producer and SyncProducer replace the broker connection, used by tests to inject mock producers
+	Producer     sarama.AsyncProducer `json:"-"`
+	SyncProducer sarama.SyncProducer  `json:"-"`
+}
+
+// KafkaTLSConfig should contains TLS certificates for connecting to secured Kafka clusters
+type KafkaTLSConfig struct {
+	CACert     string `json:"kafka-tls-ca-cert"`
+	ClientCert string `json:"kafka-tls-client-cert"`
+	ClientKey  string `json:"kafka-tls-client-key"`
+}
+
+// SASLKafkaConfig SASL configuration
+type SASLKafkaConfig struct {
+	UseSASL   bool   `json:"kafka-use-sasl"`
+	Mechanism string `json:"kafka-mechanism"`
+	Username  string `json:"kafka-username"`
+	Password  string `json:"kafka-password"`
+}
+
+// NewTLSConfig loads TLS certificates
+func NewTLSConfig(clientCertFile, clientKeyFile, caCertFile string) (*tls.Config, error) {
+	tlsConfig := tls.Config{}
+
+	if clientCertFile != "" && clientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+		if err != nil {
+			return &tlsConfig, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caCertFile != "" {
+		caCert, err := os.ReadFile(caCertFile)
+		if err != nil {
+			return &tlsConfig, err
+		}
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	return &tlsConfig, nil
+}
+
+// NewKafkaConfig returns Kafka config with or without TLS
+func NewKafkaConfig(saslConfig *SASLKafkaConfig, tlsConfig *KafkaTLSConfig) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+
+	if tlsConfig != nil && (tlsConfig.ClientCert != "" || tlsConfig.CACert != "") {
+		config.Net.TLS.Enable = true
+		tlsConfig, err := NewTLSConfig(tlsConfig.ClientCert, tlsConfig.ClientKey, tlsConfig.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Kafka TLS config: %w", err)
+		}
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if saslConfig != nil && saslConfig.UseSASL {
+		mechanism := sarama.SASLMechanism(saslConfig.Mechanism)
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = mechanism
+		config.Net.SASL.User = saslConfig.Username
+		config.Net.SASL.Password = saslConfig.Password
+
+		switch mechanism {
+		case sarama.SASLTypeSCRAMSHA256:
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hashGenerator: scram.SHA256}
+			}
+		case sarama.SASLTypeSCRAMSHA512:
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hashGenerator: scram.SHA512}
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// scramClient implements sarama.SCRAMClient on top of xdg-go/scram
+type scramClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	hashGenerator scram.HashGeneratorFcn
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) (err error) {
+	c.Client, err = c.hashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.ClientConversation = c.Client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.ClientConversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.ClientConversation.Done()
+}
@@ -0,0 +1,68 @@
+package settings
+
+import (
+	"github.com/go-sql-driver/mysql"
+)
+
+// SecretMask replaces the secrets of the settings returned to the clients, see AppSettings.Redact
+const SecretMask = "******"
+
+// Redact returns a copy of the settings with the secrets replaced by SecretMask: the JWT key of
+// the time shift, the SASL passwords of Kafka and the passwords of the MySQL output DSNs
+func (s AppSettings) Redact() AppSettings {
+	if s.TimeShift.JWTKey != "" {
+		s.TimeShift.JWTKey = SecretMask
+	}
+	if s.InputKafkaConfig.SASLConfig.Password != "" {
+		s.InputKafkaConfig.SASLConfig.Password = SecretMask
+	}
+	if s.OutputKafkaConfig.SASLConfig.Password != "" {
+		s.OutputKafkaConfig.SASLConfig.Password = SecretMask
+	}
+	if len(s.OutputMySQL) > 0 {
+		dsns := make([]string, len(s.OutputMySQL))
+		for i, dsn := range s.OutputMySQL {
+			dsns[i] = redactDSN(dsn)
+		}
+		s.OutputMySQL = dsns
+	}
+	return s
+}
+
+// RestoreSecrets puts back the secrets of stored which were sent back masked by Redact,
+// so that settings read from a redacted copy can be saved again without their secrets
+func (s *AppSettings) RestoreSecrets(stored *AppSettings) {
+	if s.TimeShift.JWTKey == SecretMask {
+		s.TimeShift.JWTKey = stored.TimeShift.JWTKey
+	}
+	if s.InputKafkaConfig.SASLConfig.Password == SecretMask {
+		s.InputKafkaConfig.SASLConfig.Password = stored.InputKafkaConfig.SASLConfig.Password
+	}
+	if s.OutputKafkaConfig.SASLConfig.Password == SecretMask {
+		s.OutputKafkaConfig.SASLConfig.Password = stored.OutputKafkaConfig.SASLConfig.Password
+	}
+	for i, dsn := range s.OutputMySQL {
+		if redactDSN(dsn) != dsn {
+			continue
+		}
+		for _, original := range stored.OutputMySQL {
+			if redactDSN(original) == dsn {
+				s.OutputMySQL[i] = original
+				break
+			}
+		}
+	}
+}
+
+// redactDSN masks the password of a MySQL DSN, the whole DSN when it can't be parsed
+func redactDSN(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return SecretMask
+	}
+	if cfg.Passwd == "" {
+		return dsn
+	}
+	cfg.Passwd = SecretMask
+	return cfg.FormatDSN()
+}
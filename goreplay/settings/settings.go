@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"record-traffic-press/goreplay/common"
 	"record-traffic-press/goreplay/core/capture"
+	"record-traffic-press/goreplay/proto"
 	"time"
 )
 
@@ -47,9 +48,25 @@ type AppSettings struct {
 	OutputFile         []string      `json:"output-file"`
 	OutputFileConfig   FileOutputConfig
 
+	// OutputPcap writes the captured messages as pcap, or pcapng when the path ends with ".pcapng"
+	OutputPcap       []string `json:"output-pcap"`
+	OutputPcapConfig PcapOutputConfig
+
 	InputRAW       []string `json:"input-raw"`
 	InputRAWConfig RAWInputConfig
 
+	// InputAmplify multiplies the traffic of every input, e.g. 3.5, see core.Amplifier
+	InputAmplify       float64       `json:"input-amplify"`
+	InputAmplifyJitter time.Duration `json:"input-amplify-jitter"`
+
+	// InputShard and InputShards keep the payloads whose session hash modulo InputShards is InputShard,
+	// set on the workers of a distributed replay, see core.Shard. The sessions are the client IPs when
+	// an output or the correlation uses them, the captured connections otherwise.
+	InputShard  int `json:"input-shard"`
+	InputShards int `json:"input-shards"`
+
+	LoadProfile LoadProfileConfig
+
 	Middleware string `json:"middleware"`
 
 	InputHTTP    []string
@@ -61,6 +78,37 @@ type AppSettings struct {
 	OutputBinary       []string `json:"output-binary"`
 	OutputBinaryConfig BinaryOutputConfig
 
+	OutputDubbo       []string `json:"output-dubbo"`
+	OutputDubboConfig DubboOutputConfig
+
+	// OutputHTTP2 replays HTTP/2 and gRPC streams, "http://host:port" over h2c or "https://host:port" over TLS
+	OutputHTTP2       []string `json:"output-http2"`
+	OutputHTTP2Config HTTP2OutputConfig
+
+	// OutputRedis replays redis commands, "host:port" of the target server
+	OutputRedis       []string `json:"output-redis"`
+	OutputRedisConfig RedisOutputConfig
+
+	// OutputMySQL replays MySQL queries, the DSN of the target database, e.g. "user:password@tcp(host:3306)/db"
+	OutputMySQL       []string `json:"output-mysql"`
+	OutputMySQLConfig MySQLOutputConfig
+
+	OutputDiff       []string `json:"output-diff"`
+	OutputDiffConfig DiffOutputConfig
+
+	SLO SLOConfig
+
+	Correlation CorrelationConfig
+
+	TimeShift TimeShiftConfig
+
+	// LatencyWindow is how often the latency percentiles of the http, http2, binary, dubbo, redis and mysql outputs are logged
+	LatencyWindow time.Duration `json:"latency-window"`
+
+	InputKafkaConfig  InputKafkaConfig
+	OutputKafkaConfig OutputKafkaConfig
+	KafkaTLSConfig    KafkaTLSConfig
+
 	ModifierConfig HTTPModifierConfig
 }
 
@@ -80,6 +128,7 @@ type TCPOutputConfig struct {
 	Sticky     bool `json:"output-tcp-sticky"`
 	SkipVerify bool `json:"output-tcp-skip-verify"`
 	Workers    int  `json:"output-tcp-workers"`
+	Stats      bool `json:"-"` // filled from AppSettings.OutputTCPStats
 
 	GetInitMessage     func() *common.Message                         `json:"-"`
 	WriteBeforeMessage func(conn net.Conn, msg *common.Message) error `json:"-"`
@@ -96,11 +145,22 @@ type FileOutputConfig struct {
 	OnClose           func(string)  `json:"-"`
 }
 
+// PcapOutputConfig pcap output configuration. The messages without their captured packets, e.g. read by
+// the file input, are written over synthesized TCP connections between ClientIP and ServerIP:ServerPort
+type PcapOutputConfig struct {
+	ClientIP   string `json:"output-pcap-client-ip"`
+	ServerIP   string `json:"output-pcap-server-ip"`
+	ServerPort uint16 `json:"output-pcap-server-port"`
+}
+
 // WebSocketOutputConfig WebSocket output configuration
 type WebSocketOutputConfig struct {
 	Sticky     bool `json:"output-ws-sticky"`
 	SkipVerify bool `json:"output-ws-skip-verify"`
 	Workers    int  `json:"output-ws-workers"`
+	Stats      bool `json:"-"` // filled from AppSettings.OutputWebSocketStats
+	// Session routes the payloads of a session through one worker in captured order, see proto.SessionID
+	Session string `json:"output-ws-session"`
 
 	Headers map[string][]string `json:"output-ws-headers"`
 }
@@ -112,6 +172,114 @@ type BinaryOutputConfig struct {
 	BufferSize     common.Size   `json:"output-tcp-response-buffer"`
 	Debug          bool          `json:"output-binary-debug"`
 	TrackResponses bool          `json:"output-binary-track-response"`
+	LatencyWindow  time.Duration `json:"-"`                     // filled from AppSettings.LatencyWindow
+	Session        string        `json:"output-binary-session"` // see proto.SessionID
+}
+
+// DubboOutputConfig struct for holding dubbo output configuration
+type DubboOutputConfig struct {
+	Connections    int           `json:"output-dubbo-connections"`
+	Timeout        time.Duration `json:"output-dubbo-timeout"`
+	TrackResponses bool          `json:"output-dubbo-track-response"`
+	Debug          bool          `json:"output-dubbo-debug"`
+	// ServiceVersion and Group replace the ones of the captured invocations when set
+	ServiceVersion string `json:"output-dubbo-service-version"`
+	Group          string `json:"output-dubbo-group"`
+
+	LatencyWindow time.Duration `json:"-"` // filled from AppSettings.LatencyWindow
+}
+
+// HTTP2OutputConfig struct for holding HTTP/2 output configuration
+type HTTP2OutputConfig struct {
+	Workers        int           `json:"output-http2-workers"` // concurrent streams
+	Timeout        time.Duration `json:"output-http2-timeout"`
+	TrackResponses bool          `json:"output-http2-track-response"`
+	SkipVerify     bool          `json:"output-http2-skip-verify"` // don't verify the certificate of a TLS target
+	Debug          bool          `json:"output-http2-debug"`
+
+	LatencyWindow time.Duration `json:"-"` // filled from AppSettings.LatencyWindow
+}
+
+// RedisOutputConfig struct for holding redis output configuration
+type RedisOutputConfig struct {
+	ReadOnly       bool          `json:"output-redis-read-only"`  // only replay the commands which don't modify the data
+	KeyPrefix      []string      `json:"output-redis-key-prefix"` // "from=to", replaces the prefix from of the keys by to
+	Timeout        time.Duration `json:"output-redis-timeout"`
+	TrackResponses bool          `json:"output-redis-track-response"`
+	Debug          bool          `json:"output-redis-debug"`
+
+	LatencyWindow time.Duration `json:"-"` // filled from AppSettings.LatencyWindow
+}
+
+// MySQLOutputConfig struct for holding MySQL output configuration
+type MySQLOutputConfig struct {
+	AllowWrites    bool          `json:"output-mysql-allow-writes"` // by default only the queries reading data are replayed
+	Timeout        time.Duration `json:"output-mysql-timeout"`
+	TrackResponses bool          `json:"output-mysql-track-response"`
+	Debug          bool          `json:"output-mysql-debug"`
+
+	LatencyWindow time.Duration `json:"-"` // filled from AppSettings.LatencyWindow
+}
+
+// Load profile stage types
+const (
+	LoadStageRamp  = "ramp"  // linear change from From to To
+	LoadStageStep  = "step"  // From to To in Steps equal steps
+	LoadStageSpike = "spike" // sudden jump to To for the first half of the stage, back to From for the second half
+	LoadStageHold  = "hold"  // keeps To, e.g. a soak test
+)
+
+// Load profile targets
+const (
+	LoadTargetSpeed   = "speed"   // FileInput and KafkaInput replay speed
+	LoadTargetAmplify = "amplify" // input amplification factor, see core.Amplifier
+)
+
+// LoadStage is a part of a load profile, the rates are factors of the recorded traffic rate
+type LoadStage struct {
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	Duration time.Duration `json:"duration"`
+	From     float64       `json:"from"`
+	To       float64       `json:"to"`
+	Steps    int           `json:"steps"`
+}
+
+// LoadProfileConfig changes the replay rate over time, the last rate is kept once all the stages are over
+type LoadProfileConfig struct {
+	Target string      `json:"load-profile-target"`
+	Stages []LoadStage `json:"load-profile"`
+}
+
+// CorrelationConfig extracts values from the original and replayed responses and replaces the original
+// values by the replayed ones in the later requests of the same session, e.g. tokens or created IDs
+type CorrelationConfig struct {
+	Rules   []string      `json:"correlate"`         // "re:<regexp>" or "json:<path>", see core.ParseCorrelationRule
+	Session string        `json:"correlate-session"` // see proto.SessionID, defaults to the connection
+	Wait    time.Duration `json:"correlate-wait"`    // how long a request carrying an original value waits for the replayed one, 0 doesn't wait
+}
+
+// TimeShiftConfig moves the timestamps embedded in the recorded requests by the time elapsed since their capture
+type TimeShiftConfig struct {
+	Rules  []string `json:"time-shift"`         // "header:<name>", "param:<name>", "json:<path>" or "jwt:<header>", see core.ParseTimeShiftRule
+	JWTKey string   `json:"time-shift-jwt-key"` // signs the shifted HS256/HS384/HS512 tokens again, their signature is kept otherwise
+}
+
+// SLOConfig holds the thresholds a replay is passed or failed against, e.g. "p99<300ms", see core.ParseSLORule
+type SLOConfig struct {
+	Rules       []string `json:"slo"`
+	Abort       bool     `json:"slo-abort"`        // stop the replay once a rule is breached
+	MinRequests int64    `json:"slo-min-requests"` // samples of a rule needed before a breach stops the replay
+}
+
+// DiffOutputConfig struct for holding configuration of the replayed responses comparator
+type DiffOutputConfig struct {
+	Headers      []string      `json:"output-diff-header"`       // headers compared besides status and body
+	IgnoreFields []string      `json:"output-diff-ignore-field"` // body fields skipped, see proto.DiffIgnore
+	TTL          time.Duration `json:"output-diff-ttl"`
+	MaxPending   int           `json:"output-diff-max-pending"`
+	// Handler receives every mismatch, used to collect them without a file
+	Handler func(*proto.DiffRecord) `json:"-"`
 }
 
 // HTTPOutputConfig struct for holding http output configuration
@@ -132,6 +300,10 @@ type HTTPOutputConfig struct {
 	CompatibilityMode bool          `json:"output-http-compatibility-mode"`
 	RequestGroup      string        `json:"output-http-request-group"`
 	Debug             bool          `json:"output-http-debug"`
+	LatencyWindow     time.Duration `json:"-"`                     // filled from AppSettings.LatencyWindow
+	OpenLoop          bool          `json:"output-http-open-loop"` // never wait for the target, see output.HTTPOutput
+	OpenLoopBacklog   int           `json:"output-http-open-loop-backlog"`
+	Session           string        `json:"output-http-session"` // see proto.SessionID
 	RawURL            string        `json:"-"`
 	Url               *url.URL      `json:"-"`
 }
@@ -154,18 +326,33 @@ func (hoc *HTTPOutputConfig) Copy() *HTTPOutputConfig {
 		CompatibilityMode: hoc.CompatibilityMode,
 		RequestGroup:      hoc.RequestGroup,
 		Debug:             hoc.Debug,
+		LatencyWindow:     hoc.LatencyWindow,
+		OpenLoop:          hoc.OpenLoop,
+		OpenLoopBacklog:   hoc.OpenLoopBacklog,
+		Session:           hoc.Session,
 	}
 }
 
+// CheckSettings fills defaults of the global Settings
 func CheckSettings() {
+	Settings.Check()
+}
 
-	if Settings.OutputFileConfig.SizeLimit < 1 {
-		Settings.OutputFileConfig.SizeLimit.Set("32mb")
+// Check fills defaults for options that were not set
+func (s *AppSettings) Check() {
+	if s.OutputFileConfig.SizeLimit < 1 {
+		s.OutputFileConfig.SizeLimit.Set("32mb")
+	}
+	if s.OutputFileConfig.OutputFileMaxSize < 1 {
+		s.OutputFileConfig.OutputFileMaxSize.Set("1tb")
+	}
+	if s.CopyBufferSize < 1 {
+		s.CopyBufferSize.Set("5mb")
 	}
-	if Settings.OutputFileConfig.OutputFileMaxSize < 1 {
-		Settings.OutputFileConfig.OutputFileMaxSize.Set("1tb")
+	if s.SLO.MinRequests < 1 {
+		s.SLO.MinRequests = 100
 	}
-	if Settings.CopyBufferSize < 1 {
-		Settings.CopyBufferSize.Set("5mb")
+	if s.Correlation.Session == "" {
+		s.Correlation.Session = proto.SessionByConnection
 	}
 }
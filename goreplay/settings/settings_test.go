@@ -12,3 +12,89 @@ func TestAppSettings(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestAppSettingsJSONModifier(t *testing.T) {
+	body := `{"ModifierConfig":{
+		"http-allow-json":["$.user.role:^admin$"],
+		"http-set-json":["$.a=1","$.items[0].name=bob"],
+		"http-delete-json":["$.items[1]"],
+		"http-rewrite-json":["$.user.email: @example\\.com$,@test.local"]}}`
+
+	var a AppSettings
+	if err := json.Unmarshal([]byte(body), &a); err != nil {
+		t.Fatal(err)
+	}
+	m := a.ModifierConfig
+	if len(m.JSONFilters) != 1 || m.JSONFilters[0].Regexp.String() != "^admin$" {
+		t.Errorf("wrong filters %v", m.JSONFilters)
+	}
+	if len(m.JSONSets) != 2 || m.JSONSets[1].Path[1] != 0 || m.JSONSets[1].Value != "bob" {
+		t.Errorf("wrong sets %v", m.JSONSets)
+	}
+	if len(m.JSONDeletes) != 1 || m.JSONDeletes[0][1] != 1 {
+		t.Errorf("wrong deletes %v", m.JSONDeletes)
+	}
+	if len(m.JSONRewrite) != 1 || string(m.JSONRewrite[0].Target) != "@test.local" {
+		t.Errorf("wrong rewrites %v", m.JSONRewrite)
+	}
+
+	// the settings are stored as JSON and read back before a replay
+	data, err := json.Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b AppSettings
+	if err = json.Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.ModifierConfig.JSONSets.String() != m.JSONSets.String() ||
+		b.ModifierConfig.JSONRewrite.String() != m.JSONRewrite.String() ||
+		b.ModifierConfig.JSONFilters[0].Regexp.String() != "^admin$" {
+		t.Errorf("settings changed by a JSON round trip: %s", data)
+	}
+
+	if err = json.Unmarshal([]byte(`{"ModifierConfig":{"http-set-json":["$.a"]}}`), &b); err == nil {
+		t.Error("Should error on a value without =")
+	}
+}
+
+func TestAppSettingsRedact(t *testing.T) {
+	a := AppSettings{
+		OutputMySQL: []string{"root:secret@tcp(127.0.0.1:3306)/shop", "reader@tcp(127.0.0.1:3306)/shop"},
+		TimeShift:   TimeShiftConfig{JWTKey: "jwt-secret"},
+	}
+	a.InputKafkaConfig.SASLConfig.Password = "in-secret"
+	a.OutputKafkaConfig.SASLConfig.Password = "out-secret"
+
+	r := a.Redact()
+	if r.TimeShift.JWTKey != SecretMask {
+		t.Errorf("JWT key not redacted: %q", r.TimeShift.JWTKey)
+	}
+	if r.InputKafkaConfig.SASLConfig.Password != SecretMask || r.OutputKafkaConfig.SASLConfig.Password != SecretMask {
+		t.Errorf("Kafka passwords not redacted: %q %q", r.InputKafkaConfig.SASLConfig.Password, r.OutputKafkaConfig.SASLConfig.Password)
+	}
+	if r.OutputMySQL[0] != "root:"+SecretMask+"@tcp(127.0.0.1:3306)/shop" {
+		t.Errorf("password not redacted: %q", r.OutputMySQL[0])
+	}
+	if r.OutputMySQL[1] != a.OutputMySQL[1] {
+		t.Errorf("DSN without password changed: %q", r.OutputMySQL[1])
+	}
+	if a.OutputMySQL[0] != "root:secret@tcp(127.0.0.1:3306)/shop" || a.TimeShift.JWTKey != "jwt-secret" || a.InputKafkaConfig.SASLConfig.Password != "in-secret" {
+		t.Error("original settings modified")
+	}
+
+	r.OutputMySQL = append(r.OutputMySQL, "app:"+SecretMask+"@tcp(10.0.0.1:3306)/shop")
+	r.RestoreSecrets(&a)
+	if r.TimeShift.JWTKey != "jwt-secret" {
+		t.Errorf("JWT key not restored: %q", r.TimeShift.JWTKey)
+	}
+	if r.InputKafkaConfig.SASLConfig.Password != "in-secret" || r.OutputKafkaConfig.SASLConfig.Password != "out-secret" {
+		t.Errorf("Kafka passwords not restored: %q %q", r.InputKafkaConfig.SASLConfig.Password, r.OutputKafkaConfig.SASLConfig.Password)
+	}
+	if r.OutputMySQL[0] != a.OutputMySQL[0] || r.OutputMySQL[1] != a.OutputMySQL[1] {
+		t.Errorf("DSNs not restored: %v", r.OutputMySQL)
+	}
+	if r.OutputMySQL[2] != "app:"+SecretMask+"@tcp(10.0.0.1:3306)/shop" {
+		t.Errorf("unknown DSN restored: %q", r.OutputMySQL[2])
+	}
+}
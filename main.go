@@ -1,18 +1,32 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"record-traffic-press/cluster"
 	"record-traffic-press/config/conf"
 	"record-traffic-press/config/db"
+	cache "record-traffic-press/config/redis"
 	"record-traffic-press/model"
 	"record-traffic-press/routers"
+	"syscall"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/redis"
 	"github.com/gin-gonic/gin"
 )
 
+var (
+	workerMode = flag.Bool("worker", false, "以分布式回放 worker 模式启动, 通过 Redis 接收任务")
+	workerID   = flag.String("worker-id", "", "worker ID, 集群内唯一, 默认为 主机名-进程号")
+)
+
 func main() {
+	flag.Parse()
 
 	// 设置日志格式为 JSON
 	//logrus.SetFormatter(&logrus.JSONFormatter{})
@@ -24,6 +38,14 @@ func main() {
 	// 初始化配置文件
 	conf.ReadFromLocal()
 
+	// 初始化Redis, 分布式回放依赖
+	cache.InitRedis()
+
+	if *workerMode {
+		runWorker()
+		return
+	}
+
 	// 初始化数据库
 	db.InitialDB()
 
@@ -48,6 +70,28 @@ func main() {
 	r.Use(sessions.Sessions("mysession", store))
 
 	routers.RecordControllerRoutersInit(r)
+	routers.ReplayControllerRoutersInit(r)
+	routers.ClusterControllerRoutersInit(r)
 
 	r.Run()
 }
+
+// runWorker worker 模式不启动 web 服务及数据库, 收到退出信号后停止正在执行的任务
+func runWorker() {
+	if !cache.IsValid() {
+		logrus.Fatal("cluster worker requires redis")
+	}
+
+	host, _ := os.Hostname()
+	id := *workerID
+	if id == "" {
+		id = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cluster.NewWorker(id, host, os.Getpid()).Run(ctx); err != nil {
+		logrus.Fatal(err)
+	}
+}
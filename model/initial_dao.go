@@ -9,6 +9,9 @@ import (
 var tableList = []TableWrapper{
 	// 使用 Traffic 数据源
 	{GetRecordTrafficDAO(), db.Traffic},
+	{GetReplayRunDAO(), db.Traffic},
+	{GetReplayDiffDAO(), db.Traffic},
+	{GetReplayLatencyDAO(), db.Traffic},
 }
 
 type (
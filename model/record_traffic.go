@@ -1,6 +1,11 @@
 package model
 
-import "github.com/sirupsen/logrus"
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"record-traffic-press/constant/common"
+)
 
 // TableName 表名
 func (s *RecordTraffic) TableName() string {
@@ -9,10 +14,21 @@ func (s *RecordTraffic) TableName() string {
 
 type RecordTraffic struct {
 	BaseModel
-	StartTime int64  `gorm:"column:start_time;type:TIMESTAMP;comment:'开始时间'" json:"start_time"`
-	EndTime   int64  `gorm:"column:end_time;type:TIMESTAMP;comment:'结束时间'" json:"end_time"`
-	Settings  string `gorm:"column:settings;type:varchar(102);comment:'配置信息'" json:"settings"`
-	Status    int32  `gorm:"column:status;type:int;comment:'状态, 1:初始化; 2:进行中; 3:结束;'" json:"status"`
+	StartTime     int64  `gorm:"column:start_time;type:TIMESTAMP;comment:'开始时间'" json:"start_time"`
+	EndTime       int64  `gorm:"column:end_time;type:TIMESTAMP;comment:'结束时间'" json:"end_time"`
+	Settings      string `gorm:"column:settings;type:text;comment:'配置信息'" json:"settings"`
+	Status        int32  `gorm:"column:status;type:int;comment:'状态, 1:初始化; 2:进行中; 3:结束;'" json:"status"`
+	Verdict       int32  `gorm:"column:verdict;type:int;default:0;comment:'压测结论, 0:未配置SLO; 1:通过; 2:未通过;'" json:"verdict"`
+	VerdictDetail string `gorm:"column:verdict_detail;type:text;comment:'压测结论明细, 各SLO规则的实际值及是否通过'" json:"verdict_detail"`
+}
+
+// RecordTrafficQuery 列表查询条件, 零值表示不过滤
+type RecordTrafficQuery struct {
+	Status    int32 // 状态
+	StartTime int64 // 创建时间起始(含)
+	EndTime   int64 // 创建时间截止(含)
+	Page      int   // 页码, 从1开始
+	PageSize  int   // 每页条数
 }
 
 // RecordTrafficDAO 数据库访问对象
@@ -38,3 +54,92 @@ func (t *RecordTrafficDAO) Insert(recordTraffic *RecordTraffic) error {
 
 	return nil
 }
+
+// GetByID 根据主键查询, 记录不存在时返回 nil
+func (t *RecordTrafficDAO) GetByID(id int32) (*RecordTraffic, error) {
+	var recordTraffic RecordTraffic
+
+	err := t.db.Where("id = ? AND flag = ?", id, common.No.Code).First(&recordTraffic).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		logrus.Errorf("get record traffic failed. id:%d, err:%v", id, err)
+		return nil, err
+	}
+
+	return &recordTraffic, nil
+}
+
+// UpdateStatus 仅当当前状态为 fromStatus 时更新状态及其他字段, 返回是否更新成功
+func (t *RecordTrafficDAO) UpdateStatus(id int32, fromStatus int32, values map[string]interface{}) (bool, error) {
+	result := t.db.Model(&RecordTraffic{}).
+		Where("id = ? AND status = ? AND flag = ?", id, fromStatus, common.No.Code).
+		Updates(values)
+
+	if result.Error != nil {
+		logrus.Errorf("update record traffic status failed. id:%d, err:%v", id, result.Error)
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// List 分页查询未删除的记录, 按主键倒序, 同时返回满足条件的总数
+func (t *RecordTrafficDAO) List(query *RecordTrafficQuery) ([]*RecordTraffic, int64, error) {
+	var (
+		list  []*RecordTraffic
+		total int64
+	)
+
+	tx := t.db.Model(&RecordTraffic{}).Where("flag = ?", common.No.Code)
+	if query.Status > 0 {
+		tx = tx.Where("status = ?", query.Status)
+	}
+	if query.StartTime > 0 {
+		tx = tx.Where("create_time >= ?", query.StartTime)
+	}
+	if query.EndTime > 0 {
+		tx = tx.Where("create_time <= ?", query.EndTime)
+	}
+
+	if err := tx.Count(&total).Error; err != nil {
+		logrus.Errorf("count record traffic failed. err:%v", err)
+		return nil, 0, err
+	}
+
+	if total == 0 {
+		return list, 0, nil
+	}
+
+	err := tx.Order("id DESC").
+		Offset((query.Page - 1) * query.PageSize).
+		Limit(query.PageSize).
+		Find(&list).Error
+
+	if err != nil {
+		logrus.Errorf("list record traffic failed. err:%v", err)
+		return nil, 0, err
+	}
+
+	return list, total, nil
+}
+
+// Delete 软删除, 进行中的任务不允许删除, 返回是否删除成功
+func (t *RecordTrafficDAO) Delete(id int32, updateTime int64) (bool, error) {
+	result := t.db.Model(&RecordTraffic{}).
+		Where("id = ? AND flag = ? AND status <> ?", id, common.No.Code, common.RecordStatusRecording.Code).
+		Updates(map[string]interface{}{
+			"flag":        common.Yes.Code,
+			"update_time": updateTime,
+		})
+
+	if result.Error != nil {
+		logrus.Errorf("delete record traffic failed. id:%d, err:%v", id, result.Error)
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
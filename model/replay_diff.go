@@ -0,0 +1,103 @@
+package model
+
+import (
+	"github.com/sirupsen/logrus"
+	"record-traffic-press/constant/common"
+)
+
+// TableName 表名
+func (s *ReplayDiffSummary) TableName() string {
+	return "replay_diff_summary"
+}
+
+// ReplayDiffSummary 回放按接口汇总的比对结果, HTTP 接口为 "METHOD /path", Dubbo 接口为 "service.method"
+type ReplayDiffSummary struct {
+	BaseModel
+	RunID           int32  `gorm:"column:run_id;type:int;index;comment:'回放执行ID'" json:"run_id"`
+	Protocol        string `gorm:"column:protocol;type:varchar(16);comment:'协议, http/dubbo'" json:"protocol"`
+	Endpoint        string `gorm:"column:endpoint;type:varchar(512);comment:'接口'" json:"endpoint"`
+	Total           int64  `gorm:"column:total;type:bigint;comment:'比对总数'" json:"total"`
+	Matched         int64  `gorm:"column:matched;type:bigint;comment:'一致数'" json:"matched"`
+	Mismatched      int64  `gorm:"column:mismatched;type:bigint;comment:'不一致数'" json:"mismatched"`
+	Missing         int64  `gorm:"column:missing;type:bigint;comment:'缺少响应数'" json:"missing"`
+	OriginalLatency int64  `gorm:"column:original_latency;type:bigint;comment:'原始平均耗时(微秒)'" json:"original_latency"`
+	ReplayedLatency int64  `gorm:"column:replayed_latency;type:bigint;comment:'回放平均耗时(微秒)'" json:"replayed_latency"`
+}
+
+// TableName 表名
+func (s *ReplayDiffSample) TableName() string {
+	return "replay_diff_sample"
+}
+
+// ReplayDiffSample 抽样保存的不一致示例
+type ReplayDiffSample struct {
+	BaseModel
+	RunID     int32  `gorm:"column:run_id;type:int;index;comment:'回放执行ID'" json:"run_id"`
+	Endpoint  string `gorm:"column:endpoint;type:varchar(512);comment:'接口'" json:"endpoint"`
+	PayloadID string `gorm:"column:payload_id;type:varchar(64);comment:'请求ID'" json:"payload_id"`
+	Diffs     string `gorm:"column:diffs;type:text;comment:'差异字段, JSON 数组'" json:"diffs"`
+}
+
+// ReplayDiffDAO 数据库访问对象
+type ReplayDiffDAO struct {
+	BaseDAO
+}
+
+var replayDiffDAO ReplayDiffDAO
+
+func GetReplayDiffDAO() *ReplayDiffDAO {
+	return &replayDiffDAO
+}
+
+// BatchInsert 批量保存汇总及示例
+func (t *ReplayDiffDAO) BatchInsert(summaries []*ReplayDiffSummary, samples []*ReplayDiffSample) error {
+	if len(summaries) > 0 {
+		if err := t.db.Create(&summaries).Error; err != nil {
+			logrus.Errorf("insert replay diff summary failed. err:%v", err)
+			return err
+		}
+	}
+
+	if len(samples) > 0 {
+		if err := t.db.Create(&samples).Error; err != nil {
+			logrus.Errorf("insert replay diff sample failed. err:%v", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListSummaries 查询回放的接口汇总, 不一致数多的在前, 可按协议过滤
+func (t *ReplayDiffDAO) ListSummaries(runID int32, protocol string) ([]*ReplayDiffSummary, error) {
+	var list []*ReplayDiffSummary
+
+	tx := t.db.Where("run_id = ? AND flag = ?", runID, common.No.Code)
+	if protocol != "" {
+		tx = tx.Where("protocol = ?", protocol)
+	}
+
+	if err := tx.Order("mismatched DESC, id ASC").Find(&list).Error; err != nil {
+		logrus.Errorf("list replay diff summary failed. run_id:%d, err:%v", runID, err)
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// ListSamples 查询回放的不一致示例, endpoint 为空时返回全部接口
+func (t *ReplayDiffDAO) ListSamples(runID int32, endpoint string) ([]*ReplayDiffSample, error) {
+	var list []*ReplayDiffSample
+
+	tx := t.db.Where("run_id = ? AND flag = ?", runID, common.No.Code)
+	if endpoint != "" {
+		tx = tx.Where("endpoint = ?", endpoint)
+	}
+
+	if err := tx.Order("id ASC").Find(&list).Error; err != nil {
+		logrus.Errorf("list replay diff sample failed. run_id:%d, err:%v", runID, err)
+		return nil, err
+	}
+
+	return list, nil
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"github.com/sirupsen/logrus"
+	"record-traffic-press/constant/common"
+)
+
+// TableName 表名
+func (s *ReplayLatency) TableName() string {
+	return "replay_latency"
+}
+
+// ReplayLatency 回放输出按接口统计的耗时分位数, 耗时单位均为微秒
+type ReplayLatency struct {
+	BaseModel
+	RunID    int32  `gorm:"column:run_id;type:int;index;comment:'回放执行ID'" json:"run_id"`
+	Output   string `gorm:"column:output;type:varchar(256);comment:'回放输出'" json:"output"`
+	Endpoint string `gorm:"column:endpoint;type:varchar(512);comment:'接口'" json:"endpoint"`
+	Requests int64  `gorm:"column:requests;type:bigint;comment:'请求数, 含无响应的失败请求'" json:"requests"`
+	Count    int64  `gorm:"column:count;type:bigint;comment:'响应数'" json:"count"`
+	Errors   int64  `gorm:"column:errors;type:bigint;comment:'错误数'" json:"errors"`
+	Timeouts int64  `gorm:"column:timeouts;type:bigint;comment:'超时数'" json:"timeouts"`
+	Mean     int64  `gorm:"column:mean;type:bigint;comment:'平均耗时'" json:"mean"`
+	P50      int64  `gorm:"column:p50;type:bigint;comment:'P50 耗时'" json:"p50"`
+	P90      int64  `gorm:"column:p90;type:bigint;comment:'P90 耗时'" json:"p90"`
+	P99      int64  `gorm:"column:p99;type:bigint;comment:'P99 耗时'" json:"p99"`
+	P999     int64  `gorm:"column:p999;type:bigint;comment:'P99.9 耗时'" json:"p999"`
+	Max      int64  `gorm:"column:max;type:bigint;comment:'最大耗时'" json:"max"`
+}
+
+// ReplayLatencyDAO 数据库访问对象
+type ReplayLatencyDAO struct {
+	BaseDAO
+}
+
+var replayLatencyDAO ReplayLatencyDAO
+
+func GetReplayLatencyDAO() *ReplayLatencyDAO {
+	return &replayLatencyDAO
+}
+
+// BatchInsert 批量保存耗时统计
+func (t *ReplayLatencyDAO) BatchInsert(list []*ReplayLatency) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	if err := t.db.Create(&list).Error; err != nil {
+		logrus.Errorf("insert replay latency failed. err:%v", err)
+		return err
+	}
+
+	return nil
+}
+
+// ListByRunID 查询回放的耗时统计, 按输出及接口排序
+func (t *ReplayLatencyDAO) ListByRunID(runID int32) ([]*ReplayLatency, error) {
+	var list []*ReplayLatency
+
+	if err := t.db.Where("run_id = ? AND flag = ?", runID, common.No.Code).Order("output ASC, endpoint ASC").Find(&list).Error; err != nil {
+		logrus.Errorf("list replay latency failed. run_id:%d, err:%v", runID, err)
+		return nil, err
+	}
+
+	return list, nil
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"record-traffic-press/constant/common"
+)
+
+// TableName 表名
+func (s *ReplayRun) TableName() string {
+	return "replay_run"
+}
+
+// ReplayRun 回放执行记录, 汇总一次回放的比对结果
+type ReplayRun struct {
+	BaseModel
+	RecordID   int32 `gorm:"column:record_id;type:int;index;comment:'录制任务ID'" json:"record_id"`
+	StartTime  int64 `gorm:"column:start_time;type:TIMESTAMP;comment:'开始时间'" json:"start_time"`
+	EndTime    int64 `gorm:"column:end_time;type:TIMESTAMP;comment:'结束时间'" json:"end_time"`
+	Status     int32 `gorm:"column:status;type:int;comment:'状态, 1:进行中; 2:结束; 3:失败;'" json:"status"`
+	Total      int64 `gorm:"column:total;type:bigint;comment:'比对总数'" json:"total"`
+	Matched    int64 `gorm:"column:matched;type:bigint;comment:'一致数'" json:"matched"`
+	Mismatched int64 `gorm:"column:mismatched;type:bigint;comment:'不一致数'" json:"mismatched"`
+	Missing    int64 `gorm:"column:missing;type:bigint;comment:'缺少响应数'" json:"missing"`
+}
+
+// ReplayRunDAO 数据库访问对象
+type ReplayRunDAO struct {
+	BaseDAO
+}
+
+var replayRunDAO ReplayRunDAO
+
+func GetReplayRunDAO() *ReplayRunDAO {
+	return &replayRunDAO
+}
+
+// Insert 保存
+func (t *ReplayRunDAO) Insert(run *ReplayRun) error {
+	err := t.db.Create(run).Error
+
+	if err != nil {
+		logrus.Errorf("insert replay run failed. record_id:%d, err:%v", run.RecordID, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetByID 根据主键查询, 记录不存在时返回 nil
+func (t *ReplayRunDAO) GetByID(id int32) (*ReplayRun, error) {
+	var run ReplayRun
+
+	err := t.db.Where("id = ? AND flag = ?", id, common.No.Code).First(&run).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		logrus.Errorf("get replay run failed. id:%d, err:%v", id, err)
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+// ListByRecordID 分页查询录制任务的回放记录, 按主键倒序, 同时返回总数
+func (t *ReplayRunDAO) ListByRecordID(recordID int32, page, pageSize int) ([]*ReplayRun, int64, error) {
+	var (
+		list  []*ReplayRun
+		total int64
+	)
+
+	tx := t.db.Model(&ReplayRun{}).Where("record_id = ? AND flag = ?", recordID, common.No.Code)
+
+	if err := tx.Count(&total).Error; err != nil {
+		logrus.Errorf("count replay run failed. record_id:%d, err:%v", recordID, err)
+		return nil, 0, err
+	}
+
+	if total == 0 {
+		return list, 0, nil
+	}
+
+	err := tx.Order("id DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&list).Error
+
+	if err != nil {
+		logrus.Errorf("list replay run failed. record_id:%d, err:%v", recordID, err)
+		return nil, 0, err
+	}
+
+	return list, total, nil
+}
+
+// UpdateStatus 仅当当前状态为 fromStatus 时更新状态及其他字段, 返回是否更新成功
+func (t *ReplayRunDAO) UpdateStatus(id int32, fromStatus int32, values map[string]interface{}) (bool, error) {
+	result := t.db.Model(&ReplayRun{}).
+		Where("id = ? AND status = ? AND flag = ?", id, fromStatus, common.No.Code).
+		Updates(values)
+
+	if result.Error != nil {
+		logrus.Errorf("update replay run status failed. id:%d, err:%v", id, result.Error)
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// UpdateStatusByRecordID 更新录制任务当前状态为 fromStatus 的回放记录, 返回更新条数
+func (t *ReplayRunDAO) UpdateStatusByRecordID(recordID int32, fromStatus int32, values map[string]interface{}) (int64, error) {
+	result := t.db.Model(&ReplayRun{}).
+		Where("record_id = ? AND status = ? AND flag = ?", recordID, fromStatus, common.No.Code).
+		Updates(values)
+
+	if result.Error != nil {
+		logrus.Errorf("update replay run status failed. record_id:%d, err:%v", recordID, result.Error)
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,52 @@
+-- 回放执行记录及比对结果, 对应 model.ReplayRun / model.ReplayDiffSummary / model.ReplayDiffSample
+
+CREATE TABLE IF NOT EXISTS `replay_run` (
+  `id`          int       NOT NULL AUTO_INCREMENT COMMENT '主键自增ID',
+  `flag`        int       DEFAULT 0 COMMENT '是否删除(0:否,1:是)',
+  `create_time` TIMESTAMP NULL COMMENT '创建时间',
+  `update_time` TIMESTAMP NULL COMMENT '更新时间',
+  `order_id`    int       DEFAULT NULL COMMENT '排序ID',
+  `record_id`   int       DEFAULT NULL COMMENT '录制任务ID',
+  `start_time`  TIMESTAMP NULL COMMENT '开始时间',
+  `end_time`    TIMESTAMP NULL COMMENT '结束时间',
+  `status`      int       DEFAULT NULL COMMENT '状态, 1:进行中; 2:结束; 3:失败;',
+  `total`       bigint    DEFAULT NULL COMMENT '比对总数',
+  `matched`     bigint    DEFAULT NULL COMMENT '一致数',
+  `mismatched`  bigint    DEFAULT NULL COMMENT '不一致数',
+  `missing`     bigint    DEFAULT NULL COMMENT '缺少响应数',
+  PRIMARY KEY (`id`),
+  KEY `idx_replay_run_record_id` (`record_id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COMMENT = '回放执行记录';
+
+CREATE TABLE IF NOT EXISTS `replay_diff_summary` (
+  `id`               int          NOT NULL AUTO_INCREMENT COMMENT '主键自增ID',
+  `flag`             int          DEFAULT 0 COMMENT '是否删除(0:否,1:是)',
+  `create_time`      TIMESTAMP    NULL COMMENT '创建时间',
+  `update_time`      TIMESTAMP    NULL COMMENT '更新时间',
+  `order_id`         int          DEFAULT NULL COMMENT '排序ID',
+  `run_id`           int          DEFAULT NULL COMMENT '回放执行ID',
+  `protocol`         varchar(16)  DEFAULT NULL COMMENT '协议, http/dubbo',
+  `endpoint`         varchar(512) DEFAULT NULL COMMENT '接口',
+  `total`            bigint       DEFAULT NULL COMMENT '比对总数',
+  `matched`          bigint       DEFAULT NULL COMMENT '一致数',
+  `mismatched`       bigint       DEFAULT NULL COMMENT '不一致数',
+  `missing`          bigint       DEFAULT NULL COMMENT '缺少响应数',
+  `original_latency` bigint       DEFAULT NULL COMMENT '原始平均耗时(微秒)',
+  `replayed_latency` bigint       DEFAULT NULL COMMENT '回放平均耗时(微秒)',
+  PRIMARY KEY (`id`),
+  KEY `idx_replay_diff_summary_run_id` (`run_id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COMMENT = '回放按接口汇总的比对结果';
+
+CREATE TABLE IF NOT EXISTS `replay_diff_sample` (
+  `id`          int          NOT NULL AUTO_INCREMENT COMMENT '主键自增ID',
+  `flag`        int          DEFAULT 0 COMMENT '是否删除(0:否,1:是)',
+  `create_time` TIMESTAMP    NULL COMMENT '创建时间',
+  `update_time` TIMESTAMP    NULL COMMENT '更新时间',
+  `order_id`    int          DEFAULT NULL COMMENT '排序ID',
+  `run_id`      int          DEFAULT NULL COMMENT '回放执行ID',
+  `endpoint`    varchar(512) DEFAULT NULL COMMENT '接口',
+  `payload_id`  varchar(64)  DEFAULT NULL COMMENT '请求ID',
+  `diffs`       text         COMMENT '差异字段, JSON 数组',
+  PRIMARY KEY (`id`),
+  KEY `idx_replay_diff_sample_run_id` (`run_id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COMMENT = '抽样保存的不一致示例';
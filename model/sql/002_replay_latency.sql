@@ -0,0 +1,24 @@
+-- 回放输出按接口统计的耗时分位数, 对应 model.ReplayLatency
+
+CREATE TABLE IF NOT EXISTS `replay_latency` (
+  `id`          int          NOT NULL AUTO_INCREMENT COMMENT '主键自增ID',
+  `flag`        int          DEFAULT 0 COMMENT '是否删除(0:否,1:是)',
+  `create_time` TIMESTAMP    NULL COMMENT '创建时间',
+  `update_time` TIMESTAMP    NULL COMMENT '更新时间',
+  `order_id`    int          DEFAULT NULL COMMENT '排序ID',
+  `run_id`      int          DEFAULT NULL COMMENT '回放执行ID',
+  `output`      varchar(256) DEFAULT NULL COMMENT '回放输出',
+  `endpoint`    varchar(512) DEFAULT NULL COMMENT '接口',
+  `requests`    bigint       DEFAULT NULL COMMENT '请求数, 含无响应的失败请求',
+  `count`       bigint       DEFAULT NULL COMMENT '响应数',
+  `errors`      bigint       DEFAULT NULL COMMENT '错误数',
+  `timeouts`    bigint       DEFAULT NULL COMMENT '超时数',
+  `mean`        bigint       DEFAULT NULL COMMENT '平均耗时',
+  `p50`         bigint       DEFAULT NULL COMMENT 'P50 耗时',
+  `p90`         bigint       DEFAULT NULL COMMENT 'P90 耗时',
+  `p99`         bigint       DEFAULT NULL COMMENT 'P99 耗时',
+  `p999`        bigint       DEFAULT NULL COMMENT 'P99.9 耗时',
+  `max`         bigint       DEFAULT NULL COMMENT '最大耗时',
+  PRIMARY KEY (`id`),
+  KEY `idx_replay_latency_run_id` (`run_id`)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COMMENT = '回放输出按接口统计的耗时分位数';
@@ -0,0 +1,6 @@
+-- record_traffic 的配置信息改为完整的 AppSettings JSON, 并增加压测结论, 对应 model.RecordTraffic
+
+ALTER TABLE `record_traffic`
+  MODIFY COLUMN `settings` text COMMENT '配置信息',
+  ADD COLUMN `verdict` int DEFAULT 0 COMMENT '压测结论, 0:未配置SLO; 1:通过; 2:未通过;',
+  ADD COLUMN `verdict_detail` text COMMENT '压测结论明细, 各SLO规则的实际值及是否通过';
@@ -0,0 +1,14 @@
+package routers
+
+import (
+	"github.com/gin-gonic/gin"
+	"record-traffic-press/controller"
+	"record-traffic-press/middlewares"
+)
+
+func ClusterControllerRoutersInit(r *gin.Engine) {
+	clusterRouters := r.Group("/cluster", middlewares.InitMiddleware)
+	{
+		clusterRouters.GET("/workers", controller.ClusterController{}.Workers)
+	}
+}
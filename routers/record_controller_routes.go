@@ -16,5 +16,8 @@ func RecordControllerRoutersInit(r *gin.Engine) {
 		recordRouters.GET("/detail", controller.RecordController{}.Detail)
 		recordRouters.POST("/add", controller.RecordController{}.Add)
 		recordRouters.POST("/edit", controller.RecordController{}.Edit)
+		recordRouters.POST("/delete", controller.RecordController{}.Delete)
+		recordRouters.POST("/start", controller.RecordController{}.Start)
+		recordRouters.POST("/stop", controller.RecordController{}.Stop)
 	}
 }
@@ -0,0 +1,15 @@
+package routers
+
+import (
+	"github.com/gin-gonic/gin"
+	"record-traffic-press/controller"
+	"record-traffic-press/middlewares"
+)
+
+func ReplayControllerRoutersInit(r *gin.Engine) {
+	replayRouters := r.Group("/replay", middlewares.InitMiddleware)
+	{
+		replayRouters.GET("/list", controller.ReplayController{}.List)
+		replayRouters.GET("/report", controller.ReplayController{}.Report)
+	}
+}